@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// runCommand runs a one-off admin command instead of the server:
+//
+//	penguin-core redeploy-scripts [-outdated] [-concurrency N] [sheetId ...]
+func runCommand(userService *service.UserService, args []string) error {
+	switch args[0] {
+	case "redeploy-scripts":
+		return redeployScripts(userService, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func redeployScripts(userService *service.UserService, args []string) error {
+	fs := flag.NewFlagSet("redeploy-scripts", flag.ContinueOnError)
+	outdated := fs.Bool("outdated", false, "only reports whose script comes from an older template")
+	concurrency := fs.Int("concurrency", 0, "scripts updated at once (default PENGUIN_REDEPLOY_CONCURRENCY)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	req := service.RedeployRequest{SheetIds: fs.Args(), OutdatedOnly: *outdated, Concurrency: *concurrency}
+	job, err := userService.RedeployScripts(req, func(job service.RedeployJob, r service.RedeployResult) {
+		done := job.Updated + job.Failed + job.Skipped
+		if r.Error != "" {
+			log.Printf("[%d/%d] %s %s: %s", done, job.Total, r.SheetId, r.Status, r.Error)
+		} else {
+			log.Printf("[%d/%d] %s %s", done, job.Total, r.SheetId, r.Status)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if job.Failed > 0 {
+		return fmt.Errorf("%d of %d reports failed", job.Failed, job.Total)
+	}
+	return nil
+}
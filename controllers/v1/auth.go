@@ -0,0 +1,68 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+type AuthController struct {
+	authService *service.AuthService
+}
+
+func NewAuthController(authService *service.AuthService) *AuthController {
+	return &AuthController{authService: authService}
+}
+
+// GET /v1/me
+func (ctl *AuthController) Me(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, middleware.CurrentPrincipal(ctx))
+}
+
+// POST /v1/admin/api-keys
+func (ctl *AuthController) CreateAPIKey(ctx *gin.Context) {
+	var req service.CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, meta, err := ctl.authService.CreateAPIKey(middleware.CurrentPrincipal(ctx).WorkspaceID, req)
+	if err == service.ErrUserNotFound {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The plaintext key is never stored, so this is the only chance to read it.
+	ctx.JSON(http.StatusCreated, gin.H{"api_key": key, "key": meta})
+}
+
+// GET /v1/admin/api-keys
+func (ctl *AuthController) ListAPIKeys(ctx *gin.Context) {
+	keys, err := ctl.authService.ListAPIKeys(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"keys": keys, "count": len(keys)})
+}
+
+// DELETE /v1/admin/api-keys/:id
+func (ctl *AuthController) RevokeAPIKey(ctx *gin.Context) {
+	err := ctl.authService.RevokeAPIKey(middleware.CurrentPrincipal(ctx).WorkspaceID, ctx.Param("id"))
+	if err == service.ErrAPIKeyNotFound {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
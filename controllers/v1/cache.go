@@ -0,0 +1,81 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/querycache"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// DELETE /v1/reports/:id/cache
+func (ctl *UserController) InvalidateReportCache(ctx *gin.Context) {
+	n, err := ctl.userService.InvalidateReportCache(ctx.Param("id"))
+	switch {
+	case errors.Is(err, service.ErrReportNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrNoStoredQuery), errors.Is(err, datasource.ErrUnknownSource):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		log.Printf("Failed to invalidate cache of %s: %v", ctx.Param("id"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	default:
+		ctx.JSON(http.StatusOK, gin.H{"invalidated": n})
+	}
+}
+
+// DELETE /v1/admin/query-cache?db_name=
+//
+// Without db_name, drops the cached results of every data source of the
+// caller's workspace.
+func (ctl *UserController) InvalidateQueryCache(ctx *gin.Context) {
+	n, err := ctl.userService.InvalidateQueryCache(middleware.CurrentPrincipal(ctx), ctx.Query("db_name"))
+	switch {
+	case errors.Is(err, datasource.ErrUnknownSource):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		log.Printf("Failed to invalidate query cache: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	default:
+		ctx.JSON(http.StatusOK, gin.H{"invalidated": n})
+	}
+}
+
+// GET /v1/admin/query-cache
+func (ctl *UserController) GetQueryCacheStats(ctx *gin.Context) {
+	stats, enabled := ctl.userService.QueryCacheStats()
+	ctx.JSON(http.StatusOK, gin.H{"enabled": enabled, "stats": stats})
+}
+
+// noCache reports whether the request asks not to be served from the
+// query cache, with a Cache-Control: no-cache header.
+func noCache(ctx *gin.Context) bool {
+	for _, directive := range strings.Split(ctx.GetHeader("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheHeader tells in X-Cache whether the response came from the query
+// cache.
+func cacheHeader(ctx *gin.Context, status querycache.Status) {
+	if status != "" {
+		ctx.Header("X-Cache", strings.ToUpper(string(status)))
+	}
+}
+
+// withCache adds the query cache status to a response body, when the
+// request got as far as the cache.
+func withCache(body gin.H, status querycache.Status) gin.H {
+	if status != "" {
+		body["cache"] = status
+	}
+	return body
+}
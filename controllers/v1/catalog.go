@@ -0,0 +1,55 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/middleware"
+)
+
+// GET /v1/sources/:db/schemas
+func (ctl *UserController) GetSchemas(ctx *gin.Context) {
+	schemas, err := ctl.userService.ListSchemas(middleware.CurrentPrincipal(ctx), ctx.Param("db"))
+	if err != nil {
+		catalogError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"schemas": schemas, "count": len(schemas)})
+}
+
+// GET /v1/sources/:db/schemas/:schema/tables
+func (ctl *UserController) GetTables(ctx *gin.Context) {
+	tables, err := ctl.userService.ListTables(middleware.CurrentPrincipal(ctx), ctx.Param("db"), ctx.Param("schema"))
+	if err != nil {
+		catalogError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"tables": tables, "count": len(tables)})
+}
+
+// GET /v1/sources/:db/schemas/:schema/tables/:table/columns
+func (ctl *UserController) GetColumns(ctx *gin.Context) {
+	columns, err := ctl.userService.ListColumns(middleware.CurrentPrincipal(ctx), ctx.Param("db"), ctx.Param("schema"), ctx.Param("table"))
+	if err != nil {
+		catalogError(ctx, err)
+		return
+	}
+	if len(columns) == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "table not found"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"columns": columns, "count": len(columns)})
+}
+
+func catalogError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, datasource.ErrUnknownSource):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, datasource.ErrIntrospectionUnsupported):
+		ctx.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
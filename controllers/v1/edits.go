@@ -0,0 +1,170 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// POST /v1/reports/:id/edits
+//
+// Called by the report's bound Apps Script, which authenticates with the
+// editor's Google identity token rather than Penguin credentials.
+func (ctl *UserController) RecordEdit(ctx *gin.Context) {
+	var req service.EditInput
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	edit, err := ctl.userService.RecordEdit(ctx.Param("id"), bearerToken(ctx), req)
+	switch {
+	case errors.Is(err, service.ErrReportNotFound), errors.Is(err, service.ErrInvalidIdentity):
+		// Same answer for both, so the endpoint does not reveal report ids
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidIdentity.Error()})
+	case err != nil:
+		log.Printf("Failed to record edit of %s: %v", ctx.Param("id"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not record edit"})
+	default:
+		ctx.JSON(http.StatusCreated, edit)
+	}
+}
+
+// POST /v1/reports/:id/restore
+//
+// Called by the report's bound Apps Script to undo a denied edit.
+func (ctl *UserController) RestoreValues(ctx *gin.Context) {
+	var req service.RestoreRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := ctl.userService.RestoreValues(ctx.Param("id"), bearerToken(ctx), req)
+	switch {
+	case errors.Is(err, service.ErrReportNotFound), errors.Is(err, service.ErrInvalidIdentity):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidIdentity.Error()})
+	case errors.Is(err, service.ErrInvalidRestore):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		log.Printf("Failed to read values to restore in %s: %v", ctx.Param("id"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read values to restore"})
+	default:
+		ctx.JSON(http.StatusOK, resp)
+	}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(ctx *gin.Context) string {
+	scheme, value, _ := strings.Cut(ctx.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+// GET /v1/reports/:id/edits?row=&email=&limit=&offset=
+func (ctl *UserController) ListReportEdits(ctx *gin.Context) {
+	filter, ok := editFilter(ctx)
+	if !ok {
+		return
+	}
+	filter.SheetId = ctx.Param("id")
+	filter.Email = ctx.Query("email")
+	if filter.Row, ok = intQuery(ctx, "row", ctx.DefaultQuery("row", "0")); !ok {
+		return
+	}
+
+	edits, err := ctl.userService.ListReportEdits(middleware.CurrentPrincipal(ctx), filter)
+	if err != nil {
+		editsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"edits": edits, "count": len(edits)})
+}
+
+// GET /v1/reports/:id/rows/:row/edits
+func (ctl *UserController) ListRowEdits(ctx *gin.Context) {
+	filter, ok := editFilter(ctx)
+	if !ok {
+		return
+	}
+	filter.SheetId = ctx.Param("id")
+	if filter.Row, ok = intQuery(ctx, "row", ctx.Param("row")); !ok {
+		return
+	}
+	if filter.Row == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid row"})
+		return
+	}
+
+	edits, err := ctl.userService.ListReportEdits(middleware.CurrentPrincipal(ctx), filter)
+	if err != nil {
+		editsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"edits": edits, "count": len(edits)})
+}
+
+// GET /v1/users/:email/edits
+func (ctl *UserController) ListUserEdits(ctx *gin.Context) {
+	filter, ok := editFilter(ctx)
+	if !ok {
+		return
+	}
+	filter.Email = ctx.Param("email")
+
+	edits, err := ctl.userService.ListUserEdits(middleware.CurrentPrincipal(ctx), filter)
+	if err != nil {
+		editsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"edits": edits, "count": len(edits)})
+}
+
+// editFilter reads the paging parameters shared by the history listings.
+func editFilter(ctx *gin.Context) (service.EditFilter, bool) {
+	var f service.EditFilter
+	var ok bool
+	if f.Limit, ok = intQuery(ctx, "limit", ctx.DefaultQuery("limit", "0")); !ok {
+		return f, false
+	}
+	if f.Offset, ok = intQuery(ctx, "offset", ctx.DefaultQuery("offset", "0")); !ok {
+		return f, false
+	}
+	return f, true
+}
+
+// intQuery parses a non-negative integer parameter, writing a 400
+// response when it is not one.
+func intQuery(ctx *gin.Context, name, s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return 0, false
+	}
+	return n, true
+}
+
+func editsError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrReportNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrEditHistoryDenied):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrNoStoredQuery), errors.Is(err, datasource.ErrUnknownSource):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAccessDenied):
+		queryError(ctx, err)
+	default:
+		log.Printf("Edit history request failed: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,78 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/export"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// GET /v1/reports/:id/export?format=csv|xlsx|jsonl|parquet&source=query|snapshot&version=N
+//
+// source=snapshot exports a stored version instead of re-running the
+// query, the latest one unless version is given. The X-Truncated trailer
+// tells whether rows beyond the report row limit were left out; it is
+// only known once the file is complete.
+func (ctl *UserController) ExportReport(ctx *gin.Context) {
+	format, err := export.ParseFormat(ctx.DefaultQuery("format", string(export.CSV)))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	started := false
+	open := func(filename string) io.Writer {
+		started = true
+		ctx.Header("Content-Type", format.ContentType())
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		ctx.Header("Trailer", "X-Truncated")
+		ctx.Status(http.StatusOK)
+		return ctx.Writer
+	}
+
+	principal := middleware.CurrentPrincipal(ctx)
+	var truncated bool
+	switch ctx.DefaultQuery("source", "query") {
+	case "query":
+		truncated, err = ctl.userService.ExportReport(principal, ctx.Param("id"), format, open)
+	case "snapshot":
+		version, ok := versionParam(ctx, ctx.DefaultQuery("version", "0"))
+		if !ok {
+			return
+		}
+		truncated, err = ctl.userService.ExportVersion(principal, ctx.Param("id"), version, format, open)
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "source must be query or snapshot"})
+		return
+	}
+	if err == nil {
+		ctx.Writer.Header().Set("X-Truncated", strconv.FormatBool(truncated))
+		return
+	}
+
+	if started {
+		// Headers are gone; resetting the connection is the only way left
+		// to tell the client the file is incomplete
+		log.Printf("Export of report %s failed midway: %v", ctx.Param("id"), err)
+		panic(http.ErrAbortHandler)
+	}
+
+	switch {
+	case errors.Is(err, service.ErrReportNotFound), errors.Is(err, service.ErrVersionNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrNoStoredQuery):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, datasource.ErrUnknownSource):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		queryError(ctx, err)
+	}
+}
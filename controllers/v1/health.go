@@ -0,0 +1,54 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// GET /v1/reports/:id/health
+func (ctl *UserController) CheckHealth(ctx *gin.Context) {
+	health, err := ctl.userService.CheckHealth(middleware.CurrentPrincipal(ctx), ctx.Param("id"))
+	if err != nil {
+		healthError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, health)
+}
+
+// POST /v1/reports/:id/repair
+func (ctl *UserController) RepairReport(ctx *gin.Context) {
+	health, err := ctl.userService.RepairReport(middleware.CurrentPrincipal(ctx), ctx.Param("id"))
+	if err != nil {
+		healthError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, health)
+}
+
+// GET /v1/admin/report-health?unhealthy=true
+func (ctl *UserController) ListHealth(ctx *gin.Context) {
+	list, err := ctl.userService.ListHealth(middleware.CurrentPrincipal(ctx).WorkspaceID, ctx.Query("unhealthy") == "true")
+	if err != nil {
+		healthError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"reports": list, "count": len(list)})
+}
+
+func healthError(ctx *gin.Context, err error) {
+	if errors.Is(err, service.ErrReportNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrReportDenied) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Report health request failed: %v", err)
+	ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+}
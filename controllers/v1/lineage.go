@@ -0,0 +1,52 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// GET /v1/lineage/tables/:table/reports
+//
+// :table may be schema-qualified, e.g. penguin.dev_logs.
+func (ctl *UserController) GetTableReports(ctx *gin.Context) {
+	reports, err := ctl.userService.TableReports(middleware.CurrentPrincipal(ctx).WorkspaceID, ctx.Param("table"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"reports": reports, "count": len(reports)})
+}
+
+// POST /v1/lineage/impact
+func (ctl *UserController) AnalyzeImpact(ctx *gin.Context) {
+	var req service.ImpactRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := ctl.userService.AnalyzeImpact(middleware.CurrentPrincipal(ctx).WorkspaceID, req)
+	if errors.Is(err, service.ErrInvalidImpactRequest) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// POST /v1/admin/lineage/rebuild
+func (ctl *UserController) RebuildLineage(ctx *gin.Context) {
+	n, err := ctl.userService.RebuildLineage(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"reports": n})
+}
@@ -0,0 +1,229 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// POST /v1/logs
+func (ctl *UserController) IngestLogs(ctx *gin.Context) {
+	var req service.IngestLogsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logs, err := ctl.userService.IngestLogs(middleware.CurrentPrincipal(ctx), req.Logs)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ids := make([]string, len(logs))
+	for i, l := range logs {
+		ids[i] = l.Id
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"ids": ids, "count": len(ids)})
+}
+
+// GET /v1/logs?level=&service=&from=&to=&q=&limit=&offset=
+//
+// level may be repeated; from and to are RFC 3339 times.
+func (ctl *UserController) SearchLogs(ctx *gin.Context) {
+	f, ok := logPage(ctx)
+	if !ok {
+		return
+	}
+	f.Levels = ctx.QueryArray("level")
+	f.Service = ctx.Query("service")
+	f.Query = ctx.Query("q")
+	if f.From, ok = timeQuery(ctx, "from"); !ok {
+		return
+	}
+	if f.To, ok = timeQuery(ctx, "to"); !ok {
+		return
+	}
+
+	logs, err := ctl.userService.SearchLogs(middleware.CurrentPrincipal(ctx).WorkspaceID, f)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"logs": logs, "count": len(logs)})
+}
+
+// GET /v1/logs/searches
+func (ctl *UserController) ListLogSearches(ctx *gin.Context) {
+	list, err := ctl.userService.ListLogSearches(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"searches": list, "count": len(list)})
+}
+
+// POST /v1/logs/searches
+func (ctl *UserController) CreateLogSearch(ctx *gin.Context) {
+	var req service.LogSearch
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	search, err := ctl.userService.CreateLogSearch(middleware.CurrentPrincipal(ctx), req)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, search)
+}
+
+// DELETE /v1/logs/searches/:id
+func (ctl *UserController) DeleteLogSearch(ctx *gin.Context) {
+	if err := ctl.userService.DeleteLogSearch(middleware.CurrentPrincipal(ctx), ctx.Param("id")); err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// GET /v1/logs/searches/:id/results?limit=&offset=
+func (ctl *UserController) RunLogSearch(ctx *gin.Context) {
+	page, ok := logPage(ctx)
+	if !ok {
+		return
+	}
+	workspaceId := middleware.CurrentPrincipal(ctx).WorkspaceID
+	search, err := ctl.userService.GetLogSearch(workspaceId, ctx.Param("id"))
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+
+	f := search.Filter(time.Now())
+	f.Limit, f.Offset = page.Limit, page.Offset
+	logs, err := ctl.userService.SearchLogs(workspaceId, f)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"logs": logs, "count": len(logs)})
+}
+
+// POST /v1/logs/searches/:id/report
+//
+// Creates a report sheet showing the search's results, which refreshes
+// like any other report.
+func (ctl *UserController) CreateLogSearchReport(ctx *gin.Context) {
+	var req service.LogReportRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	report, err := ctl.userService.LogSearchReport(middleware.CurrentPrincipal(ctx), ctx.Param("id"), req)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctl.createReport(ctx, *report)
+}
+
+// GET /v1/admin/log-retention
+func (ctl *UserController) ListLogRetention(ctx *gin.Context) {
+	list, err := ctl.userService.ListLogRetention(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"policies": list, "count": len(list)})
+}
+
+// POST /v1/admin/log-retention
+func (ctl *UserController) CreateLogRetention(ctx *gin.Context) {
+	var req service.LogRetention
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := ctl.userService.CreateLogRetention(middleware.CurrentPrincipal(ctx).WorkspaceID, req)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, policy)
+}
+
+// DELETE /v1/admin/log-retention/:id
+func (ctl *UserController) DeleteLogRetention(ctx *gin.Context) {
+	if err := ctl.userService.DeleteLogRetention(middleware.CurrentPrincipal(ctx).WorkspaceID, ctx.Param("id")); err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// POST /v1/admin/log-retention/run
+//
+// Applies the workspace's retention policies now rather than at the next
+// scheduled run.
+func (ctl *UserController) ApplyLogRetention(ctx *gin.Context) {
+	n, err := ctl.userService.ApplyLogRetention(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		logsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"deleted": n})
+}
+
+// logPage reads the paging parameters of the log listings.
+func logPage(ctx *gin.Context) (service.LogFilter, bool) {
+	var f service.LogFilter
+	var ok bool
+	if f.Limit, ok = intQuery(ctx, "limit", ctx.DefaultQuery("limit", "0")); !ok {
+		return f, false
+	}
+	if f.Offset, ok = intQuery(ctx, "offset", ctx.DefaultQuery("offset", "0")); !ok {
+		return f, false
+	}
+	return f, true
+}
+
+// timeQuery parses an optional RFC 3339 parameter, writing a 400
+// response when it is not one.
+func timeQuery(ctx *gin.Context, name string) (time.Time, bool) {
+	s := ctx.Query(name)
+	if s == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ", want an RFC 3339 time"})
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func logsError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidLog), errors.Is(err, service.ErrInvalidLogSearch), errors.Is(err, service.ErrInvalidRetention):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrLogSearchNotFound), errors.Is(err, service.ErrRetentionNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrLogSearchDenied):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrLogSearchExists), errors.Is(err, service.ErrNoLogSource):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Logs request failed: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
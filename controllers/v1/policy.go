@@ -0,0 +1,54 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// GET /v1/admin/data-policies
+func (ctl *UserController) ListPolicies(ctx *gin.Context) {
+	policies, err := ctl.userService.ListPolicies(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"policies": policies, "count": len(policies)})
+}
+
+// POST /v1/admin/data-policies
+func (ctl *UserController) CreatePolicy(ctx *gin.Context) {
+	var req service.DataPolicy
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := ctl.userService.CreatePolicy(middleware.CurrentPrincipal(ctx).WorkspaceID, req)
+	if errors.Is(err, service.ErrInvalidPolicy) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, policy)
+}
+
+// DELETE /v1/admin/data-policies/:id
+func (ctl *UserController) DeletePolicy(ctx *gin.Context) {
+	err := ctl.userService.DeletePolicy(middleware.CurrentPrincipal(ctx).WorkspaceID, ctx.Param("id"))
+	if errors.Is(err, service.ErrPolicyNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Data access policy deleted"})
+}
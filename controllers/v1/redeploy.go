@@ -0,0 +1,75 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/service"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+// POST /v1/admin/scripts/redeploy
+//
+// Starts the job and answers 202 with it; poll GET .../redeploy/:id.
+func (ctl *UserController) StartRedeploy(ctx *gin.Context) {
+	var req service.RedeployRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := ctl.userService.StartRedeploy(req)
+	if errors.Is(err, service.ErrReportNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// GET /v1/admin/scripts/redeploy/:id
+func (ctl *UserController) GetRedeploy(ctx *gin.Context) {
+	job, err := ctl.userService.GetRedeploy(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, job)
+}
+
+// GET /v1/admin/scripts/unregistered
+func (ctl *UserController) ListUnregisteredScripts(ctx *gin.Context) {
+	list, err := ctl.userService.ListUnregisteredScripts()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"reports": list, "count": len(list)})
+}
+
+// PUT /v1/admin/reports/:id/script
+func (ctl *UserController) RegisterScript(ctx *gin.Context) {
+	var req struct {
+		ScriptId string `json:"scriptId" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := ctl.userService.RegisterScript(ctx.Param("id"), req.ScriptId)
+	switch {
+	case errors.Is(err, service.ErrReportNotFound), errors.Is(err, utils.ErrScriptNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrScriptNotBound):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusOK, gin.H{"sheetId": ctx.Param("id"), "scriptId": req.ScriptId})
+	}
+}
@@ -0,0 +1,109 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/secrets"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+type SecretController struct {
+	secretService *service.SecretService
+}
+
+func NewSecretController(secretService *service.SecretService) *SecretController {
+	return &SecretController{secretService: secretService}
+}
+
+// GET /v1/admin/secrets
+func (ctl *SecretController) ListSecrets(ctx *gin.Context) {
+	list, err := ctl.secretService.ListSecrets()
+	if err != nil {
+		secretError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"secrets": list, "count": len(list)})
+}
+
+// PUT /v1/admin/secrets/:name
+func (ctl *SecretController) PutSecret(ctx *gin.Context) {
+	var req service.PutSecretRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sec, err := ctl.secretService.PutSecret(ctx.Param("name"), req)
+	if err != nil {
+		secretError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, sec)
+}
+
+// DELETE /v1/admin/secrets/:name
+func (ctl *SecretController) DeleteSecret(ctx *gin.Context) {
+	if err := ctl.secretService.DeleteSecret(ctx.Param("name")); err != nil {
+		secretError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// POST /v1/admin/secrets/rewrap
+func (ctl *SecretController) RewrapSecrets(ctx *gin.Context) {
+	n, err := ctl.secretService.RewrapSecrets()
+	if err != nil {
+		secretError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"rewrapped": n})
+}
+
+// GET /v1/admin/google/authorize
+//
+// ?credentials= names a workspace's Google credentials; the deployment's
+// are used without it.
+func (ctl *SecretController) GoogleAuthURL(ctx *gin.Context) {
+	url, err := ctl.secretService.GoogleAuthURL(middleware.CurrentPrincipal(ctx).UserID, ctx.Query("credentials"))
+	if err != nil {
+		secretError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"url": url})
+}
+
+type authorizeGoogleRequest struct {
+	Code        string `json:"code" binding:"required"`
+	State       string `json:"state" binding:"required"`
+	Credentials string `json:"credentials"`
+}
+
+// POST /v1/admin/google/authorize
+func (ctl *SecretController) AuthorizeGoogle(ctx *gin.Context) {
+	var req authorizeGoogleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ctl.secretService.AuthorizeGoogle(middleware.CurrentPrincipal(ctx).UserID, req.Credentials, req.State, req.Code); err != nil {
+		secretError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func secretError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSecretsDisabled):
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, secrets.ErrNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, secrets.ErrInvalidName), errors.Is(err, service.ErrInvalidOAuthState):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// POST /v1/reports/:id/sync
+func (ctl *UserController) SyncReport(ctx *gin.Context) {
+	version, err := ctl.userService.SyncReport(middleware.CurrentPrincipal(ctx), ctx.Param("id"))
+	if err != nil {
+		versionError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, version)
+}
+
+// GET /v1/reports/:id/versions
+func (ctl *UserController) ListVersions(ctx *gin.Context) {
+	versions, err := ctl.userService.ListVersions(middleware.CurrentPrincipal(ctx), ctx.Param("id"))
+	if err != nil {
+		versionError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"versions": versions, "count": len(versions)})
+}
+
+// GET /v1/reports/:id/versions/:version
+func (ctl *UserController) GetVersion(ctx *gin.Context) {
+	version, ok := versionParam(ctx, ctx.Param("version"))
+	if !ok {
+		return
+	}
+	v, err := ctl.userService.GetVersion(middleware.CurrentPrincipal(ctx), ctx.Param("id"), version)
+	if err != nil {
+		versionError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, v)
+}
+
+// GET /v1/reports/:id/versions/diff?from=N&to=M
+//
+// to defaults to the latest version.
+func (ctl *UserController) DiffVersions(ctx *gin.Context) {
+	from, ok := versionParam(ctx, ctx.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := versionParam(ctx, ctx.DefaultQuery("to", "0"))
+	if !ok {
+		return
+	}
+	diff, err := ctl.userService.DiffVersions(middleware.CurrentPrincipal(ctx), ctx.Param("id"), from, to)
+	if err != nil {
+		versionError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, diff)
+}
+
+// versionParam parses a version number, writing a 400 response when it is
+// not one. "0" stands for the latest version.
+func versionParam(ctx *gin.Context, s string) (int, bool) {
+	version, err := strconv.Atoi(s)
+	if err != nil || version < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return 0, false
+	}
+	return version, true
+}
+
+func versionError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrReportNotFound), errors.Is(err, service.ErrVersionNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrEmptySheet), errors.Is(err, service.ErrNoStoredQuery), errors.Is(err, datasource.ErrUnknownSource):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAccessDenied):
+		queryError(ctx, err)
+	default:
+		log.Printf("Report version request failed: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,104 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// GET /v1/templates
+func (ctl *UserController) ListTemplates(ctx *gin.Context) {
+	templates, err := ctl.userService.ListTemplates(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"templates": templates, "count": len(templates)})
+}
+
+// GET /v1/templates/:id
+func (ctl *UserController) GetTemplate(ctx *gin.Context) {
+	template, err := ctl.userService.GetTemplate(middleware.CurrentPrincipal(ctx).WorkspaceID, ctx.Param("id"))
+	if err != nil {
+		templateError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, template)
+}
+
+// POST /v1/templates
+func (ctl *UserController) CreateTemplate(ctx *gin.Context) {
+	var req service.ReportTemplate
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := ctl.userService.CreateTemplate(middleware.CurrentPrincipal(ctx), req)
+	if err != nil {
+		templateError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, template)
+}
+
+// PUT /v1/templates/:id
+func (ctl *UserController) UpdateTemplate(ctx *gin.Context) {
+	var req service.ReportTemplate
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := ctl.userService.UpdateTemplate(middleware.CurrentPrincipal(ctx), ctx.Param("id"), req)
+	if err != nil {
+		templateError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, template)
+}
+
+// DELETE /v1/templates/:id
+func (ctl *UserController) DeleteTemplate(ctx *gin.Context) {
+	if err := ctl.userService.DeleteTemplate(middleware.CurrentPrincipal(ctx), ctx.Param("id")); err != nil {
+		templateError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Report template deleted"})
+}
+
+// POST /v1/templates/:id/instantiate
+func (ctl *UserController) InstantiateTemplate(ctx *gin.Context) {
+	var req service.InstantiateRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	report, err := ctl.userService.InstantiateTemplate(middleware.CurrentPrincipal(ctx).WorkspaceID, ctx.Param("id"), req)
+	if err != nil {
+		templateError(ctx, err)
+		return
+	}
+	ctl.createReport(ctx, *report)
+}
+
+func templateError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTemplateNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidTemplate):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTemplateDenied):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTemplateExists):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,41 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// POST /v1/reports/:id/trigger
+func (ctl *UserController) InstallTrigger(ctx *gin.Context) {
+	status, err := ctl.userService.InstallTrigger(middleware.CurrentPrincipal(ctx), ctx.Param("id"))
+	switch {
+	case errors.Is(err, service.ErrReportNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrReportDenied):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrNoBoundScript):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil && status != nil:
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "trigger": status})
+	case err != nil:
+		log.Printf("Installing edit trigger failed: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusOK, status)
+	}
+}
+
+// GET /v1/admin/reports/unenforced
+func (ctl *UserController) ListUnenforced(ctx *gin.Context) {
+	list, err := ctl.userService.ListUnenforced(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"reports": list, "count": len(list)})
+}
@@ -1,14 +1,18 @@
 package v1
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/middleware"
 	"github.com/nishantd01/penguin-core/models"
 	"github.com/nishantd01/penguin-core/service"
+	"github.com/nishantd01/penguin-core/sqlutil"
 )
 
 type UserController struct {
@@ -39,7 +43,7 @@ func (ctl *UserController) GetUser(c *gin.Context) {
 
 // GET /v1/dbnames
 func (ctl *UserController) GetDbNames(ctx *gin.Context) {
-	dbNames, err := ctl.userService.GetDbNames()
+	dbNames, err := ctl.userService.GetDbNames(middleware.CurrentPrincipal(ctx).WorkspaceID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -50,9 +54,22 @@ func (ctl *UserController) GetDbNames(ctx *gin.Context) {
 	})
 }
 
+// GET /v1/sources
+func (ctl *UserController) GetSources(ctx *gin.Context) {
+	sources, err := ctl.userService.GetSources(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"sources": sources,
+		"count":   len(sources),
+	})
+}
+
 // GET /v1/roles
 func (ctl *UserController) GetRoles(ctx *gin.Context) {
-	roleNames, err := ctl.userService.GetRoles()
+	roleNames, err := ctl.userService.GetRoles(middleware.CurrentPrincipal(ctx).WorkspaceID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -71,7 +88,11 @@ func (c *UserController) CheckAccess(ctx *gin.Context) {
 
 	log.Printf("Reques Body %+v\n", req)
 
-	hasAccess, err := c.userService.CheckAccess(req)
+	hasAccess, err := c.userService.CheckAccess(bearerToken(ctx), req)
+	if errors.Is(err, service.ErrReportNotFound) || errors.Is(err, service.ErrInvalidIdentity) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidIdentity.Error()})
+		return
+	}
 	if err != nil {
 		log.Printf("Errorf %v\n", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -96,10 +117,63 @@ func (ctl *UserController) CreateReport(ctx *gin.Context) {
 
 	fmt.Printf("req %v\n", report)
 
-	code, msg, URL := ctl.userService.CreateReport(report)
+	ctl.createReport(ctx, report)
+}
+
+// createReport creates the report and writes the response, honouring an
+// Idempotency-Key header when one is sent.
+func (ctl *UserController) createReport(ctx *gin.Context, report models.ReportInput) {
+	principal := middleware.CurrentPrincipal(ctx)
+	report.NoCache = report.NoCache || noCache(ctx)
+
+	key := ctx.GetHeader("Idempotency-Key")
+	if key == "" {
+		code, msg, URL, cache := ctl.userService.CreateReport(principal, report)
+		cacheHeader(ctx, cache)
+		ctx.JSON(code, withCache(gin.H{"message": msg, "sheetUrl": URL}, cache))
+		return
+	}
+
+	result, err := ctl.userService.CreateReportOnce(principal, key, report)
+	switch {
+	case errors.Is(err, service.ErrInvalidIdempotencyKey):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrIdempotencyKeyReused):
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if result.InProgress {
+		ctx.JSON(http.StatusConflict, gin.H{"status": "in_progress", "message": "A request with this Idempotency-Key is still being processed"})
+		return
+	}
+	if result.Replayed {
+		ctx.Header("Idempotent-Replayed", "true")
+	}
+	cacheHeader(ctx, result.Cache)
+	ctx.JSON(result.Code, withCache(gin.H{"message": result.Message, "sheetUrl": result.SheetURL}, result.Cache))
+}
+
+// POST /v1/reports/:id/refresh
+func (ctl *UserController) RefreshReport(ctx *gin.Context) {
+	var req service.RefreshRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	req.NoCache = req.NoCache || noCache(ctx)
 
-	ctx.JSON(code, gin.H{"message": msg, "sheetUrl": URL})
+	code, msg, cache := ctl.userService.RefreshReport(middleware.CurrentPrincipal(ctx), ctx.Param("id"), req)
 
+	cacheHeader(ctx, cache)
+	ctx.JSON(code, withCache(gin.H{"message": msg}, cache))
 }
 
 func (ctl *UserController) ValidateSQLQuery(ctx *gin.Context) {
@@ -108,12 +182,53 @@ func (ctl *UserController) ValidateSQLQuery(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
+	req.NoCache = req.NoCache || noCache(ctx)
 
-	response, err := ctl.userService.ValidateSQLQuery(req)
+	response, err := ctl.userService.ValidateSQLQuery(middleware.CurrentPrincipal(ctx), req)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		queryError(ctx, err)
 		return
 	}
 
+	cacheHeader(ctx, response.Cache)
 	ctx.JSON(http.StatusOK, response)
 }
+
+func (ctl *UserController) PreviewSQLQuery(ctx *gin.Context) {
+	var req service.SQLPreviewRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	req.NoCache = req.NoCache || noCache(ctx)
+
+	response, err := ctl.userService.PreviewSQLQuery(middleware.CurrentPrincipal(ctx), req)
+	if err != nil {
+		queryError(ctx, err)
+		return
+	}
+
+	cacheHeader(ctx, response.Cache)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// queryError maps the errors of running an ad-hoc query to a response.
+// Access denials list every forbidden object so they can be fixed at once.
+// Mistakes in the request, including SQL the source rejects, are 400s;
+// anything else is the service's or the source's fault.
+func queryError(ctx *gin.Context, err error) {
+	var denied *service.AccessDeniedError
+	switch {
+	case errors.As(err, &denied):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": service.ErrAccessDenied.Error(), "forbidden": denied.Objects})
+	case errors.Is(err, service.ErrQueryTooExpensive), errors.Is(err, service.ErrQueryTimeout):
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, sqlutil.ErrEmptyQuery), errors.Is(err, sqlutil.ErrMultipleStatements),
+		errors.Is(err, sqlutil.ErrInvalidParameter), errors.Is(err, service.ErrInvalidCursor),
+		errors.Is(err, datasource.ErrUnknownSource), datasource.IsStatementError(err):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Query request failed: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
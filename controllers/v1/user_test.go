@@ -0,0 +1,56 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/config"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/internal/testdb"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+func TestCheckAccessNeedsIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t, `
+		CREATE TABLE penguin.spreadsheet (id TEXT PRIMARY KEY, workspace_id TEXT NOT NULL, trigger_owner TEXT);
+		INSERT INTO penguin.spreadsheet VALUES ('sheet-a', 'w1', 'owner@example.com');
+	`)
+	cfg := &config.Config{ScriptClientID: "script-client"}
+	sources := datasource.NewRegistry(db, nil, "", "")
+	defer sources.Close()
+	users := service.NewUserService(db, sources, cfg)
+	defer users.Close()
+
+	r := gin.New()
+	r.POST("/check-edit-permission", NewUserController(users).CheckAccess)
+
+	// Unknown sheets answer like bad tokens, so sheet ids cannot be probed
+	tests := []struct {
+		name  string
+		sheet string
+		auth  string
+	}{
+		{"no token", "sheet-a", ""},
+		{"malformed token", "sheet-a", "Bearer not-a-token"},
+		{"unknown sheet", "sheet-x", "Bearer not-a-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"email": "alice@example.com", "sheet_id": "` + tt.sheet + `", "column_name": "note"}`
+			req := httptest.NewRequest(http.MethodPost, "/check-edit-permission", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("answered %d, want 401: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
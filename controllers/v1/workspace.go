@@ -0,0 +1,116 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// GET /v1/admin/workspaces
+func (ctl *UserController) ListWorkspaces(ctx *gin.Context) {
+	list, err := ctl.userService.ListWorkspaces()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"workspaces": list, "count": len(list)})
+}
+
+// POST /v1/admin/workspaces
+func (ctl *UserController) CreateWorkspace(ctx *gin.Context) {
+	var req service.CreateWorkspaceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspace, err := ctl.userService.CreateWorkspace(req)
+	if errors.Is(err, service.ErrWorkspaceExists) || errors.Is(err, service.ErrUserExists) || errors.Is(err, datasource.ErrSourceExists) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrSecretsDisabled) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "googleCredentials needs secret storage: " + err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, workspace)
+}
+
+// GET /v1/admin/users
+func (ctl *UserController) ListUsers(ctx *gin.Context) {
+	list, err := ctl.userService.ListUsers(middleware.CurrentPrincipal(ctx).WorkspaceID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"users": list, "count": len(list)})
+}
+
+// POST /v1/admin/users
+func (ctl *UserController) CreateUser(ctx *gin.Context) {
+	var req service.CreateUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := ctl.userService.CreateUser(middleware.CurrentPrincipal(ctx).WorkspaceID, req)
+	switch {
+	case errors.Is(err, service.ErrForeignRole):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrUserExists):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusCreated, user)
+	}
+}
+
+// POST /v1/admin/roles
+func (ctl *UserController) CreateRole(ctx *gin.Context) {
+	var req service.CreateRoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := ctl.userService.CreateRole(middleware.CurrentPrincipal(ctx).WorkspaceID, req)
+	switch {
+	case errors.Is(err, service.ErrRoleExists):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusCreated, role)
+	}
+}
+
+// POST /v1/admin/sources
+func (ctl *UserController) CreateSource(ctx *gin.Context) {
+	var req service.CreateSourceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	source, err := ctl.userService.CreateSource(middleware.CurrentPrincipal(ctx).WorkspaceID, req)
+	switch {
+	case errors.Is(err, datasource.ErrInvalidSource), errors.Is(err, service.ErrSecretsDisabled):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, datasource.ErrSourceExists):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusCreated, source)
+	}
+}
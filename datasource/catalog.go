@@ -0,0 +1,117 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrIntrospectionUnsupported = errors.New("driver does not support schema introspection")
+
+type SchemaInfo struct {
+	Name string `json:"name"`
+}
+
+type TableInfo struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind"` // table, view, materialized_view or foreign_table
+	// RowEstimate comes from planner statistics and is nil when the
+	// source has none, e.g. a table that was never analyzed.
+	RowEstimate *int64 `json:"row_estimate"`
+}
+
+type ColumnMeta struct {
+	Name       string     `json:"name"`
+	Position   int        `json:"position"`
+	DataType   string     `json:"data_type"`
+	Type       ColumnType `json:"type"`
+	Nullable   bool       `json:"nullable"`
+	PrimaryKey bool       `json:"primary_key"`
+	Default    *string    `json:"default,omitempty"`
+}
+
+// Introspector is implemented by drivers that can describe a source's
+// catalog for the query editor.
+type Introspector interface {
+	Schemas(ctx context.Context, db *sql.DB) ([]SchemaInfo, error)
+	Tables(ctx context.Context, db *sql.DB, schema string) ([]TableInfo, error)
+	Columns(ctx context.Context, db *sql.DB, schema, table string) ([]ColumnMeta, error)
+}
+
+func (s *Source) Introspector() (Introspector, error) {
+	// Browsing runs as the connection's own user, which sees more than
+	// the source's role
+	if s.Role != "" {
+		return nil, fmt.Errorf("%w: %s is restricted to a database role", ErrIntrospectionUnsupported, s.Name)
+	}
+	in, ok := s.Driver.(Introspector)
+	if !ok {
+		return nil, ErrIntrospectionUnsupported
+	}
+	return in, nil
+}
+
+func scanSchemas(rows *sql.Rows, err error) ([]SchemaInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []SchemaInfo
+	for rows.Next() {
+		var s SchemaInfo
+		if err := rows.Scan(&s.Name); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, rows.Err()
+}
+
+// scanTables reads rows of (schema, name, kind, row estimate).
+func scanTables(rows *sql.Rows, err error) ([]TableInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []TableInfo
+	for rows.Next() {
+		var t TableInfo
+		if err := rows.Scan(&t.Schema, &t.Name, &t.Kind, &t.RowEstimate); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+// scanColumns reads rows of (name, position, data type, nullable,
+// default, primary key). Callers map the data types with mapColumnTypes.
+func scanColumns(rows *sql.Rows, err error) ([]ColumnMeta, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []ColumnMeta
+	for rows.Next() {
+		var c ColumnMeta
+		if err := rows.Scan(&c.Name, &c.Position, &c.DataType, &c.Nullable, &c.Default, &c.PrimaryKey); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func mapColumnTypes(d Driver) func([]ColumnMeta, error) ([]ColumnMeta, error) {
+	return func(columns []ColumnMeta, err error) ([]ColumnMeta, error) {
+		for i := range columns {
+			columns[i].Type = d.ColumnType(columns[i].DataType)
+		}
+		return columns, err
+	}
+}
@@ -0,0 +1,72 @@
+package datasource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	DateLayout      = "2006-01-02"
+	TimestampLayout = "2006-01-02 15:04:05"
+)
+
+// ConvertValue turns a value scanned from a source into one that the
+// Sheets API and JSON encoding render faithfully. Drivers differ in what
+// they scan into interface{}: MySQL returns most columns as []byte while
+// Postgres returns native Go types, so values are normalised by the
+// mapped column type rather than by their Go type alone.
+func ConvertValue(v interface{}, t ColumnType) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return convertText(string(val), val, t)
+	case string:
+		return convertText(val, nil, t)
+	case time.Time:
+		if t == TypeDate {
+			return val.Format(DateLayout)
+		}
+		return val.Format(TimestampLayout)
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return val
+	case fmt.Stringer:
+		return val.String()
+	default:
+		// Any other value the driver returns, such as a slice or map.
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(b)
+	}
+}
+
+func convertText(s string, raw []byte, t ColumnType) interface{} {
+	switch t {
+	case TypeInteger:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case TypeNumber:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case TypeBoolean:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+		// MySQL BIT(1) scans as a single raw byte.
+		if len(raw) == 1 && raw[0] <= 1 {
+			return raw[0] == 1
+		}
+	case TypeBytes:
+		if raw != nil {
+			return base64.StdEncoding.EncodeToString(raw)
+		}
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ColumnType is the driver-independent type a source column is mapped to
+// before its values are written to a sheet.
+type ColumnType string
+
+const (
+	TypeString    ColumnType = "string"
+	TypeInteger   ColumnType = "integer"
+	TypeNumber    ColumnType = "number"
+	TypeBoolean   ColumnType = "boolean"
+	TypeDate      ColumnType = "date"
+	TypeTimestamp ColumnType = "timestamp"
+	TypeBytes     ColumnType = "bytes"
+	TypeJSON      ColumnType = "json"
+)
+
+// Driver covers the dialect-specific parts of running report queries
+// against a source: connecting, validating, mapping column types and
+// limiting rows.
+type Driver interface {
+	// Name is the value stored in penguin.snowflake_databases.driver.
+	Name() string
+
+	// Open connects to the source through the database/sql driver
+	// imported in sqldrivers.go.
+	Open(dsn string) (*sql.DB, error)
+
+	// BeginRead starts the read-only transaction report queries run in.
+	// Dialects that can scope name resolution to a single transaction
+	// set it to schema when one is given.
+	BeginRead(ctx context.Context, db *sql.DB, schema string) (*sql.Tx, error)
+
+	// Validate checks the query's syntax and references without
+	// fetching any rows.
+	Validate(ctx context.Context, conn Conn, query string) error
+
+	// ColumnType maps a database type name, as reported by
+	// sql.ColumnType.DatabaseTypeName, to a ColumnType.
+	ColumnType(dbType string) ColumnType
+
+	// LimitQuery restricts query to at most n rows.
+	LimitQuery(query string, n int) string
+
+	// PageQuery returns rows [offset, offset+limit) of query.
+	PageQuery(query string, limit, offset int) string
+
+	// QuoteIdent quotes an identifier for use in generated SQL.
+	QuoteIdent(name string) string
+
+	// Placeholder returns the bind marker for the n-th (1-based) query
+	// argument.
+	Placeholder(n int) string
+}
+
+// Conn is satisfied by both *sql.DB and *sql.Tx.
+type Conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a driver available to the registry under its Name.
+func Register(d Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[d.Name()] = d
+}
+
+func LookupDriver(name string) (Driver, error) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	d, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported driver %q", name)
+	}
+	return d, nil
+}
+
+// Drivers lists the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// prepareValidator implements Driver.Validate by asking the server to
+// parse and plan the query through a prepared statement, without running
+// it. Postgres and MySQL prepare server-side (go-sql-driver/mysql does
+// unless interpolateParams is set in the DSN) and SQLite compiles the
+// statement with sqlite3_prepare, so all of them resolve tables and
+// columns.
+type prepareValidator struct{}
+
+func (prepareValidator) Validate(ctx context.Context, conn Conn, query string) error {
+	stmt, err := conn.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	return stmt.Close()
+}
+
+// wrapLimit runs query as a derived table rather than appending LIMIT to
+// its text, which would break queries that already end in LIMIT, OFFSET,
+// FETCH or a comment.
+func wrapLimit(query string, n int) string {
+	return fmt.Sprintf("SELECT * FROM (%s\n) AS penguin_limit LIMIT %d", query, n)
+}
+
+// wrapPage pages through query the same way wrapLimit limits it. The
+// newline keeps a trailing line comment from swallowing the wrapper.
+func wrapPage(query string, limit, offset int) string {
+	return fmt.Sprintf("SELECT * FROM (%s\n) AS penguin_page LIMIT %d OFFSET %d", query, limit, offset)
+}
+
+func questionMark(int) string { return "?" }
+
+// beginReadOnly starts a read-only transaction for dialects that honour
+// sql.TxOptions.ReadOnly.
+func beginReadOnly(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	return db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+}
+
+func quoteWith(name, quote string) string {
+	return quote + strings.ReplaceAll(name, quote, quote+quote) + quote
+}
+
+// baseType strips length and precision so "VARCHAR(255)" and
+// "DECIMAL(18,3)" map like their bare type names.
+func baseType(dbType string) string {
+	t := strings.ToUpper(strings.TrimSpace(dbType))
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = strings.TrimSpace(t[:i])
+	}
+	return t
+}
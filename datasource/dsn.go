@@ -0,0 +1,83 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// checkDSN refuses DSNs a workspace could use to reach the server's own
+// files. It returns the DSN to store, which for SQLite is the resolved
+// path of the file.
+func (r *Registry) checkDSN(driver, dsn string) (string, error) {
+	switch driver {
+	case SQLite:
+		return sqlitePath(r.sqliteDir, dsn)
+	case MySQL:
+		// A server asking for LOAD DATA LOCAL INFILE would be sent any
+		// file it names
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidSource, err)
+		}
+		if cfg.AllowAllFiles {
+			return "", fmt.Errorf("%w: allowAllFiles is not allowed", ErrInvalidSource)
+		}
+	}
+	return dsn, nil
+}
+
+// sqlitePath resolves name, a plain path, inside dir. URIs and their
+// parameters are refused since they can name other files or reopen the
+// file writable, and so are symbolic links leading out of dir.
+func sqlitePath(dir, name string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("%w: no sqlite directory is configured", ErrInvalidSource)
+	}
+	// Open turns the path into a URI, where these are not plain characters
+	if name == "" || strings.HasPrefix(name, "file:") || strings.ContainsAny(name, "?#%") {
+		return "", fmt.Errorf("%w: sqlite DSN must be a file path", ErrInvalidSource)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("%w: sqlite directory: %v", ErrInvalidSource, err)
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is not inside the sqlite directory", ErrInvalidSource, name)
+	}
+	return path, nil
+}
+
+// sameDatabase reports whether db is connected to the metadata database.
+// Host names can be aliased, so it compares what the servers report: the
+// database name and when the server started.
+func sameDatabase(ctx context.Context, meta, db *sql.DB) (bool, error) {
+	const q = `SELECT current_database(), pg_postmaster_start_time()`
+	var metaName, name string
+	var metaStart, start time.Time
+	if err := meta.QueryRowContext(ctx, q).Scan(&metaName, &metaStart); err != nil {
+		return false, err
+	}
+	if err := db.QueryRowContext(ctx, q).Scan(&name, &start); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+	return name == metaName && start.Equal(metaStart), nil
+}
@@ -0,0 +1,73 @@
+package datasource
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqlitePath(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	for _, f := range []string{filepath.Join(dir, "a.db"), filepath.Join(outside, "b.db")} {
+		if err := os.WriteFile(f, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "b.db"), filepath.Join(dir, "link.db")); err != nil {
+		t.Fatal(err)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+		dsn  string
+		want string
+	}{
+		{"relative", dir, "a.db", filepath.Join(root, "a.db")},
+		{"absolute", dir, filepath.Join(dir, "a.db"), filepath.Join(root, "a.db")},
+		{"dot dot back in", dir, "sub/../a.db", filepath.Join(root, "a.db")},
+		{"no directory", "", "a.db", ""},
+		{"outside", dir, filepath.Join(outside, "b.db"), ""},
+		{"dot dot", dir, "../" + filepath.Base(outside) + "/b.db", ""},
+		{"symlink out", dir, "link.db", ""},
+		{"directory itself", dir, ".", ""},
+		{"missing", dir, "missing.db", ""},
+		{"uri", dir, "file:a.db", ""},
+		{"parameters", dir, "a.db?mode=rw", ""},
+		{"escaped", dir, "%2e%2e/b.db", ""},
+		{"memory", dir, ":memory:", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sqlitePath(tt.dir, tt.dsn)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidSource) {
+					t.Errorf("sqlitePath(%q) = %q, %v, want ErrInvalidSource", tt.dsn, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("sqlitePath(%q) = %q, %v, want %q", tt.dsn, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDSNMySQL(t *testing.T) {
+	r := &Registry{}
+	if _, err := r.checkDSN(MySQL, "user:pw@tcp(db:3306)/analytics"); err != nil {
+		t.Errorf("plain DSN refused: %v", err)
+	}
+	if _, err := r.checkDSN(MySQL, "user:pw@tcp(db:3306)/analytics?allowAllFiles=true"); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("allowAllFiles accepted: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+//go:build duckdb
+
+package datasource
+
+// DuckDB is opt-in: go-duckdb needs cgo and a prebuilt libduckdb, so the
+// default build leaves it out. Build with -tags duckdb after adding
+// github.com/marcboeker/go-duckdb to go.mod.
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+const DuckDB = "duckdb"
+
+type duckdbDriver struct{ prepareValidator }
+
+func init() { Register(duckdbDriver{}) }
+
+func (duckdbDriver) Name() string { return DuckDB }
+
+// Open takes a database file path, or an empty DSN for an in-memory
+// database that queries Parquet/CSV files directly.
+func (duckdbDriver) Open(dsn string) (*sql.DB, error) {
+	return sql.Open("duckdb", dsn)
+}
+
+// BeginRead starts a plain transaction, as go-duckdb rejects the
+// read-only transaction option.
+func (duckdbDriver) BeginRead(ctx context.Context, db *sql.DB, schema string) (*sql.Tx, error) {
+	return db.BeginTx(ctx, nil)
+}
+
+func (duckdbDriver) ColumnType(dbType string) ColumnType {
+	switch baseType(dbType) {
+	case "TINYINT", "SMALLINT", "INTEGER", "BIGINT", "HUGEINT",
+		"UTINYINT", "USMALLINT", "UINTEGER", "UBIGINT":
+		return TypeInteger
+	case "FLOAT", "DOUBLE", "DECIMAL", "REAL":
+		return TypeNumber
+	case "BOOLEAN":
+		return TypeBoolean
+	case "DATE":
+		return TypeDate
+	case "TIMESTAMP", "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE", "TIMESTAMP_S", "TIMESTAMP_MS", "TIMESTAMP_NS":
+		return TypeTimestamp
+	case "BLOB":
+		return TypeBytes
+	case "JSON", "STRUCT", "MAP", "LIST":
+		return TypeJSON
+	default:
+		return TypeString
+	}
+}
+
+func (duckdbDriver) LimitQuery(query string, n int) string { return wrapLimit(query, n) }
+
+func (duckdbDriver) PageQuery(query string, limit, offset int) string {
+	return wrapPage(query, limit, offset)
+}
+
+func (duckdbDriver) QuoteIdent(name string) string { return quoteWith(name, `"`) }
+
+func (duckdbDriver) Placeholder(n int) string { return questionMark(n) }
+
+func (duckdbDriver) Schemas(ctx context.Context, db *sql.DB) ([]SchemaInfo, error) {
+	return scanSchemas(db.QueryContext(ctx, `
+		SELECT DISTINCT schema_name
+		FROM information_schema.schemata
+		WHERE schema_name NOT IN ('information_schema', 'pg_catalog')
+		ORDER BY schema_name
+	`))
+}
+
+func (duckdbDriver) Tables(ctx context.Context, db *sql.DB, schema string) ([]TableInfo, error) {
+	return scanTables(db.QueryContext(ctx, `
+		SELECT t.table_schema, t.table_name,
+			CASE t.table_type WHEN 'VIEW' THEN 'view' ELSE 'table' END,
+			d.estimated_size
+		FROM information_schema.tables t
+		LEFT JOIN duckdb_tables() d ON d.schema_name = t.table_schema AND d.table_name = t.table_name
+		WHERE t.table_schema = ?
+		ORDER BY t.table_name
+	`, schema))
+}
+
+func (d duckdbDriver) Columns(ctx context.Context, db *sql.DB, schema, table string) ([]ColumnMeta, error) {
+	return mapColumnTypes(d)(scanColumns(db.QueryContext(ctx, `
+		SELECT c.column_name, c.ordinal_position, c.data_type, c.is_nullable = 'YES', c.column_default,
+			EXISTS (
+				SELECT 1
+				FROM duckdb_constraints() k
+				WHERE k.constraint_type = 'PRIMARY KEY'
+				AND k.schema_name = c.table_schema
+				AND k.table_name = c.table_name
+				AND list_contains(k.constraint_column_names, c.column_name)
+			)
+		FROM information_schema.columns c
+		WHERE c.table_schema = ? AND c.table_name = ?
+		ORDER BY c.ordinal_position
+	`, schema, table)))
+}
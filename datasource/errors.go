@@ -0,0 +1,42 @@
+package datasource
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// statementClasses are the SQLSTATE classes of errors caused by the
+// statement itself: bad syntax, unknown names, type mismatches, invalid
+// data and unsupported features.
+var statementClasses = map[string]bool{
+	"0A": true, // feature not supported
+	"21": true, // cardinality violation
+	"22": true, // data exception
+	"42": true, // syntax error or access rule violation
+}
+
+// IsStatementError reports whether err is the database rejecting the
+// query that was sent, as opposed to a failure to reach or use the
+// database, so callers can blame the query's author rather than the
+// service.
+func IsStatementError(err error) bool {
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) {
+		return statementClasses[string(pgErr.Code.Class())]
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return statementClasses[string(myErr.SQLState[:2])]
+	}
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.Code {
+		case sqlite3.ErrError, sqlite3.ErrMismatch, sqlite3.ErrRange, sqlite3.ErrTooBig:
+			return true
+		}
+	}
+	return false
+}
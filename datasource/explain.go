@@ -0,0 +1,64 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+var ErrExplainUnsupported = errors.New("driver does not support cost estimation")
+
+// Estimate is the planner's view of a query, obtained without running it.
+type Estimate struct {
+	Rows float64 `json:"rows"`
+	Cost float64 `json:"cost"`
+	// Relations are the tables the plan scans, schema-qualified where the
+	// dialect reports a schema.
+	Relations []string `json:"relations"`
+	// Scans are the same tables, for dialects whose plans name the real
+	// schema and table of each rather than the alias the query gave it.
+	// Views show up as the tables they read.
+	Scans []Relation `json:"-"`
+}
+
+// Relation is a schema-qualified table.
+type Relation struct {
+	Schema string
+	Name   string
+}
+
+// Explainer is implemented by drivers whose EXPLAIN output carries row
+// and cost estimates.
+type Explainer interface {
+	Explain(ctx context.Context, conn Conn, query string, args []interface{}) (*Estimate, error)
+}
+
+// NameResolver is implemented by drivers that can look unqualified names
+// up the way the source does when it runs a query, such as through
+// Postgres's search_path.
+type NameResolver interface {
+	// TableSchema returns the schema of the table or view name refers
+	// to, or "" when it refers to none.
+	TableSchema(ctx context.Context, conn Conn, name string) (string, error)
+
+	// FunctionSchemas returns the schemas on the lookup path holding a
+	// function called name.
+	FunctionSchemas(ctx context.Context, conn Conn, name string) ([]string, error)
+}
+
+func (s *Source) Explain(ctx context.Context, conn Conn, query string, args []interface{}) (*Estimate, error) {
+	ex, ok := s.Driver.(Explainer)
+	if !ok {
+		return nil, ErrExplainUnsupported
+	}
+	return ex.Explain(ctx, conn, query, args)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
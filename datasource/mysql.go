@@ -0,0 +1,153 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const MySQL = "mysql"
+
+type mysqlDriver struct{ prepareValidator }
+
+func init() { Register(mysqlDriver{}) }
+
+func (mysqlDriver) Name() string { return MySQL }
+
+func (mysqlDriver) Open(dsn string) (*sql.DB, error) {
+	return sql.Open("mysql", dsn)
+}
+
+// BeginRead ignores schema: a MySQL schema is a database, chosen by the
+// DSN, and USE would outlive the transaction.
+func (mysqlDriver) BeginRead(ctx context.Context, db *sql.DB, schema string) (*sql.Tx, error) {
+	return beginReadOnly(ctx, db)
+}
+
+func (mysqlDriver) ColumnType(dbType string) ColumnType {
+	switch baseType(dbType) {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR",
+		"UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT":
+		return TypeInteger
+	case "DECIMAL", "FLOAT", "DOUBLE":
+		return TypeNumber
+	case "BIT", "BOOL", "BOOLEAN":
+		return TypeBoolean
+	case "DATE":
+		return TypeDate
+	case "DATETIME", "TIMESTAMP":
+		return TypeTimestamp
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB":
+		return TypeBytes
+	case "JSON":
+		return TypeJSON
+	default:
+		return TypeString
+	}
+}
+
+func (mysqlDriver) LimitQuery(query string, n int) string { return wrapLimit(query, n) }
+
+func (mysqlDriver) PageQuery(query string, limit, offset int) string {
+	return wrapPage(query, limit, offset)
+}
+
+func (mysqlDriver) QuoteIdent(name string) string { return quoteWith(name, "`") }
+
+func (mysqlDriver) Placeholder(n int) string { return questionMark(n) }
+
+func (mysqlDriver) Schemas(ctx context.Context, db *sql.DB) ([]SchemaInfo, error) {
+	return scanSchemas(db.QueryContext(ctx, `
+		SELECT schema_name
+		FROM information_schema.schemata
+		WHERE schema_name NOT IN ('information_schema', 'mysql', 'performance_schema', 'sys')
+		ORDER BY schema_name
+	`))
+}
+
+// Tables uses information_schema.tables.table_rows, which InnoDB fills
+// with an estimate.
+func (mysqlDriver) Tables(ctx context.Context, db *sql.DB, schema string) ([]TableInfo, error) {
+	return scanTables(db.QueryContext(ctx, `
+		SELECT table_schema, table_name,
+			CASE table_type WHEN 'VIEW' THEN 'view' ELSE 'table' END,
+			table_rows
+		FROM information_schema.tables
+		WHERE table_schema = ?
+		ORDER BY table_name
+	`, schema))
+}
+
+func (d mysqlDriver) Columns(ctx context.Context, db *sql.DB, schema, table string) ([]ColumnMeta, error) {
+	return mapColumnTypes(d)(scanColumns(db.QueryContext(ctx, `
+		SELECT column_name, ordinal_position, data_type, is_nullable = 'YES', column_default, column_key = 'PRI'
+		FROM information_schema.columns
+		WHERE table_schema = ? AND table_name = ?
+		ORDER BY ordinal_position
+	`, schema, table)))
+}
+
+// Explain reads EXPLAIN FORMAT=JSON. MySQL reports one cost for the whole
+// query block; the row estimate is what the last table in the join order
+// produces.
+func (mysqlDriver) Explain(ctx context.Context, conn Conn, query string, args []interface{}) (*Estimate, error) {
+	var raw []byte
+	if err := conn.QueryRowContext(ctx, "EXPLAIN FORMAT=JSON "+query, args...).Scan(&raw); err != nil {
+		return nil, err
+	}
+
+	var plan map[string]interface{}
+	if err := json.Unmarshal(raw, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+
+	est := &Estimate{}
+	relations := make(map[string]bool)
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if info, ok := node["cost_info"].(map[string]interface{}); ok && est.Cost == 0 {
+				est.Cost = jsonNumber(info["query_cost"])
+			}
+			if name, ok := node["table_name"].(string); ok {
+				relations[name] = true
+				est.Rows = jsonNumber(node["rows_produced_per_join"])
+			}
+			// Visit keys in a fixed order so nested_loop tables are seen
+			// in join order.
+			keys := make([]string, 0, len(node))
+			for k := range node {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(node[k])
+			}
+		case []interface{}:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	walk(plan)
+
+	est.Relations = sortedKeys(relations)
+	return est, nil
+}
+
+// jsonNumber reads MySQL plan numbers, which are emitted either as JSON
+// numbers or as quoted strings depending on the field.
+func jsonNumber(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
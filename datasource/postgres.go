@@ -0,0 +1,207 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const Postgres = "postgres"
+
+type postgresDriver struct{ prepareValidator }
+
+func init() { Register(postgresDriver{}) }
+
+func (postgresDriver) Name() string { return Postgres }
+
+func (postgresDriver) Open(dsn string) (*sql.DB, error) {
+	return sql.Open("postgres", dsn)
+}
+
+// BeginRead sets search_path with SET LOCAL so it ends with the
+// transaction instead of leaking to the next user of the pooled connection.
+func (d postgresDriver) BeginRead(ctx context.Context, db *sql.DB, schema string) (*sql.Tx, error) {
+	tx, err := beginReadOnly(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if schema != "" {
+		if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO "+d.QuoteIdent(schema)); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to switch schema: %w", err)
+		}
+	}
+	return tx, nil
+}
+
+func (postgresDriver) ColumnType(dbType string) ColumnType {
+	switch baseType(dbType) {
+	case "INT2", "INT4", "INT8", "SMALLINT", "INTEGER", "BIGINT", "OID":
+		return TypeInteger
+	case "FLOAT4", "FLOAT8", "NUMERIC", "DECIMAL", "REAL", "MONEY":
+		return TypeNumber
+	case "BOOL", "BOOLEAN":
+		return TypeBoolean
+	case "DATE":
+		return TypeDate
+	case "TIMESTAMP", "TIMESTAMPTZ":
+		return TypeTimestamp
+	case "BYTEA":
+		return TypeBytes
+	case "JSON", "JSONB":
+		return TypeJSON
+	default:
+		return TypeString
+	}
+}
+
+func (postgresDriver) LimitQuery(query string, n int) string { return wrapLimit(query, n) }
+
+func (postgresDriver) PageQuery(query string, limit, offset int) string {
+	return wrapPage(query, limit, offset)
+}
+
+func (postgresDriver) QuoteIdent(name string) string { return quoteWith(name, `"`) }
+
+func (postgresDriver) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+func (postgresDriver) Schemas(ctx context.Context, db *sql.DB) ([]SchemaInfo, error) {
+	return scanSchemas(db.QueryContext(ctx, `
+		SELECT nspname
+		FROM pg_catalog.pg_namespace
+		WHERE nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'
+		ORDER BY nspname
+	`))
+}
+
+// Tables reads pg_class directly so views and row estimates (reltuples,
+// -1 until the table is first analyzed) come back in one query.
+func (postgresDriver) Tables(ctx context.Context, db *sql.DB, schema string) ([]TableInfo, error) {
+	return scanTables(db.QueryContext(ctx, `
+		SELECT n.nspname, c.relname,
+			CASE c.relkind
+				WHEN 'v' THEN 'view'
+				WHEN 'm' THEN 'materialized_view'
+				WHEN 'f' THEN 'foreign_table'
+				ELSE 'table'
+			END,
+			CASE WHEN c.reltuples < 0 OR c.relkind = 'v' THEN NULL ELSE c.reltuples::bigint END
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+		ORDER BY c.relname
+	`, schema))
+}
+
+// Columns reports udt_name rather than data_type so types map the same
+// way as the DatabaseTypeName lib/pq returns for query results.
+func (d postgresDriver) Columns(ctx context.Context, db *sql.DB, schema, table string) ([]ColumnMeta, error) {
+	return mapColumnTypes(d)(scanColumns(db.QueryContext(ctx, `
+		SELECT c.column_name, c.ordinal_position, c.udt_name, c.is_nullable = 'YES', c.column_default,
+			EXISTS (
+				SELECT 1
+				FROM pg_catalog.pg_index i
+				JOIN pg_catalog.pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY (i.indkey)
+				WHERE i.indisprimary
+				AND i.indrelid = format('%I.%I', c.table_schema, c.table_name)::regclass
+				AND a.attname = c.column_name
+			)
+		FROM information_schema.columns c
+		WHERE c.table_schema = $1 AND c.table_name = $2
+		ORDER BY c.ordinal_position
+	`, schema, table)))
+}
+
+type pgPlanNode struct {
+	RelationName string       `json:"Relation Name"`
+	Schema       string       `json:"Schema"`
+	TotalCost    float64      `json:"Total Cost"`
+	PlanRows     float64      `json:"Plan Rows"`
+	Plans        []pgPlanNode `json:"Plans"`
+}
+
+// Explain runs EXPLAIN (FORMAT JSON, VERBOSE); VERBOSE is what adds the
+// schema of each scanned relation.
+func (postgresDriver) Explain(ctx context.Context, conn Conn, query string, args []interface{}) (*Estimate, error) {
+	var raw []byte
+	if err := conn.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON, VERBOSE) "+query, args...).Scan(&raw); err != nil {
+		return nil, err
+	}
+
+	var plans []struct {
+		Plan pgPlanNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	if len(plans) == 0 {
+		return nil, errors.New("empty plan")
+	}
+
+	root := plans[0].Plan
+	relations := make(map[string]bool)
+	scans := make(map[Relation]bool)
+	var walk func(n pgPlanNode)
+	walk = func(n pgPlanNode) {
+		if n.RelationName != "" {
+			name := n.RelationName
+			if n.Schema != "" {
+				name = n.Schema + "." + name
+			}
+			relations[name] = true
+			scans[Relation{Schema: n.Schema, Name: n.RelationName}] = true
+		}
+		for _, child := range n.Plans {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	est := &Estimate{Rows: root.PlanRows, Cost: root.TotalCost, Relations: sortedKeys(relations)}
+	for r := range scans {
+		est.Scans = append(est.Scans, r)
+	}
+	return est, nil
+}
+
+// TableSchema resolves name with to_regclass, which follows search_path,
+// including the pg_catalog schema Postgres searches before it.
+func (d postgresDriver) TableSchema(ctx context.Context, conn Conn, name string) (string, error) {
+	var schema string
+	err := conn.QueryRowContext(ctx, `
+		SELECT n.nspname
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.oid = pg_catalog.to_regclass($1)
+	`, d.QuoteIdent(name)).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return schema, err
+}
+
+func (postgresDriver) FunctionSchemas(ctx context.Context, conn Conn, name string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT DISTINCT n.nspname
+		FROM pg_catalog.pg_proc p
+		JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+		WHERE p.proname = $1 AND n.nspname = ANY (pg_catalog.current_schemas(true))
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, rows.Err()
+}
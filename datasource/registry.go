@@ -0,0 +1,443 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nishantd01/penguin-core/secrets"
+)
+
+var (
+	ErrUnknownSource = errors.New("unknown data source")
+	ErrSourceExists  = errors.New("a data source with this name already exists")
+	ErrInvalidSource = errors.New("invalid data source")
+)
+
+// validName restricts source names to what a secret name can embed.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,99}$`)
+
+// Config is one row of penguin.snowflake_databases.
+type Config struct {
+	Name string `json:"name"`
+	// WorkspaceId owns the source; other workspaces cannot query it.
+	WorkspaceId string `json:"workspace_id"`
+	Driver      string `json:"driver"`
+	DSNRef      string `json:"-"`
+	// DefaultSchema resolves unqualified table names in report queries,
+	// for dialects that support per-transaction schemas.
+	DefaultSchema string `json:"default_schema,omitempty"`
+	// Role is the Postgres role read transactions switch to, with the
+	// setting penguin.workspace_id set to WorkspaceId. A source on the
+	// service's own database uses one to see only what the role is granted.
+	Role            string        `json:"role,omitempty"`
+	MaxOpenConns    int           `json:"max_open_conns"`
+	MaxIdleConns    int           `json:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`
+}
+
+// Source is a registered data source with its open connection pool.
+type Source struct {
+	Config
+	DB     *sql.DB
+	Driver Driver
+
+	// shared is set when DB is the service's own connection, which must
+	// not be reconfigured or closed by the registry.
+	shared bool
+
+	// secretVersion is the version of the secret the DSN came from, for
+	// "secret:" references; a newer one reopens the pool.
+	secretVersion int
+
+	// checkedAt is when Config was last compared with the registry row.
+	checkedAt time.Time
+
+	// mu guards the fields below, which let a replaced pool be closed
+	// once nobody is using it any more.
+	mu      sync.Mutex
+	active  int
+	retired bool
+	closed  bool
+}
+
+// configTTL is how long a source's registry row is trusted before Get
+// reads it again. Changes to a source take up to this long to apply.
+const configTTL = 30 * time.Second
+
+// retireGrace is how long a replaced pool stays open for callers that got
+// it from Get but have not started using it yet. Tests shorten it.
+var retireGrace = time.Minute
+
+// Registry hands out one connection pool per data source listed in
+// penguin.snowflake_databases. Pools are opened lazily and reopened when
+// the registry row or the DSN secret changes; rows are re-read at most
+// once per configTTL.
+type Registry struct {
+	meta *sql.DB
+
+	// secrets resolves "secret:" DSN references; nil disables them.
+	secrets *secrets.Store
+
+	// defaultName is used when a request does not name a source.
+	defaultName string
+
+	// sqliteDir holds the files SQLite sources added with Add may open;
+	// empty refuses them.
+	sqliteDir string
+
+	mu    sync.Mutex
+	pools map[string]*Source
+}
+
+func NewRegistry(meta *sql.DB, store *secrets.Store, defaultName, sqliteDir string) *Registry {
+	return &Registry{meta: meta, secrets: store, defaultName: defaultName, sqliteDir: sqliteDir, pools: make(map[string]*Source)}
+}
+
+const selectConfig = `
+	SELECT database_name, workspace_id, driver, COALESCE(dsn_ref, ''), COALESCE(default_schema, ''), COALESCE(db_role, ''),
+		max_open_conns, max_idle_conns, conn_max_lifetime_seconds
+	FROM penguin.snowflake_databases
+`
+
+// List returns the sources of a workspace.
+func (r *Registry) List(workspaceId string) ([]Config, error) {
+	rows, err := r.meta.Query(selectConfig+" WHERE workspace_id = $1 ORDER BY database_name", workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configs []Config
+	for rows.Next() {
+		cfg, err := scanConfig(rows)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, *cfg)
+	}
+	return configs, rows.Err()
+}
+
+// Add registers a source for cfg.WorkspaceId. Its DSN is checked by
+// connecting and stored as the secret source.<name>.dsn, which dsn_ref then
+// points to, so a source never references credentials it was not given.
+// SQLite files must be inside the registry's SQLite directory and Postgres
+// DSNs must not lead to the metadata database.
+func (r *Registry) Add(ctx context.Context, cfg Config, dsn string) error {
+	if r.secrets == nil {
+		return fmt.Errorf("%w: storing a DSN needs secret storage, which is disabled", ErrInvalidSource)
+	}
+	if !validName.MatchString(cfg.Name) {
+		return fmt.Errorf("%w: name %q", ErrInvalidSource, cfg.Name)
+	}
+	driver, err := LookupDriver(cfg.Driver)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+	if dsn, err = r.checkDSN(cfg.Driver, dsn); err != nil {
+		return err
+	}
+	var exists bool
+	err = r.meta.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM penguin.snowflake_databases WHERE database_name = $1)`, cfg.Name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrSourceExists, cfg.Name)
+	}
+
+	db, err := driver.Open(dsn)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("%w: cannot connect: %v", ErrInvalidSource, err)
+	}
+	if cfg.Driver == Postgres {
+		same, err := sameDatabase(ctx, r.meta, db)
+		if err == nil && same {
+			err = fmt.Errorf("%w: the DSN leads to the service's own database", ErrInvalidSource)
+		}
+		if err != nil {
+			db.Close()
+			return err
+		}
+	}
+	db.Close()
+
+	// The row goes first: a concurrent Add of the same name fails on it
+	// before overwriting the other's secret
+	secretName := "source." + cfg.Name + ".dsn"
+	_, err = r.meta.ExecContext(ctx, `
+		INSERT INTO penguin.snowflake_databases (database_name, workspace_id, driver, dsn_ref, default_schema,
+			max_open_conns, max_idle_conns, conn_max_lifetime_seconds)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)
+	`, cfg.Name, cfg.WorkspaceId, cfg.Driver, "secret:"+secretName, cfg.DefaultSchema,
+		cfg.MaxOpenConns, cfg.MaxIdleConns, int(cfg.ConnMaxLifetime/time.Second))
+	if err != nil {
+		return err
+	}
+	if _, err := r.secrets.Put(ctx, secretName, []byte(dsn)); err != nil {
+		r.meta.ExecContext(ctx, `DELETE FROM penguin.snowflake_databases WHERE database_name = $1`, cfg.Name)
+		return err
+	}
+	return nil
+}
+
+// Get returns the pool for the named source, or the default source when
+// name is empty. Names not present in the registry yield ErrUnknownSource.
+func (r *Registry) Get(name string) (*Source, error) {
+	if name == "" {
+		name = r.defaultName
+	}
+
+	r.mu.Lock()
+	src, ok := r.pools[name]
+	if ok && time.Since(src.checkedAt) < configTTL {
+		r.mu.Unlock()
+		return src, nil
+	}
+	r.mu.Unlock()
+
+	// The meta database, the secret store and the new pool's ping are
+	// all reached without holding the lock
+	cfg, err := scanConfig(r.meta.QueryRow(selectConfig+" WHERE database_name = $1", name))
+	if err == sql.ErrNoRows {
+		r.replace(name, src, nil)
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSource, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && src.Config == *cfg && r.secretCurrent(src) {
+		r.mu.Lock()
+		src.checkedAt = time.Now()
+		r.mu.Unlock()
+		return src, nil
+	}
+
+	fresh, err := r.open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	fresh.checkedAt = time.Now()
+	return r.replace(name, src, fresh), nil
+}
+
+// replace installs fresh as the pool of name in place of old, retiring
+// old. When another caller replaced old first, its pool wins and fresh is
+// closed instead.
+func (r *Registry) replace(name string, old, fresh *Source) *Source {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if current, ok := r.pools[name]; ok && current != old {
+		if fresh != nil {
+			fresh.close()
+		}
+		return current
+	}
+	if old != nil {
+		old.retire()
+	}
+	if fresh == nil {
+		delete(r.pools, name)
+	} else {
+		r.pools[name] = fresh
+	}
+	return fresh
+}
+
+func (r *Registry) open(cfg *Config) (*Source, error) {
+	driver, err := LookupDriver(cfg.Driver)
+	if err != nil {
+		return nil, fmt.Errorf("data source %q: %w", cfg.Name, err)
+	}
+
+	if cfg.Role != "" && cfg.Driver != Postgres {
+		return nil, fmt.Errorf("data source %q: db_role is only supported for %s sources", cfg.Name, Postgres)
+	}
+	if cfg.DSNRef == "" {
+		if cfg.Driver != Postgres {
+			return nil, fmt.Errorf("data source %q: dsn_ref is required for %s sources", cfg.Name, cfg.Driver)
+		}
+		return &Source{Config: *cfg, DB: r.meta, Driver: driver, shared: true}, nil
+	}
+
+	dsn, version, err := r.resolveDSN(cfg.DSNRef)
+	if err != nil {
+		return nil, fmt.Errorf("data source %q: %w", cfg.Name, err)
+	}
+
+	db, err := driver.Open(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("data source %q: %w", cfg.Name, err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("data source %q: %w", cfg.Name, err)
+	}
+
+	log.Printf("Opened connection pool for data source %s (%s)", cfg.Name, cfg.Driver)
+	return &Source{Config: *cfg, DB: db, Driver: driver, secretVersion: version}, nil
+}
+
+// ReadTx runs fn inside a read-only transaction on the source, scoped to
+// its default schema and role. The transaction is always rolled back, so
+// nothing fn does can persist.
+func (s *Source) ReadTx(ctx context.Context, fn func(conn Conn) error) error {
+	return s.Use(func(db *sql.DB) error {
+		tx, err := s.Driver.BeginRead(ctx, db, s.DefaultSchema)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if s.Role != "" {
+			if _, err := tx.ExecContext(ctx, "SET LOCAL ROLE "+s.Driver.QuoteIdent(s.Role)); err != nil {
+				return fmt.Errorf("failed to switch role: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, "SELECT set_config('penguin.workspace_id', $1, true)", s.WorkspaceId); err != nil {
+				return fmt.Errorf("failed to set workspace: %w", err)
+			}
+		}
+		return fn(tx)
+	})
+}
+
+// Use runs fn with the source's pool, which is kept open until fn
+// returns even if the registry replaces it meanwhile.
+func (s *Source) Use(fn func(db *sql.DB) error) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("data source %q was reconfigured, retry the request", s.Name)
+	}
+	s.active++
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		idle := s.retired && s.active == 0
+		s.mu.Unlock()
+		if idle {
+			s.close()
+		}
+	}()
+	return fn(s.DB)
+}
+
+// retire closes the pool once it is idle, after giving callers that
+// already hold it retireGrace to start using it.
+func (s *Source) retire() {
+	time.AfterFunc(retireGrace, func() {
+		s.mu.Lock()
+		s.retired = true
+		idle := s.active == 0
+		s.mu.Unlock()
+		if idle {
+			s.close()
+		}
+	})
+}
+
+// Close closes every pool opened by the registry.
+func (r *Registry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, src := range r.pools {
+		src.close()
+		delete(r.pools, name)
+	}
+}
+
+func (s *Source) close() {
+	s.mu.Lock()
+	done := s.closed
+	s.closed = true
+	s.mu.Unlock()
+	if done || s.shared {
+		return
+	}
+	if err := s.DB.Close(); err != nil {
+		log.Printf("Failed to close data source %s: %v", s.Name, err)
+	}
+}
+
+// resolveDSN turns a dsn_ref into a connection string, so credentials
+// never live in the registry. "env:NAME" reads an environment variable and
+// "secret:NAME" an encrypted secret, whose version it also returns.
+func (r *Registry) resolveDSN(ref string) (string, int, error) {
+	kind, name, ok := strings.Cut(ref, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed dsn_ref %q", ref)
+	}
+
+	switch kind {
+	case "env":
+		dsn := os.Getenv(name)
+		if dsn == "" {
+			return "", 0, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return dsn, 0, nil
+	case "secret":
+		if r.secrets == nil {
+			return "", 0, fmt.Errorf("dsn_ref %q needs secret storage, which is disabled", ref)
+		}
+		dsn, version, err := r.secrets.GetVersion(context.Background(), name)
+		if err != nil {
+			return "", 0, err
+		}
+		return string(dsn), version, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported dsn_ref kind %q", kind)
+	}
+}
+
+// secretCurrent reports whether src was opened with the current version of
+// its DSN secret. Sources not using one are always current.
+func (r *Registry) secretCurrent(src *Source) bool {
+	kind, name, _ := strings.Cut(src.DSNRef, ":")
+	if kind != "secret" || r.secrets == nil {
+		return true
+	}
+	version, err := r.secrets.Version(context.Background(), name)
+	if err != nil {
+		// Keep serving from the open pool; reopening would fail anyway
+		log.Printf("Failed to check secret of data source %s: %v", src.Name, err)
+		return true
+	}
+	return version == src.secretVersion
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanConfig(row rowScanner) (*Config, error) {
+	var cfg Config
+	var lifetimeSeconds int
+	err := row.Scan(&cfg.Name, &cfg.WorkspaceId, &cfg.Driver, &cfg.DSNRef, &cfg.DefaultSchema, &cfg.Role,
+		&cfg.MaxOpenConns, &cfg.MaxIdleConns, &lifetimeSeconds)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ConnMaxLifetime = time.Duration(lifetimeSeconds) * time.Second
+	return &cfg, nil
+}
@@ -0,0 +1,165 @@
+package datasource
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nishantd01/penguin-core/internal/testdb"
+)
+
+const registrySchema = `
+CREATE TABLE penguin.snowflake_databases (
+    database_name TEXT PRIMARY KEY,
+    workspace_id TEXT NOT NULL,
+    driver TEXT NOT NULL DEFAULT 'postgres',
+    dsn_ref TEXT,
+    default_schema TEXT,
+    db_role TEXT,
+    max_open_conns INT NOT NULL DEFAULT 10,
+    max_idle_conns INT NOT NULL DEFAULT 2,
+    conn_max_lifetime_seconds INT NOT NULL DEFAULT 300
+);
+INSERT INTO penguin.snowflake_databases (database_name, workspace_id, driver, dsn_ref) VALUES
+('sales', 'w1', 'sqlite', 'env:SALES_DSN'),
+('hr', 'w2', 'sqlite', 'env:HR_DSN');
+`
+
+// sqliteFile creates a database file whose only table holds name.
+func sqliteFile(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name+".db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE source (name TEXT); INSERT INTO source VALUES (?)`, name); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sourceName(t *testing.T, src *Source) string {
+	t.Helper()
+	var name string
+	err := src.Use(func(db *sql.DB) error {
+		return db.QueryRow(`SELECT name FROM source`).Scan(&name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func isClosed(src *Source) bool {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return src.closed
+}
+
+func TestRegistryGet(t *testing.T) {
+	t.Setenv("SALES_DSN", sqliteFile(t, "sales"))
+	t.Setenv("HR_DSN", sqliteFile(t, "hr"))
+	r := NewRegistry(testdb.Open(t, registrySchema), nil, "sales", "")
+	defer r.Close()
+
+	if _, err := r.Get("missing"); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("Get(missing) = %v, want ErrUnknownSource", err)
+	}
+
+	src, err := r.Get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Name != "sales" || src.WorkspaceId != "w1" || sourceName(t, src) != "sales" {
+		t.Errorf("default source is %s of %s", src.Name, src.WorkspaceId)
+	}
+	if again, err := r.Get("sales"); err != nil || again != src {
+		t.Errorf("Get opened a second pool: %v", err)
+	}
+
+	// An unchanged row keeps the pool after configTTL too
+	src.checkedAt = time.Now().Add(-configTTL)
+	if again, err := r.Get("sales"); err != nil || again != src {
+		t.Errorf("Get reopened an unchanged source: %v", err)
+	}
+
+	configs, err := r.List("w2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].Name != "hr" {
+		t.Errorf("List(w2) = %+v", configs)
+	}
+}
+
+func TestRegistryReplace(t *testing.T) {
+	defer func(grace time.Duration) { retireGrace = grace }(retireGrace)
+	retireGrace = 10 * time.Millisecond
+
+	t.Setenv("SALES_DSN", sqliteFile(t, "sales"))
+	t.Setenv("ARCHIVE_DSN", sqliteFile(t, "archive"))
+	meta := testdb.Open(t, registrySchema)
+	r := NewRegistry(meta, nil, "sales", "")
+	defer r.Close()
+
+	old, err := r.Get("sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A caller still using the old pool keeps it open past the grace
+	inUse, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- old.Use(func(db *sql.DB) error {
+			close(inUse)
+			<-release
+			return db.Ping()
+		})
+	}()
+	<-inUse
+
+	if _, err := meta.Exec(`UPDATE penguin.snowflake_databases SET dsn_ref = 'env:ARCHIVE_DSN' WHERE database_name = 'sales'`); err != nil {
+		t.Fatal(err)
+	}
+	old.checkedAt = time.Now().Add(-configTTL)
+	fresh, err := r.Get("sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == old || sourceName(t, fresh) != "archive" {
+		t.Fatal("Get did not reopen the source after its dsn_ref changed")
+	}
+
+	time.Sleep(5 * retireGrace)
+	if isClosed(old) {
+		t.Fatal("replaced pool closed while in use")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("query on the replaced pool failed: %v", err)
+	}
+	if !isClosed(old) {
+		t.Error("replaced pool left open once idle")
+	}
+	if err := old.Use(func(db *sql.DB) error { return nil }); err == nil {
+		t.Error("closed pool still usable")
+	}
+
+	// Removing the row retires the pool and forgets the source
+	if _, err := meta.Exec(`DELETE FROM penguin.snowflake_databases WHERE database_name = 'sales'`); err != nil {
+		t.Fatal(err)
+	}
+	fresh.checkedAt = time.Now().Add(-configTTL)
+	if _, err := r.Get("sales"); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("Get of a removed source = %v, want ErrUnknownSource", err)
+	}
+	time.Sleep(5 * retireGrace)
+	if !isClosed(fresh) {
+		t.Error("pool of a removed source left open")
+	}
+}
@@ -0,0 +1,8 @@
+package datasource
+
+// The database/sql drivers behind the dialects. go-sqlite3 needs cgo.
+import (
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
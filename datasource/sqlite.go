@@ -0,0 +1,98 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+const SQLite = "sqlite"
+
+type sqliteDriver struct{ prepareValidator }
+
+func init() { Register(sqliteDriver{}) }
+
+func (sqliteDriver) Name() string { return SQLite }
+
+// Open expects a file path or "file:" URI. Analytics files are opened
+// read-only unless the DSN says otherwise.
+func (sqliteDriver) Open(dsn string) (*sql.DB, error) {
+	if !strings.Contains(dsn, "mode=") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		if !strings.HasPrefix(dsn, "file:") {
+			dsn = "file:" + dsn
+		}
+		dsn += sep + "mode=ro"
+	}
+	return sql.Open("sqlite3", dsn)
+}
+
+// BeginRead starts a plain transaction; the file itself is opened
+// read-only, see Open.
+func (sqliteDriver) BeginRead(ctx context.Context, db *sql.DB, schema string) (*sql.Tx, error) {
+	return db.BeginTx(ctx, nil)
+}
+
+// ColumnType follows SQLite's type affinity rules, since declared types
+// are free-form text.
+func (sqliteDriver) ColumnType(dbType string) ColumnType {
+	t := baseType(dbType)
+	switch {
+	case t == "":
+		return TypeString
+	case t == "BOOLEAN" || t == "BOOL":
+		return TypeBoolean
+	case t == "DATE":
+		return TypeDate
+	case t == "DATETIME" || t == "TIMESTAMP":
+		return TypeTimestamp
+	case strings.Contains(t, "INT"):
+		return TypeInteger
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return TypeString
+	case strings.Contains(t, "BLOB"):
+		return TypeBytes
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"),
+		strings.Contains(t, "NUMERIC"), strings.Contains(t, "DECIMAL"):
+		return TypeNumber
+	default:
+		return TypeString
+	}
+}
+
+func (sqliteDriver) LimitQuery(query string, n int) string { return wrapLimit(query, n) }
+
+func (sqliteDriver) PageQuery(query string, limit, offset int) string {
+	return wrapPage(query, limit, offset)
+}
+
+func (sqliteDriver) QuoteIdent(name string) string { return quoteWith(name, `"`) }
+
+func (sqliteDriver) Placeholder(n int) string { return questionMark(n) }
+
+// Schemas lists the attached databases, "main" being the opened file.
+func (sqliteDriver) Schemas(ctx context.Context, db *sql.DB) ([]SchemaInfo, error) {
+	return scanSchemas(db.QueryContext(ctx, `SELECT name FROM pragma_database_list ORDER BY seq`))
+}
+
+// Tables has no row estimates to offer: SQLite only keeps sqlite_stat1
+// after ANALYZE, and then per index rather than per table.
+func (d sqliteDriver) Tables(ctx context.Context, db *sql.DB, schema string) ([]TableInfo, error) {
+	return scanTables(db.QueryContext(ctx, `
+		SELECT ?, name, type, NULL
+		FROM `+d.QuoteIdent(schema)+`.sqlite_master
+		WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+		ORDER BY name
+	`, schema))
+}
+
+func (d sqliteDriver) Columns(ctx context.Context, db *sql.DB, schema, table string) ([]ColumnMeta, error) {
+	return mapColumnTypes(d)(scanColumns(db.QueryContext(ctx, `
+		SELECT name, cid + 1, type, "notnull" = 0, dflt_value, pk > 0
+		FROM pragma_table_info(?, ?)
+		ORDER BY cid
+	`, table, schema)))
+}
@@ -0,0 +1,34 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), record: make([]string, len(columns))}
+	for i, c := range columns {
+		cw.record[i] = c.Name
+	}
+	if err := cw.w.Write(cw.record); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) WriteRow(values []interface{}) error {
+	for i, v := range values {
+		cw.record[i] = formatText(v)
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
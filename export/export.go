@@ -0,0 +1,112 @@
+// Package export writes query results to files: CSV, XLSX, JSON Lines
+// and Parquet. Writers stream rows as they are produced, except Parquet,
+// which buffers one row group at a time.
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nishantd01/penguin-core/datasource"
+)
+
+type Format string
+
+const (
+	CSV     Format = "csv"
+	XLSX    Format = "xlsx"
+	JSONL   Format = "jsonl"
+	Parquet Format = "parquet"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format, use csv, xlsx, jsonl or parquet")
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case CSV, XLSX, JSONL, Parquet:
+		return f, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case JSONL:
+		return "application/x-ndjson"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+// Column describes one column of the exported file.
+type Column struct {
+	Name string                `json:"name"`
+	Type datasource.ColumnType `json:"type"`
+}
+
+// Writer receives the columns once and then the rows, with values as
+// returned by datasource.ConvertValue, or json.Number for numbers decoded
+// from stored snapshots. Close flushes buffered data and
+// writes any trailer; it does not close the underlying io.Writer.
+type Writer interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// NewWriter starts a file of the given format. Column names are made
+// unique first, since JSON Lines keys and Parquet fields must be.
+func NewWriter(f Format, w io.Writer, columns []Column) (Writer, error) {
+	columns = uniqueNames(columns)
+	switch f {
+	case CSV:
+		return newCSVWriter(w, columns)
+	case XLSX:
+		return newXLSXWriter(w, columns)
+	case JSONL:
+		return newJSONLWriter(w, columns), nil
+	case Parquet:
+		return newParquetWriter(w, columns), nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// uniqueNames returns the columns with empty names replaced by column_N
+// and repeated names suffixed _2, _3 and so on, in column order.
+func uniqueNames(columns []Column) []Column {
+	renamed := make([]Column, len(columns))
+	seen := make(map[string]bool, len(columns))
+	for i, c := range columns {
+		base := c.Name
+		if base == "" {
+			base = fmt.Sprintf("column_%d", i+1)
+		}
+		c.Name = base
+		for n := 2; seen[c.Name]; n++ {
+			c.Name = fmt.Sprintf("%s_%d", base, n)
+		}
+		seen[c.Name] = true
+		renamed[i] = c
+	}
+	return renamed
+}
+
+// formatText renders a value for text-only formats.
+func formatText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,133 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/datasource"
+)
+
+var testColumns = []Column{
+	{Name: "id", Type: datasource.TypeInteger},
+	{Name: "name", Type: datasource.TypeString},
+	{Name: "amount", Type: datasource.TypeNumber},
+	{Name: "ok", Type: datasource.TypeBoolean},
+}
+
+var testRows = [][]interface{}{
+	{int64(1), "a, \"quoted\"", 1.5, true},
+	{json.Number("9007199254740993"), nil, nil, false},
+	{int64(-3), "<x & y>", 0.1, nil},
+}
+
+func writeFile(t *testing.T, f Format, columns []Column, rows [][]interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(f, &buf, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	got := string(writeFile(t, CSV, testColumns, testRows))
+	want := "id,name,amount,ok\n" +
+		"1,\"a, \"\"quoted\"\"\",1.5,true\n" +
+		"9007199254740993,,,false\n" +
+		"-3,<x & y>,0.1,\n"
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	got := string(writeFile(t, JSONL, testColumns, testRows))
+	want := `{"id":1,"name":"a, \"quoted\"","amount":1.5,"ok":true}` + "\n" +
+		`{"id":9007199254740993,"name":null,"amount":null,"ok":false}` + "\n" +
+		`{"id":-3,"name":"\u003cx \u0026 y\u003e","amount":0.1,"ok":null}` + "\n"
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	data := writeFile(t, XLSX, testColumns, testRows)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet = string(b)
+	}
+
+	for _, want := range []string{
+		`<c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`,
+		`<c r="A2"><v>1</v></c>`,
+		`<c r="B2" t="inlineStr"><is><t xml:space="preserve">a, &#34;quoted&#34;</t></is></c>`,
+		`<c r="D2" t="b"><v>1</v></c>`,
+		`<row r="3"><c r="A3"><v>9007199254740993</v></c><c r="D3" t="b"><v>0</v></c></row>`,
+		`<c r="B4" t="inlineStr"><is><t xml:space="preserve">&lt;x &amp; y&gt;</t></is></c>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet is missing %s", want)
+		}
+	}
+	if !strings.HasSuffix(sheet, `</sheetData></worksheet>`) {
+		t.Errorf("sheet is not closed: %s", sheet)
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	columns := []Column{{Name: "id"}, {Name: "id"}, {Name: ""}, {Name: "id_2"}, {Name: "id"}}
+	var got []string
+	for _, c := range uniqueNames(columns) {
+		got = append(got, c.Name)
+	}
+	want := []string{"id", "id_2", "column_3", "id_2_2", "id_3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("uniqueNames = %v, want %v", got, want)
+	}
+	if columns[1].Name != "id" {
+		t.Fatalf("uniqueNames changed its argument")
+	}
+
+	line := string(writeFile(t, JSONL, columns[:2], [][]interface{}{{1, 2}}))
+	if want := `{"id":1,"id_2":2}` + "\n"; line != want {
+		t.Fatalf("got %s, want %s", line, want)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
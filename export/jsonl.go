@@ -0,0 +1,44 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// jsonlWriter writes one JSON object per row, keeping the column order.
+type jsonlWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+func newJSONLWriter(w io.Writer, columns []Column) *jsonlWriter {
+	jw := &jsonlWriter{w: bufio.NewWriter(w), keys: make([][]byte, len(columns))}
+	for i, c := range columns {
+		jw.keys[i], _ = json.Marshal(c.Name)
+	}
+	return jw
+}
+
+func (jw *jsonlWriter) WriteRow(values []interface{}) error {
+	jw.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			jw.w.WriteByte(',')
+		}
+		val, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		jw.w.Write(jw.keys[i])
+		jw.w.WriteByte(':')
+		jw.w.Write(val)
+	}
+	// bufio.Writer errors are sticky, so this reports any failed write
+	_, err := jw.w.WriteString("}\n")
+	return err
+}
+
+func (jw *jsonlWriter) Close() error {
+	return jw.w.Flush()
+}
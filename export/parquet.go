@@ -0,0 +1,351 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/nishantd01/penguin-core/datasource"
+)
+
+// Parquet physical types, encodings and converted types used here. Every
+// column is written OPTIONAL, PLAIN encoded and uncompressed, with one
+// data page per column chunk.
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional  = 1
+	parquetPlain     = 0
+	parquetRLE       = 3
+	parquetDataPage  = 0
+	parquetUTF8      = 0
+	parquetNoConvert = -1
+)
+
+// parquetRowGroupRows is how many rows are buffered before a row group
+// is written out.
+const parquetRowGroupRows = 10000
+
+var parquetMagic = []byte("PAR1")
+
+type parquetColumn struct {
+	name      string
+	physical  int32
+	converted int32
+	present   []bool
+	bools     []bool
+	values    bytes.Buffer // PLAIN encoded non-null values, except booleans
+}
+
+type parquetChunk struct {
+	column     *parquetColumn
+	numValues  int64
+	size       int64
+	pageOffset int64
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int64
+}
+
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []*parquetColumn
+	rows    int
+	groups  []parquetRowGroup
+}
+
+func newParquetWriter(w io.Writer, columns []Column) *parquetWriter {
+	pw := &parquetWriter{w: w, columns: make([]*parquetColumn, len(columns))}
+	for i, c := range columns {
+		col := &parquetColumn{name: c.Name, physical: parquetByteArray, converted: parquetUTF8}
+		switch c.Type {
+		case datasource.TypeInteger:
+			col.physical, col.converted = parquetInt64, parquetNoConvert
+		case datasource.TypeNumber:
+			col.physical, col.converted = parquetDouble, parquetNoConvert
+		case datasource.TypeBoolean:
+			col.physical, col.converted = parquetBoolean, parquetNoConvert
+		}
+		pw.columns[i] = col
+	}
+	return pw
+}
+
+func (pw *parquetWriter) WriteRow(values []interface{}) error {
+	for i, v := range values {
+		if err := pw.columns[i].append(v); err != nil {
+			return err
+		}
+	}
+	pw.rows++
+	if pw.rows == parquetRowGroupRows {
+		return pw.flush()
+	}
+	return nil
+}
+
+func (c *parquetColumn) append(v interface{}) error {
+	if v == nil {
+		c.present = append(c.present, false)
+		return nil
+	}
+
+	var buf [8]byte
+	switch c.physical {
+	case parquetInt64:
+		n, ok := toInt64(v)
+		if !ok {
+			return fmt.Errorf("column %s: cannot write %v as an integer", c.name, v)
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(n))
+		c.values.Write(buf[:])
+	case parquetDouble:
+		f, ok := toFloat64(v)
+		if !ok {
+			return fmt.Errorf("column %s: cannot write %v as a number", c.name, v)
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		c.values.Write(buf[:])
+	case parquetBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			var err error
+			if b, err = strconv.ParseBool(formatText(v)); err != nil {
+				return fmt.Errorf("column %s: cannot write %v as a boolean", c.name, v)
+			}
+		}
+		c.bools = append(c.bools, b)
+	default:
+		s := formatText(v)
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(s)))
+		c.values.Write(buf[:4])
+		c.values.WriteString(s)
+	}
+	c.present = append(c.present, true)
+	return nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case float64:
+		return int64(n), n == math.Trunc(n)
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	if i, ok := toInt64(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+func (pw *parquetWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+// flush writes the buffered rows as one row group.
+func (pw *parquetWriter) flush() error {
+	if pw.offset == 0 {
+		if err := pw.write(parquetMagic); err != nil {
+			return err
+		}
+	}
+	if pw.rows == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{numRows: int64(pw.rows)}
+	for _, c := range pw.columns {
+		body := c.page()
+
+		var header bytes.Buffer
+		ph := newThriftStruct(&header)
+		ph.i32(1, parquetDataPage)
+		ph.i32(2, int32(len(body)))
+		ph.i32(3, int32(len(body)))
+		dph := ph.child(5)
+		dph.i32(1, int32(len(c.present)))
+		dph.i32(2, parquetPlain)
+		dph.i32(3, parquetRLE)
+		dph.i32(4, parquetRLE)
+		dph.end()
+		ph.end()
+
+		chunk := parquetChunk{
+			column:     c,
+			numValues:  int64(len(c.present)),
+			size:       int64(header.Len() + len(body)),
+			pageOffset: pw.offset,
+		}
+		if err := pw.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(body); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+
+		c.present, c.bools = c.present[:0], c.bools[:0]
+		c.values.Reset()
+	}
+	pw.groups = append(pw.groups, group)
+	pw.rows = 0
+	return nil
+}
+
+// page returns a v1 data page body: the definition levels, RLE/bit-packed
+// hybrid encoded with a length prefix, followed by the non-null values.
+func (c *parquetColumn) page() []byte {
+	var levels bytes.Buffer
+	groups := (len(c.present) + 7) / 8
+	writeVarint(&levels, uint64(groups)<<1|1) // a single bit-packed run
+	levels.Write(packBits(c.present, groups))
+
+	var body bytes.Buffer
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(levels.Len()))
+	body.Write(size[:])
+	body.Write(levels.Bytes())
+
+	if c.physical == parquetBoolean {
+		body.Write(packBits(c.bools, (len(c.bools)+7)/8))
+	} else {
+		body.Write(c.values.Bytes())
+	}
+	return body.Bytes()
+}
+
+// packBits packs bits LSB first into n bytes.
+func packBits(bits []bool, n int) []byte {
+	packed := make([]byte, n)
+	for i, b := range bits {
+		if b {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+// Close writes the last row group and the file footer.
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	var meta bytes.Buffer
+	fm := newThriftStruct(&meta)
+	fm.i32(1, 1)
+
+	fm.list(2, thriftStruct, len(pw.columns)+1)
+	root := newThriftStruct(&meta)
+	root.str(4, "schema")
+	root.i32(5, int32(len(pw.columns)))
+	root.end()
+	for _, c := range pw.columns {
+		se := newThriftStruct(&meta)
+		se.i32(1, c.physical)
+		se.i32(3, parquetOptional)
+		se.str(4, c.name)
+		if c.converted != parquetNoConvert {
+			se.i32(6, c.converted)
+		}
+		se.end()
+	}
+
+	var numRows int64
+	for _, g := range pw.groups {
+		numRows += g.numRows
+	}
+	fm.i64(3, numRows)
+
+	fm.list(4, thriftStruct, len(pw.groups))
+	for _, g := range pw.groups {
+		rg := newThriftStruct(&meta)
+		rg.list(1, thriftStruct, len(g.chunks))
+		var total int64
+		for _, ch := range g.chunks {
+			cc := newThriftStruct(&meta)
+			cc.i64(2, ch.pageOffset)
+			md := cc.child(3)
+			md.i32(1, ch.column.physical)
+			md.listI32(2, parquetPlain, parquetRLE)
+			md.list(3, thriftBinary, 1)
+			writeVarint(&meta, uint64(len(ch.column.name)))
+			meta.WriteString(ch.column.name)
+			md.i32(4, 0) // UNCOMPRESSED
+			md.i64(5, ch.numValues)
+			md.i64(6, ch.size)
+			md.i64(7, ch.size)
+			md.i64(9, ch.pageOffset)
+			md.end()
+			cc.end()
+			total += ch.size
+		}
+		rg.i64(2, total)
+		rg.i64(3, g.numRows)
+		rg.end()
+	}
+	fm.str(6, "penguin-core")
+	fm.end()
+
+	if err := pw.write(meta.Bytes()); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(meta.Len()))
+	if err := pw.write(size[:]); err != nil {
+		return err
+	}
+	return pw.write(parquetMagic)
+}
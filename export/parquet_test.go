@@ -0,0 +1,262 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/datasource"
+)
+
+// thriftReader decodes the Thrift compact protocol into maps of field id
+// to value, independently of the writer, to read back Parquet metadata.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	c := r.b[r.pos]
+	r.pos++
+	return c
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return fields
+		}
+		typ := h & 0x0f
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(typ)
+		last = id
+	}
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1, 2: // booleans in a struct carry the value in the type
+		return typ == 1
+	case 3:
+		return r.byte()
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos:]))
+		r.pos += 8
+		return v
+	case 8:
+		n := int(r.varint())
+		s := string(r.b[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9:
+		h := r.byte()
+		n, elem := int(h>>4), h&0x0f
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case 12:
+		return r.readStruct()
+	}
+	panic(fmt.Sprintf("unknown thrift type %d", typ))
+}
+
+type parquetField struct {
+	name      string
+	physical  int64
+	converted interface{}
+}
+
+// readParquet decodes a file written by parquetWriter: its schema, and
+// its rows as int64, float64, bool, string or nil.
+func readParquet(t *testing.T, data []byte) ([]parquetField, [][]interface{}) {
+	t.Helper()
+	if !bytes.HasPrefix(data, parquetMagic) || !bytes.HasSuffix(data, parquetMagic) {
+		t.Fatalf("missing PAR1 magic")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := &thriftReader{b: data[len(data)-8-size : len(data)-8]}
+	meta := footer.readStruct()
+	if footer.pos != size {
+		t.Fatalf("footer is %d bytes, decoded %d", size, footer.pos)
+	}
+
+	schema := meta[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	if root[5].(int64) != int64(len(schema)-1) {
+		t.Fatalf("root has %d children, schema has %d fields", root[5], len(schema)-1)
+	}
+	var fields []parquetField
+	for _, e := range schema[1:] {
+		se := e.(map[int16]interface{})
+		if se[3].(int64) != parquetOptional {
+			t.Fatalf("field %v is not optional", se[4])
+		}
+		fields = append(fields, parquetField{name: se[4].(string), physical: se[1].(int64), converted: se[6]})
+	}
+
+	var rows [][]interface{}
+	var total int64
+	for _, g := range meta[4].([]interface{}) {
+		group := g.(map[int16]interface{})
+		numRows := int(group[3].(int64))
+		total += int64(numRows)
+		groupRows := make([][]interface{}, numRows)
+		for i := range groupRows {
+			groupRows[i] = make([]interface{}, len(fields))
+		}
+		for c, ch := range group[1].([]interface{}) {
+			md := ch.(map[int16]interface{})[3].(map[int16]interface{})
+			if path := md[3].([]interface{}); !reflect.DeepEqual(path, []interface{}{fields[c].name}) {
+				t.Fatalf("chunk %d path %v, want %s", c, path, fields[c].name)
+			}
+			values := readPage(t, data, int(md[9].(int64)), fields[c].physical)
+			if len(values) != numRows || md[5].(int64) != int64(numRows) {
+				t.Fatalf("chunk %d has %d values, row group has %d rows", c, len(values), numRows)
+			}
+			for i, v := range values {
+				groupRows[i][c] = v
+			}
+		}
+		rows = append(rows, groupRows...)
+	}
+	if meta[3].(int64) != total {
+		t.Fatalf("file has %d rows, row groups have %d", meta[3], total)
+	}
+	return fields, rows
+}
+
+// readPage decodes the data page at offset.
+func readPage(t *testing.T, data []byte, offset int, physical int64) []interface{} {
+	t.Helper()
+	r := &thriftReader{b: data, pos: offset}
+	header := r.readStruct()
+	if header[1].(int64) != parquetDataPage {
+		t.Fatalf("page at %d is not a data page", offset)
+	}
+	body := data[r.pos : r.pos+int(header[3].(int64))]
+	n := int(header[5].(map[int16]interface{})[1].(int64))
+
+	levelsLen := int(binary.LittleEndian.Uint32(body))
+	levels := &thriftReader{b: body[4 : 4+levelsLen]}
+	run := levels.varint()
+	if run&1 != 1 || int(run>>1) != (n+7)/8 {
+		t.Fatalf("definition levels are not one bit-packed run of %d values", n)
+	}
+	packed := levels.b[levels.pos:]
+
+	values := body[4+levelsLen:]
+	out := make([]interface{}, n)
+	next := 0
+	for i := range out {
+		if packed[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		switch physical {
+		case parquetBoolean:
+			out[i] = values[next/8]&(1<<(next%8)) != 0
+			next++
+		case parquetInt64:
+			out[i] = int64(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case parquetDouble:
+			out[i] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case parquetByteArray:
+			l := int(binary.LittleEndian.Uint32(values))
+			out[i] = string(values[4 : 4+l])
+			values = values[4+l:]
+		}
+	}
+	return out
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	columns := append(testColumns, Column{Name: "name", Type: datasource.TypeTimestamp})
+	rows := [][]interface{}{
+		{int64(1), "a", 1.5, true, "2025-08-15T10:15:00Z"},
+		{json.Number("9007199254740993"), nil, json.Number("2.25"), false, nil},
+		{"-3", "é", 7, "true", "x"},
+		{nil, nil, nil, nil, nil},
+	}
+	fields, got := readParquet(t, writeFile(t, Parquet, columns, rows))
+
+	wantFields := []parquetField{
+		{"id", parquetInt64, nil},
+		{"name", parquetByteArray, int64(parquetUTF8)},
+		{"amount", parquetDouble, nil},
+		{"ok", parquetBoolean, nil},
+		{"name_2", parquetByteArray, int64(parquetUTF8)},
+	}
+	if !reflect.DeepEqual(fields, wantFields) {
+		t.Fatalf("schema %+v, want %+v", fields, wantFields)
+	}
+	want := [][]interface{}{
+		{int64(1), "a", 1.5, true, "2025-08-15T10:15:00Z"},
+		{int64(9007199254740993), nil, 2.25, false, nil},
+		{int64(-3), "é", 7.0, true, "x"},
+		{nil, nil, nil, nil, nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	columns := []Column{{Name: "n", Type: datasource.TypeInteger}, {Name: "even", Type: datasource.TypeBoolean}}
+	rows := make([][]interface{}, parquetRowGroupRows+3)
+	for i := range rows {
+		rows[i] = []interface{}{int64(i), i%2 == 0}
+		if i%7 == 0 {
+			rows[i][1] = nil
+		}
+	}
+	_, got := readParquet(t, writeFile(t, Parquet, columns, rows))
+	if !reflect.DeepEqual(got, rows) {
+		t.Fatalf("read back %d rows, not the %d written", len(got), len(rows))
+	}
+}
+
+func TestParquetEmpty(t *testing.T) {
+	fields, rows := readParquet(t, writeFile(t, Parquet, testColumns, nil))
+	if len(fields) != len(testColumns) || len(rows) != 0 {
+		t.Fatalf("got %d fields and %d rows", len(fields), len(rows))
+	}
+}
+
+func TestParquetRejectsBadValues(t *testing.T) {
+	w, _ := NewWriter(Parquet, &bytes.Buffer{}, testColumns[:1])
+	if err := w.WriteRow([]interface{}{1.5}); err == nil {
+		t.Fatal("wrote 1.5 into an integer column")
+	}
+}
@@ -0,0 +1,99 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol type ids, enough to encode Parquet metadata.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftStructWriter encodes one struct with the Thrift compact protocol.
+// Fields must be written in increasing id order and the struct closed
+// with end.
+type thriftStructWriter struct {
+	b    *bytes.Buffer
+	last int16
+}
+
+func newThriftStruct(b *bytes.Buffer) *thriftStructWriter {
+	return &thriftStructWriter{b: b}
+}
+
+func (s *thriftStructWriter) field(id int16, typ byte) {
+	if delta := id - s.last; delta > 0 && delta <= 15 {
+		s.b.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		s.b.WriteByte(typ)
+		writeVarint(s.b, zigzag(int64(id)))
+	}
+	s.last = id
+}
+
+func (s *thriftStructWriter) i32(id int16, v int32) {
+	s.field(id, thriftI32)
+	writeVarint(s.b, zigzag(int64(v)))
+}
+
+func (s *thriftStructWriter) i64(id int16, v int64) {
+	s.field(id, thriftI64)
+	writeVarint(s.b, zigzag(v))
+}
+
+func (s *thriftStructWriter) binary(id int16, v []byte) {
+	s.field(id, thriftBinary)
+	writeVarint(s.b, uint64(len(v)))
+	s.b.Write(v)
+}
+
+func (s *thriftStructWriter) str(id int16, v string) {
+	s.binary(id, []byte(v))
+}
+
+// list writes a list field header; the n elements follow.
+func (s *thriftStructWriter) list(id int16, elemType byte, n int) {
+	s.field(id, thriftList)
+	writeListHeader(s.b, elemType, n)
+}
+
+func (s *thriftStructWriter) listI32(id int16, values ...int32) {
+	s.field(id, thriftList)
+	writeListHeader(s.b, thriftI32, len(values))
+	for _, v := range values {
+		writeVarint(s.b, zigzag(int64(v)))
+	}
+}
+
+// child starts a nested struct field.
+func (s *thriftStructWriter) child(id int16) *thriftStructWriter {
+	s.field(id, thriftStruct)
+	return newThriftStruct(s.b)
+}
+
+func (s *thriftStructWriter) end() {
+	s.b.WriteByte(0)
+}
+
+func writeListHeader(b *bytes.Buffer, elemType byte, n int) {
+	if n < 15 {
+		b.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	b.WriteByte(0xF0 | elemType)
+	writeVarint(b, uint64(n))
+}
+
+func writeVarint(b *bytes.Buffer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
@@ -0,0 +1,136 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// xlsxWriter writes a single-sheet workbook. The sheet part is the last
+// zip entry, so rows stream straight into it.
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+// Style index of the bold header cells in styles.xml.
+const xlsxHeaderStyle = 1
+
+func newXLSXWriter(w io.Writer, columns []Column) (*xlsxWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw := &xlsxWriter{zw: zw, sheet: bufio.NewWriter(f)}
+	xw.sheet.WriteString(xml.Header)
+	xw.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	// Keep the header row in view while scrolling, as in the Google Sheet
+	xw.sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	xw.sheet.WriteString(`<sheetData>`)
+
+	header := make([]interface{}, len(columns))
+	for i, c := range columns {
+		header[i] = c.Name
+	}
+	if err := xw.writeRow(header, xlsxHeaderStyle); err != nil {
+		return nil, err
+	}
+	return xw, nil
+}
+
+func (xw *xlsxWriter) WriteRow(values []interface{}) error {
+	return xw.writeRow(values, 0)
+}
+
+func (xw *xlsxWriter) writeRow(values []interface{}, style int) error {
+	xw.row++
+	n := strconv.Itoa(xw.row)
+	xw.sheet.WriteString(`<row r="` + n + `">`)
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		ref := columnName(i) + n
+		attrs := ` r="` + ref + `"`
+		if style != 0 {
+			attrs += ` s="` + strconv.Itoa(style) + `"`
+		}
+
+		switch val := v.(type) {
+		case bool:
+			b := "0"
+			if val {
+				b = "1"
+			}
+			xw.sheet.WriteString(`<c` + attrs + ` t="b"><v>` + b + `</v></c>`)
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+			xw.sheet.WriteString(`<c` + attrs + `><v>` + formatText(val) + `</v></c>`)
+		default:
+			xw.sheet.WriteString(`<c` + attrs + ` t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(xw.sheet, []byte(formatText(val)))
+			xw.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	// bufio.Writer errors are sticky, so this reports any failed write
+	_, err := xw.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (xw *xlsxWriter) Close() error {
+	xw.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := xw.sheet.Flush(); err != nil {
+		return err
+	}
+	return xw.zw.Close()
+}
+
+// columnName converts a 0-based column index to its letters: A, Z, AA.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
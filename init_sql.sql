@@ -1,23 +1,103 @@
 CREATE SCHEMA penguin;
 
+-- Workspaces partition roles, users, data sources, templates, policies and
+-- reports, so departments can share a deployment. drive_folder_id is where
+-- their spreadsheets go, NULL for the deployment's folder, and
+-- google_credentials the name of their Google secrets, NULL for the
+-- deployment's. The default workspace administers the deployment.
+CREATE TABLE penguin.workspace (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    drive_folder_id VARCHAR(255),
+    google_credentials VARCHAR(100),
+    default_source VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO penguin.workspace (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'default');
+
+-- Data-source registry. dsn_ref points at the connection string (e.g.
+-- 'env:ANALYTICS_DSN'); NULL means the service's own database.
+-- default_schema resolves unqualified table names in report queries.
 CREATE TABLE penguin.snowflake_databases (
-    database_name VARCHAR(255) PRIMARY KEY
+    database_name VARCHAR(255) PRIMARY KEY, -- unique across workspaces
+    workspace_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES penguin.workspace (id),
+    driver VARCHAR(50) NOT NULL DEFAULT 'postgres',
+    dsn_ref VARCHAR(255),
+    default_schema VARCHAR(255),
+    db_role VARCHAR(63),     -- Postgres role read transactions switch to; NULL keeps the connection's
+
+    max_open_conns INT NOT NULL DEFAULT 10,
+    max_idle_conns INT NOT NULL DEFAULT 2,
+    conn_max_lifetime_seconds INT NOT NULL DEFAULT 300
+);
+
+-- Encrypted credentials: Google client secret and OAuth token, data source
+-- DSNs. ciphertext is sealed with a per-secret data key, and wrapped_key is
+-- that data key sealed with the key encryption key key_id.
+CREATE TABLE penguin.secret (
+    name VARCHAR(128) PRIMARY KEY,
+    ciphertext BYTEA NOT NULL,
+    wrapped_key BYTEA NOT NULL,
+    key_id VARCHAR(16) NOT NULL,
+    version INT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
 );
 
 CREATE TABLE penguin.role (
     id UUID PRIMARY KEY,
+    workspace_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES penguin.workspace (id),
     name VARCHAR(255) NOT NULL
 );
 
+-- Application logs, ingested through POST /api/v1/logs. Timestamps are
+-- UTC. attributes holds the structured fields of an entry, and
+-- search_vector backs full-text search over the message.
 CREATE TABLE penguin.dev_logs (
     id UUID PRIMARY KEY,
+    workspace_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES penguin.workspace (id),
     timestamp TIMESTAMP,
     level VARCHAR(20),
     service_name VARCHAR(100),
-    message VARCHAR(500)
+    message VARCHAR(500),
+    attributes JSONB,
+    search_vector TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', COALESCE(message, ''))) STORED
+);
+
+CREATE INDEX dev_logs_workspace_time_idx ON penguin.dev_logs (workspace_id, timestamp DESC);
+CREATE INDEX dev_logs_search_idx ON penguin.dev_logs USING GIN (search_vector);
+
+-- Log reports read the logs of their own workspace through this view, as
+-- penguin_log_reader, which may read nothing else. The service sets
+-- penguin.workspace_id in each read transaction of a source with that role.
+DO $$ BEGIN
+    CREATE ROLE penguin_log_reader NOLOGIN;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+GRANT penguin_log_reader TO CURRENT_USER;
+GRANT USAGE ON SCHEMA penguin TO penguin_log_reader;
+
+CREATE VIEW penguin.workspace_logs WITH (security_barrier) AS
+SELECT id, timestamp, level, service_name, message, attributes, search_vector
+FROM penguin.dev_logs
+WHERE workspace_id = NULLIF(current_setting('penguin.workspace_id', true), '')::uuid;
+
+GRANT SELECT ON penguin.workspace_logs TO penguin_log_reader;
+
+-- Retention: logs of a workspace matching service_name and level, NULL
+-- for any, are deleted once older than max_age_seconds.
+CREATE TABLE penguin.log_retention (
+    id UUID PRIMARY KEY,
+    workspace_id UUID NOT NULL REFERENCES penguin.workspace (id),
+    service_name VARCHAR(100),
+    level VARCHAR(20),
+    max_age_seconds BIGINT NOT NULL CHECK (max_age_seconds > 0),
+    created_at TIMESTAMP NOT NULL
 );
 
-INSERT INTO penguin.snowflake_databases (database_name) VALUES ('penguin');
+INSERT INTO penguin.snowflake_databases (database_name, default_schema) VALUES ('penguin', 'penguin');
+INSERT INTO penguin.snowflake_databases (database_name, default_schema, db_role) VALUES ('penguin_logs', 'penguin', 'penguin_log_reader');
 
 INSERT INTO penguin.dev_logs (id,timestamp, level, service_name, message) VALUES
 ('39b17c2a-b542-4ec1-84ea-97d62b21db68','2025-08-15 10:15:00', 'INFO', 'auth-service', 'User login successful for user_id=12345'),
@@ -28,28 +108,221 @@ INSERT INTO penguin.dev_logs (id,timestamp, level, service_name, message) VALUES
 
 CREATE TABLE penguin.user (
     id UUID PRIMARY KEY,
+    workspace_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES penguin.workspace (id),
     name VARCHAR(255) NOT NULL,
     email VARCHAR(255) NOT NULL UNIQUE,
     role_id UUID NOT NULL,
     FOREIGN KEY (role_id) REFERENCES penguin.role (id)
 );
 
+-- Pending Google authorizations: the OAuth state handed to the consent
+-- page, redeemed once by the admin who asked for it
+CREATE TABLE penguin.oauth_state (
+    state CHAR(64) PRIMARY KEY,
+    credentials VARCHAR(128) NOT NULL,
+    user_id UUID NOT NULL REFERENCES penguin.user (id),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE penguin.api_key (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES penguin.user (id)
+);
+
+-- Saved report definitions that reports can be instantiated from.
+CREATE TABLE penguin.report_template (
+    id UUID PRIMARY KEY,
+    workspace_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES penguin.workspace (id),
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    db_name VARCHAR(255),            -- NULL for the default source
+    sql_script TEXT NOT NULL,
+    columns JSONB,
+    parameters JSONB,
+    parameter_values JSONB,          -- default parameter values
+    created_by UUID,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (workspace_id, name),
+    FOREIGN KEY (db_name) REFERENCES penguin.snowflake_databases (database_name),
+    FOREIGN KEY (created_by) REFERENCES penguin.user (id)
+);
+
+-- Saved log searches, which reports can be created from. levels is a JSON
+-- array; window_seconds limits results to the most recent entries.
+CREATE TABLE penguin.log_search (
+    id UUID PRIMARY KEY,
+    workspace_id UUID NOT NULL REFERENCES penguin.workspace (id),
+    name VARCHAR(255) NOT NULL,
+    levels JSONB,
+    service_name VARCHAR(100),
+    query TEXT,
+    window_seconds BIGINT,
+    created_by UUID REFERENCES penguin.user (id),
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (workspace_id, name)
+);
+
 CREATE TABLE penguin.spreadsheet (
     id VARCHAR(255) PRIMARY KEY,
+    workspace_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES penguin.workspace (id),
     report_name VARCHAR(255) NOT NULL,
     created_at TIMESTAMP,
-    schema JSONB
+    created_by UUID REFERENCES penguin.user (id), -- NULL for reports from before it was recorded
+    schema JSONB,            -- sheet columns in order: [{"id", "name", "type"}]
+    db_name VARCHAR(255),
+    sql_script TEXT,
+    definition JSONB,        -- columns and declared parameters
+    parameter_values JSONB,  -- parameter set the sheet was last built with
+    refreshed_at TIMESTAMP,
+    template_id UUID,        -- template the report was instantiated from
+    script_id VARCHAR(255),  -- bound Apps Script project
+    script_version VARCHAR(16), -- script template the bound script was generated from
+    script_hash CHAR(64),    -- SHA-256 of the generated script code
+    deployment_id VARCHAR(255), -- API executable deployment of the bound script
+    trigger_owner VARCHAR(255), -- Google account the installed edit trigger runs as
+    trigger_status VARCHAR(16) NOT NULL DEFAULT 'unknown', -- installed, failed or unknown
+    trigger_error TEXT,      -- why the last installation failed
+    trigger_checked_at TIMESTAMP,
+    FOREIGN KEY (db_name) REFERENCES penguin.snowflake_databases (database_name),
+    FOREIGN KEY (template_id) REFERENCES penguin.report_template (id) ON DELETE SET NULL
+);
+
+-- Outcomes of create-report calls made with an Idempotency-Key, so retries
+-- return the original sheet instead of creating another one.
+CREATE TABLE penguin.idempotency_key (
+    user_id UUID NOT NULL,
+    endpoint VARCHAR(50) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,   -- SHA-256 of the request body
+    status VARCHAR(20) NOT NULL,      -- in_progress or completed
+    response_code INT,
+    response_body JSONB,
+    created_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
+    PRIMARY KEY (user_id, endpoint, key),
+    FOREIGN KEY (user_id) REFERENCES penguin.user (id)
 );
 
 CREATE TABLE penguin.spreadsheetpermissions (
     id UUID PRIMARY key,
     spreadsheet_id VARCHAR(255) NOT NULL,
     role_id UUID,
-    columns_permissions TEXT,
+    columns_permissions TEXT,   -- JSON array of the column IDs the role may edit (names before column IDs)
     FOREIGN KEY (spreadsheet_id) REFERENCES penguin.spreadsheet (id),
     FOREIGN KEY (role_id) REFERENCES penguin.role (id)
 );
 
+-- Tables and columns each report's SQL reads, extracted when the report is
+-- created. column_name is NULL for the table itself and '*' for SELECT *;
+-- schema_name is NULL when the query left the table unqualified.
+CREATE TABLE penguin.report_lineage (
+    id UUID PRIMARY KEY,
+    spreadsheet_id VARCHAR(255) NOT NULL,
+    schema_name VARCHAR(255),
+    table_name VARCHAR(255) NOT NULL,
+    column_name VARCHAR(255),
+    ambiguous BOOLEAN NOT NULL DEFAULT FALSE,
+    FOREIGN KEY (spreadsheet_id) REFERENCES penguin.spreadsheet (id) ON DELETE CASCADE
+);
+
+CREATE INDEX report_lineage_table_idx ON penguin.report_lineage (table_name, column_name);
+
+-- Edits made in report sheets, as reported by the bound Apps Script after
+-- the edit was allowed. Values are as Sheets reports them; multi-cell
+-- edits such as pastes have new_values instead of new_value.
+CREATE TABLE penguin.report_edit (
+    id UUID PRIMARY KEY,
+    spreadsheet_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    user_id UUID,                     -- NULL when the email is not a penguin user
+    sheet_name VARCHAR(255),
+    range_a1 VARCHAR(255) NOT NULL,
+    column_name VARCHAR(255),
+    column_id VARCHAR(64),            -- ID tagged on the first edited column
+    row_number INT NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    new_values JSONB,                 -- rows of values of a multi-cell edit
+    edited_at TIMESTAMP NOT NULL,     -- as reported by the script
+    received_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (spreadsheet_id) REFERENCES penguin.spreadsheet (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES penguin.user (id)
+);
+
+CREATE INDEX report_edit_report_idx ON penguin.report_edit (spreadsheet_id, row_number, edited_at);
+CREATE INDEX report_edit_email_idx ON penguin.report_edit (email, edited_at);
+
+-- Last known values of the protected cells of each sheet row, from the
+-- last write, refresh or sync plus the allowed edits since. Denied edits
+-- are reverted to these. row_number is the sheet row as last written; the
+-- header is row 1. Reports with a key column find rows by row_key instead,
+-- since sorting or inserting rows in Sheets moves them.
+CREATE TABLE penguin.report_row (
+    spreadsheet_id VARCHAR(255) NOT NULL,
+    row_number INT NOT NULL,
+    row_key TEXT,                     -- key column value, NULL when empty or repeated
+    cells JSONB NOT NULL,             -- column ID -> value
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (spreadsheet_id, row_number),
+    FOREIGN KEY (spreadsheet_id) REFERENCES penguin.spreadsheet (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX report_row_key_idx ON penguin.report_row (spreadsheet_id, row_key);
+
+-- Latest drift check of each report: how the live spreadsheet differs from
+-- what penguin.spreadsheet says it should be.
+CREATE TABLE penguin.report_health (
+    spreadsheet_id VARCHAR(255) PRIMARY KEY,
+    checked_at TIMESTAMP NOT NULL,
+    healthy BOOLEAN NOT NULL,
+    issues JSONB NOT NULL,
+    repaired_at TIMESTAMP,
+    FOREIGN KEY (spreadsheet_id) REFERENCES penguin.spreadsheet (id) ON DELETE CASCADE
+);
+
+-- Point-in-time copies of a report's data, taken on create, refresh and
+-- sync. data is the gzipped JSON array of rows, header first.
+CREATE TABLE penguin.report_snapshot (
+    id UUID PRIMARY KEY,
+    spreadsheet_id VARCHAR(255) NOT NULL,
+    version INT NOT NULL,
+    kind VARCHAR(20) NOT NULL,        -- create, refresh or sync
+    created_at TIMESTAMP NOT NULL,
+    created_by UUID,
+    row_count INT NOT NULL,
+    columns JSONB NOT NULL,           -- names and types, in sheet order
+    editable_columns JSONB,
+    parameter_values JSONB,
+    data BYTEA NOT NULL,
+    UNIQUE (spreadsheet_id, version),
+    FOREIGN KEY (spreadsheet_id) REFERENCES penguin.spreadsheet (id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES penguin.user (id)
+);
+
+-- Tables and columns each role may query in report SQL. Policies only
+-- grant access; admin roles bypass them. schema_name and table_name may be
+-- '*'; columns is a JSON array of column names, NULL for all of them.
+CREATE TABLE penguin.data_access_policy (
+    id UUID PRIMARY KEY,
+    workspace_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES penguin.workspace (id),
+    role_id UUID NOT NULL,
+    db_name VARCHAR(255),              -- NULL applies to every source
+    schema_name VARCHAR(255) NOT NULL,
+    table_name VARCHAR(255) NOT NULL,
+    columns JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (role_id) REFERENCES penguin.role (id),
+    FOREIGN KEY (db_name) REFERENCES penguin.snowflake_databases (database_name)
+);
+
 -- Insert default roles
 INSERT INTO penguin.role (id,name)
 VALUES
@@ -58,6 +331,14 @@ VALUES
 ('9c82cb57-0df3-4e86-8fa1-36ce983fc701','ADMIN 3'), 
 ('d1f3fbc5-4a1d-4e89-a2ef-9a4f6fdab123','ADMIN 4');
 
+-- Let the non-admin roles report on the logs of their workspace. They get
+-- no policy on penguin.dev_logs, which holds every workspace's logs.
+INSERT INTO penguin.data_access_policy (id, role_id, db_name, schema_name, table_name)
+VALUES
+('c2d4e6f8-1a3b-4c5d-8e7f-9a0b1c2d3e4f','b5d7cf7f-b2de-4a6c-8d44-0e8d3d1c7b12','penguin_logs','penguin','workspace_logs'),
+('d3e5f7a9-2b4c-4d6e-9f80-ab1c2d3e4f50','9c82cb57-0df3-4e86-8fa1-36ce983fc701','penguin_logs','penguin','workspace_logs'),
+('e4f6a8b0-3c5d-4e7f-a091-bc2d3e4f5061','d1f3fbc5-4a1d-4e89-a2ef-9a4f6fdab123','penguin_logs','penguin','workspace_logs');
+
 -- Insert default users
 INSERT INTO penguin.user (id, name, email, role_id)
 VALUES
@@ -77,4 +358,4 @@ VALUES
 -- INSERT INTO penguin.spreadsheetpermissions (id,spreadsheet_id, role_id, columns_permissions)
 -- VALUES
 -- ('c7b2ae35-6404-49b3-9c6b-86a967a0d8d4','a1d4902b-655a-4e9e-bf24-5f8e8b3cc33d', 'b5d7cf7f-b2de-4a6c-8d44-0e8d3d1c7b12', ARRAY['date','amount','product']),
--- ('6d0f8be9-7ac5-4e41-9e9f-51b0592cfb3c' ,'a1d4902b-655a-4e9e-bf24-5f8e8b3cc33d', 'd1f3fbc5-4a1d-4e89-a2ef-9a4f6fdab123', ARRAY['date','amount']);
\ No newline at end of file
+-- ('6d0f8be9-7ac5-4e41-9e9f-51b0592cfb3c' ,'a1d4902b-655a-4e9e-bf24-5f8e8b3cc33d', 'd1f3fbc5-4a1d-4e89-a2ef-9a4f6fdab123', ARRAY['date','amount']);
@@ -0,0 +1,87 @@
+// Package testdb stands in for the metadata database in tests. It opens a
+// SQLite database with the penguin schema attached and translates the
+// Postgres placeholders and casts the service writes, which is enough for
+// the queries the tests exercise; anything more Postgres specific needs a
+// real server.
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const driverName = "penguin-sqlite"
+
+func init() {
+	sql.Register(driverName, sqliteDriver{})
+}
+
+// Open returns a database in a temporary directory, with schema, SQLite
+// DDL using the penguin schema, already applied.
+func Open(t testing.TB, schema string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(driverName, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	return db
+}
+
+var (
+	// SQLite numbers parameters in order of appearance unless told which
+	// one is meant, and "$1" names one instead
+	placeholder = regexp.MustCompile(`\$(\d+)`)
+	cast        = regexp.MustCompile(`::[a-z]+(\[\])?`)
+)
+
+func translate(query string) string {
+	query = placeholder.ReplaceAllString(query, "?$1")
+	return cast.ReplaceAllString(query, "")
+}
+
+// sqliteDriver opens dir/main.db with dir/penguin.db attached as penguin
+// on every connection of the pool.
+type sqliteDriver struct{}
+
+func (sqliteDriver) Open(dir string) (driver.Conn, error) {
+	c, err := (&sqlite3.SQLiteDriver{}).Open(filepath.Join(dir, "main.db") + "?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	lite := c.(*sqlite3.SQLiteConn)
+	if _, err := lite.Exec("ATTACH DATABASE ? AS penguin", []driver.Value{filepath.Join(dir, "penguin.db")}); err != nil {
+		lite.Close()
+		return nil, err
+	}
+	return conn{lite}, nil
+}
+
+type conn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c conn) Prepare(query string) (driver.Stmt, error) {
+	return c.SQLiteConn.Prepare(translate(query))
+}
+
+func (c conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.SQLiteConn.PrepareContext(ctx, translate(query))
+}
+
+func (c conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.SQLiteConn.ExecContext(ctx, translate(query), args)
+}
+
+func (c conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.SQLiteConn.QueryContext(ctx, translate(query), args)
+}
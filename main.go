@@ -3,31 +3,32 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
+	"github.com/nishantd01/penguin-core/config"
 	v1 "github.com/nishantd01/penguin-core/controllers/v1"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/middleware"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/secrets"
 	"github.com/nishantd01/penguin-core/service"
-)
-
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "postgres_user"
-	password = "qwerty"
-	dbname   = "penguin"
+	"github.com/nishantd01/penguin-core/utils"
 )
 
 func main() {
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
-		"password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
-
-	fmt.Printf("password %v\n", password)
+	cfg := config.Load()
+	if cfg.PublicURL == "" || cfg.ScriptClientID == "" {
+		log.Fatal("PENGUIN_PUBLIC_URL and PENGUIN_SCRIPT_CLIENT_ID must be set")
+	}
+	if cfg.DatabaseURL == "" {
+		log.Fatal("PENGUIN_DATABASE_URL must be set")
+	}
 
-	db, err := sql.Open("postgres", psqlInfo)
+	db, err := sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
 		panic(err)
 	}
@@ -40,7 +41,40 @@ func main() {
 
 	fmt.Println("Successfully connected")
 
-	r := gin.Default()
+	var secretStore *secrets.Store
+	if cfg.SecretKey != "" {
+		keys, err := secrets.NewKeyring(cfg.SecretKey, cfg.SecretRetiredKeys)
+		if err != nil {
+			panic(err)
+		}
+		secretStore = secrets.NewStore(db, keys)
+		utils.UseSecrets(secretStore)
+	} else if cfg.FileCredentials {
+		log.Printf("⚠️ PENGUIN_SECRET_KEY is not set; Google credentials are read from files and secret: DSNs are unavailable. Run a single replica only")
+	} else {
+		// Replicas would each keep and refresh their own token.json
+		log.Fatal("PENGUIN_SECRET_KEY is required; set PENGUIN_FILE_CREDENTIALS=true to read credentials.json and token.json on a single replica")
+	}
+
+	sources := datasource.NewRegistry(db, secretStore, cfg.DefaultSource, cfg.SQLiteDir)
+	defer sources.Close()
+
+	userService := service.NewUserService(db, sources, cfg)
+	defer userService.Close()
+
+	if len(os.Args) > 1 {
+		if err := runCommand(userService, os.Args[1:]); err != nil {
+			log.Printf("%s: %v", os.Args[1], err)
+			userService.Close()
+			sources.Close()
+			db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	r := gin.New()
+	r.Use(gin.Logger(), middleware.Recovery())
 
 	r.Use(cors.Default())
 
@@ -48,23 +82,115 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:9000", "https://yourdomain.com"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	userService := service.NewUserService(db)
 	userController := v1.NewUserController(userService)
+	if cfg.AuditInterval > 0 {
+		go userService.RunHealthAudits(cfg.AuditInterval, cfg.AuditConcurrency, cfg.AuditAutoRepair)
+	}
+	if cfg.LogRetentionInterval > 0 {
+		go userService.RunLogRetention(cfg.LogRetentionInterval)
+	}
+	authService := service.NewAuthService(db, cfg.JWTSecret)
+	authController := v1.NewAuthController(authService)
+	secretController := v1.NewSecretController(service.NewSecretService(db, secretStore))
 
 	v1Group := r.Group("/api/v1")
 	{
-		// v1Group.GET("/users/:id", userController.GetUser)
-		v1Group.GET("/dbnames", userController.GetDbNames)
-		v1Group.GET("/roles", userController.GetRoles)
+		// Called by the bound Apps Script's edit trigger, which has no user
+		// credentials and sends an identity token of the account it runs as.
 		v1Group.POST("/check-edit-permission", userController.CheckAccess)
-		v1Group.POST("/create-report", userController.CreateReport)
-		v1Group.POST("/validate-sql-query", userController.ValidateSQLQuery)
+		v1Group.POST("/reports/:id/edits", userController.RecordEdit)
+		v1Group.POST("/reports/:id/restore", userController.RestoreValues)
+	}
+
+	authed := v1Group.Group("", middleware.Authenticate(authService))
+	// Reports of other workspaces answer 404
+	inWorkspace := middleware.ReportInWorkspace(userService)
+	{
+		// authed.GET("/users/:id", userController.GetUser)
+		authed.GET("/me", authController.Me)
+		authed.GET("/dbnames", userController.GetDbNames)
+		authed.GET("/sources", userController.GetSources)
+		authed.GET("/sources/:db/schemas", userController.GetSchemas)
+		authed.GET("/sources/:db/schemas/:schema/tables", userController.GetTables)
+		authed.GET("/sources/:db/schemas/:schema/tables/:table/columns", userController.GetColumns)
+		authed.GET("/roles", userController.GetRoles)
+		authed.POST("/create-report", middleware.RequireRoles(cfg.ReportCreatorRoles), userController.CreateReport)
+		authed.POST("/reports/:id/refresh", inWorkspace, middleware.RequireRoles(cfg.ReportCreatorRoles), userController.RefreshReport)
+		authed.POST("/reports/:id/sync", inWorkspace, middleware.RequireRoles(cfg.ReportCreatorRoles), userController.SyncReport)
+		authed.GET("/reports/:id/health", inWorkspace, middleware.RequireRoles(cfg.ReportCreatorRoles), userController.CheckHealth)
+		authed.POST("/reports/:id/repair", inWorkspace, middleware.RequireRoles(cfg.ReportCreatorRoles), userController.RepairReport)
+		authed.POST("/reports/:id/trigger", inWorkspace, middleware.RequireRoles(cfg.ReportCreatorRoles), userController.InstallTrigger)
+		authed.DELETE("/reports/:id/cache", inWorkspace, middleware.RequireRoles(cfg.ReportCreatorRoles), userController.InvalidateReportCache)
+		authed.GET("/reports/:id/export", inWorkspace, middleware.RequireRoles(cfg.ExportRoles), userController.ExportReport)
+		authed.GET("/reports/:id/edits", inWorkspace, userController.ListReportEdits)
+		authed.GET("/reports/:id/rows/:row/edits", inWorkspace, userController.ListRowEdits)
+		authed.GET("/users/:email/edits", userController.ListUserEdits)
+		authed.GET("/reports/:id/versions", inWorkspace, userController.ListVersions)
+		authed.GET("/reports/:id/versions/diff", inWorkspace, userController.DiffVersions)
+		authed.GET("/reports/:id/versions/:version", inWorkspace, userController.GetVersion)
+		authed.GET("/templates", userController.ListTemplates)
+		authed.GET("/templates/:id", userController.GetTemplate)
+		authed.POST("/templates", middleware.RequireRoles(cfg.ReportCreatorRoles), userController.CreateTemplate)
+		authed.PUT("/templates/:id", middleware.RequireRoles(cfg.ReportCreatorRoles), userController.UpdateTemplate)
+		authed.DELETE("/templates/:id", middleware.RequireRoles(cfg.ReportCreatorRoles), userController.DeleteTemplate)
+		authed.POST("/templates/:id/instantiate", middleware.RequireRoles(cfg.ReportCreatorRoles), userController.InstantiateTemplate)
+		authed.POST("/validate-sql-query", middleware.RequireRoles(cfg.SQLValidatorRoles), userController.ValidateSQLQuery)
+		authed.POST("/preview-sql-query", middleware.RequireRoles(cfg.SQLValidatorRoles), userController.PreviewSQLQuery)
+		authed.GET("/lineage/tables/:table/reports", userController.GetTableReports)
+		authed.POST("/lineage/impact", userController.AnalyzeImpact)
+		authed.POST("/logs", middleware.RequireRoles(cfg.LogWriterRoles), userController.IngestLogs)
+		authed.GET("/logs", middleware.RequireRoles(cfg.LogReaderRoles), userController.SearchLogs)
+		authed.GET("/logs/searches", middleware.RequireRoles(cfg.LogReaderRoles), userController.ListLogSearches)
+		authed.POST("/logs/searches", middleware.RequireRoles(cfg.LogReaderRoles), userController.CreateLogSearch)
+		authed.DELETE("/logs/searches/:id", middleware.RequireRoles(cfg.LogReaderRoles), userController.DeleteLogSearch)
+		authed.GET("/logs/searches/:id/results", middleware.RequireRoles(cfg.LogReaderRoles), userController.RunLogSearch)
+		authed.POST("/logs/searches/:id/report", middleware.RequireAllRoles(cfg.LogReaderRoles, cfg.ReportCreatorRoles), userController.CreateLogSearchReport)
+	}
+
+	admin := authed.Group("/admin", middleware.RequireRoles(cfg.AdminRoles))
+	{
+		admin.POST("/api-keys", authController.CreateAPIKey)
+		admin.GET("/api-keys", authController.ListAPIKeys)
+		admin.DELETE("/api-keys/:id", authController.RevokeAPIKey)
+		admin.GET("/users", userController.ListUsers)
+		admin.POST("/users", userController.CreateUser)
+		admin.POST("/roles", userController.CreateRole)
+		admin.POST("/sources", userController.CreateSource)
+		admin.POST("/lineage/rebuild", userController.RebuildLineage)
+		admin.GET("/data-policies", userController.ListPolicies)
+		admin.POST("/data-policies", userController.CreatePolicy)
+		admin.DELETE("/data-policies/:id", userController.DeletePolicy)
+		admin.GET("/report-health", userController.ListHealth)
+		admin.GET("/reports/unenforced", userController.ListUnenforced)
+		admin.GET("/log-retention", userController.ListLogRetention)
+		admin.POST("/log-retention", userController.CreateLogRetention)
+		admin.DELETE("/log-retention/:id", userController.DeleteLogRetention)
+		admin.POST("/log-retention/run", userController.ApplyLogRetention)
+		admin.DELETE("/query-cache", userController.InvalidateQueryCache)
+	}
+
+	// Deployment-wide operations, for admins of the default workspace only
+	operator := admin.Group("", middleware.RequireWorkspace(models.DefaultWorkspaceID))
+	{
+		operator.GET("/workspaces", userController.ListWorkspaces)
+		operator.POST("/workspaces", userController.CreateWorkspace)
+		operator.GET("/secrets", secretController.ListSecrets)
+		operator.PUT("/secrets/:name", secretController.PutSecret)
+		operator.DELETE("/secrets/:name", secretController.DeleteSecret)
+		operator.POST("/secrets/rewrap", secretController.RewrapSecrets)
+		operator.GET("/google/authorize", secretController.GoogleAuthURL)
+		operator.POST("/google/authorize", secretController.AuthorizeGoogle)
+		operator.POST("/scripts/redeploy", userController.StartRedeploy)
+		operator.GET("/scripts/redeploy/:id", userController.GetRedeploy)
+		operator.GET("/scripts/unregistered", userController.ListUnregisteredScripts)
+		operator.PUT("/reports/:id/script", userController.RegisterScript)
+		operator.GET("/query-cache", userController.GetQueryCacheStats)
 	}
 
 	r.Run(":8084")
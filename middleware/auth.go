@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+const principalKey = "penguin.principal"
+
+// Authenticate rejects requests that do not carry a valid API key or JWT.
+// The key may be sent as "Authorization: Bearer <token>" or "X-API-Key".
+func Authenticate(auth *service.AuthService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.GetHeader("X-API-Key")
+		if h := ctx.GetHeader("Authorization"); token == "" && h != "" {
+			scheme, value, _ := strings.Cut(h, " ")
+			if strings.EqualFold(scheme, "Bearer") {
+				token = strings.TrimSpace(value)
+			}
+		}
+
+		principal, err := auth.Authenticate(token)
+		if err != nil {
+			if !errors.Is(err, service.ErrUnauthenticated) {
+				log.Printf("Authentication failed: %v", err)
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "authentication failed"})
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.Set(principalKey, principal)
+		ctx.Next()
+	}
+}
+
+// RequireRoles only lets callers whose role name is in roles through.
+// An empty list allows every authenticated caller.
+func RequireRoles(roles []string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if len(roles) == 0 {
+			ctx.Next()
+			return
+		}
+
+		principal := CurrentPrincipal(ctx)
+		if principal == nil || !principal.HasRole(roles) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireAllRoles lets callers through whose role name is in each of
+// lists, for endpoints doing the work of several roles. Empty lists allow
+// every authenticated caller, as with RequireRoles.
+func RequireAllRoles(lists ...[]string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal := CurrentPrincipal(ctx)
+		for _, roles := range lists {
+			if len(roles) > 0 && (principal == nil || !principal.HasRole(roles)) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// CurrentPrincipal returns the caller set by Authenticate, or nil.
+func CurrentPrincipal(ctx *gin.Context) *models.Principal {
+	v, ok := ctx.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	p, _ := v.(*models.Principal)
+	return p
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/internal/testdb"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+const authSchema = `
+CREATE TABLE penguin.role (id TEXT PRIMARY KEY, workspace_id TEXT NOT NULL, name TEXT NOT NULL);
+CREATE TABLE penguin.user (id TEXT PRIMARY KEY, workspace_id TEXT NOT NULL, name TEXT NOT NULL, email TEXT NOT NULL UNIQUE, role_id TEXT NOT NULL);
+CREATE TABLE penguin.api_key (
+    id TEXT PRIMARY KEY, user_id TEXT NOT NULL, name TEXT NOT NULL, prefix TEXT NOT NULL, key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL, last_used_at TIMESTAMP, revoked_at TIMESTAMP
+);
+INSERT INTO penguin.role VALUES ('r1', 'w1', 'ADMIN 1'), ('r2', 'w1', 'ADMIN 2');
+INSERT INTO penguin.user VALUES ('u1', 'w1', 'Admin', 'admin@example.com', 'r1'), ('u2', 'w1', 'Alice', 'alice@example.com', 'r2');
+`
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth := service.NewAuthService(testdb.Open(t, authSchema), "")
+	adminKey, _, err := auth.CreateAPIKey("w1", service.CreateAPIKeyRequest{UserId: "u1", Name: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKey, _, err := auth.CreateAPIKey("w1", service.CreateAPIKeyRequest{UserId: "u2", Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(Authenticate(auth))
+	whoami := func(ctx *gin.Context) { ctx.String(http.StatusOK, CurrentPrincipal(ctx).UserID) }
+	r.GET("/any", whoami)
+	r.GET("/admin", RequireRoles([]string{"ADMIN 1"}), whoami)
+	r.GET("/both", RequireAllRoles([]string{"ADMIN 1", "ADMIN 2"}, []string{"ADMIN 2"}), whoami)
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		value    string
+		wantCode int
+		wantUser string
+	}{
+		{"no credentials", "/any", "", "", http.StatusUnauthorized, ""},
+		{"unknown key", "/any", "X-API-Key", "pk_unknown", http.StatusUnauthorized, ""},
+		{"api key header", "/any", "X-API-Key", aliceKey, http.StatusOK, "u2"},
+		{"bearer", "/any", "Authorization", "Bearer " + aliceKey, http.StatusOK, "u2"},
+		{"other scheme", "/any", "Authorization", "Basic " + aliceKey, http.StatusUnauthorized, ""},
+		{"jwt disabled", "/any", "Authorization", "Bearer a.b.c", http.StatusUnauthorized, ""},
+		{"role required", "/admin", "X-API-Key", aliceKey, http.StatusForbidden, ""},
+		{"role held", "/admin", "X-API-Key", adminKey, http.StatusOK, "u1"},
+		{"one of two roles", "/both", "X-API-Key", adminKey, http.StatusForbidden, ""},
+		{"both roles", "/both", "X-API-Key", aliceKey, http.StatusOK, "u2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("%s answered %d, want %d: %s", tt.path, w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantUser != "" && w.Body.String() != tt.wantUser {
+				t.Errorf("principal %q, want %q", w.Body, tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery answers a panicking handler with 500, like gin's Recovery,
+// except for http.ErrAbortHandler. That one is passed on to net/http,
+// which resets the connection, so a handler that fails after streaming
+// part of a response leaves the client with a failed transfer instead of
+// a body that ends as if complete.
+func Recovery() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("💥 Panic serving %s %s: %v\n%s", ctx.Request.Method, ctx.Request.URL.Path, err, debug.Stack())
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+		}()
+		ctx.Next()
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Recovery())
+	r.GET("/panic", func(ctx *gin.Context) { panic("boom") })
+	r.GET("/abort", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+		ctx.Writer.WriteString("partial")
+		ctx.Writer.Flush()
+		panic(http.ErrAbortHandler)
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("panic answered %d, want 500", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/abort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("aborted response read as complete")
+	}
+}
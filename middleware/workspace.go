@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+// ReportInWorkspace answers 404 for reports of other workspaces than the
+// caller's, so their IDs cannot even be probed. The report ID is the :id
+// route parameter.
+func ReportInWorkspace(users *service.UserService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal := CurrentPrincipal(ctx)
+		workspaceId, err := users.ReportWorkspace(ctx.Param("id"))
+		if err != nil && !errors.Is(err, service.ErrReportNotFound) {
+			log.Printf("Failed to look up workspace of report %s: %v", ctx.Param("id"), err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if err != nil || principal == nil || principal.WorkspaceID != workspaceId {
+			ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": service.ErrReportNotFound.Error()})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireWorkspace only lets callers of the given workspace through. It
+// keeps deployment-wide operations with the default workspace's admins.
+func RequireWorkspace(workspaceId string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal := CurrentPrincipal(ctx)
+		if principal == nil || principal.WorkspaceID != workspaceId {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only available to operators of the deployment"})
+			return
+		}
+		ctx.Next()
+	}
+}
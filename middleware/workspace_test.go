@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nishantd01/penguin-core/config"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/internal/testdb"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/service"
+)
+
+func TestReportInWorkspace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t, `
+		CREATE TABLE penguin.spreadsheet (id TEXT PRIMARY KEY, workspace_id TEXT NOT NULL);
+		INSERT INTO penguin.spreadsheet VALUES ('sheet-a', 'w1'), ('sheet-b', 'w2');
+	`)
+	sources := datasource.NewRegistry(db, nil, "", "")
+	defer sources.Close()
+	users := service.NewUserService(db, sources, &config.Config{})
+	defer users.Close()
+
+	as := func(workspaceId string) gin.HandlerFunc {
+		return func(ctx *gin.Context) {
+			if workspaceId != "" {
+				ctx.Set(principalKey, &models.Principal{UserID: "u1", WorkspaceID: workspaceId})
+			}
+		}
+	}
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	r := gin.New()
+	r.GET("/w1/reports/:id", as("w1"), ReportInWorkspace(users), ok)
+	r.GET("/anonymous/reports/:id", as(""), ReportInWorkspace(users), ok)
+	r.GET("/w1/deployment", as("w1"), RequireWorkspace("w1"), ok)
+	r.GET("/w2/deployment", as("w2"), RequireWorkspace("w1"), ok)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/w1/reports/sheet-a", http.StatusOK},
+		// Another workspace's report looks just like a missing one
+		{"/w1/reports/sheet-b", http.StatusNotFound},
+		{"/w1/reports/sheet-x", http.StatusNotFound},
+		{"/anonymous/reports/sheet-a", http.StatusNotFound},
+		{"/w1/deployment", http.StatusOK},
+		{"/w2/deployment", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s answered %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+// DefaultWorkspaceID is the workspace created with the schema. Its admins
+// administer the deployment as a whole.
+const DefaultWorkspaceID = "00000000-0000-0000-0000-000000000001"
+
+// Principal is the authenticated caller of an API request, resolved to a
+// row in penguin.user.
+type Principal struct {
+	UserID   string `json:"userId"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	RoleID   string `json:"roleId"`
+	RoleName string `json:"roleName"`
+	// WorkspaceID scopes everything the principal sees.
+	WorkspaceID string `json:"workspaceId"`
+	AuthMethod  string `json:"authMethod"` // "api_key" or "jwt"
+}
+
+func (p *Principal) HasRole(roles []string) bool {
+	for _, r := range roles {
+		if r == p.RoleName {
+			return true
+		}
+	}
+	return false
+}
@@ -6,8 +6,31 @@ type Column struct {
 	WritableBy []string `json:"writableBy"`
 }
 
+// Parameter declares a {{name}} placeholder used in a report's SqlScript.
+// Type is one of string, integer, number, boolean, date or timestamp.
+type Parameter struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Default  interface{} `json:"default,omitempty"`
+	Required bool        `json:"required,omitempty"`
+}
+
 type ReportInput struct {
-	ReportName string   `json:"reportName"`
-	SqlScript  string   `json:"sqlScript"` // could use this script or dummy table
-	Columns    []Column `json:"columns"`
+	ReportName      string                 `json:"reportName"`
+	SqlScript       string                 `json:"sqlScript"` // could use this script or dummy table
+	DBName          string                 `json:"dbName"`    // data source to run SqlScript against, empty for the default
+	Columns         []Column               `json:"columns"`
+	Parameters      []Parameter            `json:"parameters"`
+	ParameterValues map[string]interface{} `json:"parameterValues"`
+	// KeyColumn identifies a row across versions of the report, so diffs
+	// can match rows by it. Optional; rows are matched by position without.
+	KeyColumn string `json:"keyColumn,omitempty"`
+	// CacheTTL is how long the report's query results are reused, as a Go
+	// duration: empty for the server default, "0s" to never reuse them.
+	CacheTTL string `json:"cacheTtl,omitempty"`
+	// NoCache runs the query even when a cached result exists.
+	NoCache bool `json:"noCache,omitempty"`
+	// TemplateId records the template a report was instantiated from. It
+	// is set by the server, never read from requests.
+	TemplateId string `json:"-"`
 }
@@ -0,0 +1,296 @@
+// Package querycache keeps query results for a while, so that validating,
+// previewing and building reports from the same query does not run it
+// against the data source every time. Small results are held in an
+// in-memory LRU; larger ones are spilled to files in a directory, which
+// has an LRU and size budget of its own.
+//
+// The cache is process-local: replicas each keep their own results, and
+// invalidating drops them only in the process that handles the request.
+package querycache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Status tells how a result was served.
+type Status string
+
+const (
+	Hit  Status = "hit"
+	Miss Status = "miss"
+	// Bypass is a miss the caller asked for: the cache was not read, but
+	// the fresh result replaced whatever it held.
+	Bypass Status = "bypass"
+)
+
+type Config struct {
+	// MemoryBytes is the budget of the in-memory LRU.
+	MemoryBytes int64
+	// SpillBytes is the largest encoded result kept in memory; larger
+	// ones go to Dir.
+	SpillBytes int64
+	// Dir holds spilled results, up to DiskBytes, in a subdirectory of
+	// its own per process. Results too large for memory are not cached
+	// when it is empty.
+	Dir       string
+	DiskBytes int64
+}
+
+type Stats struct {
+	MemoryEntries int   `json:"memoryEntries"`
+	MemoryBytes   int64 `json:"memoryBytes"`
+	DiskEntries   int   `json:"diskEntries"`
+	DiskBytes     int64 `json:"diskBytes"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+}
+
+// fileSuffix marks the files of spilled results.
+const fileSuffix = ".qcache"
+
+type entry struct {
+	key     string
+	tags    []string
+	expires time.Time
+	size    int64
+	// data is the gob-encoded result, nil for results on disk.
+	data   []byte
+	onDisk bool
+}
+
+// Cache is safe for concurrent use. Its index lives in memory, so a
+// restart starts from empty.
+type Cache struct {
+	cfg Config
+
+	mu sync.Mutex
+	// Front is most recently used.
+	memory, disk *list.List
+	items        map[string]*list.Element
+	memoryBytes  int64
+	diskBytes    int64
+	hits, misses int64
+}
+
+func New(cfg Config) *Cache {
+	c := &Cache{cfg: cfg, memory: list.New(), disk: list.New(), items: make(map[string]*list.Element)}
+	if cfg.Dir == "" {
+		return c
+	}
+	// Dir may be shared with other processes, so each keeps its files
+	// apart and never touches anyone else's
+	err := os.MkdirAll(cfg.Dir, 0o700)
+	if err == nil {
+		c.cfg.Dir, err = os.MkdirTemp(cfg.Dir, "run-*")
+	}
+	if err != nil {
+		log.Printf("⚠️ Query cache keeps results in memory only, cannot use %s: %v", cfg.Dir, err)
+		c.cfg.Dir = ""
+	}
+	return c
+}
+
+// Close drops every result and deletes the cache's spill directory.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memory.Init()
+	c.disk.Init()
+	c.items = make(map[string]*list.Element)
+	c.memoryBytes, c.diskBytes = 0, 0
+	if c.cfg.Dir == "" {
+		return nil
+	}
+	return os.RemoveAll(c.cfg.Dir)
+}
+
+// Key hashes the parts that identify a result into a cache key.
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get decodes the result stored under key into v and reports whether
+// there was one.
+func (c *Cache) Get(key string, v interface{}) bool {
+	data, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		log.Printf("⚠️ Dropping unreadable query cache entry: %v", err)
+		c.Remove(key)
+		return false
+	}
+	return true
+}
+
+func (c *Cache) lookup(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.removeLocked(el)
+		c.misses++
+		return nil, false
+	}
+
+	data := e.data
+	if e.onDisk {
+		var err error
+		if data, err = os.ReadFile(c.path(key)); err != nil {
+			log.Printf("⚠️ Dropping query cache entry whose file is unreadable: %v", err)
+			c.removeLocked(el)
+			c.misses++
+			return nil, false
+		}
+		c.disk.MoveToFront(el)
+	} else {
+		c.memory.MoveToFront(el)
+	}
+	c.hits++
+	return data, true
+}
+
+// Set stores v under key, one returned by Key, for ttl, replacing any
+// earlier result. tags let Invalidate drop groups of results at once.
+// Results too large for both stores are not cached; a ttl of 0 or less
+// caches nothing.
+func (c *Cache) Set(key string, v interface{}, ttl time.Duration, tags ...string) error {
+	if ttl <= 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	e := &entry{key: key, tags: tags, expires: time.Now().Add(ttl), size: int64(buf.Len())}
+
+	if e.size <= c.cfg.SpillBytes && e.size <= c.cfg.MemoryBytes {
+		e.data = buf.Bytes()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.removeKeyLocked(key)
+		c.items[key] = c.memory.PushFront(e)
+		c.memoryBytes += e.size
+		for c.memoryBytes > c.cfg.MemoryBytes {
+			c.removeLocked(c.memory.Back())
+		}
+		return nil
+	}
+
+	if c.cfg.Dir == "" || e.size > c.cfg.DiskBytes {
+		return nil
+	}
+	// Write outside the lock, then move the file in place under it
+	tmp, err := os.CreateTemp(c.cfg.Dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeKeyLocked(key)
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	e.onDisk = true
+	c.items[key] = c.disk.PushFront(e)
+	c.diskBytes += e.size
+	for c.diskBytes > c.cfg.DiskBytes {
+		c.removeLocked(c.disk.Back())
+	}
+	return nil
+}
+
+// Remove drops the result stored under key, if any.
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeKeyLocked(key)
+}
+
+// Invalidate drops every result stored with tag and returns how many
+// there were.
+func (c *Cache) Invalidate(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, el := range c.items {
+		if slices.Contains(el.Value.(*entry).tags, tag) {
+			c.removeLocked(el)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		MemoryEntries: c.memory.Len(),
+		MemoryBytes:   c.memoryBytes,
+		DiskEntries:   c.disk.Len(),
+		DiskBytes:     c.diskBytes,
+		Hits:          c.hits,
+		Misses:        c.misses,
+	}
+}
+
+func (c *Cache) removeKeyLocked(key string) {
+	if el, ok := c.items[key]; ok {
+		c.removeLocked(el)
+	}
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	e := el.Value.(*entry)
+	delete(c.items, e.key)
+	if e.onDisk {
+		c.disk.Remove(el)
+		c.diskBytes -= e.size
+		if err := os.Remove(c.path(e.key)); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ Could not delete query cache file: %v", err)
+		}
+		return
+	}
+	c.memory.Remove(el)
+	c.memoryBytes -= e.size
+}
+
+// path is the file of a spilled result. Keys are hex hashes, so they are
+// safe file names.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.cfg.Dir, key+fileSuffix)
+}
@@ -0,0 +1,159 @@
+package querycache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryLRU(t *testing.T) {
+	// Each value encodes to a little over 100 bytes, so three fit
+	c := New(Config{MemoryBytes: 350, SpillBytes: 1 << 10})
+	value := func(s string) string { return strings.Repeat(s, 100) }
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := c.Set(k, value(k), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got string
+	// Reading a makes b the least recently used
+	if !c.Get("a", &got) || got != value("a") {
+		t.Fatalf("Get(a) = %q", got)
+	}
+	if err := c.Set("d", value("d"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if ok := c.Get(k, &got); ok != want {
+			t.Errorf("Get(%s) = %v, want %v", k, ok, want)
+		}
+	}
+	if st := c.Stats(); st.MemoryEntries != 3 || st.MemoryBytes > 350 || st.DiskEntries != 0 {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	parent := t.TempDir()
+	// Another process's file in the shared directory must survive
+	other := filepath.Join(parent, "other"+fileSuffix)
+	if err := os.WriteFile(other, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(Config{MemoryBytes: 1 << 10, SpillBytes: 64, Dir: parent, DiskBytes: 450})
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("New removed a file it does not own: %v", err)
+	}
+	if filepath.Dir(c.cfg.Dir) != parent {
+		t.Fatalf("spill directory %s is not under %s", c.cfg.Dir, parent)
+	}
+
+	small, large := "small", strings.Repeat("x", 200)
+	for k, v := range map[string]string{"s": small, "l1": large, "l2": large} {
+		if err := c.Set(k, v, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := c.Stats(); st.MemoryEntries != 1 || st.DiskEntries != 2 {
+		t.Fatalf("Stats() = %+v", st)
+	}
+	var got string
+	if !c.Get("l1", &got) || got != large {
+		t.Fatalf("Get(l1) = %q", got)
+	}
+
+	// Over DiskBytes, the least recently used file goes
+	if err := c.Set("l3", large, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if c.Get("l2", &got) {
+		t.Error("l2 was not evicted")
+	}
+	if _, err := os.Stat(c.path("l2")); !os.IsNotExist(err) {
+		t.Errorf("file of evicted l2 left behind: %v", err)
+	}
+	if !c.Get("l1", &got) || !c.Get("l3", &got) {
+		t.Error("recently used results were evicted")
+	}
+
+	// Too large for either store
+	if err := c.Set("huge", strings.Repeat("x", 1000), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if c.Get("huge", &got) {
+		t.Error("result larger than DiskBytes was cached")
+	}
+
+	dir := c.cfg.Dir
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Close left %s: %v", dir, err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Close removed a file it does not own: %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := New(Config{MemoryBytes: 1 << 10, SpillBytes: 1 << 10})
+	if err := c.Set("k", 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("never", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var got int
+	if c.Get("k", &got) {
+		t.Error("expired result was served")
+	}
+	if c.Get("never", &got) {
+		t.Error("result with no ttl was cached")
+	}
+	if st := c.Stats(); st.MemoryEntries != 0 || st.Hits != 0 || st.Misses != 2 {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := New(Config{MemoryBytes: 1 << 10, SpillBytes: 1 << 10})
+	sets := []struct {
+		key  string
+		tags []string
+	}{
+		{"a", []string{"source:x", "query:1"}},
+		{"b", []string{"source:x", "query:2"}},
+		{"c", []string{"source:y", "query:1"}},
+	}
+	for _, s := range sets {
+		if err := c.Set(s.key, s.key, time.Minute, s.tags...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := c.Invalidate("query:1"); n != 2 {
+		t.Errorf("Invalidate(query:1) = %d, want 2", n)
+	}
+	var left []string
+	for _, k := range []string{"a", "b", "c"} {
+		var got string
+		if c.Get(k, &got) {
+			left = append(left, got)
+		}
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("left %v, want %v", left, want)
+	}
+	if n := c.Invalidate("source:y"); n != 0 {
+		t.Errorf("Invalidate(source:y) = %d, want 0", n)
+	}
+}
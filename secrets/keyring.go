@@ -0,0 +1,161 @@
+// Package secrets stores credentials in penguin.secret with envelope
+// encryption: every value is sealed with its own data key, and data keys
+// are sealed with a key encryption key (KEK) taken from the environment.
+// Rotating the KEK only re-seals the data keys.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid secret key")
+	ErrUnknownKey = errors.New("secret sealed with an unknown key")
+)
+
+// keySize is the length of KEKs and data keys: AES-256.
+const keySize = 32
+
+type kek struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring holds the current KEK, which seals new data keys, and the
+// retired ones still needed to open data keys sealed before a rotation.
+type Keyring struct {
+	current *kek
+	keys    map[string]*kek
+}
+
+// NewKeyring parses base64 encoded 32 byte keys. A key's ID is derived
+// from the key itself, so retired keys can be listed in any order.
+func NewKeyring(current string, retired []string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*kek)}
+	cur, err := parseKEK(current)
+	if err != nil {
+		return nil, err
+	}
+	k.current = cur
+	k.keys[cur.id] = cur
+
+	for _, raw := range retired {
+		old, err := parseKEK(raw)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[old.id] = old
+	}
+	return k, nil
+}
+
+// CurrentKeyID identifies the KEK new data keys are sealed with.
+func (k *Keyring) CurrentKeyID() string {
+	return k.current.id
+}
+
+func parseKEK(raw string) (*kek, error) {
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("%w: want %d bytes, got %d", ErrInvalidKey, keySize, len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &kek{id: hex.EncodeToString(sum[:])[:16], aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a fresh nonce, which it prepends to the
+// result. ad binds the ciphertext to the secret's name.
+func seal(aead cipher.AEAD, plaintext, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func open(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, ad)
+}
+
+// encrypt seals value under a new data key, and the data key under the
+// current KEK.
+func (k *Keyring) encrypt(name string, value []byte) (ciphertext, wrappedKey []byte, keyID string, err error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if ciphertext, err = seal(aead, value, []byte(name)); err != nil {
+		return nil, nil, "", err
+	}
+	if wrappedKey, err = seal(k.current.aead, dataKey, []byte(name)); err != nil {
+		return nil, nil, "", err
+	}
+	return ciphertext, wrappedKey, k.current.id, nil
+}
+
+func (k *Keyring) decrypt(name string, ciphertext, wrappedKey []byte, keyID string) ([]byte, error) {
+	dataKey, err := k.unwrap(name, wrappedKey, keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	value, err := open(aead, ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+	}
+	return value, nil
+}
+
+func (k *Keyring) unwrap(name string, wrappedKey []byte, keyID string) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (key %s)", ErrUnknownKey, name, keyID)
+	}
+	dataKey, err := open(key.aead, wrappedKey, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of %s: %w", name, err)
+	}
+	return dataKey, nil
+}
+
+// rewrap seals a data key under the current KEK.
+func (k *Keyring) rewrap(name string, wrappedKey []byte, keyID string) ([]byte, error) {
+	dataKey, err := k.unwrap(name, wrappedKey, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return seal(k.current.aead, dataKey, []byte(name))
+}
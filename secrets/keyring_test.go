@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	b := make([]byte, keySize)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestSealOpen(t *testing.T) {
+	keys, err := NewKeyring(newKey(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []byte(`{"client_id": "x"}`)
+	ciphertext, wrappedKey, keyID, err := keys.encrypt("google.client_secret", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != keys.CurrentKeyID() {
+		t.Fatalf("sealed with key %s, current is %s", keyID, keys.CurrentKeyID())
+	}
+	if bytes.Contains(ciphertext, value) {
+		t.Fatal("ciphertext contains the value")
+	}
+
+	got, err := keys.decrypt("google.client_secret", ciphertext, wrappedKey, keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("decrypt = %q, want %q", got, value)
+	}
+
+	// The name is bound to the ciphertext, so a value cannot be moved to
+	// another secret
+	if _, err := keys.decrypt("google.oauth_token", ciphertext, wrappedKey, keyID); err == nil {
+		t.Fatal("decrypted a value under another name")
+	}
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := keys.decrypt("google.client_secret", tampered, wrappedKey, keyID); err == nil {
+		t.Fatal("decrypted a tampered value")
+	}
+}
+
+func TestEncryptUsesFreshDataKeys(t *testing.T) {
+	keys, err := NewKeyring(newKey(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, k1, _, err := keys.encrypt("dsn", []byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, k2, _, err := keys.encrypt("dsn", []byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(c1, c2) || bytes.Equal(k1, k2) {
+		t.Fatal("two encryptions of the same value are identical")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	oldKEK, newKEK := newKey(t), newKey(t)
+	before, err := NewKeyring(oldKEK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, wrappedKey, oldID, err := before.encrypt("dsn", []byte("postgres://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := NewKeyring(newKEK, []string{oldKEK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.CurrentKeyID() == oldID {
+		t.Fatal("rotated keyring kept the old key ID")
+	}
+	// Retired keys still open what they sealed
+	if _, err := rotated.decrypt("dsn", ciphertext, wrappedKey, oldID); err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, err := rotated.rewrap("dsn", wrappedKey, oldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewKeyring(newKEK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := after.decrypt("dsn", ciphertext, rewrapped, after.CurrentKeyID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "postgres://" {
+		t.Fatalf("decrypt after rewrap = %q", got)
+	}
+
+	// Once the retired key is dropped, the old wrapping cannot be opened
+	if _, err := after.decrypt("dsn", ciphertext, wrappedKey, oldID); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("decrypt with a dropped key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestNewKeyringRejectsBadKeys(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	for name, tt := range map[string]struct {
+		current string
+		retired []string
+	}{
+		"not base64":    {"not base64!", nil},
+		"short key":     {short, nil},
+		"short retired": {newKey(t), []string{short}},
+		"empty":         {"", nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.current, tt.retired); !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("NewKeyring = %v, want ErrInvalidKey", err)
+			}
+		})
+	}
+}
+
+func TestKeyIDIsStable(t *testing.T) {
+	key := newKey(t)
+	a, err := NewKeyring(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewKeyring(newKey(t), []string{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.keys[a.CurrentKeyID()]; !ok {
+		t.Fatal("a retired key has another ID than when it was current")
+	}
+}
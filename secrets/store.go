@@ -0,0 +1,185 @@
+package secrets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("secret not found")
+	ErrInvalidName = errors.New("invalid secret name")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// Secret describes a stored secret. Values are never listed.
+type Secret struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	KeyId     string    `json:"keyId"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store reads and writes penguin.secret.
+type Store struct {
+	db   *sql.DB
+	keys *Keyring
+}
+
+func NewStore(db *sql.DB, keys *Keyring) *Store {
+	return &Store{db: db, keys: keys}
+}
+
+// Get returns the value of a secret.
+func (s *Store) Get(ctx context.Context, name string) ([]byte, error) {
+	value, _, err := s.GetVersion(ctx, name)
+	return value, err
+}
+
+// GetVersion returns the value of a secret and its version, which goes up
+// by one each time the value is replaced.
+func (s *Store) GetVersion(ctx context.Context, name string) ([]byte, int, error) {
+	var ciphertext, wrappedKey []byte
+	var keyID string
+	var version int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT ciphertext, wrapped_key, key_id, version FROM penguin.secret WHERE name = $1
+	`, name).Scan(&ciphertext, &wrappedKey, &keyID, &version)
+	if err == sql.ErrNoRows {
+		return nil, 0, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	value, err := s.keys.decrypt(name, ciphertext, wrappedKey, keyID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, version, nil
+}
+
+// Version returns the current version of a secret without decrypting it.
+func (s *Store) Version(ctx context.Context, name string) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT version FROM penguin.secret WHERE name = $1`, name).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return version, err
+}
+
+// Put creates a secret or replaces its value, under a new data key.
+func (s *Store) Put(ctx context.Context, name string, value []byte) (*Secret, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	ciphertext, wrappedKey, keyID, err := s.keys.encrypt(name, value)
+	if err != nil {
+		return nil, err
+	}
+
+	sec := Secret{Name: name, KeyId: keyID}
+	now := time.Now()
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO penguin.secret (name, ciphertext, wrapped_key, key_id, version, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, 1, $5, $5)
+		ON CONFLICT (name) DO UPDATE SET
+			ciphertext = EXCLUDED.ciphertext, wrapped_key = EXCLUDED.wrapped_key, key_id = EXCLUDED.key_id,
+			version = penguin.secret.version + 1, updated_at = EXCLUDED.updated_at
+		RETURNING version, created_at, updated_at
+	`, name, ciphertext, wrappedKey, keyID, now).Scan(&sec.Version, &sec.CreatedAt, &sec.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &sec, nil
+}
+
+func (s *Store) Delete(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM penguin.secret WHERE name = $1`, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return nil
+}
+
+func (s *Store) List(ctx context.Context) ([]Secret, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT name, version, key_id, created_at, updated_at FROM penguin.secret ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Secret{}
+	for rows.Next() {
+		var sec Secret
+		if err := rows.Scan(&sec.Name, &sec.Version, &sec.KeyId, &sec.CreatedAt, &sec.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, sec)
+	}
+	return list, rows.Err()
+}
+
+// Rewrap seals every data key not yet under the current KEK with it, so
+// the retired keys can be dropped. Values and versions are unchanged. It
+// returns the number of secrets re-sealed.
+func (s *Store) Rewrap(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	current := s.keys.CurrentKeyID()
+	rows, err := tx.QueryContext(ctx, `
+		SELECT name, wrapped_key, key_id FROM penguin.secret WHERE key_id <> $1 FOR UPDATE
+	`, current)
+	if err != nil {
+		return 0, err
+	}
+	type rewrapped struct {
+		name string
+		key  []byte
+	}
+	var pending []rewrapped
+	for rows.Next() {
+		var name, keyID string
+		var wrappedKey []byte
+		if err := rows.Scan(&name, &wrappedKey, &keyID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		key, err := s.keys.rewrap(name, wrappedKey, keyID)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, rewrapped{name, key})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range pending {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE penguin.secret SET wrapped_key = $1, key_id = $2 WHERE name = $3
+		`, p.key, current, p.name); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(pending), nil
+}
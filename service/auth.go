@@ -0,0 +1,211 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+// apiKeyPrefix marks tokens that should be looked up in penguin.api_key
+// rather than verified as a JWT.
+const apiKeyPrefix = "pk_"
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	ErrAPIKeyNotFound  = errors.New("api key not found")
+	ErrUserNotFound    = errors.New("user not found")
+)
+
+type AuthService struct {
+	db        *sql.DB
+	jwtSecret []byte
+}
+
+func NewAuthService(db *sql.DB, jwtSecret string) *AuthService {
+	return &AuthService{db: db, jwtSecret: []byte(jwtSecret)}
+}
+
+type APIKeyMeta struct {
+	Id         string     `json:"id"`
+	UserId     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	UserId string `json:"user_id" binding:"required"`
+	Name   string `json:"name" binding:"required"`
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// Authenticate resolves a bearer token, either an API key or a JWT, to
+// the penguin.user it belongs to.
+func (s *AuthService) Authenticate(token string) (*models.Principal, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	if IsAPIKey(token) {
+		return s.authenticateAPIKey(token)
+	}
+	return s.authenticateJWT(token)
+}
+
+func (s *AuthService) authenticateAPIKey(key string) (*models.Principal, error) {
+	var keyID string
+	p := &models.Principal{AuthMethod: "api_key"}
+	err := s.db.QueryRow(`
+		SELECT k.id, u.id, u.name, u.email, r.id, r.name, u.workspace_id
+		FROM penguin.api_key k
+		JOIN penguin.user u ON u.id = k.user_id
+		JOIN penguin.role r ON r.id = u.role_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL
+	`, hashAPIKey(key)).Scan(&keyID, &p.UserID, &p.Name, &p.Email, &p.RoleID, &p.RoleName, &p.WorkspaceID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.Exec(`UPDATE penguin.api_key SET last_used_at = $1 WHERE id = $2`, time.Now(), keyID); err != nil {
+		log.Printf("Failed to record api key usage: %v", err)
+	}
+	return p, nil
+}
+
+func (s *AuthService) authenticateJWT(token string) (*models.Principal, error) {
+	if len(s.jwtSecret) == 0 {
+		return nil, ErrUnauthenticated
+	}
+
+	claims, err := utils.VerifyHS256(token, s.jwtSecret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	// Prefer the email claim; fall back to sub, which may hold either the
+	// user id or the email.
+	subject, _ := claims["email"].(string)
+	if subject == "" {
+		subject, _ = claims["sub"].(string)
+	}
+	if subject == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	query := `
+		SELECT u.id, u.name, u.email, r.id, r.name, u.workspace_id
+		FROM penguin.user u
+		JOIN penguin.role r ON r.id = u.role_id
+		WHERE u.email = $1
+	`
+	if _, err := uuid.Parse(subject); err == nil {
+		query = `
+		SELECT u.id, u.name, u.email, r.id, r.name, u.workspace_id
+		FROM penguin.user u
+		JOIN penguin.role r ON r.id = u.role_id
+		WHERE u.id = $1
+	`
+	}
+
+	p := &models.Principal{AuthMethod: "jwt"}
+	err = s.db.QueryRow(query, subject).Scan(&p.UserID, &p.Name, &p.Email, &p.RoleID, &p.RoleName, &p.WorkspaceID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CreateAPIKey issues a new key for a user of workspaceId. The plaintext
+// key is only returned here; the database keeps its SHA-256 hash.
+func (s *AuthService) CreateAPIKey(workspaceId string, req CreateAPIKeyRequest) (string, *APIKeyMeta, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+
+	meta := &APIKeyMeta{
+		Id:        uuid.New().String(),
+		UserId:    req.UserId,
+		Name:      req.Name,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		CreatedAt: time.Now(),
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO penguin.api_key (id, user_id, name, prefix, key_hash, created_at)
+		SELECT $1, u.id, $3, $4, $5, $6 FROM penguin.user u
+		WHERE u.id = $2 AND u.workspace_id = $7
+	`, meta.Id, meta.UserId, meta.Name, meta.Prefix, hashAPIKey(key), meta.CreatedAt, workspaceId)
+	if err != nil {
+		return "", nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", nil, ErrUserNotFound
+	}
+	return key, meta, nil
+}
+
+func (s *AuthService) ListAPIKeys(workspaceId string) ([]APIKeyMeta, error) {
+	rows, err := s.db.Query(`
+		SELECT k.id, k.user_id, k.name, k.prefix, k.created_at, k.last_used_at, k.revoked_at
+		FROM penguin.api_key k
+		JOIN penguin.user u ON u.id = k.user_id
+		WHERE u.workspace_id = $1
+		ORDER BY k.created_at
+	`, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKeyMeta
+	for rows.Next() {
+		var k APIKeyMeta
+		if err := rows.Scan(&k.Id, &k.UserId, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *AuthService) RevokeAPIKey(workspaceId, id string) error {
+	res, err := s.db.Exec(`
+		UPDATE penguin.api_key SET revoked_at = $1
+		WHERE id = $2 AND revoked_at IS NULL
+			AND user_id IN (SELECT id FROM penguin.user WHERE workspace_id = $3)
+	`, time.Now(), id, workspaceId)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
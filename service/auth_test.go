@@ -0,0 +1,110 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	s := newTestService(t)
+	auth := NewAuthService(s.db, "")
+
+	key, meta, err := auth.CreateAPIKey(alice.WorkspaceID, CreateAPIKeyRequest{UserId: aliceID, Name: "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := auth.Authenticate(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UserID != aliceID || p.RoleName != "ADMIN 2" || p.WorkspaceID != alice.WorkspaceID || p.AuthMethod != "api_key" {
+		t.Errorf("Authenticate() = %+v", p)
+	}
+
+	if _, err := auth.Authenticate(key + "0"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("unknown key: %v, want ErrUnauthenticated", err)
+	}
+	if err := auth.RevokeAPIKey(otherWorkspaceID, meta.Id); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoking from another workspace: %v, want ErrAPIKeyNotFound", err)
+	}
+	if keys, err := auth.ListAPIKeys(otherWorkspaceID); err != nil || len(keys) != 0 {
+		t.Errorf("other workspace lists %v, %v", keys, err)
+	}
+	if err := auth.RevokeAPIKey(alice.WorkspaceID, meta.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authenticate(key); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("revoked key: %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestCreateAPIKeyOtherWorkspace(t *testing.T) {
+	s := newTestService(t)
+	auth := NewAuthService(s.db, "")
+	if _, _, err := auth.CreateAPIKey(alice.WorkspaceID, CreateAPIKeyRequest{UserId: bobID, Name: "stolen"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("key for a user of another workspace: %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	s := newTestService(t)
+	const secret = "jwt-secret"
+	auth := NewAuthService(s.db, secret)
+	exp := time.Now().Add(time.Minute).Unix()
+
+	tests := []struct {
+		name   string
+		token  string
+		wantID string
+	}{
+		{"email", signJWT(t, secret, map[string]interface{}{"email": "bob@example.org", "exp": exp}), bobID},
+		{"sub user id", signJWT(t, secret, map[string]interface{}{"sub": aliceID, "exp": exp}), aliceID},
+		{"sub email", signJWT(t, secret, map[string]interface{}{"sub": "alice@example.com", "exp": exp}), aliceID},
+		{"unknown user", signJWT(t, secret, map[string]interface{}{"email": "eve@example.com", "exp": exp}), ""},
+		{"no subject", signJWT(t, secret, map[string]interface{}{"exp": exp}), ""},
+		{"expired", signJWT(t, secret, map[string]interface{}{"email": "bob@example.org", "exp": time.Now().Add(-time.Minute).Unix()}), ""},
+		{"wrong secret", signJWT(t, "other", map[string]interface{}{"email": "bob@example.org", "exp": exp}), ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := auth.Authenticate(tt.token)
+			if tt.wantID == "" {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Errorf("Authenticate() = %+v, %v, want ErrUnauthenticated", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.UserID != tt.wantID || p.AuthMethod != "jwt" {
+				t.Errorf("Authenticate() = %+v, want user %s", p, tt.wantID)
+			}
+		})
+	}
+
+	// Without a secret no JWT is accepted, however it is signed
+	unsigned := NewAuthService(s.db, "")
+	if _, err := unsigned.Authenticate(signJWT(t, "", map[string]interface{}{"email": "bob@example.org", "exp": exp})); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("JWT with auth disabled: %v, want ErrUnauthenticated", err)
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nishantd01/penguin-core/config"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/export"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/querycache"
+	"github.com/nishantd01/penguin-core/sqlutil"
+)
+
+var ErrInvalidCacheTTL = errors.New("invalid cacheTtl, want a duration such as \"10m\"")
+
+// newQueryCache returns nil when the cache is disabled.
+func newQueryCache(cfg *config.Config) *querycache.Cache {
+	if cfg.QueryCacheTTL <= 0 {
+		return nil
+	}
+	return querycache.New(querycache.Config{
+		MemoryBytes: int64(cfg.QueryCacheMemoryMB) << 20,
+		SpillBytes:  int64(cfg.QueryCacheSpillKB) << 10,
+		Dir:         cfg.QueryCacheDir,
+		DiskBytes:   int64(cfg.QueryCacheDiskMB) << 20,
+	})
+}
+
+// Close deletes the files of spilled query results.
+func (s *UserService) Close() {
+	if s.cache == nil {
+		return
+	}
+	if err := s.cache.Close(); err != nil {
+		log.Printf("⚠️ Could not delete query cache files: %v", err)
+	}
+}
+
+// queryResult is a report query's rows as cached, header first. The
+// report's extra columns are left out, since reports sharing a query may
+// add different ones.
+type queryResult struct {
+	Columns []export.Column
+	Data    [][]interface{}
+	// Truncated is set when the query returned more than MaxReportRows
+	// rows and only the first ones were kept.
+	Truncated bool
+}
+
+// messageWithRows appends a note about truncated results to a success
+// message.
+func (s *UserService) messageWithRows(msg string, result *queryResult) string {
+	if !result.Truncated {
+		return msg
+	}
+	return fmt.Sprintf("%s; only the first %d rows were written", msg, s.cfg.MaxReportRows)
+}
+
+// cacheKey identifies one kind of result of query, bound to args, on
+// source. extra holds whatever else the result depends on, such as a page.
+func cacheKey(kind string, source *datasource.Source, query string, args []interface{}, extra ...interface{}) string {
+	var sb strings.Builder
+	for _, values := range [][]interface{}{args, extra} {
+		for _, v := range values {
+			// The type too, so 1 and "1" differ
+			fmt.Fprintf(&sb, "%T:%v\x00", v, v)
+		}
+	}
+	return querycache.Key(kind, source.Name, sqlutil.Normalize(query), sb.String())
+}
+
+// The tags every result is stored with, so all results of a source or of
+// one query can be invalidated at once.
+func sourceTag(source string) string { return "source:" + source }
+
+func queryTag(source, query string) string {
+	return "query:" + querycache.Key(source, sqlutil.Normalize(query))
+}
+
+// cached reads the result stored under key into v, unless bypass is set,
+// and tells how the call is served.
+func (s *UserService) cached(key string, bypass bool, v interface{}) querycache.Status {
+	switch {
+	case s.cache == nil:
+		return querycache.Miss
+	case bypass:
+		return querycache.Bypass
+	case s.cache.Get(key, v):
+		return querycache.Hit
+	}
+	return querycache.Miss
+}
+
+// storeResult caches v for ttl. Failing to cache is not an error for the
+// caller, who has the result either way.
+func (s *UserService) storeResult(key string, v interface{}, ttl time.Duration, source *datasource.Source, query string) {
+	if s.cache == nil {
+		return
+	}
+	if err := s.cache.Set(key, v, ttl, sourceTag(source.Name), queryTag(source.Name, query)); err != nil {
+		log.Printf("⚠️ Could not cache query result: %v", err)
+	}
+}
+
+// reportTTL parses a report's cacheTtl, empty for the configured default.
+func (s *UserService) reportTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return s.cfg.QueryCacheTTL, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidCacheTTL, ttl)
+	}
+	return d, nil
+}
+
+// reportData runs the report query on behalf of principal, after checking
+// it against the role's data access policies, and returns the sheet rows,
+// header first, along with the column types. Results are reused for ttl
+// unless bypass is set; the cost estimate is only checked when the query
+// actually runs.
+func (s *UserService) reportData(principal *models.Principal, source *datasource.Source, query string, args []interface{}, newCols []models.Column, ttl time.Duration, bypass bool) (*queryResult, querycache.Status, error) {
+	if err := s.authorizeQuery(principal, source, query, args); err != nil {
+		return nil, "", err
+	}
+
+	key := cacheKey("report", source, query, args, s.cfg.MaxReportRows)
+	var result queryResult
+	status := s.cached(key, bypass, &result)
+	if status != querycache.Hit {
+		ctx := context.Background()
+		if _, err := s.estimateQuery(ctx, source, query, args); err != nil {
+			return nil, "", err
+		}
+		err := source.ReadTx(ctx, func(conn datasource.Conn) error {
+			var err error
+			result.Data, result.Columns, result.Truncated, err = readSheetData(conn, source, query, args, nil, s.cfg.MaxReportRows)
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		s.storeResult(key, &result, ttl, source, query)
+	}
+
+	data, columns := withExtraColumns(result, newCols)
+	return &queryResult{Columns: columns, Data: data, Truncated: result.Truncated}, status, nil
+}
+
+// withExtraColumns appends the report's extra columns to a query result,
+// with empty cells, unless the query returns them itself.
+func withExtraColumns(result queryResult, newCols []models.Column) ([][]interface{}, []export.Column) {
+	columns := append([]export.Column(nil), result.Columns...)
+	for _, col := range newCols {
+		if !containsColumn(columns, col.Name) {
+			columns = append(columns, export.Column{Name: col.Name, Type: datasource.TypeString})
+		}
+	}
+	if len(columns) == len(result.Columns) {
+		return result.Data, columns
+	}
+
+	data := make([][]interface{}, len(result.Data))
+	for i, row := range result.Data {
+		full := make([]interface{}, len(columns))
+		copy(full, row)
+		for j := len(row); j < len(columns); j++ {
+			if i == 0 {
+				full[j] = columns[j].Name
+			} else {
+				full[j] = ""
+			}
+		}
+		data[i] = full
+	}
+	return data, columns
+}
+
+// InvalidateReportCache drops the cached results of a report's query, for
+// every set of parameter values, and returns how many there were.
+func (s *UserService) InvalidateReportCache(sheetId string) (int, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return 0, err
+	}
+	if report.SqlScript == "" {
+		return 0, ErrNoStoredQuery
+	}
+	if s.cache == nil {
+		return 0, nil
+	}
+	source, err := s.reportSource(report)
+	if err != nil {
+		return 0, err
+	}
+	// Placeholders do not depend on the values, so the stored ones give
+	// the query every cached result was stored under
+	query, _, _, err := bindQuery(source, report.SqlScript, report.Definition.Parameters, report.ParameterValues)
+	if err != nil {
+		return 0, err
+	}
+	return s.cache.Invalidate(queryTag(source.Name, query)), nil
+}
+
+// InvalidateQueryCache drops the cached results of one data source of the
+// principal's workspace or, when dbName is empty, of all of them.
+func (s *UserService) InvalidateQueryCache(principal *models.Principal, dbName string) (int, error) {
+	var names []string
+	if dbName != "" {
+		source, err := s.source(principal, dbName)
+		if err != nil {
+			return 0, err
+		}
+		names = []string{source.Name}
+	} else {
+		sources, err := s.sources.List(principalWorkspace(principal))
+		if err != nil {
+			return 0, err
+		}
+		for _, src := range sources {
+			names = append(names, src.Name)
+		}
+	}
+	if s.cache == nil {
+		return 0, nil
+	}
+
+	n := 0
+	for _, name := range names {
+		n += s.cache.Invalidate(sourceTag(name))
+	}
+	return n, nil
+}
+
+// QueryCacheStats describes the whole cache, across workspaces.
+func (s *UserService) QueryCacheStats() (querycache.Stats, bool) {
+	if s.cache == nil {
+		return querycache.Stats{}, false
+	}
+	return s.cache.Stats(), true
+}
+
+// reportDataError maps an error of reportData to the status and message
+// create and refresh answer with, or 0 when there is none.
+func reportDataError(err error) (int, string) {
+	switch {
+	case err == nil:
+		return 0, ""
+	case errors.Is(err, ErrAccessDenied):
+		return http.StatusForbidden, err.Error()
+	case errors.Is(err, ErrQueryTooExpensive):
+		return http.StatusUnprocessableEntity, err.Error()
+	case datasource.IsStatementError(err):
+		return http.StatusBadRequest, err.Error()
+	}
+	log.Printf("Error preparing sheet data: %v", err)
+	return http.StatusInternalServerError, "Failed to prepare data for sheet"
+}
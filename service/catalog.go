@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+func (s *UserService) introspector(principal *models.Principal, dbName string) (*datasource.Source, datasource.Introspector, error) {
+	source, err := s.source(principal, dbName)
+	if err != nil {
+		return nil, nil, err
+	}
+	in, err := source.Introspector()
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, in, nil
+}
+
+// catalogPolicies returns the policies listings are filtered through, so
+// the catalog shows only what the caller's queries may read. all is set
+// for admins, who are not subject to policies.
+func (s *UserService) catalogPolicies(principal *models.Principal, source *datasource.Source) (policies []DataPolicy, all bool, err error) {
+	if principal == nil {
+		return nil, false, errNoPrincipalQuery
+	}
+	if principal.HasRole(s.cfg.AdminRoles) {
+		return nil, true, nil
+	}
+	policies, err = s.rolePolicies(principal.RoleID, source.Name)
+	return policies, false, err
+}
+
+// schemaGranted tells whether any policy covers a table of schema.
+func schemaGranted(policies []DataPolicy, schema string) bool {
+	for _, p := range policies {
+		if p.Schema == "*" || strings.EqualFold(p.Schema, schema) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UserService) ListSchemas(principal *models.Principal, dbName string) ([]datasource.SchemaInfo, error) {
+	source, in, err := s.introspector(principal, dbName)
+	if err != nil {
+		return nil, err
+	}
+	policies, all, err := s.catalogPolicies(principal, source)
+	if err != nil {
+		return nil, err
+	}
+	var schemas []datasource.SchemaInfo
+	err = source.Use(func(db *sql.DB) error {
+		schemas, err = in.Schemas(context.Background(), db)
+		return err
+	})
+	if err != nil || all {
+		return schemas, err
+	}
+
+	granted := []datasource.SchemaInfo{}
+	for _, schema := range schemas {
+		if schemaGranted(policies, schema.Name) {
+			granted = append(granted, schema)
+		}
+	}
+	return granted, nil
+}
+
+func (s *UserService) ListTables(principal *models.Principal, dbName, schema string) ([]datasource.TableInfo, error) {
+	source, in, err := s.introspector(principal, dbName)
+	if err != nil {
+		return nil, err
+	}
+	policies, all, err := s.catalogPolicies(principal, source)
+	if err != nil {
+		return nil, err
+	}
+	var tables []datasource.TableInfo
+	err = source.Use(func(db *sql.DB) error {
+		tables, err = in.Tables(context.Background(), db, schema)
+		return err
+	})
+	if err != nil || all {
+		return tables, err
+	}
+
+	granted := []datasource.TableInfo{}
+	for _, t := range tables {
+		if grantFor(policies, t.Schema, t.Name) != nil {
+			granted = append(granted, t)
+		}
+	}
+	return granted, nil
+}
+
+func (s *UserService) ListColumns(principal *models.Principal, dbName, schema, table string) ([]datasource.ColumnMeta, error) {
+	source, in, err := s.introspector(principal, dbName)
+	if err != nil {
+		return nil, err
+	}
+	policies, all, err := s.catalogPolicies(principal, source)
+	if err != nil {
+		return nil, err
+	}
+	// A table no policy covers looks missing rather than forbidden, so
+	// its existence is not given away either
+	grant := grantFor(policies, schema, table)
+	if !all && grant == nil {
+		return nil, nil
+	}
+	var columns []datasource.ColumnMeta
+	err = source.Use(func(db *sql.DB) error {
+		columns, err = in.Columns(context.Background(), db, schema, table)
+		return err
+	})
+	if err != nil || all || grant.allColumns {
+		return columns, err
+	}
+
+	granted := []datasource.ColumnMeta{}
+	for _, c := range columns {
+		if grant.columns[strings.ToLower(c.Name)] {
+			granted = append(granted, c)
+		}
+	}
+	return granted, nil
+}
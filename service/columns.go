@@ -0,0 +1,107 @@
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/export"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+// SheetColumn is a column of a report sheet. Id is stable for the life of
+// the report and is tagged on the sheet column as developer metadata, so
+// permissions follow the column rather than its header text. The list of
+// a report's columns, in sheet order, is its schema.
+type SheetColumn struct {
+	Id   string                `json:"id"`
+	Name string                `json:"name"`
+	Type datasource.ColumnType `json:"type"`
+}
+
+// assignColumnIds gives each column of a result an ID, reusing the ID of
+// the column with the same name in prev so a refresh keeps permissions.
+// Repeated names are matched in order.
+func assignColumnIds(prev []SheetColumn, columns []export.Column) []SheetColumn {
+	used := make([]bool, len(prev))
+	sheetCols := make([]SheetColumn, len(columns))
+	for i, col := range columns {
+		sheetCols[i] = SheetColumn{Name: col.Name, Type: col.Type}
+		for j, p := range prev {
+			if !used[j] && p.Name == col.Name {
+				used[j] = true
+				sheetCols[i].Id = p.Id
+				break
+			}
+		}
+		if sheetCols[i].Id == "" {
+			sheetCols[i].Id = uuid.New().String()
+		}
+	}
+	return sheetCols
+}
+
+func sheetColumnIds(columns []SheetColumn) []string {
+	ids := make([]string, len(columns))
+	for i, col := range columns {
+		ids[i] = col.Id
+	}
+	return ids
+}
+
+// keyColumnId returns the ID of the column named key, "" when key is
+// empty or not among columns.
+func keyColumnId(columns []SheetColumn, key string) string {
+	if key == "" {
+		return ""
+	}
+	for _, col := range columns {
+		if col.Name == key {
+			return col.Id
+		}
+	}
+	return ""
+}
+
+// columnPermissions maps each role to the IDs of the columns it may edit.
+// Report definitions name columns, so a name shared by several sheet
+// columns grants all of them.
+func columnPermissions(columns []models.Column, sheetCols []SheetColumn) map[string][]string {
+	permissions := make(map[string][]string)
+	for _, col := range columns {
+		for _, sc := range sheetCols {
+			if sc.Name != col.Name {
+				continue
+			}
+			for _, role := range col.WritableBy {
+				if !contains(permissions[role], sc.Id) {
+					permissions[role] = append(permissions[role], sc.Id)
+				}
+			}
+		}
+	}
+	return permissions
+}
+
+// parseSchema reads penguin.spreadsheet.schema. Reports created before
+// column IDs stored a name -> type object there; for those it returns nil.
+func parseSchema(raw []byte) ([]SheetColumn, error) {
+	if len(raw) == 0 || raw[0] != '[' {
+		return nil, nil
+	}
+	var columns []SheetColumn
+	if err := json.Unmarshal(raw, &columns); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// loadSheetColumns returns the columns of a report, or nil for reports
+// without column IDs.
+func (s *UserService) loadSheetColumns(sheetId string) ([]SheetColumn, error) {
+	var raw []byte
+	if err := s.db.QueryRow(`SELECT schema FROM penguin.spreadsheet WHERE id = $1`, sheetId).Scan(&raw); err != nil {
+		return nil, err
+	}
+	return parseSchema(raw)
+}
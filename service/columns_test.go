@@ -0,0 +1,35 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/export"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+func TestColumnPermissions(t *testing.T) {
+	sheetCols := []SheetColumn{{Id: "c1", Name: "id"}, {Id: "c2", Name: "note"}, {Id: "c3", Name: "note"}, {Id: "c4", Name: "amount"}}
+	columns := []models.Column{
+		{Name: "note", WritableBy: []string{"editor", "admin"}},
+		{Name: "amount", WritableBy: []string{"admin"}},
+		{Name: "note", WritableBy: []string{"admin"}},
+		{Name: "missing", WritableBy: []string{"editor"}},
+	}
+	want := map[string][]string{
+		"editor": {"c2", "c3"},
+		"admin":  {"c2", "c3", "c4"},
+	}
+	if got := columnPermissions(columns, sheetCols); !reflect.DeepEqual(got, want) {
+		t.Fatalf("columnPermissions = %v, want %v", got, want)
+	}
+}
+
+func TestAssignColumnIds(t *testing.T) {
+	prev := []SheetColumn{{Id: "c1", Name: "id"}, {Id: "c2", Name: "note"}, {Id: "c3", Name: "note"}}
+	got := assignColumnIds(prev, []export.Column{{Name: "note"}, {Name: "id"}, {Name: "new"}, {Name: "note"}})
+	ids := sheetColumnIds(got)
+	if ids[0] != "c2" || ids[1] != "c1" || ids[3] != "c3" || ids[2] == "" || ids[2] == "c1" {
+		t.Fatalf("assignColumnIds gave %v", ids)
+	}
+}
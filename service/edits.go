@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+var (
+	ErrInvalidIdentity   = errors.New("invalid identity token")
+	ErrEditHistoryDenied = errors.New("only admins may browse other users' edits")
+)
+
+const (
+	defaultEditPageSize = 100
+	maxEditPageSize     = 1000
+)
+
+// scriptCaller verifies the identity token a report's bound script sends,
+// from ScriptApp.getIdentityToken, and returns the report's workspace.
+// The edit trigger runs as the Google account that installed it, so the
+// token must be a Google ID token for ScriptClientID with the verified
+// email of the account recorded when Penguin installed the trigger.
+// Scripts run any other way, such as by an editor from the script editor,
+// carry their runner's token and are refused.
+func (s *UserService) scriptCaller(ctx context.Context, sheetId, idToken string) (string, error) {
+	var workspaceId, owner string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT workspace_id, COALESCE(trigger_owner, '') FROM penguin.spreadsheet WHERE id = $1
+	`, sheetId).Scan(&workspaceId, &owner)
+	if err == sql.ErrNoRows {
+		return "", ErrReportNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if idToken == "" {
+		return "", ErrInvalidIdentity
+	}
+
+	payload, err := s.validateIDToken(ctx, idToken, s.cfg.ScriptClientID)
+	if err != nil {
+		log.Printf("Rejected identity token for %s: %v", sheetId, err)
+		return "", ErrInvalidIdentity
+	}
+	email, _ := payload.Claims["email"].(string)
+	verified, _ := payload.Claims["email_verified"].(bool)
+	if email == "" || !verified {
+		return "", ErrInvalidIdentity
+	}
+	if owner == "" || !strings.EqualFold(email, owner) {
+		log.Printf("Rejected identity token for %s: %s is not the account its edit trigger runs as", sheetId, email)
+		return "", ErrInvalidIdentity
+	}
+	return workspaceId, nil
+}
+
+// scriptEditor authenticates a call from a report's edit trigger, see
+// scriptCaller, and returns the email of the editor it reports, from the
+// edit event, as stored for a user of the report's workspace.
+func (s *UserService) scriptEditor(ctx context.Context, sheetId, idToken, editor string) (string, error) {
+	workspaceId, err := s.scriptCaller(ctx, sheetId, idToken)
+	if err != nil {
+		return "", err
+	}
+	if editor == "" {
+		// Google leaves the editor out for some accounts
+		return "", ErrInvalidIdentity
+	}
+
+	var email string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT email FROM penguin.user WHERE workspace_id = $1 AND lower(email) = lower($2)
+	`, workspaceId, editor).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidIdentity
+	}
+	if err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+// EditInput is one allowed edit as the bound Apps Script reports it.
+// Editor is trusted only because the identity token the script sends
+// proves the call comes from the trigger Penguin installed.
+type EditInput struct {
+	Editor     string `json:"editor"`
+	Range      string `json:"range" binding:"required"`
+	SheetName  string `json:"sheet_name"`
+	ColumnName string `json:"column_name"`
+	Row        int    `json:"row" binding:"required,min=1"`
+	// ColumnIds are the IDs tagged on the range's columns, "" for
+	// untagged ones.
+	ColumnIds []string `json:"column_ids"`
+	OldValue  *string  `json:"old_value"`
+	NewValue  *string  `json:"new_value"`
+	// Values are the range's values after the edit, row by row.
+	Values [][]interface{} `json:"values"`
+	// RowKeys are the values of the report's key column in the range's
+	// rows, when the report has one.
+	RowKeys  []interface{} `json:"row_keys"`
+	EditedAt time.Time     `json:"edited_at"`
+}
+
+// ReportEdit is an entry of a report's edit history.
+type ReportEdit struct {
+	Id         string  `json:"id"`
+	SheetId    string  `json:"sheetId"`
+	Email      string  `json:"email"`
+	UserId     string  `json:"userId,omitempty"`
+	SheetName  string  `json:"sheetName,omitempty"`
+	Range      string  `json:"range"`
+	ColumnName string  `json:"columnName"`
+	ColumnId   string  `json:"columnId,omitempty"`
+	Row        int     `json:"row"`
+	OldValue   *string `json:"oldValue"`
+	NewValue   *string `json:"newValue"`
+	// NewValues are all the values of a multi-cell edit.
+	NewValues  [][]interface{} `json:"newValues,omitempty"`
+	EditedAt   time.Time       `json:"editedAt"`
+	ReceivedAt time.Time       `json:"receivedAt"`
+}
+
+// RecordEdit appends an edit to the history of sheetId, sent by its edit
+// trigger with the identity token idToken.
+func (s *UserService) RecordEdit(sheetId, idToken string, in EditInput) (*ReportEdit, error) {
+	email, err := s.scriptEditor(context.Background(), sheetId, idToken, in.Editor)
+	if err != nil {
+		return nil, err
+	}
+
+	e := ReportEdit{
+		Id:         uuid.New().String(),
+		SheetId:    sheetId,
+		Email:      email,
+		SheetName:  in.SheetName,
+		Range:      in.Range,
+		ColumnName: in.ColumnName,
+		Row:        in.Row,
+		OldValue:   in.OldValue,
+		NewValue:   in.NewValue,
+		NewValues:  in.Values,
+		EditedAt:   in.EditedAt,
+		ReceivedAt: time.Now(),
+	}
+	if len(in.ColumnIds) > 0 {
+		e.ColumnId = in.ColumnIds[0]
+	}
+	if e.EditedAt.IsZero() {
+		e.EditedAt = e.ReceivedAt
+	}
+
+	var newValues interface{}
+	if len(e.NewValues) > 1 || (len(e.NewValues) == 1 && len(e.NewValues[0]) > 1) {
+		raw, err := json.Marshal(e.NewValues)
+		if err != nil {
+			return nil, err
+		}
+		newValues = raw
+	} else {
+		e.NewValues = nil
+	}
+
+	var userId sql.NullString
+	err = s.db.QueryRow(`
+		INSERT INTO penguin.report_edit (id, spreadsheet_id, email, user_id, sheet_name, range_a1, column_name, column_id, row_number, old_value, new_value, new_values, edited_at, received_at)
+		VALUES ($1, $2, $3, (SELECT u.id FROM penguin.user u JOIN penguin.spreadsheet s ON s.workspace_id = u.workspace_id WHERE u.email = $3 AND s.id = $2), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING user_id
+	`, e.Id, sheetId, e.Email, nullString(e.SheetName), e.Range, e.ColumnName, nullString(e.ColumnId), e.Row, e.OldValue, e.NewValue, newValues, e.EditedAt, e.ReceivedAt).Scan(&userId)
+	if err != nil {
+		return nil, err
+	}
+	e.UserId = userId.String
+
+	// The journal entry is what the script waits on; keeping the last
+	// known values current is best effort
+	if err := s.applyEdit(sheetId, email, in); err != nil {
+		log.Printf("Failed to apply edit %s to last known values of %s: %v", e.Id, sheetId, err)
+	}
+	return &e, nil
+}
+
+// EditFilter narrows down an edit history listing. Zero values match
+// everything.
+type EditFilter struct {
+	WorkspaceId string
+	SheetId     string
+	Row         int
+	Email       string
+	Limit       int
+	Offset      int
+}
+
+// ListReportEdits returns the edit history of a report, newest first.
+// Edits carry cell values, so principal must be allowed to run the
+// report's query.
+func (s *UserService) ListReportEdits(principal *models.Principal, f EditFilter) ([]ReportEdit, error) {
+	report, err := s.loadReport(f.SheetId)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := s.authorizeReport(principal, report); err != nil {
+		return nil, err
+	}
+	return s.listEdits(f)
+}
+
+// ListUserEdits returns the edits made by email across the reports of the
+// caller's workspace, newest first. Callers may list their own edits;
+// admins anyone's.
+func (s *UserService) ListUserEdits(principal *models.Principal, f EditFilter) ([]ReportEdit, error) {
+	if principal == nil || (!strings.EqualFold(principal.Email, f.Email) && !principal.HasRole(s.cfg.AdminRoles)) {
+		return nil, ErrEditHistoryDenied
+	}
+	f.WorkspaceId = principal.WorkspaceID
+	return s.listEdits(f)
+}
+
+func (s *UserService) listEdits(f EditFilter) ([]ReportEdit, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.WorkspaceId != "" {
+		add("spreadsheet_id IN (SELECT id FROM penguin.spreadsheet WHERE workspace_id = $%d)", f.WorkspaceId)
+	}
+	if f.SheetId != "" {
+		add("spreadsheet_id = $%d", f.SheetId)
+	}
+	if f.Row > 0 {
+		add("row_number = $%d", f.Row)
+	}
+	if f.Email != "" {
+		add("lower(email) = lower($%d)", f.Email)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultEditPageSize
+	}
+	if limit > maxEditPageSize {
+		limit = maxEditPageSize
+	}
+	query := `
+		SELECT id, spreadsheet_id, email, COALESCE(user_id::text, ''), COALESCE(sheet_name, ''), range_a1,
+			COALESCE(column_name, ''), COALESCE(column_id, ''), row_number, old_value, new_value, new_values, edited_at, received_at
+		FROM penguin.report_edit`
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf("\n\t\tORDER BY edited_at DESC, id\n\t\tLIMIT %d OFFSET %d", limit, max(f.Offset, 0))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []ReportEdit{}
+	for rows.Next() {
+		var e ReportEdit
+		var oldValue, newValue sql.NullString
+		var newValues []byte
+		err := rows.Scan(&e.Id, &e.SheetId, &e.Email, &e.UserId, &e.SheetName, &e.Range,
+			&e.ColumnName, &e.ColumnId, &e.Row, &oldValue, &newValue, &newValues, &e.EditedAt, &e.ReceivedAt)
+		if err != nil {
+			return nil, err
+		}
+		if newValues != nil {
+			if err := json.Unmarshal(newValues, &e.NewValues); err != nil {
+				return nil, err
+			}
+		}
+		if oldValue.Valid {
+			e.OldValue = &oldValue.String
+		}
+		if newValue.Valid {
+			e.NewValue = &newValue.String
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/api/idtoken"
+)
+
+// stubIDTokens makes s accept the tokens in emails, issued for the
+// script-client audience and each for the email it maps to, verified
+// unless it is the token "unverified".
+func stubIDTokens(s *UserService, emails map[string]string) {
+	s.validateIDToken = func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+		email, ok := emails[token]
+		if !ok || audience != "script-client" {
+			return nil, errors.New("idtoken: invalid token")
+		}
+		return &idtoken.Payload{Audience: audience, Claims: map[string]interface{}{
+			"email":          email,
+			"email_verified": token != "unverified",
+		}}, nil
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	s := newTestService(t)
+	_, err := s.db.Exec(`
+		INSERT INTO penguin.spreadsheet (id, workspace_id, report_name, schema, trigger_owner) VALUES
+		('sheet-a', '00000000-0000-0000-0000-000000000001', 'Orders', '[{"id": "c1", "name": "note"}, {"id": "c2", "name": "amount"}]', 'Owner@example.com'),
+		('sheet-untriggered', '00000000-0000-0000-0000-000000000001', 'Old', NULL, NULL);
+		INSERT INTO penguin.spreadsheetpermissions (id, spreadsheet_id, role_id, columns_permissions) VALUES
+		('p1', 'sheet-a', 'b5d7cf7f-b2de-4a6c-8d44-0e8d3d1c7b12', '["c1"]'),
+		('p2', 'sheet-untriggered', 'b5d7cf7f-b2de-4a6c-8d44-0e8d3d1c7b12', '["note"]');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubIDTokens(s, map[string]string{
+		"trigger":    "owner@example.com",
+		"editor":     "alice@example.com",
+		"unverified": "owner@example.com",
+	})
+	column := func(id string) *string { return &id }
+
+	tests := []struct {
+		name    string
+		token   string
+		req     AccessCheckRequest
+		want    bool
+		wantErr error
+	}{
+		{"granted column", "trigger", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-a", ColumnId: column("c1")}, true, nil},
+		{"editor email case", "trigger", AccessCheckRequest{Email: "ALICE@example.com", SheetId: "sheet-a", ColumnId: column("c1")}, true, nil},
+		{"other column", "trigger", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-a", ColumnId: column("c2")}, false, nil},
+		{"script predating column ids", "trigger", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-a", ColumnName: "note"}, true, nil},
+		{"editor of another workspace", "trigger", AccessCheckRequest{Email: "bob@example.org", SheetId: "sheet-a", ColumnId: column("c1")}, false, ErrInvalidIdentity},
+		{"editor left out", "trigger", AccessCheckRequest{SheetId: "sheet-a", ColumnId: column("c1")}, false, ErrInvalidIdentity},
+		{"run by the editor", "editor", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-a", ColumnId: column("c1")}, false, ErrInvalidIdentity},
+		{"unverified email", "unverified", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-a", ColumnId: column("c1")}, false, ErrInvalidIdentity},
+		{"forged token", "forged", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-a", ColumnId: column("c1")}, false, ErrInvalidIdentity},
+		{"no token", "", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-a", ColumnId: column("c1")}, false, ErrInvalidIdentity},
+		{"trigger owner unknown", "trigger", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-untriggered", ColumnName: "note"}, false, ErrInvalidIdentity},
+		{"unknown sheet", "trigger", AccessCheckRequest{Email: "alice@example.com", SheetId: "sheet-x", ColumnId: column("c1")}, false, ErrReportNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.CheckAccess(tt.token, tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckAccess() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CheckAccess() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptCallerWorkspace(t *testing.T) {
+	s := newTestService(t)
+	_, err := s.db.Exec(`INSERT INTO penguin.spreadsheet (id, workspace_id, report_name, trigger_owner) VALUES ('sheet-b', '00000000-0000-0000-0000-000000000002', 'Other', 'owner@example.org')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubIDTokens(s, map[string]string{"trigger": "owner@example.org"})
+
+	workspaceId, err := s.scriptCaller(context.Background(), "sheet-b", "trigger")
+	if err != nil || workspaceId != otherWorkspaceID {
+		t.Errorf("scriptCaller() = %q, %v, want %s", workspaceId, err, otherWorkspaceID)
+	}
+	s.cfg.ScriptClientID = "another-client"
+	if _, err := s.scriptCaller(context.Background(), "sheet-b", "trigger"); !errors.Is(err, ErrInvalidIdentity) {
+		t.Errorf("token for another audience: %v, want ErrInvalidIdentity", err)
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/nishantd01/penguin-core/datasource"
+)
+
+var ErrQueryTooExpensive = errors.New("query is too expensive")
+
+// estimateQuery asks the source's planner for row and cost estimates and
+// enforces the configured cost ceiling. Sources whose driver cannot
+// explain queries return a nil estimate and are not limited.
+func (s *UserService) estimateQuery(ctx context.Context, source *datasource.Source, query string, args []interface{}) (*datasource.Estimate, error) {
+	var est *datasource.Estimate
+	err := source.ReadTx(ctx, func(conn datasource.Conn) error {
+		var err error
+		est, err = source.Explain(ctx, conn, query, args)
+		return err
+	})
+	if errors.Is(err, datasource.ErrExplainUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error estimating query: %w", err)
+	}
+
+	if s.cfg.MaxQueryCost > 0 && est.Cost > s.cfg.MaxQueryCost {
+		log.Printf("Refusing query on %s with estimated cost %.0f", source.Name, est.Cost)
+		return est, fmt.Errorf("%w: estimated cost %.0f exceeds the limit of %.0f", ErrQueryTooExpensive, est.Cost, s.cfg.MaxQueryCost)
+	}
+	return est, nil
+}
+
+// exactCount runs a full COUNT(*) over the query, bounded by the
+// configured timeout.
+func (s *UserService) exactCount(source *datasource.Source, query string, args []interface{}) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ExactCountTimeout)
+	defer cancel()
+
+	var count int64
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM (%s\n) AS sub", query)
+	err := source.ReadTx(ctx, func(conn datasource.Conn) error {
+		return conn.QueryRowContext(ctx, countQuery, args...).Scan(&count)
+	})
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return 0, fmt.Errorf("exact count exceeded the %s time limit", s.cfg.ExactCountTimeout)
+	}
+	return count, err
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/export"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+// ExportReport re-runs a report's stored query with its last parameter
+// values and streams the result as a file. open is called once the query
+// has started returning rows, with the file name to offer; errors before
+// that point can still be reported as a normal response. It reports
+// whether the file stops at MaxReportRows.
+func (s *UserService) ExportReport(principal *models.Principal, sheetId string, format export.Format, open func(filename string) io.Writer) (bool, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return false, err
+	}
+	source, query, args, err := s.authorizeReport(principal, report)
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	if _, err := s.estimateQuery(ctx, source, query, args); err != nil {
+		return false, err
+	}
+
+	var truncated bool
+	err = source.ReadTx(ctx, func(conn datasource.Conn) error {
+		var w export.Writer
+		var err error
+		truncated, err = scanReportRows(ctx, conn, source, query, args, report.Definition.Columns, s.cfg.MaxReportRows,
+			func(columns []export.Column) error {
+				var err error
+				w, err = export.NewWriter(format, open(exportFilename(report.ReportName, format)), columns)
+				return err
+			},
+			func(row []interface{}) error {
+				return w.WriteRow(row)
+			})
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	})
+	return truncated, err
+}
+
+// ExportVersion streams a stored snapshot of a report as a file, the
+// latest one when version is 0. The caller must still be allowed to run
+// the report's query: a snapshot is the same data. It reports whether the
+// file stops at MaxReportRows.
+func (s *UserService) ExportVersion(principal *models.Principal, sheetId string, version int, format export.Format, open func(filename string) io.Writer) (bool, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return false, err
+	}
+	if _, _, _, err := s.authorizeReport(principal, report); err != nil {
+		return false, err
+	}
+
+	v, err := s.loadVersion(sheetId, version)
+	if err != nil {
+		return false, err
+	}
+	rows := v.Rows
+	truncated := false
+	if max := s.cfg.MaxReportRows; max > 0 && len(rows) > max {
+		rows, truncated = rows[:max], true
+	}
+
+	filename := exportFilename(fmt.Sprintf("%s-v%d", report.ReportName, v.Version), format)
+	w, err := export.NewWriter(format, open(filename), v.Columns)
+	if err != nil {
+		return false, err
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			return false, err
+		}
+	}
+	return truncated, w.Close()
+}
+
+// authorizeReport binds a report's stored query with its last parameter
+// values and checks principal may run it. Reports of other workspaces are
+// not found.
+func (s *UserService) authorizeReport(principal *models.Principal, report *storedReport) (*datasource.Source, string, []interface{}, error) {
+	if principal != nil && principal.WorkspaceID != report.WorkspaceId {
+		return nil, "", nil, ErrReportNotFound
+	}
+	if report.SqlScript == "" {
+		return nil, "", nil, ErrNoStoredQuery
+	}
+
+	source, err := s.reportSource(report)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	query, args, _, err := bindQuery(source, report.SqlScript, report.Definition.Parameters, report.ParameterValues)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := s.authorizeQuery(principal, source, query, args); err != nil {
+		return nil, "", nil, err
+	}
+	return source, query, args, nil
+}
+
+// exportFilename turns a report name into a safe download file name.
+func exportFilename(reportName string, format export.Format) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, reportName)
+	if strings.Trim(name, "_.") == "" {
+		name = "report"
+	}
+	return fmt.Sprintf("%s.%s", name, format)
+}
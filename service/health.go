@@ -0,0 +1,478 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+// Drift issue kinds.
+const (
+	DriftColumnMissing     = "column_missing"
+	DriftColumnInserted    = "column_inserted"
+	DriftColumnMoved       = "column_moved"
+	DriftHeaderRenamed     = "header_renamed"
+	DriftHeaderUnprotected = "header_unprotected"
+	DriftScriptUnknown     = "script_unknown"
+	DriftScriptMissing     = "script_missing"
+	DriftScriptModified    = "script_modified"
+	DriftScriptOutdated    = "script_outdated"
+	DriftNoColumnIds       = "no_column_ids"
+	DriftTriggerMissing    = "trigger_missing"
+)
+
+// Severities of drift issues. Warnings do not make a report unhealthy.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// DriftIssue is one way a live spreadsheet differs from what
+// penguin.spreadsheet says it should be.
+type DriftIssue struct {
+	Kind       string `json:"kind"`
+	Severity   string `json:"severity"`
+	ColumnId   string `json:"columnId,omitempty"`
+	Expected   string `json:"expected,omitempty"`
+	Actual     string `json:"actual,omitempty"`
+	Repairable bool   `json:"repairable"`
+	Detail     string `json:"detail"`
+}
+
+type ReportHealth struct {
+	SheetId    string       `json:"sheetId"`
+	Healthy    bool         `json:"healthy"`
+	CheckedAt  time.Time    `json:"checkedAt"`
+	RepairedAt *time.Time   `json:"repairedAt,omitempty"`
+	Issues     []DriftIssue `json:"issues"`
+}
+
+// boundScript is what penguin.spreadsheet records about a report's bound
+// Apps Script.
+type boundScript struct {
+	Id      string
+	Version string
+	Hash    string
+
+	TriggerStatus string
+	TriggerError  string
+	TriggerOwner  string
+	DeploymentId  string
+}
+
+func newBoundScript(scriptId string, settings utils.ScriptSettings) (*boundScript, error) {
+	hash, err := utils.ScriptHash(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &boundScript{
+		Id:      scriptId,
+		Version: utils.ScriptVersion,
+		Hash:    hash,
+	}, nil
+}
+
+// CheckHealth compares a report's live spreadsheet against its stored
+// columns, header protection and bound script, and records the result.
+// Only the report's creator or an admin may check it.
+func (s *UserService) CheckHealth(principal *models.Principal, sheetId string) (*ReportHealth, error) {
+	report, err := s.ownReport(principal, sheetId)
+	if err != nil {
+		return nil, err
+	}
+	ctx := googleContext(context.Background(), report.GoogleCredentials)
+
+	health := &ReportHealth{SheetId: sheetId, CheckedAt: time.Now(), Issues: []DriftIssue{}}
+	add := func(issue DriftIssue) {
+		health.Issues = append(health.Issues, issue)
+	}
+
+	if report.Columns == nil {
+		add(DriftIssue{Kind: DriftNoColumnIds, Severity: SeverityWarning,
+			Detail: "report predates column IDs; refresh it to check its columns"})
+	} else {
+		header, err := utils.ReadHeaderRow(ctx, sheetId, "Sheet1")
+		if err != nil {
+			return nil, err
+		}
+		tagged, err := utils.ReadColumnIDs(ctx, sheetId)
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range columnDrift(report.Columns, header, tagged) {
+			add(issue)
+		}
+	}
+
+	protected, err := utils.HeaderProtected(ctx, sheetId, "Sheet1")
+	if err != nil {
+		return nil, err
+	}
+	if !protected {
+		add(DriftIssue{Kind: DriftHeaderUnprotected, Severity: SeverityError, Repairable: true,
+			Detail: "the header row is no longer protected"})
+	}
+
+	script, err := s.loadBoundScript(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case script.Id == "":
+		add(DriftIssue{Kind: DriftScriptUnknown, Severity: SeverityError,
+			Detail: "no bound script is recorded for this report; register its script ID"})
+	default:
+		hash, err := utils.ScriptSourceHash(ctx, script.Id)
+		switch {
+		case errors.Is(err, utils.ErrScriptNotFound):
+			add(DriftIssue{Kind: DriftScriptMissing, Severity: SeverityError, Repairable: true,
+				Detail: "the bound script project no longer exists"})
+		case err != nil:
+			return nil, err
+		case hash != script.Hash:
+			add(DriftIssue{Kind: DriftScriptModified, Severity: SeverityError, Repairable: true,
+				Detail: "the bound script's code was changed"})
+		case script.Version != utils.ScriptVersion:
+			add(DriftIssue{Kind: DriftScriptOutdated, Severity: SeverityWarning, Repairable: true,
+				Expected: utils.ScriptVersion, Actual: script.Version,
+				Detail: "the bound script was generated from an older template"})
+		}
+	}
+
+	// The script's calls are only accepted from a trigger whose account
+	// Penguin recorded when installing it. Reports created before that
+	// relied on someone enabling the trigger from the sheet's former
+	// menu, as whoever clicked it
+	switch {
+	case script.TriggerStatus == TriggerFailed:
+		add(DriftIssue{Kind: DriftTriggerMissing, Severity: SeverityError, Repairable: true,
+			Detail: "installing the edit trigger failed: " + script.TriggerError})
+	case script.TriggerOwner == "":
+		add(DriftIssue{Kind: DriftTriggerMissing, Severity: SeverityError, Repairable: true,
+			Detail: "the edit trigger was not installed by Penguin, so edits are denied; install it again"})
+	}
+
+	health.Healthy = true
+	for _, issue := range health.Issues {
+		if issue.Severity == SeverityError {
+			health.Healthy = false
+		}
+	}
+
+	if err := s.saveHealth(ctx, health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+// columnDrift compares the stored columns with the live header and the
+// column IDs tagged on the sheet, by 0-based index.
+func columnDrift(columns []SheetColumn, header []string, tagged map[int]string) []DriftIssue {
+	var issues []DriftIssue
+	at := make(map[string]int, len(tagged))
+	for idx, id := range tagged {
+		at[id] = idx
+	}
+
+	known := make(map[string]bool, len(columns))
+	for i, col := range columns {
+		known[col.Id] = true
+		idx, ok := at[col.Id]
+		if !ok {
+			issues = append(issues, DriftIssue{Kind: DriftColumnMissing, Severity: SeverityError, ColumnId: col.Id, Expected: col.Name,
+				Detail: fmt.Sprintf("column %q was deleted; refresh the report to rewrite it", col.Name)})
+			continue
+		}
+		actual := ""
+		if idx < len(header) {
+			actual = header[idx]
+		}
+		if actual != col.Name {
+			issues = append(issues, DriftIssue{Kind: DriftHeaderRenamed, Severity: SeverityError, ColumnId: col.Id,
+				Expected: col.Name, Actual: actual, Repairable: true,
+				Detail: fmt.Sprintf("header of column %s was changed", utils.ColumnLetters(idx))})
+		}
+		if idx != i {
+			issues = append(issues, DriftIssue{Kind: DriftColumnMoved, Severity: SeverityWarning, ColumnId: col.Id,
+				Expected: utils.ColumnLetters(i), Actual: utils.ColumnLetters(idx),
+				Detail: fmt.Sprintf("column %q was moved; permissions follow it", col.Name)})
+		}
+	}
+
+	for idx, name := range header {
+		if id, ok := tagged[idx]; (!ok || !known[id]) && name != "" {
+			issues = append(issues, DriftIssue{Kind: DriftColumnInserted, Severity: SeverityWarning, Actual: name,
+				Detail: fmt.Sprintf("column %s was added in Sheets; nobody can edit it", utils.ColumnLetters(idx))})
+		}
+	}
+	return issues
+}
+
+// RepairReport restores what can be restored of a drifted report: header
+// text, header protection and the bound script. Deleted columns need a
+// refresh. It returns the health after the repair. Only the report's
+// creator or an admin may repair it.
+func (s *UserService) RepairReport(principal *models.Principal, sheetId string) (*ReportHealth, error) {
+	report, err := s.ownReport(principal, sheetId)
+	if err != nil {
+		return nil, err
+	}
+	health, err := s.CheckHealth(nil, sheetId)
+	if err != nil {
+		return nil, err
+	}
+	ctx := googleContext(context.Background(), report.GoogleCredentials)
+
+	headers := make(map[int]string)
+	var protect, redeploy, trigger bool
+	for _, issue := range health.Issues {
+		switch issue.Kind {
+		case DriftHeaderRenamed:
+			tagged, err := utils.ReadColumnIDs(ctx, sheetId)
+			if err != nil {
+				return nil, err
+			}
+			for idx, id := range tagged {
+				if id == issue.ColumnId {
+					headers[idx] = issue.Expected
+				}
+			}
+		case DriftHeaderUnprotected:
+			protect = true
+		case DriftScriptMissing, DriftScriptModified, DriftScriptOutdated:
+			redeploy = true
+		case DriftTriggerMissing:
+			trigger = true
+		}
+	}
+
+	repaired := false
+	if len(headers) > 0 {
+		if err := utils.WriteHeaderCells(ctx, sheetId, "Sheet1", headers); err != nil {
+			return nil, err
+		}
+		repaired = true
+	}
+	if protect {
+		header := make([]interface{}, len(report.Columns))
+		for i, col := range report.Columns {
+			header[i] = col.Name
+		}
+		if err := utils.ProtectHeaderRow(ctx, sheetId, "Sheet1", [][]interface{}{header}); err != nil {
+			return nil, err
+		}
+		repaired = true
+	}
+	scriptId := ""
+	if redeploy {
+		if scriptId, err = s.redeployScript(ctx, sheetId, true); err != nil {
+			return nil, err
+		}
+		repaired = true
+	}
+	// A new script has no trigger yet, and an updated one needs a
+	// deployment of its new manifest. An unregistered script is left alone.
+	if redeploy || trigger {
+		if scriptId == "" {
+			current, err := s.loadBoundScript(sheetId)
+			if err != nil {
+				return nil, err
+			}
+			scriptId = current.Id
+		}
+	}
+	if scriptId != "" {
+		if err := s.installTrigger(ctx, sheetId, scriptId); err != nil {
+			log.Printf("Failed to install edit trigger of %s: %v", sheetId, err)
+		}
+		repaired = true
+	}
+
+	if repaired {
+		if _, err := s.db.Exec(`UPDATE penguin.report_health SET repaired_at = $1 WHERE spreadsheet_id = $2`, time.Now(), sheetId); err != nil {
+			return nil, err
+		}
+		log.Printf("🔧 Repaired report %s", sheetId)
+	}
+	return s.CheckHealth(nil, sheetId)
+}
+
+// redeployScript puts freshly generated code in the report's bound script
+// and returns its ID. When the recorded script is gone it binds a new one
+// if attach is set and fails with utils.ErrScriptNotFound otherwise. With
+// no recorded script it fails with ErrScriptUnregistered: the sheet may
+// still run one, and binding a second would leave the first in place.
+func (s *UserService) redeployScript(ctx context.Context, sheetId string, attach bool) (string, error) {
+	const scriptTitle = "BoundScriptForKshitiz"
+
+	current, err := s.loadBoundScript(sheetId)
+	if err != nil {
+		return "", err
+	}
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return "", err
+	}
+	settings := utils.ScriptSettings{BaseURL: s.cfg.PublicURL, KeyColumnId: keyColumnId(report.Columns, report.Definition.KeyColumn)}
+
+	scriptId := current.Id
+	if scriptId == "" {
+		return "", ErrScriptUnregistered
+	}
+	err = utils.UpdateScript(ctx, scriptId, settings)
+	if errors.Is(err, utils.ErrScriptNotFound) && attach {
+		scriptId, err = utils.AttachScript(ctx, sheetId, scriptTitle, settings)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	script, err := newBoundScript(scriptId, settings)
+	if err != nil {
+		return "", err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE penguin.spreadsheet
+		SET script_id = $1, script_version = $2, script_hash = $3,
+			deployment_id = CASE WHEN script_id = $1 THEN deployment_id END
+		WHERE id = $4
+	`, script.Id, script.Version, script.Hash, sheetId)
+	if err != nil {
+		return "", fmt.Errorf("script %s updated but not recorded: %w", scriptId, err)
+	}
+	return scriptId, nil
+}
+
+func (s *UserService) loadBoundScript(sheetId string) (*boundScript, error) {
+	var b boundScript
+	err := s.db.QueryRow(`
+		SELECT COALESCE(script_id, ''), COALESCE(script_version, ''), COALESCE(script_hash, ''),
+			trigger_status, COALESCE(trigger_error, ''), COALESCE(trigger_owner, ''), COALESCE(deployment_id, '')
+		FROM penguin.spreadsheet WHERE id = $1
+	`, sheetId).Scan(&b.Id, &b.Version, &b.Hash, &b.TriggerStatus, &b.TriggerError, &b.TriggerOwner, &b.DeploymentId)
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (s *UserService) saveHealth(ctx context.Context, h *ReportHealth) error {
+	issuesJSON, err := json.Marshal(h.Issues)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO penguin.report_health (spreadsheet_id, checked_at, healthy, issues)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (spreadsheet_id) DO UPDATE SET checked_at = $2, healthy = $3, issues = $4
+	`, h.SheetId, h.CheckedAt, h.Healthy, issuesJSON)
+	return err
+}
+
+// ListHealth returns the latest recorded check of every audited report of
+// a workspace, only the unhealthy ones when unhealthyOnly is set.
+func (s *UserService) ListHealth(workspaceId string, unhealthyOnly bool) ([]ReportHealth, error) {
+	rows, err := s.db.Query(`
+		SELECT h.spreadsheet_id, h.healthy, h.checked_at, h.repaired_at, h.issues
+		FROM penguin.report_health h
+		JOIN penguin.spreadsheet s ON s.id = h.spreadsheet_id
+		WHERE s.workspace_id = $1 AND (NOT $2 OR NOT h.healthy)
+		ORDER BY h.checked_at DESC
+	`, workspaceId, unhealthyOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []ReportHealth{}
+	for rows.Next() {
+		var h ReportHealth
+		var repairedAt sql.NullTime
+		var issues []byte
+		if err := rows.Scan(&h.SheetId, &h.Healthy, &h.CheckedAt, &repairedAt, &issues); err != nil {
+			return nil, err
+		}
+		if repairedAt.Valid {
+			h.RepairedAt = &repairedAt.Time
+		}
+		if err := json.Unmarshal(issues, &h.Issues); err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+	}
+	return list, rows.Err()
+}
+
+// RunHealthAudits checks every report once per interval, concurrency at a
+// time, repairing the unhealthy ones when autoRepair is set. It never
+// returns; run it in its own goroutine.
+func (s *UserService) RunHealthAudits(interval time.Duration, concurrency int, autoRepair bool) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.auditReports(concurrency, autoRepair)
+	}
+}
+
+func (s *UserService) auditReports(concurrency int, autoRepair bool) {
+	rows, err := s.db.Query(`SELECT id FROM penguin.spreadsheet ORDER BY created_at`)
+	if err != nil {
+		log.Printf("Health audit could not list reports: %v", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Printf("Health audit could not list reports: %v", err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	var unhealthy, repaired atomic.Int64
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(id string) {
+			defer func() { <-sem; wg.Done() }()
+			health, err := s.CheckHealth(nil, id)
+			if err != nil {
+				log.Printf("Health check of %s failed: %v", id, err)
+				return
+			}
+			if health.Healthy {
+				return
+			}
+			unhealthy.Add(1)
+			if autoRepair {
+				if health, err = s.RepairReport(nil, id); err != nil {
+					log.Printf("Repair of %s failed: %v", id, err)
+				} else if health.Healthy {
+					repaired.Add(1)
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+	log.Printf("🩺 Health audit: %d reports, %d unhealthy, %d repaired", len(ids), unhealthy.Load(), repaired.Load())
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/querycache"
+)
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("Idempotency-Key must be 1 to 255 characters")
+	ErrIdempotencyKeyReused  = errors.New("Idempotency-Key was already used with a different request body")
+)
+
+const (
+	// idempotencyKeyTTL is how long a completed outcome is replayed.
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long a key stays in progress before
+	// another call may take it over, in case the first one died midway.
+	idempotencyLockTimeout = 10 * time.Minute
+)
+
+const endpointCreateReport = "create-report"
+
+// ReportCreation is the outcome of an idempotent create-report call.
+type ReportCreation struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	SheetURL string `json:"sheetUrl"`
+	// Cache tells whether the report was built from a cached result.
+	Cache querycache.Status `json:"cache,omitempty"`
+	// Replayed is set when the outcome was stored by an earlier call
+	// with the same key; InProgress when that call has not finished yet.
+	Replayed   bool `json:"-"`
+	InProgress bool `json:"-"`
+}
+
+// reportRequestHash identifies the report a create-report call asks for.
+// Whether the query cache may be read does not change the report, and the
+// controller merges in the Cache-Control header, which a retry need not
+// repeat, so NoCache is left out.
+func reportRequestHash(req models.ReportInput) (string, error) {
+	req.NoCache = false
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CreateReportOnce creates a report at most once per Idempotency-Key and
+// caller. Repeating a finished call returns its stored outcome instead of
+// creating another spreadsheet. Server errors are not stored: the saga
+// has cleaned up after them, so the same key may be retried.
+func (s *UserService) CreateReportOnce(principal *models.Principal, key string, req models.ReportInput) (*ReportCreation, error) {
+	if key == "" || len(key) > 255 {
+		return nil, ErrInvalidIdempotencyKey
+	}
+
+	requestHash, err := reportRequestHash(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	prior, err := s.claimIdempotencyKey(ctx, principal.UserID, endpointCreateReport, key, requestHash)
+	if err != nil {
+		return nil, err
+	}
+	if prior != nil {
+		return prior, nil
+	}
+
+	code, msg, url, cache := s.CreateReport(principal, req)
+	result := &ReportCreation{Code: code, Message: msg, SheetURL: url, Cache: cache}
+	if err := s.completeIdempotencyKey(ctx, principal.UserID, endpointCreateReport, key, result); err != nil {
+		// The report exists either way; only replays of this key are affected
+		log.Printf("Failed to store outcome of Idempotency-Key %q: %v", key, err)
+	}
+	return result, nil
+}
+
+// claimIdempotencyKey marks the key as in progress for this request and
+// returns nil, or returns the outcome of the call that claimed it first.
+func (s *UserService) claimIdempotencyKey(ctx context.Context, userId, endpoint, key, requestHash string) (*ReportCreation, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		res, err := s.db.ExecContext(ctx, `
+			INSERT INTO penguin.idempotency_key (user_id, endpoint, key, request_hash, status, created_at)
+			VALUES ($1, $2, $3, $4, 'in_progress', $5)
+			ON CONFLICT (user_id, endpoint, key) DO NOTHING
+		`, userId, endpoint, key, requestHash, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			return nil, nil
+		}
+
+		var storedHash, status string
+		var createdAt time.Time
+		var code sql.NullInt64
+		var body []byte
+		err = s.db.QueryRowContext(ctx, `
+			SELECT request_hash, status, created_at, response_code, response_body
+			FROM penguin.idempotency_key
+			WHERE user_id = $1 AND endpoint = $2 AND key = $3
+		`, userId, endpoint, key).Scan(&storedHash, &status, &createdAt, &code, &body)
+		if err == sql.ErrNoRows {
+			continue // released in the meantime, claim it again
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if time.Since(createdAt) > idempotencyKeyTTL {
+			if err := s.deleteIdempotencyKey(ctx, userId, endpoint, key, createdAt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if storedHash != requestHash {
+			return nil, ErrIdempotencyKeyReused
+		}
+
+		if status == "in_progress" {
+			if time.Since(createdAt) < idempotencyLockTimeout {
+				return &ReportCreation{Code: http.StatusConflict, InProgress: true}, nil
+			}
+			// The first call never finished; take the key over
+			res, err := s.db.ExecContext(ctx, `
+				UPDATE penguin.idempotency_key SET created_at = $1
+				WHERE user_id = $2 AND endpoint = $3 AND key = $4 AND status = 'in_progress' AND created_at = $5
+			`, time.Now(), userId, endpoint, key, createdAt)
+			if err != nil {
+				return nil, err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return nil, err
+			} else if n == 1 {
+				return nil, nil
+			}
+			return &ReportCreation{Code: http.StatusConflict, InProgress: true}, nil
+		}
+
+		var outcome ReportCreation
+		if err := json.Unmarshal(body, &outcome); err != nil {
+			return nil, fmt.Errorf("stored outcome of Idempotency-Key %q: %w", key, err)
+		}
+		outcome.Code = int(code.Int64)
+		outcome.Replayed = true
+		return &outcome, nil
+	}
+	return nil, fmt.Errorf("could not claim Idempotency-Key %q", key)
+}
+
+func (s *UserService) completeIdempotencyKey(ctx context.Context, userId, endpoint, key string, outcome *ReportCreation) error {
+	if outcome.Code >= http.StatusInternalServerError {
+		_, err := s.db.ExecContext(ctx, `
+			DELETE FROM penguin.idempotency_key WHERE user_id = $1 AND endpoint = $2 AND key = $3
+		`, userId, endpoint, key)
+		return err
+	}
+
+	body, err := json.Marshal(outcome)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE penguin.idempotency_key
+		SET status = 'completed', response_code = $1, response_body = $2, completed_at = $3
+		WHERE user_id = $4 AND endpoint = $5 AND key = $6
+	`, outcome.Code, body, time.Now(), userId, endpoint, key)
+	return err
+}
+
+func (s *UserService) deleteIdempotencyKey(ctx context.Context, userId, endpoint, key string, createdAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM penguin.idempotency_key
+		WHERE user_id = $1 AND endpoint = $2 AND key = $3 AND created_at = $4
+	`, userId, endpoint, key, createdAt)
+	return err
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nishantd01/penguin-core/models"
+)
+
+func TestReportRequestHash(t *testing.T) {
+	base := models.ReportInput{ReportName: "Orders", SqlScript: "select id from sales.orders", DBName: "sales"}
+	hash := func(req models.ReportInput) string {
+		t.Helper()
+		h, err := reportRequestHash(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	noCache := base
+	noCache.NoCache = true
+	if hash(noCache) != hash(base) {
+		t.Error("asking to skip the cache changed the request hash")
+	}
+
+	renamed := base
+	renamed.ReportName = "Orders 2"
+	if hash(renamed) == hash(base) {
+		t.Error("a different report name kept the request hash")
+	}
+}
+
+func TestCreateReportOnce(t *testing.T) {
+	s := newTestService(t)
+	// An unknown source fails before anything is created, and that
+	// outcome is stored like any other client error
+	req := models.ReportInput{ReportName: "Orders", SqlScript: "select 1", DBName: "missing"}
+
+	first, err := s.CreateReportOnce(alice, "key-1", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Code != http.StatusBadRequest || first.Replayed {
+		t.Fatalf("first call = %+v", first)
+	}
+
+	retry := req
+	retry.NoCache = true
+	again, err := s.CreateReportOnce(alice, "key-1", retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Replayed || again.Code != first.Code || again.Message != first.Message {
+		t.Errorf("retry = %+v, want a replay of %+v", again, first)
+	}
+
+	changed := req
+	changed.ReportName = "Other"
+	if _, err := s.CreateReportOnce(alice, "key-1", changed); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("key reused with another body: %v, want ErrIdempotencyKeyReused", err)
+	}
+
+	// Keys belong to their caller
+	other, err := s.CreateReportOnce(bob, "key-1", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Replayed {
+		t.Error("another user's call replayed alice's outcome")
+	}
+
+	if _, err := s.CreateReportOnce(alice, "", req); !errors.Is(err, ErrInvalidIdempotencyKey) {
+		t.Errorf("empty key: %v, want ErrInvalidIdempotencyKey", err)
+	}
+}
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	claim := func(key string) *ReportCreation {
+		t.Helper()
+		prior, err := s.claimIdempotencyKey(ctx, aliceID, endpointCreateReport, key, "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prior
+	}
+	age := func(key string, d time.Duration) {
+		t.Helper()
+		_, err := s.db.Exec(`UPDATE penguin.idempotency_key SET created_at = $1 WHERE key = $2`, time.Now().Add(-d), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if prior := claim("k"); prior != nil {
+		t.Fatalf("first claim returned %+v", prior)
+	}
+	if prior := claim("k"); prior == nil || !prior.InProgress || prior.Code != http.StatusConflict {
+		t.Fatalf("claim of a key in progress = %+v, want 409", prior)
+	}
+
+	// A call that died midway gives its key up after the lock timeout
+	age("k", idempotencyLockTimeout+time.Minute)
+	if prior := claim("k"); prior != nil {
+		t.Fatalf("claim of an abandoned key = %+v, want it taken over", prior)
+	}
+
+	// Server errors are not stored, so the key can be retried
+	if err := s.completeIdempotencyKey(ctx, aliceID, endpointCreateReport, "k", &ReportCreation{Code: http.StatusInternalServerError}); err != nil {
+		t.Fatal(err)
+	}
+	if prior := claim("k"); prior != nil {
+		t.Fatalf("claim after a server error = %+v, want a fresh claim", prior)
+	}
+
+	if err := s.completeIdempotencyKey(ctx, aliceID, endpointCreateReport, "k", &ReportCreation{Code: http.StatusOK, SheetURL: "url"}); err != nil {
+		t.Fatal(err)
+	}
+	if prior := claim("k"); prior == nil || !prior.Replayed || prior.SheetURL != "url" {
+		t.Fatalf("claim of a completed key = %+v, want its outcome", prior)
+	}
+
+	// Outcomes are only kept for idempotencyKeyTTL
+	age("k", idempotencyKeyTTL+time.Minute)
+	if prior := claim("k"); prior != nil {
+		t.Fatalf("claim of an expired key = %+v, want a fresh claim", prior)
+	}
+}
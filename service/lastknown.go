@@ -0,0 +1,408 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+var ErrInvalidRestore = errors.New("invalid restore range")
+
+// maxRestoreCells bounds one restore call; Sheets pastes are far smaller.
+const maxRestoreCells = 50000
+
+// protectedColumns returns the IDs of the columns that some role may not
+// edit. Those are the ones a denied edit can touch, so the server keeps
+// their last known values. header and ids are the names and IDs of the
+// sheet columns, in order. Only roles of the sheet's workspace count.
+func protectedColumns(ctx context.Context, tx *sql.Tx, sheetId string, columns []models.Column, header, ids []string) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT r.id::text FROM penguin.role r
+		JOIN penguin.spreadsheet s ON s.workspace_id = r.workspace_id
+		WHERE s.id = $1
+	`, sheetId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		roles = append(roles, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	writableByAll := make(map[string]bool)
+	for _, col := range columns {
+		all := true
+		for _, role := range roles {
+			if !contains(col.WritableBy, role) {
+				all = false
+				break
+			}
+		}
+		writableByAll[col.Name] = all
+	}
+
+	protected := make(map[string]bool)
+	for i, id := range ids {
+		if id != "" && i < len(header) && !writableByAll[header[i]] {
+			protected[id] = true
+		}
+	}
+	return protected, nil
+}
+
+// storeLastKnown replaces the last known values of a sheet with data,
+// header row first, as just written to or read from the sheet. ids are
+// the column IDs of data's columns; untagged columns have "" and are not
+// kept. key is the ID of the report's key column, if any: rows are then
+// also found by their key, which survives sorting and inserted rows.
+func (s *UserService) storeLastKnown(ctx context.Context, sheetId string, columns []models.Column, ids []string, key string, data [][]interface{}) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := writeLastKnown(ctx, tx, sheetId, columns, ids, key, data); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeLastKnown is storeLastKnown within tx.
+func writeLastKnown(ctx context.Context, tx *sql.Tx, sheetId string, columns []models.Column, ids []string, key string, data [][]interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	header := make([]string, len(data[0]))
+	for i, name := range data[0] {
+		header[i] = fmt.Sprint(name)
+	}
+	protected, err := protectedColumns(ctx, tx, sheetId, columns, header, ids)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM penguin.report_row WHERE spreadsheet_id = $1`, sheetId); err != nil {
+		return err
+	}
+
+	if len(protected) > 0 {
+		keys := rowKeys(data[1:], indexOf(ids, key))
+		stmt, err := tx.PrepareContext(ctx, pq.CopyInSchema("penguin", "report_row", "spreadsheet_id", "row_number", "row_key", "cells", "updated_at"))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for i, row := range data[1:] {
+			cells := make(map[string]interface{}, len(protected))
+			for j, id := range ids {
+				if protected[id] && j < len(row) {
+					cells[id] = row[j]
+				}
+			}
+			cellsJSON, err := json.Marshal(cells)
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+			// Data row i sits below the header, on sheet row i+2
+			if _, err := stmt.ExecContext(ctx, sheetId, i+2, keys[i], string(cellsJSON), now); err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			stmt.Close()
+			return err
+		}
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rowKeys returns the text of column key of each row, nil for rows whose
+// key is empty or repeated, or for every row when key is -1.
+func rowKeys(rows [][]interface{}, key int) []interface{} {
+	keys := make([]interface{}, len(rows))
+	if key < 0 {
+		return keys
+	}
+	count := make(map[string]int)
+	for _, row := range rows {
+		if key < len(row) {
+			count[cellText(row[key])]++
+		}
+	}
+	for i, row := range rows {
+		if key < len(row) {
+			if k := cellText(row[key]); k != "" && count[k] == 1 {
+				keys[i] = k
+			}
+		}
+	}
+	return keys
+}
+
+func indexOf(values []string, v string) int {
+	if v == "" {
+		return -1
+	}
+	for i, s := range values {
+		if s == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// rowLocator tells how the rows of a range sent by a bound script are
+// found among the last known values: by their key when the report has a
+// key column and the script sent the keys, else by sheet row number. A
+// range that covers the key column holds keys that may have just been
+// overwritten, so it falls back to row numbers too.
+type rowLocator struct {
+	row  int
+	keys []string
+}
+
+func newRowLocator(row int, columnIds []string, key string, sent []interface{}, numRows int) rowLocator {
+	loc := rowLocator{row: row}
+	if key == "" || len(sent) != numRows || indexOf(columnIds, key) >= 0 {
+		return loc
+	}
+	loc.keys = make([]string, numRows)
+	for i, k := range sent {
+		loc.keys[i] = cellText(k)
+	}
+	return loc
+}
+
+// where returns the condition and argument matching row i of the range.
+func (l rowLocator) where(i int) (string, interface{}) {
+	if l.keys != nil {
+		return "row_key = $2", l.keys[i]
+	}
+	return "row_number = $2", l.row + i
+}
+
+// reportKeyColumn returns the ID of the key column of sheetId, "" when
+// it has none or predates column IDs.
+func (s *UserService) reportKeyColumn(sheetId string) (string, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return "", err
+	}
+	return keyColumnId(report.Columns, report.Definition.KeyColumn), nil
+}
+
+// applyEdit carries an allowed edit by email over to the last known
+// values. Only the columns the editor may write are taken, so a forged
+// edit cannot change what denied edits are restored to.
+func (s *UserService) applyEdit(sheetId, email string, in EditInput) error {
+	values := in.Values
+	if len(values) == 0 && in.NewValue != nil {
+		values = [][]interface{}{{*in.NewValue}}
+	}
+	if len(values) == 0 || len(in.ColumnIds) == 0 {
+		return nil
+	}
+
+	key, err := s.reportKeyColumn(sheetId)
+	if err != nil {
+		return err
+	}
+	loc := newRowLocator(in.Row, in.ColumnIds, key, in.RowKeys, len(values))
+	keyIndex := indexOf(in.ColumnIds, key)
+
+	writable, err := s.writableColumns(email, sheetId)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, row := range values {
+		patch := make(map[string]interface{})
+		for j, value := range row {
+			if j < len(in.ColumnIds) && in.ColumnIds[j] != "" && contains(writable, in.ColumnIds[j]) {
+				patch[in.ColumnIds[j]] = value
+			}
+		}
+		if len(patch) == 0 {
+			continue
+		}
+		patchJSON, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		// An allowed edit of the key moves the row to its new key; the key
+		// of an editor who may not write it is kept whatever the range says
+		var newKey interface{}
+		if keyIndex >= 0 && keyIndex < len(row) && contains(writable, key) {
+			if k := cellText(row[keyIndex]); k != "" {
+				newKey = k
+			}
+		}
+		// Only columns already tracked for the row are updated; the rest
+		// are not protected
+		cond, arg := loc.where(i)
+		_, err = s.db.Exec(`
+			UPDATE penguin.report_row
+			SET cells = cells || (
+				SELECT COALESCE(jsonb_object_agg(key, value), '{}'::jsonb)
+				FROM jsonb_each($3::jsonb)
+				WHERE report_row.cells ? key
+			), row_key = COALESCE($5, row_key), updated_at = $4
+			WHERE spreadsheet_id = $1 AND `+cond, sheetId, arg, string(patchJSON), time.Now(), newKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreRequest is the range of a denied edit: its first sheet row, its
+// height, and the IDs tagged on its columns ("" for untagged ones).
+// RowKeys are the values of the report's key column in its rows, when
+// the report has one.
+type RestoreRequest struct {
+	Row       int           `json:"row" binding:"required,min=1"`
+	NumRows   int           `json:"num_rows" binding:"required,min=1"`
+	ColumnIds []string      `json:"column_ids" binding:"required,min=1"`
+	RowKeys   []interface{} `json:"row_keys"`
+}
+
+// RestoreResponse holds the last known values of a range. Known is false
+// for cells the server has no value for: unprotected columns and rows
+// added in Sheets.
+type RestoreResponse struct {
+	Values [][]interface{} `json:"values"`
+	Known  [][]bool        `json:"known"`
+}
+
+// RestoreValues returns the last known values of a range of sheetId, for
+// its bound script to undo a denied edit with. idToken must be the edit
+// trigger's, see scriptCaller.
+func (s *UserService) RestoreValues(sheetId, idToken string, req RestoreRequest) (*RestoreResponse, error) {
+	if req.NumRows*len(req.ColumnIds) > maxRestoreCells {
+		return nil, fmt.Errorf("%w: at most %d cells", ErrInvalidRestore, maxRestoreCells)
+	}
+	// Anyone may have made the denied edit, Penguin user or not, so only
+	// the trigger is authenticated, not the editor
+	if _, err := s.scriptCaller(context.Background(), sheetId, idToken); err != nil {
+		return nil, err
+	}
+
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	sheetCols := report.Columns
+	key := keyColumnId(sheetCols, report.Definition.KeyColumn)
+
+	resp := &RestoreResponse{
+		Values: make([][]interface{}, req.NumRows),
+		Known:  make([][]bool, req.NumRows),
+	}
+	for i := range resp.Values {
+		resp.Values[i] = make([]interface{}, len(req.ColumnIds))
+		resp.Known[i] = make([]bool, len(req.ColumnIds))
+	}
+	if sheetCols == nil {
+		return resp, nil
+	}
+
+	// The header row is protected by the sheet itself, but restore it too
+	// in case a paste got past that
+	if req.Row == 1 {
+		for j, id := range req.ColumnIds {
+			for _, col := range sheetCols {
+				if id != "" && col.Id == id {
+					resp.Values[0][j], resp.Known[0][j] = col.Name, true
+				}
+			}
+		}
+	}
+
+	loc := newRowLocator(req.Row, req.ColumnIds, key, req.RowKeys, req.NumRows)
+	var rows *sql.Rows
+	if loc.keys != nil {
+		rows, err = s.db.Query(`
+			SELECT row_number, row_key, cells FROM penguin.report_row
+			WHERE spreadsheet_id = $1 AND row_key = ANY($2)
+		`, sheetId, pq.Array(loc.keys))
+	} else {
+		rows, err = s.db.Query(`
+			SELECT row_number, row_key, cells FROM penguin.report_row
+			WHERE spreadsheet_id = $1 AND row_number BETWEEN $2 AND $3
+		`, sheetId, req.Row, req.Row+req.NumRows-1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Index of each range row by key, or by row number
+	position := make(map[string]int, req.NumRows)
+	for i := 0; i < req.NumRows; i++ {
+		if loc.keys != nil {
+			position[loc.keys[i]] = i
+		} else {
+			position[strconv.Itoa(req.Row+i)] = i
+		}
+	}
+
+	for rows.Next() {
+		var rowNumber int
+		var rowKey sql.NullString
+		var raw []byte
+		if err := rows.Scan(&rowNumber, &rowKey, &raw); err != nil {
+			return nil, err
+		}
+		i, ok := position[strconv.Itoa(rowNumber)]
+		if loc.keys != nil {
+			i, ok = position[rowKey.String]
+		}
+		if !ok {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var cells map[string]interface{}
+		if err := dec.Decode(&cells); err != nil {
+			return nil, err
+		}
+		for j, id := range req.ColumnIds {
+			if value, ok := cells[id]; ok && id != "" {
+				resp.Values[i][j], resp.Known[i][j] = value, true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	log.Printf("Restoring %dx%d cells of %s from row %d", req.NumRows, len(req.ColumnIds), sheetId, req.Row)
+	return resp, nil
+}
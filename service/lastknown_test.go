@@ -0,0 +1,48 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRowKeys(t *testing.T) {
+	rows := [][]interface{}{
+		{int64(1), "a"},
+		{int64(2), "b"},
+		{int64(2), "c"},
+		{nil, "d"},
+		{json.Number("9007199254740993"), "e"},
+	}
+	want := []interface{}{"1", nil, nil, nil, "9007199254740993"}
+	if got := rowKeys(rows, 0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rowKeys = %v, want %v", got, want)
+	}
+	if got := rowKeys(rows, -1); !reflect.DeepEqual(got, make([]interface{}, len(rows))) {
+		t.Fatalf("rowKeys without a key = %v", got)
+	}
+}
+
+func TestRowLocator(t *testing.T) {
+	tests := []struct {
+		name      string
+		columnIds []string
+		key       string
+		sent      []interface{}
+		cond      string
+		arg       interface{}
+	}{
+		{"by key", []string{"c2"}, "c1", []interface{}{7.0, "x"}, "row_key = $2", "x"},
+		{"no key column", []string{"c2"}, "", []interface{}{7.0, "x"}, "row_number = $2", 6},
+		{"keys not sent", []string{"c2"}, "c1", nil, "row_number = $2", 6},
+		{"edit covers the key", []string{"c1", "c2"}, "c1", []interface{}{7.0, "x"}, "row_number = $2", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, arg := newRowLocator(5, tt.columnIds, tt.key, tt.sent, 2).where(1)
+			if cond != tt.cond || arg != tt.arg {
+				t.Fatalf("where(1) = %q, %v, want %q, %v", cond, arg, tt.cond, tt.arg)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nishantd01/penguin-core/sqlutil"
+)
+
+var ErrInvalidImpactRequest = errors.New("invalid impact request")
+
+// replaceLineage records the lineage of a report in a transaction of its
+// own, so readers never see it half replaced.
+func (s *UserService) replaceLineage(ctx context.Context, sheetId, defaultSchema, script string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := recordLineage(ctx, tx, sheetId, defaultSchema, script); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordLineage replaces the lineage rows of a report with the tables and
+// columns its script reads, within tx. Unqualified tables are attributed
+// to defaultSchema when the source has one.
+func recordLineage(ctx context.Context, tx *sql.Tx, sheetId, defaultSchema, script string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM penguin.report_lineage WHERE spreadsheet_id = $1`, sheetId); err != nil {
+		return err
+	}
+
+	schemaOf := func(t sqlutil.TableRef) interface{} {
+		switch {
+		case t.Schema != "":
+			return t.Schema
+		case defaultSchema != "":
+			return defaultSchema
+		}
+		return nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO penguin.report_lineage (id, spreadsheet_id, schema_name, table_name, column_name, ambiguous)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	lineage := sqlutil.ExtractLineage(script)
+	for _, t := range lineage.Tables {
+		if _, err := stmt.ExecContext(ctx, uuid.New(), sheetId, schemaOf(t), t.Name, nil, false); err != nil {
+			return err
+		}
+	}
+	for _, c := range lineage.Columns {
+		if _, err := stmt.ExecContext(ctx, uuid.New(), sheetId, schemaOf(c.Table), c.Table.Name, c.Column, c.Ambiguous); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ReportRef struct {
+	Id         string `json:"id"`
+	ReportName string `json:"reportName"`
+	DBName     string `json:"dbName"`
+	URL        string `json:"url"`
+}
+
+type LineageReport struct {
+	ReportRef
+	Columns []string `json:"columns"`
+}
+
+// splitTableName accepts "table" or "schema.table".
+func splitTableName(name string) (schema, table string) {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// lineageRows returns the lineage rows of every report of a workspace
+// reading table, which may be schema-qualified. Rows recorded without a
+// schema match any schema.
+func (s *UserService) lineageRows(workspaceId, table string) (*sql.Rows, error) {
+	schema, name := splitTableName(table)
+	return s.db.Query(`
+		SELECT sp.id, sp.report_name, COALESCE(sp.db_name, ''), COALESCE(l.column_name, ''), l.ambiguous
+		FROM penguin.report_lineage l
+		JOIN penguin.spreadsheet sp ON sp.id = l.spreadsheet_id
+		WHERE sp.workspace_id = $1
+		  AND LOWER(l.table_name) = LOWER($2)
+		  AND ($3 = '' OR l.schema_name IS NULL OR LOWER(l.schema_name) = LOWER($3))
+		ORDER BY sp.report_name, sp.id, l.column_name
+	`, workspaceId, name, schema)
+}
+
+// TableReports lists the reports whose SQL reads table, with the columns
+// of it each one references.
+func (s *UserService) TableReports(workspaceId, table string) ([]LineageReport, error) {
+	rows, err := s.lineageRows(workspaceId, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []LineageReport{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var ref ReportRef
+		var column string
+		var ambiguous bool
+		if err := rows.Scan(&ref.Id, &ref.ReportName, &ref.DBName, &column, &ambiguous); err != nil {
+			return nil, err
+		}
+		i, ok := index[ref.Id]
+		if !ok {
+			ref.URL = sheetURL(ref.Id)
+			i = len(reports)
+			index[ref.Id] = i
+			reports = append(reports, LineageReport{ReportRef: ref, Columns: []string{}})
+		}
+		if column != "" && !contains(reports[i].Columns, column) {
+			reports[i].Columns = append(reports[i].Columns, column)
+		}
+	}
+	return reports, rows.Err()
+}
+
+type ImpactRequest struct {
+	Table   string `json:"table" binding:"required"`
+	Column  string `json:"column" binding:"required"`
+	Action  string `json:"action" binding:"required"` // "drop" or "rename"
+	NewName string `json:"newName"`
+}
+
+type ImpactedReport struct {
+	ReportRef
+	Reasons []string `json:"reasons"`
+}
+
+type ImpactResponse struct {
+	Table   string           `json:"table"`
+	Column  string           `json:"column"`
+	Action  string           `json:"action"`
+	Reports []ImpactedReport `json:"reports"`
+	Count   int              `json:"count"`
+}
+
+// AnalyzeImpact lists the reports a proposed column drop or rename would
+// break. Reports selecting * from the table, and reports using the name
+// unqualified in a multi-table query, are listed as possibly affected.
+func (s *UserService) AnalyzeImpact(workspaceId string, req ImpactRequest) (*ImpactResponse, error) {
+	action := strings.ToLower(req.Action)
+	switch action {
+	case "drop":
+	case "rename":
+		if req.NewName == "" {
+			return nil, fmt.Errorf("%w: newName is required for a rename", ErrInvalidImpactRequest)
+		}
+	default:
+		return nil, fmt.Errorf("%w: action must be drop or rename", ErrInvalidImpactRequest)
+	}
+
+	rows, err := s.lineageRows(workspaceId, req.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	column := strings.ToLower(req.Column)
+	effect := "is dropped"
+	if action == "rename" {
+		effect = fmt.Sprintf("is renamed to %s", req.NewName)
+	}
+
+	impacted := make(map[string]*ImpactedReport)
+	for rows.Next() {
+		var ref ReportRef
+		var col string
+		var ambiguous bool
+		if err := rows.Scan(&ref.Id, &ref.ReportName, &ref.DBName, &col, &ambiguous); err != nil {
+			return nil, err
+		}
+
+		var reason string
+		switch {
+		case col == "*":
+			reason = fmt.Sprintf("selects * from %s, so the sheet's columns change when %s %s", req.Table, req.Column, effect)
+		case strings.ToLower(col) != column:
+			continue
+		case ambiguous:
+			reason = fmt.Sprintf("may read %s unqualified, which fails if it %s", req.Column, effect)
+		default:
+			reason = fmt.Sprintf("reads %s, which fails if it %s", req.Column, effect)
+		}
+
+		r, ok := impacted[ref.Id]
+		if !ok {
+			ref.URL = sheetURL(ref.Id)
+			r = &ImpactedReport{ReportRef: ref}
+			impacted[ref.Id] = r
+		}
+		if !contains(r.Reasons, reason) {
+			r.Reasons = append(r.Reasons, reason)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	resp := &ImpactResponse{Table: req.Table, Column: req.Column, Action: action, Reports: []ImpactedReport{}}
+	for _, r := range impacted {
+		resp.Reports = append(resp.Reports, *r)
+	}
+	sort.Slice(resp.Reports, func(i, j int) bool {
+		a, b := resp.Reports[i], resp.Reports[j]
+		if a.ReportName != b.ReportName {
+			return a.ReportName < b.ReportName
+		}
+		return a.Id < b.Id
+	})
+	resp.Count = len(resp.Reports)
+	return resp, nil
+}
+
+// RebuildLineage re-extracts the lineage of every stored report of a
+// workspace, for reports created before lineage was recorded or after the
+// extractor improves. It returns the number of reports processed.
+func (s *UserService) RebuildLineage(workspaceId string) (int, error) {
+	rows, err := s.db.Query(`
+		SELECT id, COALESCE(db_name, ''), sql_script
+		FROM penguin.spreadsheet
+		WHERE workspace_id = $1 AND sql_script IS NOT NULL AND sql_script <> ''
+	`, workspaceId)
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct{ id, dbName, script string }
+	var reports []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.dbName, &p.script); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		reports = append(reports, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	for _, p := range reports {
+		var defaultSchema string
+		if source, err := s.sources.Get(p.dbName); err == nil {
+			defaultSchema = source.DefaultSchema
+		} else {
+			log.Printf("Rebuilding lineage of %s without its data source: %v", p.id, err)
+		}
+		if err := s.replaceLineage(ctx, p.id, defaultSchema, p.script); err != nil {
+			return 0, fmt.Errorf("report %s: %w", p.id, err)
+		}
+	}
+	return len(reports), nil
+}
+
+func sheetURL(sheetId string) string {
+	return "https://docs.google.com/spreadsheets/d/" + sheetId
+}
@@ -0,0 +1,589 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+var (
+	ErrInvalidLog         = errors.New("invalid log entry")
+	ErrInvalidLogSearch   = errors.New("invalid log search")
+	ErrLogSearchNotFound  = errors.New("log search not found")
+	ErrLogSearchExists    = errors.New("a log search with this name already exists")
+	ErrLogSearchDenied    = errors.New("only the log search's creator or an admin may delete it")
+	ErrRetentionNotFound  = errors.New("log retention policy not found")
+	ErrInvalidRetention   = errors.New("invalid log retention policy")
+	ErrNoLogSource        = errors.New("the workspace has no log source: a source on the service's own database with db_role penguin_log_reader")
+	errLogSearchNoFilters = errors.New("a log search needs at least one filter")
+)
+
+const (
+	// maxLogBatch bounds one ingestion call.
+	maxLogBatch = 1000
+	// Column sizes of penguin.dev_logs.
+	maxLogMessage = 500
+	maxLogService = 100
+
+	defaultLogPageSize = 100
+	maxLogPageSize     = 1000
+)
+
+// logLevels are the accepted levels, from least to most severe.
+var logLevels = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// LogEntry is one row of penguin.dev_logs. Timestamp defaults to the time
+// of ingestion; Attributes carry the entry's structured fields.
+type LogEntry struct {
+	Id         string                 `json:"id"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Level      string                 `json:"level"`
+	Service    string                 `json:"service"`
+	Message    string                 `json:"message"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type IngestLogsRequest struct {
+	Logs []LogEntry `json:"logs" binding:"required"`
+}
+
+// normalizeLevel upper-cases level and fails for unknown ones.
+func normalizeLevel(level string) (string, error) {
+	level = strings.ToUpper(strings.TrimSpace(level))
+	if !contains(logLevels, level) {
+		return "", fmt.Errorf("unknown level %q, want one of %s", level, strings.Join(logLevels, ", "))
+	}
+	return level, nil
+}
+
+func validateLog(e *LogEntry, now time.Time) error {
+	var err error
+	if e.Level, err = normalizeLevel(e.Level); err != nil {
+		return err
+	}
+	e.Service = strings.TrimSpace(e.Service)
+	switch {
+	case e.Service == "":
+		return errors.New("service is required")
+	case utf8.RuneCountInString(e.Service) > maxLogService:
+		return fmt.Errorf("service is longer than %d characters", maxLogService)
+	case e.Message == "":
+		return errors.New("message is required")
+	case utf8.RuneCountInString(e.Message) > maxLogMessage:
+		return fmt.Errorf("message is longer than %d characters", maxLogMessage)
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = now
+	}
+	e.Timestamp = e.Timestamp.UTC()
+	return nil
+}
+
+// IngestLogs stores a batch of log entries in the principal's workspace.
+// The batch is stored whole or, when any entry is invalid, not at all.
+func (s *UserService) IngestLogs(principal *models.Principal, entries []LogEntry) ([]LogEntry, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: no logs in the batch", ErrInvalidLog)
+	}
+	if len(entries) > maxLogBatch {
+		return nil, fmt.Errorf("%w: at most %d logs per batch, got %d", ErrInvalidLog, maxLogBatch, len(entries))
+	}
+
+	now := time.Now()
+	attributes := make([]interface{}, len(entries))
+	for i := range entries {
+		e := &entries[i]
+		if err := validateLog(e, now); err != nil {
+			return nil, fmt.Errorf("%w: logs[%d]: %v", ErrInvalidLog, i, err)
+		}
+		e.Id = uuid.New().String()
+		if e.Attributes != nil {
+			raw, err := json.Marshal(e.Attributes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: logs[%d]: %v", ErrInvalidLog, i, err)
+			}
+			attributes[i] = raw
+		}
+	}
+
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO penguin.dev_logs (id, workspace_id, timestamp, level, service_name, message, attributes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	for i, e := range entries {
+		if _, err := stmt.ExecContext(ctx, e.Id, principalWorkspace(principal), e.Timestamp, e.Level, e.Service, e.Message, attributes[i]); err != nil {
+			return nil, fmt.Errorf("insert logs[%d]: %w", i, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// LogFilter narrows down a log search. Zero values match everything; Query
+// is full-text over the message, in web search syntax ("timeout -retry").
+type LogFilter struct {
+	Levels  []string
+	Service string
+	From    time.Time
+	To      time.Time
+	Query   string
+	Limit   int
+	Offset  int
+}
+
+// SearchLogs returns the logs of a workspace matching f, newest first.
+func (s *UserService) SearchLogs(workspaceId string, f LogFilter) ([]LogEntry, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	add("workspace_id = $%d", workspaceId)
+	if len(f.Levels) > 0 {
+		levels := make([]string, len(f.Levels))
+		for i, l := range f.Levels {
+			level, err := normalizeLevel(l)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidLogSearch, err)
+			}
+			levels[i] = level
+		}
+		add("level = ANY($%d)", pq.Array(levels))
+	}
+	if f.Service != "" {
+		add("service_name = $%d", f.Service)
+	}
+	if !f.From.IsZero() {
+		add("timestamp >= $%d", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		add("timestamp < $%d", f.To.UTC())
+	}
+	if f.Query != "" {
+		add("search_vector @@ websearch_to_tsquery('simple', $%d)", f.Query)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultLogPageSize
+	}
+	if limit > maxLogPageSize {
+		limit = maxLogPageSize
+	}
+	query := `
+		SELECT id, COALESCE(timestamp, 'epoch'), COALESCE(level, ''), COALESCE(service_name, ''), COALESCE(message, ''), attributes
+		FROM penguin.dev_logs
+		WHERE ` + strings.Join(where, " AND ") +
+		fmt.Sprintf("\n\t\tORDER BY timestamp DESC NULLS LAST, id\n\t\tLIMIT %d OFFSET %d", limit, max(f.Offset, 0))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LogEntry{}
+	for rows.Next() {
+		var e LogEntry
+		var attributes []byte
+		if err := rows.Scan(&e.Id, &e.Timestamp, &e.Level, &e.Service, &e.Message, &attributes); err != nil {
+			return nil, err
+		}
+		if attributes != nil {
+			if err := json.Unmarshal(attributes, &e.Attributes); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// LogRetention deletes the logs of a workspace matching Service and Level,
+// empty for any, once they are older than MaxAge. When several policies
+// match a log, the shortest MaxAge wins.
+type LogRetention struct {
+	Id        string    `json:"id"`
+	Service   string    `json:"service,omitempty"`
+	Level     string    `json:"level,omitempty"`
+	MaxAge    string    `json:"maxAge" binding:"required"` // a Go duration, e.g. "720h"
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (s *UserService) ListLogRetention(workspaceId string) ([]LogRetention, error) {
+	rows, err := s.db.Query(`
+		SELECT id, COALESCE(service_name, ''), COALESCE(level, ''), max_age_seconds, created_at
+		FROM penguin.log_retention
+		WHERE workspace_id = $1
+		ORDER BY created_at
+	`, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []LogRetention{}
+	for rows.Next() {
+		var r LogRetention
+		var seconds int64
+		if err := rows.Scan(&r.Id, &r.Service, &r.Level, &seconds, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.MaxAge = (time.Duration(seconds) * time.Second).String()
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func (s *UserService) CreateLogRetention(workspaceId string, r LogRetention) (*LogRetention, error) {
+	maxAge, err := time.ParseDuration(r.MaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("%w: maxAge: %v", ErrInvalidRetention, err)
+	}
+	if maxAge < time.Second {
+		return nil, fmt.Errorf("%w: maxAge must be at least 1s", ErrInvalidRetention)
+	}
+	if r.Level != "" {
+		if r.Level, err = normalizeLevel(r.Level); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRetention, err)
+		}
+	}
+
+	r.Id = uuid.New().String()
+	r.MaxAge = maxAge.Truncate(time.Second).String()
+	r.CreatedAt = time.Now()
+	_, err = s.db.Exec(`
+		INSERT INTO penguin.log_retention (id, workspace_id, service_name, level, max_age_seconds, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, r.Id, workspaceId, nullString(r.Service), nullString(r.Level), int64(maxAge/time.Second), r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *UserService) DeleteLogRetention(workspaceId, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrRetentionNotFound
+	}
+	res, err := s.db.Exec(`DELETE FROM penguin.log_retention WHERE id = $1 AND workspace_id = $2`, id, workspaceId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRetentionNotFound
+	}
+	return nil
+}
+
+// ApplyLogRetention deletes the logs past their retention, in one
+// workspace or, when workspaceId is empty, in all of them. It returns the
+// number of logs deleted.
+func (s *UserService) ApplyLogRetention(workspaceId string) (int64, error) {
+	res, err := s.db.Exec(`
+		DELETE FROM penguin.dev_logs l
+		USING penguin.log_retention r
+		WHERE l.workspace_id = r.workspace_id
+		  AND ($1 = '' OR r.workspace_id::text = $1)
+		  AND (r.service_name IS NULL OR l.service_name = r.service_name)
+		  AND (r.level IS NULL OR l.level = r.level)
+		  AND l.timestamp < (NOW() AT TIME ZONE 'UTC') - make_interval(secs => r.max_age_seconds)
+	`, workspaceId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunLogRetention applies every retention policy once per interval. It
+// never returns; run it in its own goroutine.
+func (s *UserService) RunLogRetention(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := s.ApplyLogRetention("")
+		if err != nil {
+			log.Printf("Log retention failed: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("🧹 Log retention deleted %d logs", n)
+		}
+	}
+}
+
+// LogSearch is a saved set of log filters. Window keeps the search to the
+// most recent entries, e.g. "24h", so reports created from it stay current
+// on refresh.
+type LogSearch struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name" binding:"required"`
+	Levels    []string  `json:"levels,omitempty"`
+	Service   string    `json:"service,omitempty"`
+	Query     string    `json:"query,omitempty"`
+	Window    string    `json:"window,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// LogReportRequest creates a report from a saved log search. Columns are
+// extra editable columns, as in create-report.
+type LogReportRequest struct {
+	ReportName string          `json:"reportName"`
+	Columns    []models.Column `json:"columns"`
+}
+
+const logSearchColumns = `id, name, levels, COALESCE(service_name, ''), COALESCE(query, ''), window_seconds,
+	COALESCE(created_by::text, ''), created_at`
+
+func scanLogSearch(row rowScanner) (*LogSearch, error) {
+	var ls LogSearch
+	var levels []byte
+	var window sql.NullInt64
+	err := row.Scan(&ls.Id, &ls.Name, &levels, &ls.Service, &ls.Query, &window, &ls.CreatedBy, &ls.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if levels != nil {
+		if err := json.Unmarshal(levels, &ls.Levels); err != nil {
+			return nil, err
+		}
+	}
+	if window.Valid {
+		ls.Window = (time.Duration(window.Int64) * time.Second).String()
+	}
+	return &ls, nil
+}
+
+func (s *UserService) ListLogSearches(workspaceId string) ([]LogSearch, error) {
+	rows, err := s.db.Query(`SELECT `+logSearchColumns+` FROM penguin.log_search WHERE workspace_id = $1 ORDER BY name`, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []LogSearch{}
+	for rows.Next() {
+		ls, err := scanLogSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *ls)
+	}
+	return list, rows.Err()
+}
+
+func (s *UserService) GetLogSearch(workspaceId, id string) (*LogSearch, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrLogSearchNotFound
+	}
+	ls, err := scanLogSearch(s.db.QueryRow(`SELECT `+logSearchColumns+` FROM penguin.log_search WHERE id = $1 AND workspace_id = $2`, id, workspaceId))
+	if err == sql.ErrNoRows {
+		return nil, ErrLogSearchNotFound
+	}
+	return ls, err
+}
+
+func (s *UserService) CreateLogSearch(principal *models.Principal, ls LogSearch) (*LogSearch, error) {
+	window, err := ls.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLogSearch, err)
+	}
+	levels, err := json.Marshal(ls.Levels)
+	if err != nil {
+		return nil, err
+	}
+	var windowSeconds interface{}
+	if window > 0 {
+		windowSeconds = int64(window / time.Second)
+	}
+
+	ls.Id = uuid.New().String()
+	ls.CreatedAt = time.Now()
+	var createdBy interface{}
+	if principal != nil {
+		ls.CreatedBy = principal.UserID
+		createdBy = principal.UserID
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO penguin.log_search (id, workspace_id, name, levels, service_name, query, window_seconds, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, ls.Id, principalWorkspace(principal), ls.Name, levels, nullString(ls.Service), nullString(ls.Query), windowSeconds, createdBy, ls.CreatedAt)
+	if isUniqueViolation(err) {
+		return nil, ErrLogSearchExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ls, nil
+}
+
+// validate normalizes the search's levels and window, and returns the
+// window.
+func (ls *LogSearch) validate() (time.Duration, error) {
+	for i, l := range ls.Levels {
+		level, err := normalizeLevel(l)
+		if err != nil {
+			return 0, err
+		}
+		ls.Levels[i] = level
+	}
+	var window time.Duration
+	if ls.Window != "" {
+		var err error
+		if window, err = time.ParseDuration(ls.Window); err != nil {
+			return 0, fmt.Errorf("window: %v", err)
+		}
+		if window < time.Second {
+			return 0, errors.New("window must be at least 1s")
+		}
+		ls.Window = window.Truncate(time.Second).String()
+	}
+	if len(ls.Levels) == 0 && ls.Service == "" && ls.Query == "" && window == 0 {
+		return 0, errLogSearchNoFilters
+	}
+	return window, nil
+}
+
+// DeleteLogSearch deletes a saved search of the principal's workspace.
+// Only its creator or an admin may; searches without a recorded creator
+// are left to admins.
+func (s *UserService) DeleteLogSearch(principal *models.Principal, id string) error {
+	ls, err := s.GetLogSearch(principalWorkspace(principal), id)
+	if err != nil {
+		return err
+	}
+	if principal != nil && ls.CreatedBy != principal.UserID && !principal.HasRole(s.cfg.AdminRoles) {
+		return ErrLogSearchDenied
+	}
+	res, err := s.db.Exec(`DELETE FROM penguin.log_search WHERE id = $1`, ls.Id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLogSearchNotFound
+	}
+	return nil
+}
+
+// Filter turns a saved search into the filter it stands for at now.
+func (ls *LogSearch) Filter(now time.Time) LogFilter {
+	f := LogFilter{Levels: ls.Levels, Service: ls.Service, Query: ls.Query}
+	if window, err := time.ParseDuration(ls.Window); err == nil && window > 0 {
+		f.From = now.Add(-window)
+	}
+	return f
+}
+
+// logReportSQL reads the logs of one workspace through the workspace_logs
+// view, which its source's role limits to the source's workspace. The
+// filters are parameters defaulting to the saved search, so a refresh can
+// change them.
+const logReportSQL = `SELECT timestamp, level, service_name, message
+FROM penguin.workspace_logs
+WHERE ({{levels}}::text IS NULL OR level = ANY(string_to_array({{levels}}::text, ',')))
+  AND ({{service}}::text IS NULL OR service_name = {{service}}::text)
+  AND ({{query}}::text IS NULL OR search_vector @@ websearch_to_tsquery('simple', {{query}}::text))
+  AND ({{windowSeconds}}::bigint IS NULL OR timestamp >= (NOW() AT TIME ZONE 'UTC') - make_interval(secs => {{windowSeconds}}::bigint))
+ORDER BY timestamp DESC`
+
+// LogSearchReport builds the ReportInput of a report showing the results
+// of a saved log search, ready for CreateReport.
+func (s *UserService) LogSearchReport(principal *models.Principal, id string, req LogReportRequest) (*models.ReportInput, error) {
+	workspaceId := principalWorkspace(principal)
+	ls, err := s.GetLogSearch(workspaceId, id)
+	if err != nil {
+		return nil, err
+	}
+	dbName, err := s.logSource(workspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	var windowSeconds interface{}
+	if window, err := time.ParseDuration(ls.Window); err == nil && window > 0 {
+		windowSeconds = int64(window / time.Second)
+	}
+	var levels interface{}
+	if len(ls.Levels) > 0 {
+		levels = strings.Join(ls.Levels, ",")
+	}
+
+	name := req.ReportName
+	if name == "" {
+		name = ls.Name
+	}
+	return &models.ReportInput{
+		ReportName: name,
+		SqlScript:  logReportSQL,
+		DBName:     dbName,
+		Columns:    req.Columns,
+		Parameters: []models.Parameter{
+			{Name: "levels", Type: "string", Default: levels},
+			{Name: "service", Type: "string", Default: emptyToNil(ls.Service)},
+			{Name: "query", Type: "string", Default: emptyToNil(ls.Query)},
+			{Name: "windowSeconds", Type: "integer", Default: windowSeconds},
+		},
+		ParameterValues: map[string]interface{}{},
+	}, nil
+}
+
+// logReaderRole is the database role of log sources: it can only read
+// penguin.workspace_logs, limited to the workspace of the source.
+const logReaderRole = "penguin_log_reader"
+
+// logSource names the workspace's log source: a source on the service's
+// own database that reads as logReaderRole.
+func (s *UserService) logSource(workspaceId string) (string, error) {
+	var name string
+	err := s.db.QueryRow(`
+		SELECT database_name FROM penguin.snowflake_databases
+		WHERE workspace_id = $1 AND dsn_ref IS NULL AND driver = 'postgres' AND db_role = $2
+		ORDER BY database_name
+		LIMIT 1
+	`, workspaceId, logReaderRole).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", ErrNoLogSource
+	}
+	return name, err
+}
+
+func emptyToNil(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
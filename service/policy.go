@@ -0,0 +1,476 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/sqlutil"
+)
+
+var (
+	ErrAccessDenied     = errors.New("query reads objects your role may not access")
+	ErrPolicyNotFound   = errors.New("data access policy not found")
+	ErrInvalidPolicy    = errors.New("invalid data access policy")
+	errNoPrincipalQuery = errors.New("queries need an authenticated caller")
+)
+
+// DataPolicy lets a role query a table, or every table of a schema when
+// Table is "*". Columns lists the readable columns; nil allows them all.
+// Policies only grant access: a table no policy of the caller's role
+// covers is off limits. Admin roles are not subject to policies.
+type DataPolicy struct {
+	Id        string    `json:"id"`
+	RoleId    string    `json:"roleId" binding:"required"`
+	DBName    string    `json:"dbName,omitempty"` // empty applies to every source
+	Schema    string    `json:"schema" binding:"required"`
+	Table     string    `json:"table" binding:"required"`
+	Columns   []string  `json:"columns"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ForbiddenObject is a table, column or function a query uses without a
+// policy allowing it. Column is empty when the whole table is forbidden;
+// Function is set instead of Table for function calls.
+type ForbiddenObject struct {
+	Schema   string `json:"schema,omitempty"`
+	Table    string `json:"table,omitempty"`
+	Column   string `json:"column,omitempty"`
+	Function string `json:"function,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+func (o ForbiddenObject) String() string {
+	if o.Function != "" {
+		return sqlutil.FunctionRef{Schema: o.Schema, Name: o.Function}.String() + "()"
+	}
+	name := sqlutil.TableRef{Schema: o.Schema, Name: o.Table}.String()
+	if o.Column != "" {
+		name += "." + o.Column
+	}
+	return name
+}
+
+// AccessDeniedError lists every object a query was refused for, so the
+// author can fix them all at once. It matches ErrAccessDenied.
+type AccessDeniedError struct {
+	Objects []ForbiddenObject
+}
+
+func (e *AccessDeniedError) Error() string {
+	names := make([]string, len(e.Objects))
+	for i, o := range e.Objects {
+		names[i] = o.String()
+	}
+	return fmt.Sprintf("%v: %s", ErrAccessDenied, strings.Join(names, ", "))
+}
+
+func (e *AccessDeniedError) Is(target error) bool { return target == ErrAccessDenied }
+
+// authorizeQuery checks every table, column and function query uses on
+// source against the data access policies of the principal's role. The
+// source resolves unqualified names and, where the driver allows, plans
+// the query, so tables read through views, catalog lookups or anything
+// the lineage extractor misses are checked too.
+func (s *UserService) authorizeQuery(principal *models.Principal, source *datasource.Source, query string, args []interface{}) error {
+	policies, check, err := s.queryPolicies(principal, source)
+	if err != nil || !check {
+		return err
+	}
+
+	ctx := context.Background()
+	var forbidden []ForbiddenObject
+	err = source.ReadTx(ctx, func(conn datasource.Conn) error {
+		var err error
+		forbidden, err = forbiddenObjects(sourceCatalog{ctx: ctx, conn: conn, source: source}, policies, query, args)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(forbidden) > 0 {
+		return &AccessDeniedError{Objects: forbidden}
+	}
+	return nil
+}
+
+// queryPolicies returns the policies authorizeQuery checks a query of
+// principal on source against, and whether to check it at all. Admins
+// may read every table, but on a source with a role they are held to the
+// function checks too: the role's views filter on penguin.workspace_id,
+// which set_config could change.
+func (s *UserService) queryPolicies(principal *models.Principal, source *datasource.Source) ([]DataPolicy, bool, error) {
+	if principal == nil {
+		return nil, false, errNoPrincipalQuery
+	}
+	if principal.HasRole(s.cfg.AdminRoles) {
+		if source.Role == "" {
+			return nil, false, nil
+		}
+		return []DataPolicy{{Schema: "*", Table: "*"}}, true, nil
+	}
+	policies, err := s.rolePolicies(principal.RoleID, source.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	return policies, true, nil
+}
+
+// queryCatalog is what authorizeQuery asks the source about a query.
+type queryCatalog interface {
+	// scans lists the tables the planner reads, or nil when the driver
+	// cannot tell.
+	scans(query string, args []interface{}) ([]datasource.Relation, error)
+	// tableSchema resolves an unqualified table name, "" when it names
+	// no table.
+	tableSchema(name string) (string, error)
+	// functionSchemas resolves an unqualified function name, nil when the
+	// driver cannot tell.
+	functionSchemas(name string) ([]string, error)
+}
+
+type sourceCatalog struct {
+	ctx    context.Context
+	conn   datasource.Conn
+	source *datasource.Source
+}
+
+// scans leaves out the plan on a source with a role: the role's grants
+// already decide which tables its views may read, and penguin_log_reader's
+// view reads every workspace's logs.
+func (c sourceCatalog) scans(query string, args []interface{}) ([]datasource.Relation, error) {
+	if c.source.Role != "" {
+		return nil, nil
+	}
+	est, err := c.source.Explain(c.ctx, c.conn, query, args)
+	if errors.Is(err, datasource.ErrExplainUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return est.Scans, nil
+}
+
+// tableSchema falls back to the source's default schema for drivers that
+// cannot resolve names, where it is what unqualified names resolve to.
+func (c sourceCatalog) tableSchema(name string) (string, error) {
+	if r, ok := c.source.Driver.(datasource.NameResolver); ok {
+		return r.TableSchema(c.ctx, c.conn, name)
+	}
+	return c.source.DefaultSchema, nil
+}
+
+// functionSchemas places the functions of builtinFunctions in
+// builtinSchema for drivers that cannot resolve names, and any other in
+// no schema, so that they are refused.
+func (c sourceCatalog) functionSchemas(name string) ([]string, error) {
+	if r, ok := c.source.Driver.(datasource.NameResolver); ok {
+		return r.FunctionSchemas(c.ctx, c.conn, name)
+	}
+	if builtinFunctions[c.source.Driver.Name()][strings.ToLower(name)] {
+		return []string{builtinSchema}, nil
+	}
+	return []string{""}, nil
+}
+
+// builtinSchema holds the functions that cannot run queries of their own,
+// apart from those deniedFunction lists.
+const builtinSchema = "pg_catalog"
+
+// builtinFunctions lists, per driver without name resolution, the
+// functions queries may call. Anything else, such as MySQL's LOAD_FILE
+// or SLEEP, reads files or server state or ties up the connection. Type
+// names are included for CAST(x AS DECIMAL(10, 2)) and the like.
+var builtinFunctions = map[string]map[string]bool{
+	datasource.MySQL: wordSet(`
+		abs avg ceil ceiling char char_length coalesce concat concat_ws count curdate
+		date date_add date_format date_sub datediff datetime day dayofmonth dayofweek
+		decimal dense_rank double exp first_value floor format from_unixtime greatest
+		group_concat hour if ifnull instr json_extract json_unquote lag last_value lcase
+		lead least length ln locate log lower lpad ltrim max min minute mod month nchar
+		now nullif ntile percent_rank pow power quarter rank replace reverse round
+		row_number rpad rtrim second sign sqrt str_to_date substr substring
+		substring_index sum time timestampdiff trim truncate ucase unix_timestamp upper
+		varchar week weekday year`),
+	datasource.SQLite: wordSet(`
+		abs avg char coalesce count date datetime decimal dense_rank first_value format
+		glob group_concat hex ifnull iif instr json_extract julianday lag last_value lead
+		length like lower ltrim max min nchar ntile nullif numeric nvarchar percent_rank
+		printf rank replace round row_number rtrim sign strftime substr substring sum
+		time total trim typeof unicode upper varchar`),
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// deniedFunctions are built-in function families that run SQL passed as
+// text, reach other databases, or read files and server state, all
+// without the planner seeing which tables they read. set_config could
+// reset a source's role or workspace setting.
+var deniedFunctions = []string{
+	"query_to_xml", "cursor_to_xml", "table_to_xml", "schema_to_xml", "database_to_xml",
+	"dblink", "ts_stat", "lo_", "pg_read_", "pg_ls_", "pg_stat_get_", "pg_show_", "set_config",
+}
+
+func deniedFunction(name string) bool {
+	for _, prefix := range deniedFunctions {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// forbiddenObjects lists what query uses that policies do not allow.
+func forbiddenObjects(cat queryCatalog, policies []DataPolicy, query string, args []interface{}) ([]ForbiddenObject, error) {
+	lineage := sqlutil.ExtractLineage(query)
+
+	// Qualify tables the way the source will when it runs the query
+	qualified := make(map[sqlutil.TableRef]sqlutil.TableRef)
+	resolved := make(map[string]string)
+	for _, t := range lineage.Tables {
+		q := t
+		if q.Schema == "" {
+			schema, ok := resolved[t.Name]
+			if !ok {
+				var err error
+				if schema, err = cat.tableSchema(t.Name); err != nil {
+					return nil, err
+				}
+				resolved[t.Name] = schema
+			}
+			q.Schema = schema
+		}
+		qualified[t] = q
+	}
+
+	var forbidden []ForbiddenObject
+	// grants holds the grant of every table checked so far, nil for
+	// forbidden ones, keyed by lower-cased name
+	grants := make(map[sqlutil.TableRef]*tableGrant)
+	check := func(t sqlutil.TableRef) (*tableGrant, bool) {
+		key := sqlutil.TableRef{Schema: strings.ToLower(t.Schema), Name: strings.ToLower(t.Name)}
+		if grant, seen := grants[key]; seen {
+			return grant, false
+		}
+		grant := grantFor(policies, t.Schema, t.Name)
+		grants[key] = grant
+		return grant, true
+	}
+
+	for _, t := range lineage.Tables {
+		q := qualified[t]
+		if grant, first := check(q); grant == nil && first {
+			reason := "no policy allows this table"
+			if q.Schema == "" {
+				reason = "unqualified table that resolves to no schema; qualify it with its schema"
+			}
+			forbidden = append(forbidden, ForbiddenObject{Schema: q.Schema, Table: q.Name, Reason: reason})
+		}
+	}
+
+	for _, c := range lineage.Columns {
+		q := qualified[c.Table]
+		grant, _ := check(q)
+		if grant == nil || grant.allColumns || grant.columns[strings.ToLower(c.Column)] {
+			continue
+		}
+		reason := "no policy allows this column"
+		switch {
+		case c.Column == "*":
+			reason = "SELECT * and whole-row references need access to every column; list the columns instead"
+		case c.Ambiguous:
+			reason = "unqualified column in a multi-table query; qualify it with its table"
+		}
+		forbidden = append(forbidden, ForbiddenObject{Schema: q.Schema, Table: q.Name, Column: c.Column, Reason: reason})
+	}
+
+	// The planner sees through views and anything the lineage extractor
+	// missed. Their columns are unknown, so only whole tables pass.
+	scans, err := cat.scans(query, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range scans {
+		grant, first := check(sqlutil.TableRef{Schema: r.Schema, Name: r.Name})
+		switch {
+		case !first:
+		case grant == nil:
+			forbidden = append(forbidden, ForbiddenObject{Schema: r.Schema, Table: r.Name, Reason: "the query plan reads this table and no policy allows it"})
+		case !grant.allColumns:
+			forbidden = append(forbidden, ForbiddenObject{Schema: r.Schema, Table: r.Name, Reason: "the query plan reads this table through a view or function whose columns cannot be checked"})
+		}
+	}
+
+	for _, f := range lineage.Functions {
+		if deniedFunction(strings.ToLower(f.Name)) {
+			forbidden = append(forbidden, ForbiddenObject{Schema: f.Schema, Function: f.Name, Reason: "this function reads data the access policies cannot check"})
+			continue
+		}
+		schemas := []string{f.Schema}
+		if f.Schema == "" {
+			if schemas, err = cat.functionSchemas(f.Name); err != nil {
+				return nil, err
+			}
+		}
+		for _, schema := range schemas {
+			if !strings.EqualFold(schema, builtinSchema) {
+				forbidden = append(forbidden, ForbiddenObject{Schema: schema, Function: f.Name, Reason: "only built-in functions are allowed; others may run queries of their own"})
+				break
+			}
+		}
+	}
+	return forbidden, nil
+}
+
+type tableGrant struct {
+	allColumns bool
+	columns    map[string]bool
+}
+
+// grantFor merges the policies covering schema.table, or returns nil when
+// none does. Tables with an unknown schema only match "*" policies.
+func grantFor(policies []DataPolicy, schema, table string) *tableGrant {
+	var grant *tableGrant
+	for _, p := range policies {
+		if p.Schema != "*" && (schema == "" || !strings.EqualFold(p.Schema, schema)) {
+			continue
+		}
+		if p.Table != "*" && !strings.EqualFold(p.Table, table) {
+			continue
+		}
+		if grant == nil {
+			grant = &tableGrant{columns: make(map[string]bool)}
+		}
+		if p.Columns == nil {
+			grant.allColumns = true
+		}
+		for _, c := range p.Columns {
+			grant.columns[strings.ToLower(c)] = true
+		}
+	}
+	return grant
+}
+
+func (s *UserService) rolePolicies(roleId, dbName string) ([]DataPolicy, error) {
+	rows, err := s.db.Query(`
+		SELECT id, role_id, COALESCE(db_name, ''), schema_name, table_name, columns, created_at
+		FROM penguin.data_access_policy
+		WHERE role_id = $1 AND (db_name IS NULL OR db_name = $2)
+	`, roleId, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return scanPolicies(rows)
+}
+
+func scanPolicies(rows *sql.Rows) ([]DataPolicy, error) {
+	defer rows.Close()
+
+	policies := []DataPolicy{}
+	for rows.Next() {
+		var p DataPolicy
+		var columns []byte
+		if err := rows.Scan(&p.Id, &p.RoleId, &p.DBName, &p.Schema, &p.Table, &columns, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		if columns != nil {
+			if err := json.Unmarshal(columns, &p.Columns); err != nil {
+				return nil, err
+			}
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+func (s *UserService) ListPolicies(workspaceId string) ([]DataPolicy, error) {
+	rows, err := s.db.Query(`
+		SELECT id, role_id, COALESCE(db_name, ''), schema_name, table_name, columns, created_at
+		FROM penguin.data_access_policy
+		WHERE workspace_id = $1
+		ORDER BY role_id, schema_name, table_name
+	`, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	return scanPolicies(rows)
+}
+
+func (s *UserService) CreatePolicy(workspaceId string, p DataPolicy) (*DataPolicy, error) {
+	if p.Schema == "*" && p.Table != "*" {
+		return nil, fmt.Errorf("%w: a table policy needs a schema", ErrInvalidPolicy)
+	}
+	if p.Columns != nil && p.Table == "*" {
+		return nil, fmt.Errorf("%w: columns can only be restricted on a single table", ErrInvalidPolicy)
+	}
+
+	// Policies may only name roles and sources of their own workspace
+	if err := s.checkRoles(workspaceId, map[string][]string{p.RoleId: nil}); err != nil {
+		if errors.Is(err, ErrForeignRole) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+		}
+		return nil, err
+	}
+
+	var dbName, columns interface{}
+	if p.DBName != "" {
+		var known bool
+		err := s.db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM penguin.snowflake_databases WHERE database_name = $1 AND workspace_id = $2)
+		`, p.DBName, workspaceId).Scan(&known)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: unknown data source %q", ErrInvalidPolicy, p.DBName)
+		}
+		dbName = p.DBName
+	}
+	if p.Columns != nil {
+		raw, err := json.Marshal(p.Columns)
+		if err != nil {
+			return nil, err
+		}
+		columns = raw
+	}
+
+	p.Id = uuid.New().String()
+	p.CreatedAt = time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO penguin.data_access_policy (id, workspace_id, role_id, db_name, schema_name, table_name, columns, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, p.Id, workspaceId, p.RoleId, dbName, p.Schema, p.Table, columns, p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *UserService) DeletePolicy(workspaceId, id string) error {
+	res, err := s.db.Exec(`DELETE FROM penguin.data_access_policy WHERE id = $1 AND workspace_id = $2`, id, workspaceId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPolicyNotFound
+	}
+	return nil
+}
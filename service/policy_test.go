@@ -0,0 +1,240 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/config"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+// fakeCatalog resolves names from maps instead of a source.
+type fakeCatalog struct {
+	tables    map[string]string
+	functions map[string][]string
+	planned   []datasource.Relation
+	// resolveFunction replaces functions when set.
+	resolveFunction func(name string) ([]string, error)
+}
+
+func (c fakeCatalog) scans(query string, args []interface{}) ([]datasource.Relation, error) {
+	return c.planned, nil
+}
+
+func (c fakeCatalog) tableSchema(name string) (string, error) {
+	return c.tables[name], nil
+}
+
+func (c fakeCatalog) functionSchemas(name string) ([]string, error) {
+	if c.resolveFunction != nil {
+		return c.resolveFunction(name)
+	}
+	return c.functions[name], nil
+}
+
+func TestForbiddenObjects(t *testing.T) {
+	policies := []DataPolicy{
+		{Schema: "penguin", Table: "*"},
+		{Schema: "sales", Table: "orders", Columns: []string{"id", "amount"}},
+	}
+	catalog := fakeCatalog{
+		tables: map[string]string{
+			"user":             "penguin",
+			"orders":           "sales",
+			"pg_stat_activity": "pg_catalog",
+			"user_view":        "penguin",
+		},
+		functions: map[string][]string{
+			"query_to_xml": {"pg_catalog"},
+			"to_json":      {"pg_catalog"},
+			"lower":        {"pg_catalog"},
+			"leak":         {"public"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		planned []datasource.Relation
+		want    []string
+	}{
+		{"granted columns", "select id, amount from sales.orders", nil, nil},
+		{"built-in function", "select lower(id), coalesce(amount, 0) from sales.orders", nil, nil},
+		{"ungranted column", "select id, secret from sales.orders", nil, []string{"sales.orders.secret"}},
+		{"query_to_xml", "select query_to_xml('select * from penguin.user', true, false, '')", nil, []string{"query_to_xml()"}},
+		{"qualified query_to_xml", "select pg_catalog.query_to_xml('select 1', true, false, '')", nil, []string{"pg_catalog.query_to_xml()"}},
+		{"table_to_xml", "select table_to_xml('sales.orders', true, false, '')", nil, []string{"table_to_xml()"}},
+		{"set_config", "select set_config('role', 'none', true)", nil, []string{"set_config()"}},
+		{"dblink", "select * from dblink('dbname=x', 'select secret from sales.orders') as t(secret text)", nil, []string{"dblink()"}},
+		{"whole row of restricted table", "select to_json(o) from sales.orders o", nil, []string{"sales.orders.*", "sales.orders.o"}},
+		{"whole row of granted table", "select to_json(u) from penguin.user u", nil, nil},
+		{"catalog view before default schema", "select usename from pg_stat_activity", nil, []string{"pg_catalog.pg_stat_activity"}},
+		{"for update", "select id from sales.orders for update of orders nowait", nil, nil},
+		{"user-defined function", "select leak(id) from sales.orders", nil, []string{"public.leak()"}},
+		{"qualified user-defined function", "select public.leak(1)", nil, []string{"public.leak()"}},
+		{"unresolved table", "select id from missing", nil, []string{"missing"}},
+		{
+			"view over ungranted table", "select id from user_view",
+			[]datasource.Relation{{Schema: "hr", Name: "salary"}},
+			[]string{"hr.salary"},
+		},
+		{
+			"view over restricted table", "select id from user_view",
+			[]datasource.Relation{{Schema: "sales", Name: "orders"}},
+			[]string{"sales.orders"},
+		},
+		{
+			"plan matching the query", "select id from sales.orders",
+			[]datasource.Relation{{Schema: "sales", Name: "orders"}},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cat := catalog
+			cat.planned = tt.planned
+			forbidden, err := forbiddenObjects(cat, policies, tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, o := range forbidden {
+				got = append(got, o.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("forbiddenObjects(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaGranted(t *testing.T) {
+	policies := []DataPolicy{
+		{Schema: "sales", Table: "orders"},
+		{Schema: "Penguin", Table: "*"},
+	}
+	for schema, want := range map[string]bool{"sales": true, "penguin": true, "hr": false, "": false} {
+		if got := schemaGranted(policies, schema); got != want {
+			t.Errorf("schemaGranted(%q) = %v, want %v", schema, got, want)
+		}
+	}
+	if !schemaGranted([]DataPolicy{{Schema: "*", Table: "*"}}, "hr") {
+		t.Error("a policy on every schema does not grant hr")
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	policies := []DataPolicy{{Schema: "*", Table: "*"}}
+	tests := []struct {
+		driver string
+		query  string
+		want   []string
+	}{
+		{datasource.MySQL, "select count(*), COALESCE(max(amount), 0) from orders", nil},
+		{datasource.MySQL, "select cast(amount as decimal(10, 2)) from orders", nil},
+		{datasource.MySQL, "select load_file('/etc/passwd')", []string{"load_file()"}},
+		{datasource.MySQL, "select SLEEP(60)", []string{"sleep()"}},
+		{datasource.MySQL, "select other.leak(1)", []string{"other.leak()"}},
+		{datasource.SQLite, "select strftime('%Y', created_at), total(amount) from orders", nil},
+		{datasource.SQLite, "select load_extension('x')", []string{"load_extension()"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver+" "+tt.query, func(t *testing.T) {
+			driver, err := datasource.LookupDriver(tt.driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cat := fakeCatalog{tables: map[string]string{"orders": "main"}}
+			resolve := sourceCatalog{source: &datasource.Source{Driver: driver}}
+			cat.resolveFunction = resolve.functionSchemas
+			forbidden, err := forbiddenObjects(cat, policies, tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, o := range forbidden {
+				got = append(got, o.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("forbiddenObjects(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminQueryPolicies(t *testing.T) {
+	s := &UserService{cfg: &config.Config{AdminRoles: []string{"ADMIN 1"}}}
+	admin := &models.Principal{RoleName: "ADMIN 1", WorkspaceID: "w2"}
+	catalog := fakeCatalog{
+		tables:    map[string]string{"workspace_logs": "penguin"},
+		functions: map[string][]string{"set_config": {"pg_catalog"}, "lower": {"pg_catalog"}},
+	}
+
+	_, check, err := s.queryPolicies(admin, &datasource.Source{Config: datasource.Config{Name: "penguin"}})
+	if err != nil || check {
+		t.Fatalf("admin on a source without a role: check = %v, %v, want false", check, err)
+	}
+
+	policies, check, err := s.queryPolicies(admin, &datasource.Source{Config: datasource.Config{Name: "penguin_logs", Role: "penguin_log_reader"}})
+	if err != nil || !check {
+		t.Fatalf("admin on a source with a role: check = %v, %v, want true", check, err)
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"select lower(message) from workspace_logs", nil},
+		{"select message from workspace_logs where set_config('penguin.workspace_id', 'w1', true) is not null", []string{"set_config()"}},
+	}
+	for _, tt := range tests {
+		forbidden, err := forbiddenObjects(catalog, policies, tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, o := range forbidden {
+			got = append(got, o.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("forbiddenObjects(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRoleSourceScans(t *testing.T) {
+	cat := sourceCatalog{source: &datasource.Source{Config: datasource.Config{Role: "penguin_log_reader"}}}
+	scans, err := cat.scans("select message from penguin.workspace_logs", nil)
+	if err != nil || scans != nil {
+		t.Errorf("scans() = %v, %v, want no plan on a source with a role", scans, err)
+	}
+}
+
+func TestAuthorizeQuery(t *testing.T) {
+	s := newTestService(t)
+	addSQLiteSource(t, s, "sales", alice.WorkspaceID)
+	_, err := s.db.Exec(`INSERT INTO penguin.data_access_policy (id, role_id, db_name, schema_name, table_name, columns) VALUES ('p1', $1, 'sales', 'main', 'orders', '["id"]')`, analystRoleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := s.source(alice, "sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.authorizeQuery(alice, src, "select id from main.orders", nil); err != nil {
+		t.Errorf("granted column refused: %v", err)
+	}
+	err = s.authorizeQuery(alice, src, "select id, amount from main.orders", nil)
+	var denied *AccessDeniedError
+	if !errors.As(err, &denied) || len(denied.Objects) != 1 || denied.Objects[0].String() != "main.orders.amount" {
+		t.Errorf("ungranted column: %v, want main.orders.amount denied", err)
+	}
+	if err := s.authorizeQuery(admin, src, "select id, amount from main.orders", nil); err != nil {
+		t.Errorf("admin refused: %v", err)
+	}
+	if err := s.authorizeQuery(nil, src, "select id from main.orders", nil); !errors.Is(err, errNoPrincipalQuery) {
+		t.Errorf("no caller: %v, want errNoPrincipalQuery", err)
+	}
+}
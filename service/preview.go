@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/querycache"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid or stale cursor")
+	ErrQueryTimeout  = errors.New("query timed out")
+)
+
+type SQLPreviewRequest struct {
+	Query           string                 `json:"query"`
+	DBName          string                 `json:"db_name"`
+	Parameters      []models.Parameter     `json:"parameters"`
+	ParameterValues map[string]interface{} `json:"parameter_values"`
+	Limit           int                    `json:"limit"`
+	// Cursor is the next_cursor of a previous response.
+	Cursor string `json:"cursor"`
+	// NoCache runs the query even when a cached page exists.
+	NoCache bool `json:"no_cache"`
+}
+
+type SQLPreviewResponse struct {
+	Columns    []ColumnInfo      `json:"columns"`
+	Rows       [][]interface{}   `json:"rows"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	Cache      querycache.Status `json:"cache,omitempty"`
+}
+
+// previewCursor records where the next page starts and which query it
+// belongs to, so a cursor cannot be replayed against a different query.
+type previewCursor struct {
+	Offset int    `json:"o"`
+	Query  string `json:"q"`
+}
+
+// PreviewSQLQuery returns one page of a query's rows, converted the same
+// way as rows written to a sheet. Pages are fetched with LIMIT/OFFSET, so
+// queries without an ORDER BY may return rows in a different order from
+// page to page. Pages are cached like report results.
+func (s *UserService) PreviewSQLQuery(principal *models.Principal, req SQLPreviewRequest) (*SQLPreviewResponse, error) {
+	source, err := s.source(principal, req.DBName)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args, values, err := bindQuery(source, req.Query, req.Parameters, req.ParameterValues)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorizeQuery(principal, source, query, args); err != nil {
+		return nil, err
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > s.cfg.PreviewMaxRows {
+		limit = s.cfg.PreviewMaxRows
+	}
+
+	fingerprint, err := previewFingerprint(source.Name, query, values)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := 0
+	if req.Cursor != "" {
+		cur, err := decodeCursor(req.Cursor)
+		if err != nil || cur.Query != fingerprint {
+			return nil, ErrInvalidCursor
+		}
+		offset = cur.Offset
+	}
+
+	key := cacheKey("preview", source, query, args, limit, offset)
+	var cached SQLPreviewResponse
+	status := s.cached(key, req.NoCache, &cached)
+	if status == querycache.Hit {
+		// gob does not tell empty slices from nil ones
+		if cached.Rows == nil {
+			cached.Rows = [][]interface{}{}
+		}
+		cached.Cache = status
+		return &cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.PreviewTimeout)
+	defer cancel()
+
+	if _, err := s.estimateQuery(ctx, source, query, args); err != nil {
+		return nil, err
+	}
+
+	resp := &SQLPreviewResponse{Rows: [][]interface{}{}}
+	err = source.ReadTx(ctx, func(conn datasource.Conn) error {
+		// Fetch one extra row to learn whether there is a next page
+		rows, err := conn.QueryContext(ctx, source.Driver.PageQuery(query, limit+1, offset), args...)
+		if err != nil {
+			return fmt.Errorf("error executing query: %w", err)
+		}
+		defer rows.Close()
+
+		cts, err := rows.ColumnTypes()
+		if err != nil {
+			return fmt.Errorf("error getting column types: %w", err)
+		}
+		resp.Columns = make([]ColumnInfo, len(cts))
+		for i, ct := range cts {
+			resp.Columns[i] = ColumnInfo{
+				ColName:  ct.Name(),
+				DataType: ct.DatabaseTypeName(),
+				Type:     source.Driver.ColumnType(ct.DatabaseTypeName()),
+			}
+		}
+
+		for rows.Next() {
+			if len(resp.Rows) == limit {
+				resp.NextCursor = encodeCursor(previewCursor{Offset: offset + limit, Query: fingerprint})
+				break
+			}
+
+			row := make([]interface{}, len(cts))
+			ptrs := make([]interface{}, len(cts))
+			for i := range row {
+				ptrs[i] = &row[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			for i, v := range row {
+				row[i] = datasource.ConvertValue(v, resp.Columns[i].Type)
+			}
+			resp.Rows = append(resp.Rows, row)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("row iteration error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: preview exceeded the %s time limit", ErrQueryTimeout, s.cfg.PreviewTimeout)
+		}
+		return nil, err
+	}
+
+	s.storeResult(key, resp, s.cfg.QueryCacheTTL, source, query)
+	resp.Cache = status
+	return resp, nil
+}
+
+func previewFingerprint(source, query string, values map[string]interface{}) (string, error) {
+	// json.Marshal sorts map keys, so equal parameter sets hash equally
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(source + "\x00" + query + "\x00" + string(b)))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func encodeCursor(c previewCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*previewCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c previewCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if c.Offset < 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/sqlutil"
+)
+
+// bindQuery normalises a user supplied script into a single statement
+// that can be wrapped as a subquery and binds its parameters for source.
+func bindQuery(source *datasource.Source, script string, decls []models.Parameter, values map[string]interface{}) (string, []interface{}, map[string]interface{}, error) {
+	query, err := sqlutil.CleanQuery(script)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return sqlutil.Bind(query, decls, values, source.Driver.Placeholder)
+}
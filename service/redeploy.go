@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+var (
+	ErrRedeployNotFound   = errors.New("redeploy job not found")
+	ErrScriptUnregistered = errors.New("no bound script is recorded for this report; register its script ID")
+	ErrScriptNotBound     = errors.New("script project is not bound to this report's spreadsheet")
+)
+
+// maxRedeployConcurrency bounds parallel Apps Script API calls; the API
+// allows a few dozen project writes per minute per user.
+const maxRedeployConcurrency = 10
+
+// redeployJobRetention is how long finished jobs stay available to poll.
+const redeployJobRetention = 24 * time.Hour
+
+// Redeploy job and result statuses.
+const (
+	RedeployRunning = "running"
+	RedeployDone    = "done"
+
+	RedeployUpdated = "updated"
+	RedeployFailed  = "failed"
+	RedeploySkipped = "skipped"
+)
+
+// RedeployRequest selects the reports whose bound scripts get the current
+// template. Without SheetIds every report is taken; OutdatedOnly narrows
+// the selection to scripts of an older template. Reports with no recorded
+// script are skipped, since binding a second script would not remove the
+// first; RegisterScript records the one they have.
+type RedeployRequest struct {
+	SheetIds     []string `json:"sheetIds"`
+	OutdatedOnly bool     `json:"outdatedOnly"`
+	Concurrency  int      `json:"concurrency"`
+}
+
+type RedeployResult struct {
+	SheetId  string `json:"sheetId"`
+	Status   string `json:"status"`
+	ScriptId string `json:"scriptId,omitempty"`
+	// Trigger is the edit trigger status of reports that had none
+	// installed before, which the redeploy installs.
+	Trigger string `json:"trigger,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RedeployJob is the progress of a bulk redeploy. Jobs live in memory
+// only, are lost on restart and are dropped redeployJobRetention after
+// they finish.
+type RedeployJob struct {
+	Id         string           `json:"id"`
+	Status     string           `json:"status"`
+	Version    string           `json:"version"`
+	Total      int              `json:"total"`
+	Updated    int              `json:"updated"`
+	Failed     int              `json:"failed"`
+	Skipped    int              `json:"skipped"`
+	StartedAt  time.Time        `json:"startedAt"`
+	FinishedAt *time.Time       `json:"finishedAt,omitempty"`
+	Results    []RedeployResult `json:"results"`
+}
+
+// redeployJobs holds the jobs started since the server came up.
+type redeployJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*RedeployJob
+}
+
+// StartRedeploy starts redeploying scripts in the background and returns
+// the job to poll.
+func (s *UserService) StartRedeploy(req RedeployRequest) (*RedeployJob, error) {
+	ids, err := s.redeployTargets(req)
+	if err != nil {
+		return nil, err
+	}
+	job := newRedeployJob(len(ids))
+
+	s.redeploys.mu.Lock()
+	s.redeploys.prune(time.Now().Add(-redeployJobRetention))
+	s.redeploys.jobs[job.Id] = job
+	snapshot := job.copy()
+	s.redeploys.mu.Unlock()
+
+	go s.runRedeploy(job, ids, req.Concurrency, nil)
+	return snapshot, nil
+}
+
+// RedeployScripts redeploys scripts and waits for the job to finish,
+// calling progress after each report. It is what the redeploy-scripts
+// command runs.
+func (s *UserService) RedeployScripts(req RedeployRequest, progress func(RedeployJob, RedeployResult)) (*RedeployJob, error) {
+	ids, err := s.redeployTargets(req)
+	if err != nil {
+		return nil, err
+	}
+	job := newRedeployJob(len(ids))
+	s.runRedeploy(job, ids, req.Concurrency, progress)
+	return job, nil
+}
+
+// GetRedeploy returns the current state of a job.
+func (s *UserService) GetRedeploy(id string) (*RedeployJob, error) {
+	s.redeploys.mu.Lock()
+	defer s.redeploys.mu.Unlock()
+	job, ok := s.redeploys.jobs[id]
+	if !ok {
+		return nil, ErrRedeployNotFound
+	}
+	return job.copy(), nil
+}
+
+// prune drops the jobs that finished before cutoff; callers hold mu.
+func (r *redeployJobs) prune(cutoff time.Time) {
+	for id, job := range r.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// UnregisteredScript is a report with no recorded bound script.
+type UnregisteredScript struct {
+	SheetId     string    `json:"sheetId"`
+	ReportName  string    `json:"reportName"`
+	WorkspaceId string    `json:"workspaceId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// ListUnregisteredScripts returns the reports redeploys skip because no
+// bound script is recorded for them. The Apps Script API cannot list the
+// scripts bound to a spreadsheet, so their IDs have to be looked up in
+// the script editor (Project Settings) and registered.
+func (s *UserService) ListUnregisteredScripts() ([]UnregisteredScript, error) {
+	rows, err := s.db.Query(`
+		SELECT id, report_name, workspace_id, created_at
+		FROM penguin.spreadsheet WHERE script_id IS NULL ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []UnregisteredScript{}
+	for rows.Next() {
+		var u UnregisteredScript
+		var createdAt sql.NullTime
+		if err := rows.Scan(&u.SheetId, &u.ReportName, &u.WorkspaceId, &createdAt); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = createdAt.Time
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+// RegisterScript records the script already bound to a report's sheet,
+// after checking with the Apps Script API that it is bound to it. Its
+// version and hash stay unknown until the next redeploy or repair.
+func (s *UserService) RegisterScript(sheetId, scriptId string) error {
+	ctx, err := s.sheetContext(context.Background(), sheetId)
+	if err != nil {
+		return err
+	}
+	parent, err := utils.ScriptParent(ctx, scriptId)
+	if err != nil {
+		return err
+	}
+	if parent != sheetId {
+		return ErrScriptNotBound
+	}
+	_, err = s.db.Exec(`
+		UPDATE penguin.spreadsheet SET script_id = $1, script_version = NULL, script_hash = NULL, deployment_id = NULL
+		WHERE id = $2
+	`, scriptId, sheetId)
+	if err == nil {
+		log.Printf("📎 Registered script %s of report %s", scriptId, sheetId)
+	}
+	return err
+}
+
+func (s *UserService) redeployTargets(req RedeployRequest) ([]string, error) {
+	query := `SELECT id FROM penguin.spreadsheet WHERE ($1 OR id = ANY($2)) AND (NOT $3 OR script_version IS DISTINCT FROM $4) ORDER BY created_at`
+	rows, err := s.db.Query(query, len(req.SheetIds) == 0, pq.Array(req.SheetIds), req.OutdatedOnly, utils.ScriptVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	found := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range req.SheetIds {
+		if !found[id] && !req.OutdatedOnly {
+			return nil, fmt.Errorf("%w: %s", ErrReportNotFound, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *UserService) runRedeploy(job *RedeployJob, ids []string, concurrency int, progress func(RedeployJob, RedeployResult)) {
+	if concurrency <= 0 {
+		concurrency = s.cfg.RedeployConcurrency
+	}
+	concurrency = min(max(concurrency, 1), maxRedeployConcurrency)
+	log.Printf("🚀 Redeploying script version %s to %d reports (job %s)", job.Version, len(ids), job.Id)
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(sheetId string) {
+			defer func() { <-sem; wg.Done() }()
+
+			result := s.redeployOne(context.Background(), sheetId)
+
+			s.redeploys.mu.Lock()
+			job.Results = append(job.Results, result)
+			switch result.Status {
+			case RedeployUpdated:
+				job.Updated++
+			case RedeployFailed:
+				job.Failed++
+			case RedeploySkipped:
+				job.Skipped++
+			}
+			snapshot := *job.copy()
+			s.redeploys.mu.Unlock()
+
+			if progress != nil {
+				progress(snapshot, result)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	s.redeploys.mu.Lock()
+	now := time.Now()
+	job.Status, job.FinishedAt = RedeployDone, &now
+	s.redeploys.mu.Unlock()
+	log.Printf("Redeploy job %s done: %d updated, %d failed, %d skipped", job.Id, job.Updated, job.Failed, job.Skipped)
+}
+
+func (s *UserService) redeployOne(ctx context.Context, sheetId string) RedeployResult {
+	result := RedeployResult{SheetId: sheetId, Status: RedeployUpdated}
+	// Each report is redeployed with its workspace's Google credentials
+	ctx, err := s.sheetContext(ctx, sheetId)
+	var before *boundScript
+	if err == nil {
+		before, err = s.loadBoundScript(sheetId)
+	}
+	if err == nil {
+		result.ScriptId, err = s.redeployScript(ctx, sheetId, false)
+	}
+	switch {
+	case errors.Is(err, utils.ErrScriptNotFound):
+		result.Status, result.Error = RedeploySkipped, "the bound script no longer exists; repair the report to bind one"
+		return result
+	case errors.Is(err, ErrScriptUnregistered):
+		result.Status, result.Error = RedeploySkipped, err.Error()
+		return result
+	case err != nil:
+		result.Status, result.Error = RedeployFailed, err.Error()
+		log.Printf("Redeploy of %s failed: %v", sheetId, err)
+		return result
+	}
+
+	// Triggers installed before their account was recorded are refused
+	// by the server until installed again
+	if before.TriggerStatus != TriggerInstalled || before.TriggerOwner == "" {
+		result.Trigger = TriggerInstalled
+		if err := s.installTrigger(ctx, sheetId, result.ScriptId); err != nil {
+			result.Trigger, result.Error = TriggerFailed, err.Error()
+		}
+	}
+	return result
+}
+
+func newRedeployJob(total int) *RedeployJob {
+	return &RedeployJob{
+		Id:        uuid.New().String(),
+		Status:    RedeployRunning,
+		Version:   utils.ScriptVersion,
+		Total:     total,
+		StartedAt: time.Now(),
+		Results:   []RedeployResult{},
+	}
+}
+
+// copy returns a snapshot of j; callers hold redeployJobs.mu.
+func (j *RedeployJob) copy() *RedeployJob {
+	c := *j
+	c.Results = append([]RedeployResult(nil), j.Results...)
+	return &c
+}
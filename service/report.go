@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/querycache"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+var (
+	ErrReportNotFound = errors.New("report not found")
+	ErrNoStoredQuery  = errors.New("report was created before SQL scripts were stored")
+	ErrReportDenied   = errors.New("only the report's creator or an admin may do this")
+)
+
+// reportDefinition is the part of a models.ReportInput needed to rebuild
+// a sheet later, stored in penguin.spreadsheet.definition.
+type reportDefinition struct {
+	Columns    []models.Column    `json:"columns"`
+	Parameters []models.Parameter `json:"parameters"`
+	KeyColumn  string             `json:"keyColumn,omitempty"`
+	CacheTTL   string             `json:"cacheTtl,omitempty"`
+}
+
+type storedReport struct {
+	Id          string
+	WorkspaceId string
+	// GoogleCredentials are the workspace's, empty for the deployment's.
+	GoogleCredentials string
+	ReportName        string
+	// CreatedBy is empty for reports from before it was recorded.
+	CreatedBy       string
+	DBName          string
+	SqlScript       string
+	Definition      reportDefinition
+	ParameterValues map[string]interface{}
+	// Columns is nil for reports created before column IDs.
+	Columns []SheetColumn
+}
+
+func (s *UserService) loadReport(sheetId string) (*storedReport, error) {
+	var r storedReport
+	var definition, paramValues, schema []byte
+	err := s.db.QueryRow(`
+		SELECT s.id, s.workspace_id, COALESCE(w.google_credentials, ''), s.report_name, COALESCE(s.created_by::text, ''), COALESCE(s.db_name, ''),
+			COALESCE(s.sql_script, ''), s.definition, s.parameter_values, s.schema
+		FROM penguin.spreadsheet s JOIN penguin.workspace w ON w.id = s.workspace_id
+		WHERE s.id = $1
+	`, sheetId).Scan(&r.Id, &r.WorkspaceId, &r.GoogleCredentials, &r.ReportName, &r.CreatedBy, &r.DBName, &r.SqlScript, &definition, &paramValues, &schema)
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if definition != nil {
+		if err := json.Unmarshal(definition, &r.Definition); err != nil {
+			return nil, err
+		}
+	}
+	if paramValues != nil {
+		if err := json.Unmarshal(paramValues, &r.ParameterValues); err != nil {
+			return nil, err
+		}
+	}
+	if r.Columns, err = parseSchema(schema); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ownReport loads a report that principal may maintain: one they created,
+// or any report of their workspace as an admin. A nil principal is the
+// service itself, as in the health audit.
+func (s *UserService) ownReport(principal *models.Principal, sheetId string) (*storedReport, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return report, nil
+	}
+	if principal.WorkspaceID != report.WorkspaceId {
+		return nil, ErrReportNotFound
+	}
+	if report.CreatedBy != principal.UserID && !principal.HasRole(s.cfg.AdminRoles) {
+		return nil, ErrReportDenied
+	}
+	return report, nil
+}
+
+type RefreshRequest struct {
+	// ParameterValues override the values the sheet was last built with.
+	ParameterValues map[string]interface{} `json:"parameterValues"`
+	// NoCache runs the query even when a cached result exists.
+	NoCache bool `json:"noCache"`
+}
+
+// RefreshReport re-runs a report's stored SqlScript and replaces the
+// sheet contents with the result. The query runs every time unless the
+// report sets a cacheTtl, within which the result may come from the
+// query cache; either way the fresh result is cached.
+func (s *UserService) RefreshReport(principal *models.Principal, sheetId string, req RefreshRequest) (int, string, querycache.Status) {
+	report, err := s.loadReport(sheetId)
+	if err == ErrReportNotFound {
+		return http.StatusNotFound, err.Error(), ""
+	}
+	if err != nil {
+		log.Printf("Error loading report %s: %v", sheetId, err)
+		return http.StatusInternalServerError, "Internal server error", ""
+	}
+	if report.SqlScript == "" {
+		return http.StatusConflict, ErrNoStoredQuery.Error() + " and cannot be refreshed", ""
+	}
+
+	source, err := s.reportSource(report)
+	if err != nil {
+		log.Printf("Error resolving data source %q: %v", report.DBName, err)
+		if errors.Is(err, datasource.ErrUnknownSource) {
+			return http.StatusConflict, err.Error(), ""
+		}
+		return http.StatusInternalServerError, "Could not connect to data source", ""
+	}
+
+	values := make(map[string]interface{})
+	for name, v := range report.ParameterValues {
+		values[name] = v
+	}
+	for name, v := range req.ParameterValues {
+		values[name] = v
+	}
+
+	query, args, paramValues, err := bindQuery(source, report.SqlScript, report.Definition.Parameters, values)
+	if err != nil {
+		return http.StatusBadRequest, err.Error(), ""
+	}
+
+	ttl, err := s.reportTTL(report.Definition.CacheTTL)
+	if err != nil {
+		ttl = s.cfg.QueryCacheTTL
+	}
+	// A refresh is asked for to get current data, and snapshots what it
+	// writes, so the default TTL meant for previews is not enough to
+	// reuse a result
+	bypass := req.NoCache || report.Definition.CacheTTL == ""
+	result, cache, err := s.reportData(principal, source, query, args, report.Definition.Columns, ttl, bypass)
+	if code, msg := reportDataError(err); code != 0 {
+		return code, msg, ""
+	}
+	sheetData, sheetColumns := result.Data, result.Columns
+
+	// Keep whatever was in the sheet, edits included, before replacing it
+	if _, err := s.syncReport(principal, report); err != nil {
+		log.Printf("⚠️ Could not snapshot %s before refreshing: %v", sheetId, err)
+	}
+
+	ctx := googleContext(context.Background(), report.GoogleCredentials)
+	if err := utils.ClearSheet(ctx, sheetId, "Sheet1"); err != nil {
+		log.Printf("Error clearing sheet: %v", err)
+		return http.StatusInternalServerError, "Failed to clear sheet", ""
+	}
+
+	if err := utils.WriteDataToSheet(ctx, sheetId, "Sheet1", "A1", sheetData); err != nil {
+		log.Printf("Error writing data to sheet: %v", err)
+		return http.StatusInternalServerError, "Failed to write data to sheet", ""
+	}
+
+	// Columns keep their IDs by name; the tags are rewritten since the
+	// query's column order wins over any reordering done in Sheets
+	sheetCols := assignColumnIds(report.Columns, sheetColumns)
+	if err := utils.TagColumns(ctx, sheetId, "Sheet1", sheetColumnIds(sheetCols)); err != nil {
+		log.Printf("Error tagging sheet columns: %v", err)
+		return http.StatusInternalServerError, "Failed to tag sheet columns", ""
+	}
+
+	paramValuesJSON, err := json.Marshal(paramValues)
+	if err != nil {
+		log.Printf("Failed to marshal parameter values: %v", err)
+		return http.StatusInternalServerError, "Internal server error", ""
+	}
+	schemaJSON, err := json.Marshal(sheetCols)
+	if err != nil {
+		log.Printf("Failed to marshal schema JSON: %v", err)
+		return http.StatusInternalServerError, "Internal server error", ""
+	}
+
+	// Reports from before column IDs move their name-based permissions
+	// over to the IDs just assigned
+	var permissions map[string][]string
+	if report.Columns == nil {
+		permissions = columnPermissions(report.Definition.Columns, sheetCols)
+	}
+
+	err = s.recordRefresh(context.Background(), sheetId, schemaJSON, paramValuesJSON, permissions)
+	if err != nil {
+		log.Printf("Failed to record refresh of %s: %v", sheetId, err)
+		return http.StatusInternalServerError, "Sheet refreshed but could not be recorded", ""
+	}
+
+	if err := s.storeLastKnown(context.Background(), sheetId, report.Definition.Columns, sheetColumnIds(sheetCols), keyColumnId(sheetCols, report.Definition.KeyColumn), sheetData); err != nil {
+		log.Printf("Failed to store last known values of %s: %v", sheetId, err)
+	}
+
+	snapshot := &ReportVersion{Kind: SnapshotRefresh, Columns: sheetColumns, EditableColumns: editableColumns(report.Definition.Columns), ParameterValues: paramValues}
+	if err := s.saveSnapshot(context.Background(), sheetId, principal, snapshot, sheetData); err != nil {
+		log.Printf("Failed to snapshot %s: %v", sheetId, err)
+	}
+
+	log.Printf("✅ Report %s refreshed", sheetId)
+	return http.StatusOK, s.messageWithRows("Report refreshed successfully", result), cache
+}
+
+// recordRefresh stores what a refresh wrote. permissions, when not nil,
+// replace the report's.
+func (s *UserService) recordRefresh(ctx context.Context, sheetId string, schemaJSON, paramValuesJSON []byte, permissions map[string][]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE penguin.spreadsheet SET parameter_values = $1, refreshed_at = $2, schema = $3
+		WHERE id = $4
+	`, paramValuesJSON, time.Now(), schemaJSON, sheetId)
+	if err != nil {
+		return err
+	}
+
+	if permissions != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM penguin.spreadsheetpermissions WHERE spreadsheet_id = $1`, sheetId); err != nil {
+			return err
+		}
+		if err := insertPermissions(ctx, tx, sheetId, permissions); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"log"
+)
+
+// saga runs the external side effects of a multi-step operation, such as
+// creating a report's Drive file and Apps Script project, and remembers
+// how to undo each one. When a later step fails, compensate undoes the
+// completed steps in reverse order.
+type saga struct {
+	name  string
+	steps []sagaStep
+}
+
+type sagaStep struct {
+	name       string
+	compensate func(ctx context.Context) error
+}
+
+func newSaga(name string) *saga {
+	return &saga{name: name}
+}
+
+// done records a completed step and the action that undoes it.
+func (s *saga) done(step string, compensate func(ctx context.Context) error) {
+	s.steps = append(s.steps, sagaStep{name: step, compensate: compensate})
+}
+
+// compensate undoes every completed step, newest first. A failing
+// compensation is logged and does not stop the others, since each one
+// removes a separate resource.
+func (s *saga) compensate(ctx context.Context) {
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := s.steps[i]
+		if err := step.compensate(ctx); err != nil {
+			log.Printf("⚠️ %s: failed to undo %s, clean it up by hand: %v", s.name, step.name, err)
+			continue
+		}
+		log.Printf("%s: undid %s", s.name, step.name)
+	}
+	s.steps = nil
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/models"
+)
+
+func TestSagaCompensate(t *testing.T) {
+	var undone []string
+	undo := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			undone = append(undone, name)
+			return err
+		}
+	}
+
+	steps := newSaga("create report test")
+	steps.done("spreadsheet", undo("spreadsheet", nil))
+	steps.done("script project", undo("script project", errors.New("quota exceeded")))
+	steps.done("trigger", undo("trigger", nil))
+	steps.compensate(context.Background())
+
+	// Newest first, and a failure does not stop the rest
+	if want := []string{"trigger", "script project", "spreadsheet"}; !reflect.DeepEqual(undone, want) {
+		t.Errorf("undid %v, want %v", undone, want)
+	}
+
+	undone = nil
+	steps.compensate(context.Background())
+	if undone != nil {
+		t.Errorf("second compensate undid %v again", undone)
+	}
+}
+
+func TestInsertReportRollsBack(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	script := &boundScript{Id: "script-1", Version: "1", Hash: "hash"}
+	permissions := map[string][]string{analystRoleID: {"c1"}}
+	req := models.ReportInput{ReportName: "Sales", SqlScript: "select 1"}
+
+	count := func(table string) int {
+		var n int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM penguin.` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	failed := errors.New("lineage failed")
+	err := s.insertReport(ctx, "sheet-1", alice.WorkspaceID, aliceID, req, "penguin", nil, nil, nil, script, permissions, func(tx *sql.Tx) error {
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("insertReport() = %v, want the error of the last step", err)
+	}
+	if n, p := count("spreadsheet"), count("spreadsheetpermissions"); n != 0 || p != 0 {
+		t.Fatalf("failed insert left %d spreadsheet and %d permission rows", n, p)
+	}
+
+	err = s.insertReport(ctx, "sheet-1", alice.WorkspaceID, aliceID, req, "penguin", nil, nil, nil, script, permissions, func(tx *sql.Tx) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, p := count("spreadsheet"), count("spreadsheetpermissions"); n != 1 || p != 1 {
+		t.Errorf("insert stored %d spreadsheet and %d permission rows, want 1 each", n, p)
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nishantd01/penguin-core/secrets"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+var (
+	ErrSecretsDisabled   = errors.New("secret storage is disabled; set PENGUIN_SECRET_KEY")
+	ErrInvalidOAuthState = errors.New("unknown or expired authorization state; start again from GET /api/v1/admin/google/authorize")
+)
+
+// oauthStateTTL is how long a consent URL can be redeemed.
+const oauthStateTTL = 15 * time.Minute
+
+// SecretService manages the encrypted secrets: Google credentials and
+// data source DSNs.
+type SecretService struct {
+	db    *sql.DB
+	store *secrets.Store
+}
+
+// NewSecretService takes a nil store when no key is configured; every
+// call then fails with ErrSecretsDisabled.
+func NewSecretService(db *sql.DB, store *secrets.Store) *SecretService {
+	return &SecretService{db: db, store: store}
+}
+
+// PutSecretRequest carries a secret's new value. A JSON string is stored
+// as its text; anything else, such as a Google client secret object, as
+// the raw JSON.
+type PutSecretRequest struct {
+	Value json.RawMessage `json:"value" binding:"required"`
+}
+
+func (s *SecretService) ListSecrets() ([]secrets.Secret, error) {
+	if s.store == nil {
+		return nil, ErrSecretsDisabled
+	}
+	return s.store.List(context.Background())
+}
+
+// PutSecret creates or rotates a secret. Data sources using it reopen
+// their pools on next use.
+func (s *SecretService) PutSecret(name string, req PutSecretRequest) (*secrets.Secret, error) {
+	if s.store == nil {
+		return nil, ErrSecretsDisabled
+	}
+	value := []byte(req.Value)
+	var text string
+	if json.Unmarshal(req.Value, &text) == nil {
+		value = []byte(text)
+	}
+	return s.store.Put(context.Background(), name, value)
+}
+
+func (s *SecretService) DeleteSecret(name string) error {
+	if s.store == nil {
+		return ErrSecretsDisabled
+	}
+	return s.store.Delete(context.Background(), name)
+}
+
+// RewrapSecrets re-seals every data key with the current key encryption
+// key, after which retired keys can be removed from the environment.
+func (s *SecretService) RewrapSecrets() (int, error) {
+	if s.store == nil {
+		return 0, ErrSecretsDisabled
+	}
+	return s.store.Rewrap(context.Background())
+}
+
+// GoogleAuthURL returns the consent page for authorising a Google
+// account with the named credentials, empty for the deployment's own. Its
+// state is random and recorded for userId, so only that admin can redeem
+// the code, once and within oauthStateTTL, on any replica.
+func (s *SecretService) GoogleAuthURL(userId, credentials string) (string, error) {
+	if s.store == nil {
+		return "", ErrSecretsDisabled
+	}
+	ctx := utils.WithGoogleCredentials(context.Background(), credentials)
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(b)
+	url, err := utils.GoogleAuthURL(ctx, state)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if _, err := s.db.Exec(`DELETE FROM penguin.oauth_state WHERE expires_at < $1`, now); err != nil {
+		return "", err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO penguin.oauth_state (state, credentials, user_id, expires_at) VALUES ($1, $2, $3, $4)
+	`, state, credentialsName(credentials), userId, now.Add(oauthStateTTL))
+	if err != nil {
+		return "", err
+	}
+	return url, nil
+}
+
+// AuthorizeGoogle checks the state the consent page returned and stores
+// the token obtained for code under the named credentials, replacing the
+// current one.
+func (s *SecretService) AuthorizeGoogle(userId, credentials, state, code string) error {
+	if s.store == nil {
+		return ErrSecretsDisabled
+	}
+	res, err := s.db.Exec(`
+		DELETE FROM penguin.oauth_state
+		WHERE state = $1 AND credentials = $2 AND user_id = $3 AND expires_at >= $4
+	`, state, credentialsName(credentials), userId, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidOAuthState
+	}
+	return utils.AuthorizeGoogle(utils.WithGoogleCredentials(context.Background(), credentials), code)
+}
+
+func credentialsName(credentials string) string {
+	if credentials == "" {
+		return utils.DefaultGoogleCredentials
+	}
+	return credentials
+}
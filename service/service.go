@@ -4,24 +4,45 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nishantd01/penguin-core/config"
+	"github.com/nishantd01/penguin-core/datasource"
 	"github.com/nishantd01/penguin-core/db"
+	"github.com/nishantd01/penguin-core/export"
 	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/querycache"
 	"github.com/nishantd01/penguin-core/utils"
+	"google.golang.org/api/idtoken"
 )
 
 type UserService struct {
-	db *sql.DB
+	db      *sql.DB
+	sources *datasource.Registry
+	cfg     *config.Config
+
+	redeploys redeployJobs
+	// cache holds query results; nil when disabled.
+	cache *querycache.Cache
+	// validateIDToken checks the Google ID tokens bound scripts send.
+	validateIDToken func(ctx context.Context, idToken, audience string) (*idtoken.Payload, error)
 }
 
-func NewUserService(db *sql.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *sql.DB, sources *datasource.Registry, cfg *config.Config) *UserService {
+	return &UserService{
+		db:        db,
+		sources:   sources,
+		cfg:       cfg,
+		redeploys: redeployJobs{jobs: make(map[string]*RedeployJob)},
+		cache:     newQueryCache(cfg),
+
+		validateIDToken: idtoken.Validate,
+	}
 }
 
 func (s *UserService) GetUser(id int) (*db.User, error) {
@@ -29,8 +50,8 @@ func (s *UserService) GetUser(id int) (*db.User, error) {
 	return db.GetUserByID(id)
 }
 
-func (s *UserService) GetDbNames() ([]string, error) {
-	rows, err := s.db.Query("SELECT database_name FROM penguin.snowflake_databases")
+func (s *UserService) GetDbNames(workspaceId string) ([]string, error) {
+	rows, err := s.db.Query("SELECT database_name FROM penguin.snowflake_databases WHERE workspace_id = $1", workspaceId)
 	if err != nil {
 		return nil, err
 	}
@@ -47,13 +68,17 @@ func (s *UserService) GetDbNames() ([]string, error) {
 	return dbNames, rows.Err()
 }
 
+func (s *UserService) GetSources(workspaceId string) ([]datasource.Config, error) {
+	return s.sources.List(workspaceId)
+}
+
 type RoleMeta struct {
 	Id   string `json:"id"`
 	Name string `json:"name"`
 }
 
-func (s *UserService) GetRoles() ([]RoleMeta, error) {
-	rows, err := s.db.Query("SELECT id,name FROM penguin.role")
+func (s *UserService) GetRoles(workspaceId string) ([]RoleMeta, error) {
+	rows, err := s.db.Query("SELECT id,name FROM penguin.role WHERE workspace_id = $1", workspaceId)
 	if err != nil {
 		return nil, err
 	}
@@ -70,15 +95,59 @@ func (s *UserService) GetRoles() ([]RoleMeta, error) {
 	return roleNames, rows.Err()
 }
 
+// AccessCheckRequest asks whether the editor with Email may edit a
+// column, as the report's edit trigger reports it.
 type AccessCheckRequest struct {
 	Email      string `json:"email"`
 	SheetId    string `json:"sheet_id"`
 	ColumnName string `json:"column_name"`
+	// ColumnId is the ID tagged on the edited column, "" when untagged.
+	// Scripts generated before column IDs do not send it at all.
+	ColumnId *string `json:"column_id"`
 }
 
-// take sheetId as well , match permission wiyh reportId, email & column names rather thamn report name
-func (s *UserService) CheckAccess(req AccessCheckRequest) (bool, error) {
+// CheckAccess tells whether the editor may write the column. The call is
+// authenticated like RecordEdit, by the trigger's identity token idToken.
+func (s *UserService) CheckAccess(idToken string, req AccessCheckRequest) (bool, error) {
+	email, err := s.scriptEditor(context.Background(), req.SheetId, idToken, req.Email)
+	if err != nil {
+		return false, err
+	}
+
+	sheetCols, err := s.loadSheetColumns(req.SheetId)
+	if err != nil {
+		log.Printf("Error loading columns of %s: %v", req.SheetId, err)
+		return false, err
+	}
+
+	columns, err := s.writableColumns(email, req.SheetId)
+	if err != nil {
+		return false, err
+	}
+
+	// Reports with column IDs grant by ID; header text can be edited or
+	// repeated. Older reports still grant by name, and so do the scripts
+	// generated before IDs until they are redeployed.
+	switch {
+	case sheetCols == nil:
+		return contains(columns, req.ColumnName), nil
+	case req.ColumnId == nil:
+		log.Printf("⚠️ Script of %s predates column IDs; checking %q by name until it is redeployed", req.SheetId, req.ColumnName)
+		for _, col := range sheetCols {
+			if col.Name == req.ColumnName && contains(columns, col.Id) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return *req.ColumnId != "" && contains(columns, *req.ColumnId), nil
+}
 
+// writableColumns lists the columns of a sheet the user with email may
+// edit, by ID, or by name for reports without column IDs. It fails with
+// sql.ErrNoRows when the user's role has no permissions on the sheet, or
+// the user is in another workspace.
+func (s *UserService) writableColumns(email, sheetId string) ([]string, error) {
 	query := `
         SELECT sp.columns_permissions
         FROM penguin.user u
@@ -87,130 +156,248 @@ func (s *UserService) CheckAccess(req AccessCheckRequest) (bool, error) {
         JOIN penguin.spreadsheet s ON s.id = sp.spreadsheet_id
         WHERE u.email = $1
         AND s.id = $2
+        AND s.workspace_id = u.workspace_id
     `
 
 	var columnsAllowed string
-	err := s.db.QueryRow(query, req.Email, req.SheetId).Scan(&columnsAllowed)
+	err := s.db.QueryRow(query, email, sheetId).Scan(&columnsAllowed)
 	if err != nil {
-		log.Printf("Error decoding columns_permissions:", err)
-		return false, err
+		log.Printf("Error decoding columns_permissions: %v", err)
+		return nil, err
 	}
 
 	var columns []string
 	err = json.Unmarshal([]byte(columnsAllowed), &columns)
 	if err != nil {
-		log.Printf("Error decoding each column:", err)
-		return false, err
+		log.Printf("Error decoding each column: %v", err)
+		return nil, err
 	}
-
-	return contains(columns, req.ColumnName), nil
-
-	// return false, nil
+	return columns, nil
 }
 
-func (s *UserService) CreateReport(req models.ReportInput) (int, string, string) {
+func (s *UserService) CreateReport(principal *models.Principal, req models.ReportInput) (int, string, string, querycache.Status) {
 	const scriptTitle = "BoundScriptForKshitiz"
 
-	// Step 1: Create spreadsheet
-	sheetId, err := utils.UploadSheet(req.ReportName, scriptTitle)
+	// Resolve the data source before touching Drive so a bad name fails fast
+	source, err := s.source(principal, req.DBName)
 	if err != nil {
-		log.Printf("Error creating spreadsheet: %v", err)
-		return http.StatusInternalServerError, "Could not create spreadsheet, please try again", ""
+		log.Printf("Error resolving data source %q: %v", req.DBName, err)
+		if errors.Is(err, datasource.ErrUnknownSource) {
+			return http.StatusBadRequest, err.Error(), "", ""
+		}
+		return http.StatusInternalServerError, "Could not connect to data source", "", ""
 	}
 
-	// err = utils.AddSheetToSpreadsheet(sheetId, req.ReportName)
-	// if err != nil {
+	// Bind the report parameters as real query arguments
+	query, args, paramValues, err := bindQuery(source, req.SqlScript, req.Parameters, req.ParameterValues)
+	if err != nil {
+		return http.StatusBadRequest, err.Error(), "", ""
+	}
 
-	// 	log.Printf("Error AddSheetToSpreadsheet spreadsheet: %v", err)
-	// 	return http.StatusInternalServerError, "Could not create AddSheetToSpreadsheet, please try again"
-	// }
+	ttl, err := s.reportTTL(req.CacheTTL)
+	if err != nil {
+		return http.StatusBadRequest, err.Error(), "", ""
+	}
 
-	// Step 5: Prepare sheet data
-	sheetData, err := prepareData(s.db, req.SqlScript, req.Columns)
+	// Read the data before creating the spreadsheet, so a forbidden,
+	// expensive or failing query leaves nothing behind in Drive
+	result, cache, err := s.reportData(principal, source, query, args, req.Columns, ttl, req.NoCache)
+	if code, msg := reportDataError(err); code != 0 {
+		return code, msg, "", ""
+	}
+	sheetData, sheetColumns := result.Data, result.Columns
+	if req.KeyColumn != "" && columnIndex(sheetColumns, req.KeyColumn) < 0 {
+		return http.StatusBadRequest, fmt.Sprintf("key column %q is not in the query result", req.KeyColumn), "", ""
+	}
+
+	// Marshal everything the database needs up front, so nothing can fail
+	// between creating the sheet and recording it except the writes
+	sheetCols := assignColumnIds(nil, sheetColumns)
+	schemaJSON, err := json.Marshal(sheetCols)
 	if err != nil {
-		log.Printf("Error preparing sheet data: %v", err)
-		return http.StatusInternalServerError, "Failed to prepare data for sheet", ""
+		log.Printf("Failed to marshal schema JSON: %v", err)
+		return http.StatusInternalServerError, "Internal server error", "", ""
+	}
+
+	definitionJSON, err := json.Marshal(reportDefinition{Columns: req.Columns, Parameters: req.Parameters, KeyColumn: req.KeyColumn, CacheTTL: req.CacheTTL})
+	if err != nil {
+		log.Printf("Failed to marshal report definition: %v", err)
+		return http.StatusInternalServerError, "Internal server error", "", ""
+	}
+
+	paramValuesJSON, err := json.Marshal(paramValues)
+	if err != nil {
+		log.Printf("Failed to marshal parameter values: %v", err)
+		return http.StatusInternalServerError, "Internal server error", "", ""
+	}
+
+	// Role id -> IDs of the columns the role may edit
+	permissions := columnPermissions(req.Columns, sheetCols)
+	if err := s.checkRoles(principal.WorkspaceID, permissions); err != nil {
+		if errors.Is(err, ErrForeignRole) {
+			return http.StatusBadRequest, err.Error(), "", ""
+		}
+		log.Printf("Error checking report roles: %v", err)
+		return http.StatusInternalServerError, "Internal server error", "", ""
+	}
+
+	// The workspace decides the Drive folder and Google credentials
+	workspace, err := s.loadWorkspace(principal.WorkspaceID)
+	if err != nil {
+		log.Printf("Error loading workspace %s: %v", principal.WorkspaceID, err)
+		return http.StatusInternalServerError, "Internal server error", "", ""
+	}
+	ctx := googleContext(context.Background(), workspace.GoogleCredentials)
+
+	// From here on every external step registers how to undo it, and any
+	// failure rolls the completed ones back so no orphans are left behind
+	steps := newSaga("create report " + req.ReportName)
+
+	// Step 1: Create spreadsheet
+	sheetId, err := utils.CreateSpreadsheetFile(ctx, workspace.DriveFolderId, req.ReportName)
+	if err != nil {
+		log.Printf("Error creating spreadsheet: %v", err)
+		return http.StatusInternalServerError, "Could not create spreadsheet, please try again", "", ""
+	}
+	steps.done("spreadsheet "+sheetId, func(ctx context.Context) error {
+		return utils.TrashFile(ctx, sheetId)
+	})
+
+	// Step 2: Bind the edit-restricting Apps Script
+	settings := utils.ScriptSettings{BaseURL: s.cfg.PublicURL, KeyColumnId: keyColumnId(sheetCols, req.KeyColumn)}
+	scriptId, err := utils.AttachScript(ctx, sheetId, scriptTitle, settings)
+	if scriptId != "" {
+		steps.done("script project "+scriptId, func(ctx context.Context) error {
+			return utils.DeleteScript(ctx, scriptId)
+		})
+	}
+	if err != nil {
+		log.Printf("Error attaching Apps Script: %v", err)
+		steps.compensate(ctx)
+		return http.StatusInternalServerError, "Could not create spreadsheet, please try again", "", ""
 	}
 
 	fmt.Printf("shetid %v\n", sheetId)
 
-	// Step 6: Write data to the sheet
-	err = utils.WriteDataToSheet(sheetId, "Sheet1", "A1", sheetData)
+	// Step 3: Write data to the sheet
+	err = utils.WriteDataToSheet(ctx, sheetId, "Sheet1", "A1", sheetData)
 	if err != nil {
 		log.Printf("Error writing data to sheet: %v", err)
-		return http.StatusInternalServerError, "Failed to write data to sheet", ""
+		steps.compensate(ctx)
+		return http.StatusInternalServerError, "Failed to write data to sheet", "", ""
+	}
+
+	// Permissions key on these tags; without them every edit is denied
+	if err := utils.TagColumns(ctx, sheetId, "Sheet1", sheetColumnIds(sheetCols)); err != nil {
+		log.Printf("Error tagging sheet columns: %v", err)
+		steps.compensate(ctx)
+		return http.StatusInternalServerError, "Failed to tag sheet columns", "", ""
 	}
 
-	// 5. Now protect the header row (first row)
-	err = utils.ProtectHeaderRow(sheetId, "Sheet1", sheetData)
+	// Protecting the header is best effort, the sheet is usable without it
+	err = utils.ProtectHeaderRow(ctx, sheetId, "Sheet1", sheetData)
 	if err != nil {
 		log.Printf("⚠️ Failed to protect header row: %v", err)
 	} else {
 		log.Println("✅ Header row protected")
 	}
 
-	// Convert columns to a map[string]string for schema
-	schema := make(map[string]string)
-	for _, col := range req.Columns {
-		schema[col.Name] = col.DataType
+	// Health checks compare the live script against this
+	script, err := newBoundScript(scriptId, settings)
+	if err != nil {
+		log.Printf("Error hashing Apps Script: %v", err)
+		steps.compensate(ctx)
+		return http.StatusInternalServerError, "Internal server error", "", ""
+	}
+
+	// Until the trigger is installed the sheet does not enforce anything,
+	// but the report is usable and the install can be retried
+	trigger := installEditTrigger(ctx, scriptId, "")
+	if trigger.err != nil {
+		log.Printf("⚠️ Failed to install edit trigger of %s: %v", sheetId, trigger.err)
 	}
 
-	schemaJSON, err := json.Marshal(schema)
+	// Step 4: Record the report and its permissions in one transaction.
+	// Everything the database keeps about the report commits together,
+	// so a failure leaves no partial report behind
+	snapshot := &ReportVersion{Kind: SnapshotCreate, Columns: sheetColumns, EditableColumns: editableColumns(req.Columns), ParameterValues: paramValues}
+	err = s.insertReport(ctx, sheetId, workspace.Id, principal.UserID, req, source.Name, schemaJSON, definitionJSON, paramValuesJSON, script, permissions, func(tx *sql.Tx) error {
+		if err := recordTrigger(ctx, tx, sheetId, trigger); err != nil {
+			return fmt.Errorf("record trigger: %w", err)
+		}
+		if err := recordLineage(ctx, tx, sheetId, source.DefaultSchema, req.SqlScript); err != nil {
+			return fmt.Errorf("record lineage: %w", err)
+		}
+		if err := writeLastKnown(ctx, tx, sheetId, req.Columns, sheetColumnIds(sheetCols), keyColumnId(sheetCols, req.KeyColumn), sheetData); err != nil {
+			return fmt.Errorf("store last known values: %w", err)
+		}
+		if err := insertSnapshot(ctx, tx, sheetId, principal, snapshot, sheetData); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("Failed to marshal schema JSON: %v", err)
+		log.Printf("Failed to record report %s: %v", sheetId, err)
+		steps.compensate(ctx)
+		return http.StatusInternalServerError, "Failed to save report", "", ""
 	}
 
-	createdAt := time.Now()
+	// Success log
+	log.Printf("✅ Report created successfully with spreadsheet ID: %s", sheetId)
+	return http.StatusOK, s.messageWithRows("Report created successfully", result), sheetURL(sheetId), cache
+}
 
-	_, err = s.db.Exec(`
-	INSERT INTO penguin.spreadsheet (id, report_name, created_at, schema)
-	VALUES ($1, $2, $3, $4)
-`, sheetId, req.ReportName, createdAt, schemaJSON)
+// insertReport writes the spreadsheet row and its per-role permissions,
+// then lets then add whatever else belongs to the new report. Either all
+// of them are stored or none is.
+func (s *UserService) insertReport(ctx context.Context, sheetId, workspaceId, createdBy string, req models.ReportInput, dbName string, schemaJSON, definitionJSON, paramValuesJSON []byte, script *boundScript, permissions map[string][]string, then func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO penguin.spreadsheet (id, workspace_id, report_name, created_at, created_by, schema, db_name, sql_script, definition, parameter_values, template_id,
+			script_id, script_version, script_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`, sheetId, workspaceId, req.ReportName, time.Now(), nullString(createdBy), schemaJSON, dbName, req.SqlScript, definitionJSON, paramValuesJSON, nullString(req.TemplateId),
+		script.Id, script.Version, script.Hash)
 	if err != nil {
-		log.Fatalf("Failed to insert spreadsheet: %v", err)
+		return fmt.Errorf("insert spreadsheet: %w", err)
 	}
 
-	// Step 2: Prepare permissions map
-	result := make(map[string][]string)
-	for _, col := range req.Columns {
-		for _, role := range col.WritableBy {
-			result[role] = append(result[role], col.Name)
-		}
+	if err := insertPermissions(ctx, tx, sheetId, permissions); err != nil {
+		return err
 	}
 
-	// Step 3: Prepare SQL insert statement
-	ctx := context.Background()
-	stmt, err := s.db.PrepareContext(ctx, `
+	if err := then(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertPermissions stores the columns each role may edit in a sheet.
+func insertPermissions(ctx context.Context, tx *sql.Tx, sheetId string, permissions map[string][]string) error {
+	stmt, err := tx.PrepareContext(ctx, `
 		INSERT INTO penguin.spreadsheetpermissions (id,spreadsheet_id, role_id, columns_permissions)
 		VALUES ($1, $2, $3,$4)
 	`)
 	if err != nil {
-		log.Printf("Error preparing insert statement: %v", err)
-		return http.StatusInternalServerError, "Internal server error", ""
+		return err
 	}
 	defer stmt.Close()
 
-	// Step 4: Insert permissions
-	for roleIDStr, columns := range result {
+	for roleIDStr, columns := range permissions {
 		columnsJSON, err := json.Marshal(columns)
 		if err != nil {
-			log.Printf("Error marshaling columns for role %s: %v", roleIDStr, err)
-			return http.StatusInternalServerError, "Internal server error", ""
+			return fmt.Errorf("marshal columns for role %s: %w", roleIDStr, err)
 		}
-
-		fmt.Printf("columnJson %v\n", columnsJSON)
-
-		uuidStr := uuid.New()
-		_, err = stmt.ExecContext(ctx, uuidStr, sheetId, roleIDStr, string(columnsJSON))
-		if err != nil {
-			log.Printf("Insert failed for role %s: %v", roleIDStr, err)
-			return http.StatusInternalServerError, "Internal server error", ""
+		if _, err := stmt.ExecContext(ctx, uuid.New(), sheetId, roleIDStr, string(columnsJSON)); err != nil {
+			return fmt.Errorf("insert permissions for role %s: %w", roleIDStr, err)
 		}
 	}
-
-	// Success log
-	log.Printf("✅ Report created successfully with spreadsheet ID: %s", sheetId)
-	return http.StatusOK, "Report created successfully", "https://docs.google.com/spreadsheets/d/" + sheetId
+	return nil
 }
 
 func contains(slice []string, str string) bool {
@@ -222,50 +409,77 @@ func contains(slice []string, str string) bool {
 	return false
 }
 
-func prepareData(db *sql.DB, script string, newCols []models.Column) ([][]interface{}, error) {
-
-	// sc
+func readSheetData(conn datasource.Conn, source *datasource.Source, script string, args []interface{}, newCols []models.Column, maxRows int) ([][]interface{}, []export.Column, bool, error) {
+	var data [][]interface{}
+	var columns []export.Column
+	truncated, err := scanReportRows(context.Background(), conn, source, script, args, newCols, maxRows,
+		func(cols []export.Column) error {
+			columns = cols
+			header := make([]interface{}, len(cols))
+			for i, col := range cols {
+				header[i] = col.Name
+			}
+			data = append(data, header)
+			return nil
+		},
+		func(row []interface{}) error {
+			data = append(data, row)
+			return nil
+		})
+	return data, columns, truncated, err
+}
 
+// scanReportRows runs a report query and hands its columns, then each
+// converted row, to the callbacks. The report's extra columns follow the
+// query's own, with empty cells. This is shared by the sheet and export
+// paths so both convert values and cap rows the same way. It reports
+// whether rows beyond maxRows were left out.
+func scanReportRows(ctx context.Context, conn datasource.Conn, source *datasource.Source, script string, args []interface{}, newCols []models.Column, maxRows int, header func([]export.Column) error, row func([]interface{}) error) (bool, error) {
 	fmt.Printf("query: %v\n", script)
 
-	// asihfahisofhiboashbfoahbsobfcaoiswbf
-	// select * from penguin.dev_logs limit 100 -> // select * from penguin.dev_logs limit 1
+	// One more row than kept tells whether there were more
+	if maxRows > 0 {
+		script = source.Driver.LimitQuery(script, maxRows+1)
+	}
 
-	rows, err := db.Query(script)
+	rows, err := conn.QueryContext(ctx, script, args...)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return false, fmt.Errorf("query failed: %w", err)
 	}
 	defer rows.Close()
 
-	// Get original DB column names
+	// Get original DB column names and their dialect-mapped types
 	dbCols, err := rows.Columns()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get columns: %w", err)
+		return false, fmt.Errorf("failed to get columns: %w", err)
+	}
+	colTypes, err := columnTypes(source, rows)
+	if err != nil {
+		return false, err
 	}
-
-	fmt.Printf("there %v\n", newCols)
 
 	// Create full column list with additional columns
-	allCols := append([]string{}, dbCols...)
-	var nCols []models.Column
+	columns := make([]export.Column, len(dbCols))
+	for i, name := range dbCols {
+		columns[i] = export.Column{Name: name, Type: colTypes[i]}
+	}
 	for _, col := range newCols {
-		if !contains(allCols, col.Name) {
-			allCols = append(allCols, col.Name)
-			nCols = append(nCols, col)
+		if !containsColumn(columns, col.Name) {
+			columns = append(columns, export.Column{Name: col.Name, Type: datasource.TypeString})
 		}
 	}
-
-	fmt.Printf("Here %v\n", allCols)
-
-	// Initialize the data slice with header row
-	header := make([]interface{}, len(allCols))
-	for i, colName := range allCols {
-		header[i] = colName
+	if err := header(columns); err != nil {
+		return false, err
 	}
-	data := [][]interface{}{header}
 
 	// Read DB rows
+	count := 0
 	for rows.Next() {
+		if maxRows > 0 && count == maxRows {
+			log.Printf("⚠️ Report rows capped at %d", maxRows)
+			return true, nil
+		}
+
 		rowData := make([]interface{}, len(dbCols))
 		rowPtrs := make([]interface{}, len(dbCols))
 		for i := range rowData {
@@ -273,93 +487,165 @@ func prepareData(db *sql.DB, script string, newCols []models.Column) ([][]interf
 		}
 
 		if err := rows.Scan(rowPtrs...); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+			return false, fmt.Errorf("failed to scan row: %w", err)
 		}
 
 		// Build final row with DB data + empty cells for newCols
-		fullRow := make([]interface{}, len(allCols))
-		copy(fullRow, rowData)
-
-		// Fill extra columns with empty string or default
-		for i := range nCols {
-			fullRow[len(dbCols)+i] = "" // or newCols[i].DefaultValue if available
+		fullRow := make([]interface{}, len(columns))
+		for i, v := range rowData {
+			fullRow[i] = datasource.ConvertValue(v, colTypes[i])
+		}
+		for i := len(dbCols); i < len(columns); i++ {
+			fullRow[i] = ""
 		}
 
-		data = append(data, fullRow)
+		if err := row(fullRow); err != nil {
+			return false, err
+		}
+		count++
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
+		return false, fmt.Errorf("row iteration error: %w", err)
 	}
+	return false, nil
+}
 
-	return data, nil
+func containsColumn(columns []export.Column, name string) bool {
+	for _, c := range columns {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func columnTypes(source *datasource.Source, rows *sql.Rows) ([]datasource.ColumnType, error) {
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get column types: %w", err)
+	}
+	types := make([]datasource.ColumnType, len(cts))
+	for i, ct := range cts {
+		types[i] = source.Driver.ColumnType(ct.DatabaseTypeName())
+	}
+	return types, nil
 }
 
 type SQLValidationRequest struct {
-	Query  string `json:"query"`
-	DBName string `json:"db_name"`
+	Query           string                 `json:"query"`
+	DBName          string                 `json:"db_name"`
+	Parameters      []models.Parameter     `json:"parameters"`
+	ParameterValues map[string]interface{} `json:"parameter_values"`
+	// ExactCount opts in to a full COUNT(*), bounded by a timeout.
+	ExactCount bool `json:"exact_count"`
+	// NoCache validates the query even when a cached result exists.
+	NoCache bool `json:"no_cache"`
 }
 
 type ColumnInfo struct {
-	ColName  string `json:"col_name"`
-	DataType string `json:"data_type"`
+	ColName  string                `json:"col_name"`
+	DataType string                `json:"data_type"`
+	Type     datasource.ColumnType `json:"type"`
 }
 
 type SQLValidationResponse struct {
-	Columns []ColumnInfo `json:"columns"`
-	Count   int          `json:"count"`
+	Columns  []ColumnInfo         `json:"columns"`
+	Estimate *datasource.Estimate `json:"estimate"`
+	// Count is only set when an exact count was requested and finished
+	// in time; CountError says why it is missing otherwise.
+	Count      *int64            `json:"count,omitempty"`
+	CountError string            `json:"count_error,omitempty"`
+	Cache      querycache.Status `json:"cache,omitempty"`
 }
 
-func (s *UserService) ValidateSQLQuery(req SQLValidationRequest) (*SQLValidationResponse, error) {
-	// First switch to the requested database
-	_, err := s.db.Exec(fmt.Sprintf("SET search_path TO %s", req.DBName))
+func (s *UserService) ValidateSQLQuery(principal *models.Principal, req SQLValidationRequest) (*SQLValidationResponse, error) {
+	// The name is only ever used as a registry key, never spliced into SQL
+	source, err := s.source(principal, req.DBName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to switch database: %v", err)
+		return nil, err
 	}
 
-	// Clean the query by trimming spaces and removing trailing semicolons
-	query := strings.TrimSpace(req.Query)
-	query = strings.TrimRight(query, ";")
-
-	// Try to prepare the statement to validate SQL syntax
-	stmt, err := s.db.Prepare(query)
+	// Reduce the query to a single statement and bind its parameters
+	query, args, _, err := bindQuery(source, req.Query, req.Parameters, req.ParameterValues)
 	if err != nil {
-		return nil, fmt.Errorf("invalid SQL query: %v", err)
+		return nil, err
 	}
-	defer stmt.Close()
 
-	// Execute the query with LIMIT 0 to get column information without fetching data
-	rows, err := s.db.Query(query + " LIMIT 0")
-	if err != nil {
-		return nil, fmt.Errorf("error executing query: %v", err)
+	if err := s.authorizeQuery(principal, source, query, args); err != nil {
+		return nil, err
 	}
-	defer rows.Close()
 
-	// Get column types
-	columnTypes, err := rows.ColumnTypes()
-	if err != nil {
-		return nil, fmt.Errorf("error getting column types: %v", err)
+	key := cacheKey("validate", source, query, args, req.ExactCount)
+	var cached SQLValidationResponse
+	status := s.cached(key, req.NoCache, &cached)
+	if status == querycache.Hit {
+		// gob does not tell empty slices from nil ones
+		if cached.Columns == nil {
+			cached.Columns = []ColumnInfo{}
+		}
+		cached.Cache = status
+		return &cached, nil
 	}
 
-	// Build column info
-	columns := make([]ColumnInfo, len(columnTypes))
-	for i, col := range columnTypes {
-		columns[i] = ColumnInfo{
-			ColName:  col.Name(),
-			DataType: col.DatabaseTypeName(),
+	var columns []ColumnInfo
+	ctx := context.Background()
+	err = source.ReadTx(ctx, func(conn datasource.Conn) error {
+		// Let the dialect validate SQL syntax without running the query
+		if err := source.Driver.Validate(ctx, conn, query); err != nil {
+			return fmt.Errorf("invalid SQL query: %w", err)
 		}
+
+		// Run the query wrapped in a LIMIT 0 subquery to get column
+		// information without fetching data
+		rows, err := conn.QueryContext(ctx, source.Driver.LimitQuery(query, 0), args...)
+		if err != nil {
+			return fmt.Errorf("error executing query: %w", err)
+		}
+		defer rows.Close()
+
+		// Get column types
+		columnTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return fmt.Errorf("error getting column types: %w", err)
+		}
+
+		// Build column info
+		columns = make([]ColumnInfo, len(columnTypes))
+		for i, col := range columnTypes {
+			columns[i] = ColumnInfo{
+				ColName:  col.Name(),
+				DataType: col.DatabaseTypeName(),
+				Type:     source.Driver.ColumnType(col.DatabaseTypeName()),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Get total count
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM (%s) as sub", query)
-	var count int
-	err = s.db.QueryRow(countQuery).Scan(&count)
+	response := &SQLValidationResponse{Columns: columns}
+
+	// Estimate rows and cost from the plan instead of running the query
+	response.Estimate, err = s.estimateQuery(ctx, source, query, args)
 	if err != nil {
-		return nil, fmt.Errorf("error getting row count: %v", err)
+		return nil, err
+	}
+
+	if req.ExactCount {
+		count, err := s.exactCount(source, query, args)
+		if err != nil {
+			response.CountError = err.Error()
+		} else {
+			response.Count = &count
+		}
 	}
 
-	return &SQLValidationResponse{
-		Columns: columns,
-		Count:   count,
-	}, nil
+	// A count that timed out may well finish next time
+	if response.CountError == "" {
+		s.storeResult(key, response, s.cfg.QueryCacheTTL, source, query)
+	}
+	response.Cache = status
+	return response, nil
 }
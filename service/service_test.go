@@ -0,0 +1,158 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/nishantd01/penguin-core/config"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/internal/testdb"
+	"github.com/nishantd01/penguin-core/models"
+)
+
+// Two workspaces with a user each, and an admin in the first.
+const (
+	otherWorkspaceID = "00000000-0000-0000-0000-000000000002"
+
+	adminRoleID   = "3f1c52b4-91c5-4de8-b1e2-813ea8b6e4a4"
+	analystRoleID = "b5d7cf7f-b2de-4a6c-8d44-0e8d3d1c7b12"
+	otherRoleID   = "7a0e5c3d-2b1f-4e6a-9c8d-1f2e3d4c5b6a"
+
+	adminID = "6a1d2c3b-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	aliceID = "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+	bobID   = "9f8e7d6c-5b4a-4c3d-8e2f-1a0b9c8d7e6f"
+)
+
+const testSchema = `
+CREATE TABLE penguin.workspace (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    drive_folder_id TEXT,
+    google_credentials TEXT,
+    default_source TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE penguin.snowflake_databases (
+    database_name TEXT PRIMARY KEY,
+    workspace_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+    driver TEXT NOT NULL DEFAULT 'postgres',
+    dsn_ref TEXT,
+    default_schema TEXT,
+    db_role TEXT,
+    max_open_conns INT NOT NULL DEFAULT 10,
+    max_idle_conns INT NOT NULL DEFAULT 2,
+    conn_max_lifetime_seconds INT NOT NULL DEFAULT 300
+);
+CREATE TABLE penguin.role (
+    id TEXT PRIMARY KEY,
+    workspace_id TEXT NOT NULL,
+    name TEXT NOT NULL
+);
+CREATE TABLE penguin.user (
+    id TEXT PRIMARY KEY,
+    workspace_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    role_id TEXT NOT NULL
+);
+CREATE TABLE penguin.api_key (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+CREATE TABLE penguin.spreadsheet (
+    id TEXT PRIMARY KEY,
+    workspace_id TEXT NOT NULL,
+    report_name TEXT NOT NULL,
+    created_at TIMESTAMP,
+    created_by TEXT,
+    schema TEXT,
+    db_name TEXT,
+    sql_script TEXT,
+    definition TEXT,
+    parameter_values TEXT,
+    template_id TEXT,
+    script_id TEXT,
+    script_version TEXT,
+    script_hash TEXT,
+    trigger_owner TEXT
+);
+CREATE TABLE penguin.spreadsheetpermissions (
+    id TEXT PRIMARY KEY,
+    spreadsheet_id TEXT NOT NULL,
+    role_id TEXT,
+    columns_permissions TEXT
+);
+CREATE TABLE penguin.idempotency_key (
+    user_id TEXT NOT NULL,
+    endpoint TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status TEXT NOT NULL,
+    response_code INT,
+    response_body BLOB,
+    created_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
+    PRIMARY KEY (user_id, endpoint, key)
+);
+CREATE TABLE penguin.report_template (
+    id TEXT PRIMARY KEY,
+    workspace_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    db_name TEXT,
+    sql_script TEXT NOT NULL,
+    columns BLOB,
+    parameters BLOB,
+    parameter_values BLOB,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (workspace_id, name)
+);
+CREATE TABLE penguin.data_access_policy (
+    id TEXT PRIMARY KEY,
+    workspace_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001',
+    role_id TEXT NOT NULL,
+    db_name TEXT,
+    schema_name TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    columns BLOB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO penguin.workspace (id, name) VALUES
+('00000000-0000-0000-0000-000000000001', 'default'),
+('00000000-0000-0000-0000-000000000002', 'other');
+INSERT INTO penguin.role (id, workspace_id, name) VALUES
+('3f1c52b4-91c5-4de8-b1e2-813ea8b6e4a4', '00000000-0000-0000-0000-000000000001', 'ADMIN 1'),
+('b5d7cf7f-b2de-4a6c-8d44-0e8d3d1c7b12', '00000000-0000-0000-0000-000000000001', 'ADMIN 2'),
+('7a0e5c3d-2b1f-4e6a-9c8d-1f2e3d4c5b6a', '00000000-0000-0000-0000-000000000002', 'ADMIN 2');
+INSERT INTO penguin.user (id, workspace_id, name, email, role_id) VALUES
+('6a1d2c3b-4e5f-4a6b-8c7d-9e0f1a2b3c4d', '00000000-0000-0000-0000-000000000001', 'Admin', 'admin@example.com', '3f1c52b4-91c5-4de8-b1e2-813ea8b6e4a4'),
+('1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f', '00000000-0000-0000-0000-000000000001', 'Alice', 'alice@example.com', 'b5d7cf7f-b2de-4a6c-8d44-0e8d3d1c7b12'),
+('9f8e7d6c-5b4a-4c3d-8e2f-1a0b9c8d7e6f', '00000000-0000-0000-0000-000000000002', 'Bob', 'bob@example.org', '7a0e5c3d-2b1f-4e6a-9c8d-1f2e3d4c5b6a');
+`
+
+// newTestService returns a service on a fresh copy of testSchema. The
+// query cache is off so every call reaches the database.
+func newTestService(t *testing.T) *UserService {
+	t.Helper()
+	db := testdb.Open(t, testSchema)
+	cfg := &config.Config{AdminRoles: []string{"ADMIN 1"}, DefaultSource: "penguin", ScriptClientID: "script-client"}
+	sources := datasource.NewRegistry(db, nil, cfg.DefaultSource, "")
+	t.Cleanup(sources.Close)
+	s := NewUserService(db, sources, cfg)
+	t.Cleanup(s.Close)
+	return s
+}
+
+var (
+	admin = &models.Principal{UserID: adminID, RoleID: adminRoleID, RoleName: "ADMIN 1", WorkspaceID: models.DefaultWorkspaceID}
+	alice = &models.Principal{UserID: aliceID, RoleID: analystRoleID, RoleName: "ADMIN 2", WorkspaceID: models.DefaultWorkspaceID}
+	bob   = &models.Principal{UserID: bobID, RoleID: otherRoleID, RoleName: "ADMIN 2", WorkspaceID: otherWorkspaceID}
+)
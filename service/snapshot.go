@@ -0,0 +1,547 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/export"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+var (
+	ErrVersionNotFound = errors.New("report version not found")
+	ErrEmptySheet      = errors.New("sheet has no header row")
+)
+
+// Snapshot kinds: what produced a version of a report's data.
+const (
+	SnapshotCreate  = "create"
+	SnapshotRefresh = "refresh"
+	SnapshotSync    = "sync"
+)
+
+// ReportVersion is a point-in-time copy of the data in a report's sheet.
+// Rows holds the data rows, without the header, and is only filled in
+// when a single version is fetched.
+type ReportVersion struct {
+	Version         int                    `json:"version"`
+	Kind            string                 `json:"kind"`
+	CreatedAt       time.Time              `json:"createdAt"`
+	CreatedBy       string                 `json:"createdBy,omitempty"`
+	RowCount        int                    `json:"rowCount"`
+	Columns         []export.Column        `json:"columns"`
+	EditableColumns []string               `json:"editableColumns"`
+	ParameterValues map[string]interface{} `json:"parameterValues,omitempty"`
+	Rows            [][]interface{}        `json:"rows,omitempty"`
+}
+
+// editableColumns lists the columns some role may edit in the sheet.
+func editableColumns(columns []models.Column) []string {
+	editable := []string{}
+	for _, col := range columns {
+		if len(col.WritableBy) > 0 {
+			editable = append(editable, col.Name)
+		}
+	}
+	return editable
+}
+
+// saveSnapshot stores data, header row first, as the next version of the
+// report and sets v.Version. Versions beyond SnapshotRetention are then
+// dropped, oldest first.
+func (s *UserService) saveSnapshot(ctx context.Context, sheetId string, principal *models.Principal, v *ReportVersion, data [][]interface{}) error {
+	// Two snapshots of one report racing for the same version number is
+	// rare enough that retrying is simpler than locking
+	for attempt := 0; ; attempt++ {
+		err := insertSnapshot(ctx, s.db, sheetId, principal, v, data)
+		if err == nil {
+			break
+		}
+		if !isUniqueViolation(err) || attempt == 2 {
+			return err
+		}
+	}
+	if err := s.pruneSnapshots(ctx, sheetId); err != nil {
+		log.Printf("⚠️ Could not prune snapshots of %s: %v", sheetId, err)
+	}
+	return nil
+}
+
+// pruneSnapshots keeps the latest SnapshotRetention versions of a report;
+// 0 keeps them all. Version numbers are not reused.
+func (s *UserService) pruneSnapshots(ctx context.Context, sheetId string) error {
+	if s.cfg.SnapshotRetention <= 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM penguin.report_snapshot
+		WHERE spreadsheet_id = $1
+		  AND version <= (SELECT MAX(version) FROM penguin.report_snapshot WHERE spreadsheet_id = $1) - $2
+	`, sheetId, s.cfg.SnapshotRetention)
+	return err
+}
+
+// insertSnapshot is one attempt of saveSnapshot through conn, which may
+// be a transaction.
+func insertSnapshot(ctx context.Context, conn datasource.Conn, sheetId string, principal *models.Principal, v *ReportVersion, data [][]interface{}) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	columnsJSON, err := json.Marshal(v.Columns)
+	if err != nil {
+		return err
+	}
+	editableJSON, err := json.Marshal(v.EditableColumns)
+	if err != nil {
+		return err
+	}
+	paramValuesJSON, err := json.Marshal(v.ParameterValues)
+	if err != nil {
+		return err
+	}
+
+	var createdBy interface{}
+	if principal != nil {
+		v.CreatedBy = principal.UserID
+		createdBy = principal.UserID
+	}
+	v.CreatedAt = time.Now()
+	v.RowCount = len(data) - 1
+	if v.RowCount < 0 {
+		v.RowCount = 0
+	}
+
+	return conn.QueryRowContext(ctx, `
+		INSERT INTO penguin.report_snapshot (id, spreadsheet_id, version, kind, created_at, created_by, row_count, columns, editable_columns, parameter_values, data)
+		SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5, $6, $7, $8, $9, $10
+		FROM penguin.report_snapshot WHERE spreadsheet_id = $2
+		RETURNING version
+	`, uuid.New(), sheetId, v.Kind, v.CreatedAt, createdBy, v.RowCount, columnsJSON, editableJSON, paramValuesJSON, buf.Bytes()).Scan(&v.Version)
+}
+
+const versionColumns = `version, kind, created_at, COALESCE(created_by::text, ''), row_count, columns, editable_columns, parameter_values`
+
+func scanVersion(row rowScanner, extra ...interface{}) (*ReportVersion, error) {
+	var v ReportVersion
+	var columns, editable, paramValues []byte
+	dest := append([]interface{}{&v.Version, &v.Kind, &v.CreatedAt, &v.CreatedBy, &v.RowCount, &columns, &editable, &paramValues}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(columns, &v.Columns); err != nil {
+		return nil, err
+	}
+	if editable != nil {
+		if err := json.Unmarshal(editable, &v.EditableColumns); err != nil {
+			return nil, err
+		}
+	}
+	if paramValues != nil {
+		if err := json.Unmarshal(paramValues, &v.ParameterValues); err != nil {
+			return nil, err
+		}
+	}
+	return &v, nil
+}
+
+// ListVersions returns the snapshots of a report, newest first, without
+// their data. Like GetVersion, principal must be allowed to run the
+// report's query.
+func (s *UserService) ListVersions(principal *models.Principal, sheetId string) ([]ReportVersion, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := s.authorizeReport(principal, report); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+versionColumns+`
+		FROM penguin.report_snapshot
+		WHERE spreadsheet_id = $1
+		ORDER BY version DESC
+	`, sheetId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []ReportVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *v)
+	}
+	return versions, rows.Err()
+}
+
+// GetVersion returns one snapshot of a report with its rows, the latest
+// one when version is 0. Snapshots hold the same data as the query, so
+// principal must be allowed to run it.
+func (s *UserService) GetVersion(principal *models.Principal, sheetId string, version int) (*ReportVersion, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := s.authorizeReport(principal, report); err != nil {
+		return nil, err
+	}
+	return s.loadVersion(sheetId, version)
+}
+
+func (s *UserService) loadVersion(sheetId string, version int) (*ReportVersion, error) {
+	var data []byte
+	v, err := scanVersion(s.db.QueryRow(`
+		SELECT `+versionColumns+`, data
+		FROM penguin.report_snapshot
+		WHERE spreadsheet_id = $1 AND ($2 <= 0 OR version = $2)
+		ORDER BY version DESC
+		LIMIT 1
+	`, sheetId, version), &data)
+	if err == sql.ErrNoRows {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode version %d: %w", v.Version, err)
+	}
+	if len(rows) > 0 {
+		rows = rows[1:]
+	}
+	v.Rows = rows
+	return v, nil
+}
+
+// decodeSnapshot reads back the rows saveSnapshot stored. Numbers come
+// back as json.Number so integers keep their precision.
+func decodeSnapshot(data []byte) ([][]interface{}, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	dec := json.NewDecoder(zr)
+	dec.UseNumber()
+	var rows [][]interface{}
+	if err := dec.Decode(&rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// SyncReport snapshots what the sheet currently holds, including edits
+// made in Sheets since the last create or refresh. When the sheet still
+// matches the latest version, that version is returned and nothing new
+// is stored.
+func (s *UserService) SyncReport(principal *models.Principal, sheetId string) (*ReportVersion, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := s.authorizeReport(principal, report); err != nil {
+		return nil, err
+	}
+	return s.syncReport(principal, report)
+}
+
+// syncReport is SyncReport for a report the caller is known to be
+// allowed to read.
+func (s *UserService) syncReport(principal *models.Principal, report *storedReport) (*ReportVersion, error) {
+	sheetId := report.Id
+	ctx := googleContext(context.Background(), report.GoogleCredentials)
+	data, err := utils.ReadSheet(ctx, sheetId, "Sheet1")
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrEmptySheet
+	}
+
+	tagged, err := utils.ReadColumnIDs(ctx, sheetId)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(data[0]))
+	for i := range ids {
+		ids[i] = tagged[i]
+	}
+
+	// Sheets has no column types, so take them from the report's columns,
+	// or the last snapshot for columns added since, and call anything new
+	// a string
+	byId := make(map[string]datasource.ColumnType)
+	for _, col := range report.Columns {
+		byId[col.Id] = col.Type
+	}
+	known := make(map[string]datasource.ColumnType)
+	last, err := s.loadVersion(sheetId, 0)
+	if err == nil {
+		for _, col := range last.Columns {
+			known[col.Name] = col.Type
+		}
+	} else if !errors.Is(err, ErrVersionNotFound) {
+		return nil, err
+	}
+
+	columns := make([]export.Column, len(data[0]))
+	for i, name := range data[0] {
+		col := export.Column{Name: fmt.Sprint(name), Type: datasource.TypeString}
+		if t, ok := byId[ids[i]]; ok {
+			col.Type = t
+		} else if t, ok := known[col.Name]; ok {
+			col.Type = t
+		}
+		columns[i] = col
+	}
+
+	// The API trims trailing empty cells, so rows come back ragged
+	for i, row := range data {
+		for len(row) < len(columns) {
+			row = append(row, "")
+		}
+		data[i] = row
+	}
+
+	if last != nil && sameData(last, columns, data[1:]) {
+		return last, nil
+	}
+
+	v := &ReportVersion{
+		Kind:            SnapshotSync,
+		Columns:         columns,
+		EditableColumns: editableColumns(report.Definition.Columns),
+		ParameterValues: report.ParameterValues,
+	}
+	if err := s.saveSnapshot(context.Background(), sheetId, principal, v, data); err != nil {
+		return nil, err
+	}
+	// The sheet is the truth now, edits the script missed included
+	if err := s.storeLastKnown(context.Background(), sheetId, report.Definition.Columns, ids, keyColumnId(report.Columns, report.Definition.KeyColumn), data); err != nil {
+		log.Printf("Failed to store last known values of %s: %v", sheetId, err)
+	}
+	return v, nil
+}
+
+// CellChange is one cell that differs between two versions.
+type CellChange struct {
+	Column string      `json:"column"`
+	From   interface{} `json:"from"`
+	To     interface{} `json:"to"`
+}
+
+type RowChange struct {
+	Key   string       `json:"key"`
+	Cells []CellChange `json:"cells"`
+}
+
+// VersionDiff compares two versions of a report. Rows are matched by the
+// report's key column when both versions have it, and by position
+// otherwise; KeyColumn is empty in that case. Duplicate keys are told
+// apart by a "#n" suffix in the order they appear.
+type VersionDiff struct {
+	From           int                      `json:"from"`
+	To             int                      `json:"to"`
+	KeyColumn      string                   `json:"keyColumn,omitempty"`
+	ColumnsAdded   []string                 `json:"columnsAdded"`
+	ColumnsRemoved []string                 `json:"columnsRemoved"`
+	RowsAdded      []map[string]interface{} `json:"rowsAdded"`
+	RowsRemoved    []map[string]interface{} `json:"rowsRemoved"`
+	RowsChanged    []RowChange              `json:"rowsChanged"`
+	Unchanged      int                      `json:"unchanged"`
+}
+
+// DiffVersions compares version from of a report with version to, cell by
+// cell over the columns both have. Either version may be 0 for the latest.
+func (s *UserService) DiffVersions(principal *models.Principal, sheetId string, from, to int) (*VersionDiff, error) {
+	report, err := s.loadReport(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := s.authorizeReport(principal, report); err != nil {
+		return nil, err
+	}
+	older, err := s.loadVersion(sheetId, from)
+	if err != nil {
+		return nil, err
+	}
+	newer, err := s.loadVersion(sheetId, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &VersionDiff{
+		From:           older.Version,
+		To:             newer.Version,
+		ColumnsAdded:   []string{},
+		ColumnsRemoved: []string{},
+		RowsAdded:      []map[string]interface{}{},
+		RowsRemoved:    []map[string]interface{}{},
+		RowsChanged:    []RowChange{},
+	}
+
+	var shared []string
+	for _, col := range newer.Columns {
+		if columnIndex(older.Columns, col.Name) < 0 {
+			diff.ColumnsAdded = append(diff.ColumnsAdded, col.Name)
+		} else {
+			shared = append(shared, col.Name)
+		}
+	}
+	for _, col := range older.Columns {
+		if columnIndex(newer.Columns, col.Name) < 0 {
+			diff.ColumnsRemoved = append(diff.ColumnsRemoved, col.Name)
+		}
+	}
+
+	key := report.Definition.KeyColumn
+	if key != "" && columnIndex(older.Columns, key) >= 0 && columnIndex(newer.Columns, key) >= 0 {
+		diff.KeyColumn = key
+	}
+
+	oldKeys, oldRows := keyRows(older, diff.KeyColumn)
+	newKeys, newRows := keyRows(newer, diff.KeyColumn)
+
+	for _, k := range newKeys {
+		row := newRows[k]
+		old, ok := oldRows[k]
+		if !ok {
+			diff.RowsAdded = append(diff.RowsAdded, rowMap(newer.Columns, row))
+			continue
+		}
+		var cells []CellChange
+		for _, name := range shared {
+			a := old[columnIndex(older.Columns, name)]
+			b := row[columnIndex(newer.Columns, name)]
+			if cellText(a) != cellText(b) {
+				cells = append(cells, CellChange{Column: name, From: a, To: b})
+			}
+		}
+		if len(cells) == 0 {
+			diff.Unchanged++
+		} else {
+			diff.RowsChanged = append(diff.RowsChanged, RowChange{Key: k, Cells: cells})
+		}
+	}
+	for _, k := range oldKeys {
+		if _, ok := newRows[k]; !ok {
+			diff.RowsRemoved = append(diff.RowsRemoved, rowMap(older.Columns, oldRows[k]))
+		}
+	}
+	return diff, nil
+}
+
+// keyRows indexes the rows of v by the key column, or by their 1-based
+// position when key is empty, and returns the keys in row order.
+func keyRows(v *ReportVersion, key string) ([]string, map[string][]interface{}) {
+	idx := columnIndex(v.Columns, key)
+	keys := make([]string, 0, len(v.Rows))
+	rows := make(map[string][]interface{}, len(v.Rows))
+	seen := make(map[string]int)
+	for i, row := range v.Rows {
+		k := strconv.Itoa(i + 1)
+		if idx >= 0 && idx < len(row) {
+			k = cellText(row[idx])
+			if seen[k]++; seen[k] > 1 {
+				k = fmt.Sprintf("%s#%d", k, seen[k])
+			}
+		}
+		keys = append(keys, k)
+		rows[k] = row
+	}
+	return keys, rows
+}
+
+// sameData reports whether rows under columns hold what version v does,
+// comparing cells as DiffVersions does.
+func sameData(v *ReportVersion, columns []export.Column, rows [][]interface{}) bool {
+	if len(v.Columns) != len(columns) || len(v.Rows) != len(rows) {
+		return false
+	}
+	for i, col := range columns {
+		if v.Columns[i].Name != col.Name {
+			return false
+		}
+	}
+	for i, row := range rows {
+		if len(v.Rows[i]) != len(row) {
+			return false
+		}
+		for j, cell := range row {
+			if cellText(v.Rows[i][j]) != cellText(cell) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func rowMap(columns []export.Column, row []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		if i < len(row) {
+			m[col.Name] = row[i]
+		}
+	}
+	return m
+}
+
+// cellText normalizes a cell for comparison, so the same value read back
+// from the database, a snapshot or Sheets compares equal: empty and NULL
+// are the same, and numbers compare by value. Integers are formatted
+// exactly, so keys beyond float64 precision stay distinct.
+func cellText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case json.Number:
+		if !strings.ContainsAny(val.String(), ".eE") {
+			return val.String()
+		}
+		if f, err := val.Float64(); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return val.String()
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(val)
+	}
+	return fmt.Sprint(v)
+}
+
+func columnIndex(columns []export.Column, name string) int {
+	for i, col := range columns {
+		if col.Name == name {
+			return i
+		}
+	}
+	return -1
+}
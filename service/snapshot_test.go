@@ -0,0 +1,51 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/export"
+)
+
+func TestCellText(t *testing.T) {
+	tests := []struct {
+		a, b  interface{}
+		equal bool
+	}{
+		{nil, "", true},
+		{int64(5), json.Number("5"), true},
+		{5.0, json.Number("5.0"), true},
+		{int64(5), "5", true},
+		{1.5, json.Number("1.50"), true},
+		{int64(9007199254740993), json.Number("9007199254740993"), true},
+		{json.Number("9007199254740993"), json.Number("9007199254740992"), false},
+		{int64(9007199254740993), int64(9007199254740992), false},
+		{uint64(18446744073709551615), json.Number("18446744073709551615"), true},
+		{"a", "b", false},
+	}
+	for _, tt := range tests {
+		if got := cellText(tt.a) == cellText(tt.b); got != tt.equal {
+			t.Errorf("cellText(%v) = %q, cellText(%v) = %q, want equal %v", tt.a, cellText(tt.a), tt.b, cellText(tt.b), tt.equal)
+		}
+	}
+}
+
+func TestSameData(t *testing.T) {
+	v := &ReportVersion{
+		Columns: []export.Column{{Name: "id"}, {Name: "name"}},
+		Rows:    [][]interface{}{{json.Number("1"), "a"}, {json.Number("2"), nil}},
+	}
+	columns := []export.Column{{Name: "id"}, {Name: "name"}}
+	if !sameData(v, columns, [][]interface{}{{"1", "a"}, {"2", ""}}) {
+		t.Error("the sheet as read back differs from its snapshot")
+	}
+	if sameData(v, columns, [][]interface{}{{"1", "a"}, {"2", "b"}}) {
+		t.Error("an edited cell went unnoticed")
+	}
+	if sameData(v, columns, [][]interface{}{{"1", "a"}}) {
+		t.Error("a deleted row went unnoticed")
+	}
+	if sameData(v, []export.Column{{Name: "id"}, {Name: "title"}}, [][]interface{}{{"1", "a"}, {"2", ""}}) {
+		t.Error("a renamed column went unnoticed")
+	}
+}
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/sqlutil"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("report template not found")
+	ErrInvalidTemplate  = errors.New("invalid report template")
+	ErrTemplateExists   = errors.New("a report template with this name already exists")
+	ErrTemplateDenied   = errors.New("only the template's creator or an admin may change it")
+)
+
+// ReportTemplate is a saved report definition that reports can be created
+// from without re-sending the SQL and column permissions.
+type ReportTemplate struct {
+	Id              string                 `json:"id"`
+	Name            string                 `json:"name" binding:"required"`
+	Description     string                 `json:"description"`
+	DBName          string                 `json:"dbName"`
+	SqlScript       string                 `json:"sqlScript" binding:"required"`
+	Columns         []models.Column        `json:"columns"`
+	Parameters      []models.Parameter     `json:"parameters"`
+	ParameterValues map[string]interface{} `json:"parameterValues"` // defaults for new reports
+	CreatedBy       string                 `json:"createdBy"`
+	CreatedAt       time.Time              `json:"createdAt"`
+	UpdatedAt       time.Time              `json:"updatedAt"`
+}
+
+// InstantiateRequest overrides parts of a template for one report. Empty
+// fields keep the template's values; ParameterValues are merged over the
+// template defaults.
+type InstantiateRequest struct {
+	ReportName      string                 `json:"reportName"`
+	DBName          string                 `json:"dbName"`
+	Columns         []models.Column        `json:"columns"`
+	ParameterValues map[string]interface{} `json:"parameterValues"`
+	KeyColumn       string                 `json:"keyColumn"`
+}
+
+const templateColumns = `id, name, COALESCE(description, ''), COALESCE(db_name, ''), sql_script, columns, parameters,
+	parameter_values, COALESCE(created_by::text, ''), created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTemplate(row rowScanner) (*ReportTemplate, error) {
+	var t ReportTemplate
+	var columns, parameters, values []byte
+	err := row.Scan(&t.Id, &t.Name, &t.Description, &t.DBName, &t.SqlScript, &columns, &parameters,
+		&values, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		raw []byte
+		dst interface{}
+	}{{columns, &t.Columns}, {parameters, &t.Parameters}, {values, &t.ParameterValues}} {
+		if f.raw != nil {
+			if err := json.Unmarshal(f.raw, f.dst); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &t, nil
+}
+
+func (s *UserService) ListTemplates(workspaceId string) ([]ReportTemplate, error) {
+	rows, err := s.db.Query(`SELECT `+templateColumns+` FROM penguin.report_template WHERE workspace_id = $1 ORDER BY name`, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []ReportTemplate{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+	return templates, rows.Err()
+}
+
+func (s *UserService) GetTemplate(workspaceId, id string) (*ReportTemplate, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrTemplateNotFound
+	}
+	t, err := scanTemplate(s.db.QueryRow(`SELECT `+templateColumns+` FROM penguin.report_template WHERE id = $1 AND workspace_id = $2`, id, workspaceId))
+	if err == sql.ErrNoRows {
+		return nil, ErrTemplateNotFound
+	}
+	return t, err
+}
+
+func (s *UserService) CreateTemplate(principal *models.Principal, t ReportTemplate) (*ReportTemplate, error) {
+	if err := validateTemplate(&t); err != nil {
+		return nil, err
+	}
+	if err := s.checkTemplateSource(principal, t.DBName); err != nil {
+		return nil, err
+	}
+	columns, parameters, values, err := marshalTemplate(&t)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Id = uuid.New().String()
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = t.CreatedAt
+	t.CreatedBy = ""
+	var createdBy interface{}
+	if principal != nil {
+		t.CreatedBy = principal.UserID
+		createdBy = principal.UserID
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO penguin.report_template (id, workspace_id, name, description, db_name, sql_script, columns, parameters, parameter_values, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, t.Id, principalWorkspace(principal), t.Name, t.Description, nullString(t.DBName), t.SqlScript, columns, parameters, values, createdBy, t.CreatedAt, t.UpdatedAt)
+	if isUniqueViolation(err) {
+		return nil, ErrTemplateExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateTemplate replaces a template. Only its creator or an admin may.
+func (s *UserService) UpdateTemplate(principal *models.Principal, id string, t ReportTemplate) (*ReportTemplate, error) {
+	existing, err := s.ownTemplate(principal, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTemplate(&t); err != nil {
+		return nil, err
+	}
+	if err := s.checkTemplateSource(principal, t.DBName); err != nil {
+		return nil, err
+	}
+	columns, parameters, values, err := marshalTemplate(&t)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Id = existing.Id
+	t.CreatedBy = existing.CreatedBy
+	t.CreatedAt = existing.CreatedAt
+	t.UpdatedAt = time.Now()
+	_, err = s.db.Exec(`
+		UPDATE penguin.report_template
+		SET name = $1, description = $2, db_name = $3, sql_script = $4, columns = $5, parameters = $6,
+			parameter_values = $7, updated_at = $8
+		WHERE id = $9
+	`, t.Name, t.Description, nullString(t.DBName), t.SqlScript, columns, parameters, values, t.UpdatedAt, t.Id)
+	if isUniqueViolation(err) {
+		return nil, ErrTemplateExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteTemplate removes a template. Reports created from it keep
+// working; they just no longer point at a template. Only its creator or
+// an admin may delete it.
+func (s *UserService) DeleteTemplate(principal *models.Principal, id string) error {
+	existing, err := s.ownTemplate(principal, id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`DELETE FROM penguin.report_template WHERE id = $1`, existing.Id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTemplateNotFound
+	}
+	return nil
+}
+
+// ownTemplate loads a template of the caller's workspace that the caller
+// may change: one they created, or any as an admin.
+func (s *UserService) ownTemplate(principal *models.Principal, id string) (*ReportTemplate, error) {
+	t, err := s.GetTemplate(principalWorkspace(principal), id)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil || (t.CreatedBy != principal.UserID && !principal.HasRole(s.cfg.AdminRoles)) {
+		return nil, ErrTemplateDenied
+	}
+	return t, nil
+}
+
+// checkTemplateSource rejects a db_name that is not a data source of the
+// caller's workspace; an empty one means the workspace default.
+func (s *UserService) checkTemplateSource(principal *models.Principal, name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, err := s.source(principal, name); err != nil {
+		if errors.Is(err, datasource.ErrUnknownSource) {
+			return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// InstantiateTemplate builds the ReportInput a template describes, with
+// the request's overrides applied, ready for CreateReport.
+func (s *UserService) InstantiateTemplate(workspaceId, id string, req InstantiateRequest) (*models.ReportInput, error) {
+	t, err := s.GetTemplate(workspaceId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &models.ReportInput{
+		ReportName:      t.Name,
+		SqlScript:       t.SqlScript,
+		DBName:          t.DBName,
+		Columns:         t.Columns,
+		Parameters:      t.Parameters,
+		ParameterValues: make(map[string]interface{}),
+		KeyColumn:       req.KeyColumn,
+		TemplateId:      t.Id,
+	}
+	if req.ReportName != "" {
+		input.ReportName = req.ReportName
+	}
+	if req.DBName != "" {
+		input.DBName = req.DBName
+	}
+	if req.Columns != nil {
+		input.Columns = req.Columns
+	}
+	for name, v := range t.ParameterValues {
+		input.ParameterValues[name] = v
+	}
+	for name, v := range req.ParameterValues {
+		input.ParameterValues[name] = v
+	}
+	return input, nil
+}
+
+// validateTemplate checks what can be checked without a data source: the
+// script is a single statement, every placeholder is declared, and the
+// declared types and default values are valid. Required parameters may be
+// left without a default, to be supplied when instantiating.
+func validateTemplate(t *ReportTemplate) error {
+	query, err := sqlutil.CleanQuery(t.SqlScript)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	decls := make(map[string]models.Parameter)
+	for _, p := range t.Parameters {
+		if p.Name == "" {
+			return fmt.Errorf("%w: parameter without a name", ErrInvalidTemplate)
+		}
+		if !sqlutil.ValidType(p.Type) {
+			return fmt.Errorf("%w: %s: unknown type %q", ErrInvalidTemplate, p.Name, p.Type)
+		}
+		if p.Default != nil {
+			if _, err := sqlutil.Coerce(p.Type, p.Default); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, p.Name, err)
+			}
+		}
+		decls[p.Name] = p
+	}
+	for _, name := range sqlutil.Placeholders(query) {
+		if _, ok := decls[name]; !ok {
+			return fmt.Errorf("%w: {{%s}} is used but not declared", ErrInvalidTemplate, name)
+		}
+	}
+	for name, v := range t.ParameterValues {
+		p, ok := decls[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is not declared", ErrInvalidTemplate, name)
+		}
+		if _, err := sqlutil.Coerce(p.Type, v); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, name, err)
+		}
+	}
+	return nil
+}
+
+func marshalTemplate(t *ReportTemplate) (columns, parameters, values []byte, err error) {
+	if columns, err = json.Marshal(t.Columns); err != nil {
+		return
+	}
+	if parameters, err = json.Marshal(t.Parameters); err != nil {
+		return
+	}
+	values, err = json.Marshal(t.ParameterValues)
+	return
+}
+
+// isUniqueViolation reports whether err is a Postgres unique_violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/models"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	region := models.Parameter{Name: "region", Type: "string"}
+	limit := models.Parameter{Name: "limit", Type: "integer", Default: float64(10)}
+
+	tests := []struct {
+		name    string
+		tmpl    ReportTemplate
+		wantErr bool
+	}{
+		{"declared", ReportTemplate{SqlScript: "select * from orders where region = {{region}} limit {{limit}}", Parameters: []models.Parameter{region, limit}}, false},
+		{"required without default", ReportTemplate{SqlScript: "select {{region}}", Parameters: []models.Parameter{{Name: "region", Required: true}}}, false},
+		{"default value", ReportTemplate{SqlScript: "select {{limit}}", Parameters: []models.Parameter{limit}, ParameterValues: map[string]interface{}{"limit": float64(5)}}, false},
+		{"undeclared placeholder", ReportTemplate{SqlScript: "select {{region}}"}, true},
+		{"unknown type", ReportTemplate{SqlScript: "select {{region}}", Parameters: []models.Parameter{{Name: "region", Type: "money"}}}, true},
+		{"bad default", ReportTemplate{SqlScript: "select {{limit}}", Parameters: []models.Parameter{{Name: "limit", Type: "integer", Default: "ten"}}}, true},
+		{"value of undeclared parameter", ReportTemplate{SqlScript: "select 1", ParameterValues: map[string]interface{}{"region": "eu"}}, true},
+		{"bad value", ReportTemplate{SqlScript: "select {{limit}}", Parameters: []models.Parameter{limit}, ParameterValues: map[string]interface{}{"limit": "many"}}, true},
+		{"two statements", ReportTemplate{SqlScript: "select 1; delete from orders"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTemplate(&tt.tmpl)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("validateTemplate() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidTemplate) {
+				t.Errorf("validateTemplate() = %v, want ErrInvalidTemplate", err)
+			}
+		})
+	}
+}
+
+func TestTemplateLifecycle(t *testing.T) {
+	s := newTestService(t)
+	created, err := s.CreateTemplate(admin, ReportTemplate{
+		Name:            "Orders by region",
+		SqlScript:       "select * from orders where region = {{region}} limit {{limit}}",
+		Columns:         []models.Column{{Name: "note", WritableBy: []string{analystRoleID}}},
+		Parameters:      []models.Parameter{{Name: "region", Type: "string"}, {Name: "limit", Type: "integer"}},
+		ParameterValues: map[string]interface{}{"region": "eu", "limit": float64(10)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetTemplate(alice.WorkspaceID, created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != created.Name || got.CreatedBy != adminID || !reflect.DeepEqual(got.Parameters, created.Parameters) {
+		t.Errorf("GetTemplate() = %+v, want %+v", got, created)
+	}
+
+	// Other workspaces cannot see, use or change it
+	if _, err := s.GetTemplate(bob.WorkspaceID, created.Id); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("GetTemplate from another workspace: %v, want ErrTemplateNotFound", err)
+	}
+	if _, err := s.InstantiateTemplate(bob.WorkspaceID, created.Id, InstantiateRequest{}); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("InstantiateTemplate from another workspace: %v, want ErrTemplateNotFound", err)
+	}
+	if err := s.DeleteTemplate(bob, created.Id); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("DeleteTemplate from another workspace: %v, want ErrTemplateNotFound", err)
+	}
+	if list, err := s.ListTemplates(bob.WorkspaceID); err != nil || len(list) != 0 {
+		t.Errorf("ListTemplates of another workspace = %v, %v", list, err)
+	}
+
+	// Only the creator or an admin may change it
+	if _, err := s.UpdateTemplate(alice, created.Id, *created); !errors.Is(err, ErrTemplateDenied) {
+		t.Errorf("UpdateTemplate by another user: %v, want ErrTemplateDenied", err)
+	}
+
+	input, err := s.InstantiateTemplate(alice.WorkspaceID, created.Id, InstantiateRequest{
+		ReportName:      "EU orders",
+		ParameterValues: map[string]interface{}{"limit": float64(50)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &models.ReportInput{
+		ReportName:      "EU orders",
+		SqlScript:       created.SqlScript,
+		Columns:         created.Columns,
+		Parameters:      created.Parameters,
+		ParameterValues: map[string]interface{}{"region": "eu", "limit": float64(50)},
+		TemplateId:      created.Id,
+	}
+	if !reflect.DeepEqual(input, want) {
+		t.Errorf("InstantiateTemplate() = %+v, want %+v", input, want)
+	}
+
+	if err := s.DeleteTemplate(admin, created.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetTemplate(alice.WorkspaceID, created.Id); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("GetTemplate after delete: %v, want ErrTemplateNotFound", err)
+	}
+}
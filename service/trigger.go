@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+var ErrNoBoundScript = errors.New("report has no recorded bound script")
+
+// Edit trigger states recorded per report. Reports created before the
+// trigger was installed automatically are unknown: someone may or may not
+// have enabled it from the sheet's former menu.
+const (
+	TriggerInstalled = "installed"
+	TriggerFailed    = "failed"
+	TriggerUnknown   = "unknown"
+)
+
+// TriggerStatus tells whether a report's sheet enforces column
+// permissions.
+type TriggerStatus struct {
+	SheetId      string     `json:"sheetId"`
+	ReportName   string     `json:"reportName"`
+	Status       string     `json:"status"`
+	Error        string     `json:"error,omitempty"`
+	DeploymentId string     `json:"deploymentId,omitempty"`
+	CheckedAt    *time.Time `json:"checkedAt,omitempty"`
+}
+
+// installTrigger installs the edit trigger of a report's bound script,
+// reusing the deployment of an earlier installation, and records the
+// outcome. A failure is recorded before it is returned.
+func (s *UserService) installTrigger(ctx context.Context, sheetId, scriptId string) error {
+	current, err := s.loadBoundScript(sheetId)
+	if err != nil {
+		return err
+	}
+	t := installEditTrigger(ctx, scriptId, current.DeploymentId)
+	dbErr := recordTrigger(ctx, s.db, sheetId, t)
+	if t.err != nil {
+		return t.err
+	}
+	return dbErr
+}
+
+// triggerOutcome is the result of installing an edit trigger. owner is
+// the Google account the trigger runs as.
+type triggerOutcome struct {
+	deploymentId string
+	owner        string
+	err          error
+}
+
+func installEditTrigger(ctx context.Context, scriptId, deploymentId string) triggerOutcome {
+	deploymentId, owner, err := utils.InstallEditTrigger(ctx, scriptId, deploymentId)
+	return triggerOutcome{deploymentId: deploymentId, owner: owner, err: err}
+}
+
+// recordTrigger stores the outcome of installing a report's edit trigger
+// through conn, which may be a transaction.
+func recordTrigger(ctx context.Context, conn datasource.Conn, sheetId string, t triggerOutcome) error {
+	status, msg := TriggerInstalled, ""
+	if t.err != nil {
+		status, msg = TriggerFailed, t.err.Error()
+	}
+	_, err := conn.ExecContext(ctx, `
+		UPDATE penguin.spreadsheet
+		SET deployment_id = COALESCE($1, deployment_id), trigger_owner = COALESCE($2, trigger_owner),
+			trigger_status = $3, trigger_error = $4, trigger_checked_at = $5
+		WHERE id = $6
+	`, nullString(t.deploymentId), nullString(t.owner), status, nullString(msg), time.Now(), sheetId)
+	return err
+}
+
+// InstallTrigger (re)installs the edit trigger of a report's bound script
+// and returns the recorded status, also when the installation failed.
+// Only the report's creator or an admin may install it.
+func (s *UserService) InstallTrigger(principal *models.Principal, sheetId string) (*TriggerStatus, error) {
+	if _, err := s.ownReport(principal, sheetId); err != nil {
+		return nil, err
+	}
+	script, err := s.loadBoundScript(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	if script.Id == "" {
+		return nil, ErrNoBoundScript
+	}
+
+	ctx, err := s.sheetContext(context.Background(), sheetId)
+	if err != nil {
+		return nil, err
+	}
+	installErr := s.installTrigger(ctx, sheetId, script.Id)
+	status, err := s.triggerStatus(sheetId)
+	if err != nil {
+		return nil, err
+	}
+	return status, installErr
+}
+
+// ListUnenforced returns the reports of a workspace whose edit trigger is
+// not known to be installed by Penguin. Edits to their sheets are not
+// checked, or all denied when a trigger Penguin did not install calls it.
+func (s *UserService) ListUnenforced(workspaceId string) ([]TriggerStatus, error) {
+	rows, err := s.db.Query(triggerStatusQuery+` WHERE workspace_id = $1 AND (trigger_status <> $2 OR trigger_owner IS NULL) ORDER BY created_at`, workspaceId, TriggerInstalled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []TriggerStatus{}
+	for rows.Next() {
+		t, err := scanTriggerStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *t)
+	}
+	return list, rows.Err()
+}
+
+const triggerStatusQuery = `
+	SELECT id, report_name, trigger_status, COALESCE(trigger_error, ''), COALESCE(deployment_id, ''), trigger_checked_at
+	FROM penguin.spreadsheet`
+
+func (s *UserService) triggerStatus(sheetId string) (*TriggerStatus, error) {
+	t, err := scanTriggerStatus(s.db.QueryRow(triggerStatusQuery+` WHERE id = $1`, sheetId))
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	return t, err
+}
+
+func scanTriggerStatus(row rowScanner) (*TriggerStatus, error) {
+	var t TriggerStatus
+	var checkedAt sql.NullTime
+	if err := row.Scan(&t.SheetId, &t.ReportName, &t.Status, &t.Error, &t.DeploymentId, &checkedAt); err != nil {
+		return nil, err
+	}
+	if checkedAt.Valid {
+		t.CheckedAt = &checkedAt.Time
+	}
+	return &t, nil
+}
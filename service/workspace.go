@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/nishantd01/penguin-core/datasource"
+	"github.com/nishantd01/penguin-core/models"
+	"github.com/nishantd01/penguin-core/utils"
+)
+
+var (
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	ErrWorkspaceExists   = errors.New("a workspace with this name already exists")
+	ErrUserExists        = errors.New("user already belongs to a workspace")
+	ErrForeignRole       = errors.New("role does not belong to the workspace")
+	ErrRoleExists        = errors.New("a role with this name already exists in the workspace")
+)
+
+// Workspace owns roles, users, data sources, templates, policies and
+// reports. Empty DriveFolderId and GoogleCredentials fall back to the
+// deployment's. An empty DefaultSource falls back to PENGUIN_DEFAULT_SOURCE
+// in the default workspace only, since that source belongs to it.
+type Workspace struct {
+	Id                string    `json:"id"`
+	Name              string    `json:"name"`
+	DriveFolderId     string    `json:"driveFolderId,omitempty"`
+	GoogleCredentials string    `json:"googleCredentials,omitempty"`
+	DefaultSource     string    `json:"defaultSource,omitempty"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// CreateWorkspaceRequest creates a workspace with its first admin, who can
+// then issue API keys to the workspace's other users.
+type CreateWorkspaceRequest struct {
+	Name              string `json:"name" binding:"required"`
+	DriveFolderId     string `json:"driveFolderId"`
+	GoogleCredentials string `json:"googleCredentials"`
+	DefaultSource     string `json:"defaultSource"`
+	Admin             struct {
+		Name  string `json:"name" binding:"required"`
+		Email string `json:"email" binding:"required"`
+	} `json:"admin" binding:"required"`
+}
+
+const workspaceColumns = `id, name, COALESCE(drive_folder_id, ''), COALESCE(google_credentials, ''), COALESCE(default_source, ''), created_at`
+
+func scanWorkspace(row rowScanner) (*Workspace, error) {
+	var w Workspace
+	err := row.Scan(&w.Id, &w.Name, &w.DriveFolderId, &w.GoogleCredentials, &w.DefaultSource, &w.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *UserService) ListWorkspaces() ([]Workspace, error) {
+	rows, err := s.db.Query(`SELECT ` + workspaceColumns + ` FROM penguin.workspace ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Workspace{}
+	for rows.Next() {
+		w, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *w)
+	}
+	return list, rows.Err()
+}
+
+func (s *UserService) loadWorkspace(id string) (*Workspace, error) {
+	return scanWorkspace(s.db.QueryRow(`SELECT `+workspaceColumns+` FROM penguin.workspace WHERE id = $1`, id))
+}
+
+// CreateWorkspace creates a workspace, an admin role named after the first
+// of PENGUIN_ADMIN_ROLES, its first admin user, and its log source
+// <name>_logs for log reports.
+func (s *UserService) CreateWorkspace(req CreateWorkspaceRequest) (*Workspace, error) {
+	if len(s.cfg.AdminRoles) == 0 {
+		return nil, errors.New("PENGUIN_ADMIN_ROLES is empty; a workspace needs an admin role")
+	}
+	// Named credentials are secrets; without the store they could only
+	// ever fail
+	if req.GoogleCredentials != "" && s.cfg.SecretKey == "" {
+		return nil, ErrSecretsDisabled
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	w, err := scanWorkspace(tx.QueryRow(`
+		INSERT INTO penguin.workspace (id, name, drive_folder_id, google_credentials, default_source, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+workspaceColumns,
+		uuid.New().String(), req.Name, nullString(req.DriveFolderId), nullString(req.GoogleCredentials), nullString(req.DefaultSource), time.Now()))
+	if isUniqueViolation(err) {
+		return nil, ErrWorkspaceExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logSource := req.Name + "_logs"
+	_, err = tx.Exec(`
+		INSERT INTO penguin.snowflake_databases (database_name, workspace_id, default_schema, db_role)
+		VALUES ($1, $2, 'penguin', $3)
+	`, logSource, w.Id, logReaderRole)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w: %s", datasource.ErrSourceExists, logSource)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	roleId := uuid.New().String()
+	if _, err := tx.Exec(`INSERT INTO penguin.role (id, workspace_id, name) VALUES ($1, $2, $3)`, roleId, w.Id, s.cfg.AdminRoles[0]); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`INSERT INTO penguin.user (id, workspace_id, name, email, role_id) VALUES ($1, $2, $3, $4, $5)`,
+		uuid.New().String(), w.Id, req.Admin.Name, req.Admin.Email, roleId)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w: %s", ErrUserExists, req.Admin.Email)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, tx.Commit()
+}
+
+type CreateRoleRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// CreateRole adds a role to a workspace. Role names are unique within it.
+func (s *UserService) CreateRole(workspaceId string, req CreateRoleRequest) (*RoleMeta, error) {
+	role := RoleMeta{Id: uuid.New().String(), Name: req.Name}
+	res, err := s.db.Exec(`
+		INSERT INTO penguin.role (id, workspace_id, name)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (SELECT 1 FROM penguin.role WHERE workspace_id = $2 AND name = $3)
+	`, role.Id, workspaceId, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrRoleExists, req.Name)
+	}
+	return &role, nil
+}
+
+// WorkspaceUser is a user as the workspace's admins see it.
+type WorkspaceUser struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	RoleId string `json:"roleId"`
+}
+
+type CreateUserRequest struct {
+	Name   string `json:"name" binding:"required"`
+	Email  string `json:"email" binding:"required"`
+	RoleId string `json:"roleId" binding:"required"`
+}
+
+func (s *UserService) ListUsers(workspaceId string) ([]WorkspaceUser, error) {
+	rows, err := s.db.Query(`SELECT id, name, email, role_id FROM penguin.user WHERE workspace_id = $1 ORDER BY email`, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []WorkspaceUser{}
+	for rows.Next() {
+		var u WorkspaceUser
+		if err := rows.Scan(&u.Id, &u.Name, &u.Email, &u.RoleId); err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+// CreateUser adds a user with a role of the same workspace. Emails are
+// unique across workspaces.
+func (s *UserService) CreateUser(workspaceId string, req CreateUserRequest) (*WorkspaceUser, error) {
+	if err := s.checkRoles(workspaceId, map[string][]string{req.RoleId: nil}); err != nil {
+		return nil, err
+	}
+	u := WorkspaceUser{Id: uuid.New().String(), Name: req.Name, Email: req.Email, RoleId: req.RoleId}
+	_, err := s.db.Exec(`INSERT INTO penguin.user (id, workspace_id, name, email, role_id) VALUES ($1, $2, $3, $4, $5)`,
+		u.Id, workspaceId, u.Name, u.Email, u.RoleId)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w: %s", ErrUserExists, req.Email)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// CreateSourceRequest registers a data source. The DSN is stored as an
+// encrypted secret; zero pool settings take the registry's defaults.
+type CreateSourceRequest struct {
+	Name                   string `json:"name" binding:"required"`
+	Driver                 string `json:"driver"`
+	DSN                    string `json:"dsn" binding:"required"`
+	DefaultSchema          string `json:"defaultSchema"`
+	MaxOpenConns           int    `json:"maxOpenConns"`
+	MaxIdleConns           int    `json:"maxIdleConns"`
+	ConnMaxLifetimeSeconds int    `json:"connMaxLifetimeSeconds"`
+}
+
+// CreateSource adds a data source to a workspace. The first source of a
+// workspace other than the default one becomes its default source.
+func (s *UserService) CreateSource(workspaceId string, req CreateSourceRequest) (*datasource.Config, error) {
+	if s.cfg.SecretKey == "" {
+		return nil, ErrSecretsDisabled
+	}
+	cfg := datasource.Config{
+		Name:            req.Name,
+		WorkspaceId:     workspaceId,
+		Driver:          req.Driver,
+		DefaultSchema:   req.DefaultSchema,
+		MaxOpenConns:    req.MaxOpenConns,
+		MaxIdleConns:    req.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(req.ConnMaxLifetimeSeconds) * time.Second,
+	}
+	if cfg.Driver == "" {
+		cfg.Driver = datasource.Postgres
+	}
+	if cfg.MaxOpenConns <= 0 {
+		cfg.MaxOpenConns = 10
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = 2
+	}
+	if cfg.ConnMaxLifetime <= 0 {
+		cfg.ConnMaxLifetime = 300 * time.Second
+	}
+	if err := s.sources.Add(context.Background(), cfg, req.DSN); err != nil {
+		return nil, err
+	}
+
+	if workspaceId != models.DefaultWorkspaceID {
+		if _, err := s.db.Exec(`UPDATE penguin.workspace SET default_source = $1 WHERE id = $2 AND default_source IS NULL`, cfg.Name, workspaceId); err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+// principalWorkspace is the workspace of principal. Internal callers pass
+// none and act in the default workspace.
+func principalWorkspace(principal *models.Principal) string {
+	if principal == nil {
+		return models.DefaultWorkspaceID
+	}
+	return principal.WorkspaceID
+}
+
+// googleContext makes the Google calls made with ctx use the workspace's
+// credentials.
+func googleContext(ctx context.Context, credentials string) context.Context {
+	return utils.WithGoogleCredentials(ctx, credentials)
+}
+
+// sheetContext is googleContext for the workspace of a report.
+func (s *UserService) sheetContext(ctx context.Context, sheetId string) (context.Context, error) {
+	var credentials string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(w.google_credentials, '')
+		FROM penguin.spreadsheet s JOIN penguin.workspace w ON w.id = s.workspace_id
+		WHERE s.id = $1
+	`, sheetId).Scan(&credentials)
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return googleContext(ctx, credentials), nil
+}
+
+// ReportWorkspace returns the workspace a report belongs to.
+func (s *UserService) ReportWorkspace(sheetId string) (string, error) {
+	var workspaceId string
+	err := s.db.QueryRow(`SELECT workspace_id FROM penguin.spreadsheet WHERE id = $1`, sheetId).Scan(&workspaceId)
+	if err == sql.ErrNoRows {
+		return "", ErrReportNotFound
+	}
+	return workspaceId, err
+}
+
+// source returns a data source of the principal's workspace; sources of
+// other workspaces are unknown to it. An empty name is the workspace's
+// default source.
+func (s *UserService) source(principal *models.Principal, name string) (*datasource.Source, error) {
+	workspaceId := principalWorkspace(principal)
+	if name == "" {
+		w, err := s.loadWorkspace(workspaceId)
+		if err != nil {
+			return nil, err
+		}
+		name = w.DefaultSource
+		// PENGUIN_DEFAULT_SOURCE belongs to the default workspace
+		if name == "" && workspaceId != models.DefaultWorkspaceID {
+			return nil, fmt.Errorf("%w: the workspace has no default source; name one", datasource.ErrUnknownSource)
+		}
+	}
+
+	src, err := s.sources.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if src.WorkspaceId != workspaceId {
+		return nil, fmt.Errorf("%w: %q", datasource.ErrUnknownSource, name)
+	}
+	return src, nil
+}
+
+// reportSource returns the data source of a stored report, which must
+// still belong to the report's workspace.
+func (s *UserService) reportSource(report *storedReport) (*datasource.Source, error) {
+	src, err := s.sources.Get(report.DBName)
+	if err != nil {
+		return nil, err
+	}
+	if src.WorkspaceId != report.WorkspaceId {
+		return nil, fmt.Errorf("%w: %q", datasource.ErrUnknownSource, report.DBName)
+	}
+	return src, nil
+}
+
+// checkRoles fails with ErrForeignRole unless every role of permissions
+// belongs to the workspace.
+func (s *UserService) checkRoles(workspaceId string, permissions map[string][]string) error {
+	var roles []string
+	for role := range permissions {
+		roles = append(roles, role)
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+
+	var found int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM penguin.role WHERE workspace_id = $1 AND id::text = ANY($2)
+	`, workspaceId, pq.Array(roles)).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(roles) {
+		return ErrForeignRole
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/datasource"
+)
+
+// addSQLiteSource registers a SQLite file as source name of workspaceId.
+func addSQLiteSource(t *testing.T, s *UserService, name, workspaceId string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name+".db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE orders (id INTEGER, amount REAL)`); err != nil {
+		t.Fatal(err)
+	}
+	env := "TEST_DSN_" + name
+	t.Setenv(env, path)
+	_, err = s.db.Exec(`INSERT INTO penguin.snowflake_databases (database_name, workspace_id, driver, dsn_ref) VALUES ($1, $2, 'sqlite', $3)`,
+		name, workspaceId, "env:"+env)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSourceIsolation(t *testing.T) {
+	s := newTestService(t)
+	addSQLiteSource(t, s, "sales", alice.WorkspaceID)
+	addSQLiteSource(t, s, "hr", bob.WorkspaceID)
+
+	if src, err := s.source(alice, "sales"); err != nil || src.Name != "sales" {
+		t.Fatalf("source(alice, sales) = %v", err)
+	}
+	if _, err := s.source(bob, "sales"); !errors.Is(err, datasource.ErrUnknownSource) {
+		t.Errorf("source of another workspace: %v, want ErrUnknownSource", err)
+	}
+	// PENGUIN_DEFAULT_SOURCE is the default workspace's, not bob's
+	if _, err := s.source(bob, ""); !errors.Is(err, datasource.ErrUnknownSource) {
+		t.Errorf("default source of a workspace without one: %v, want ErrUnknownSource", err)
+	}
+	if _, err := s.db.Exec(`UPDATE penguin.workspace SET default_source = 'hr' WHERE id = $1`, bob.WorkspaceID); err != nil {
+		t.Fatal(err)
+	}
+	if src, err := s.source(bob, ""); err != nil || src.Name != "hr" {
+		t.Errorf("default source of bob's workspace = %v", err)
+	}
+
+	// Queries cannot name another workspace's source either
+	_, err := s.ValidateSQLQuery(bob, SQLValidationRequest{Query: "select id from orders", DBName: "sales"})
+	if !errors.Is(err, datasource.ErrUnknownSource) {
+		t.Errorf("ValidateSQLQuery on another workspace's source: %v, want ErrUnknownSource", err)
+	}
+
+	configs, err := s.GetSources(bob.WorkspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].Name != "hr" {
+		t.Errorf("GetSources(bob) = %+v, want only hr", configs)
+	}
+}
+
+func TestReportWorkspace(t *testing.T) {
+	s := newTestService(t)
+	_, err := s.db.Exec(`INSERT INTO penguin.spreadsheet (id, workspace_id, report_name) VALUES ('sheet-b', $1, 'Other')`, bob.WorkspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.ReportWorkspace("sheet-b"); err != nil || id != bob.WorkspaceID {
+		t.Errorf("ReportWorkspace(sheet-b) = %q, %v", id, err)
+	}
+	if _, err := s.ReportWorkspace("sheet-x"); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("ReportWorkspace(sheet-x) = %v, want ErrReportNotFound", err)
+	}
+}
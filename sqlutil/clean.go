@@ -0,0 +1,61 @@
+package sqlutil
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrEmptyQuery         = errors.New("query is empty")
+	ErrMultipleStatements = errors.New("only a single statement is allowed")
+)
+
+// CleanQuery trims trailing semicolons, comments and whitespace so the
+// query can be embedded as a subquery, and rejects input holding more
+// than one statement.
+func CleanQuery(query string) (string, error) {
+	tokens := Tokenize(query)
+
+	end := len(tokens)
+	for end > 0 && (!tokens[end-1].IsCode() || tokens[end-1].Text == ";") {
+		end--
+	}
+
+	start := 0
+	for start < end && !tokens[start].IsCode() {
+		start++
+	}
+	if start == end {
+		return "", ErrEmptyQuery
+	}
+
+	var sb strings.Builder
+	for _, t := range tokens[start:end] {
+		if t.Kind == TokenSymbol && t.Text == ";" {
+			return "", ErrMultipleStatements
+		}
+		sb.WriteString(t.Text)
+	}
+	return sb.String(), nil
+}
+
+// Normalize reduces a query to a canonical text for fingerprinting:
+// comments are dropped, whitespace collapsed and unquoted identifiers and
+// keywords lower-cased, so formatting changes do not change the result.
+// The output is not meant to be run.
+func Normalize(query string) string {
+	code := Code(Tokenize(query))
+	for len(code) > 0 && code[len(code)-1].Text == ";" {
+		code = code[:len(code)-1]
+	}
+
+	parts := make([]string, len(code))
+	for i, t := range code {
+		if t.Kind == TokenIdent {
+			parts[i] = strings.ToLower(t.Text)
+		} else {
+			parts[i] = t.Text
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,42 @@
+package sqlutil
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCleanQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		err   error
+	}{
+		{"  select 1;; -- done\n", "select 1", nil},
+		{"/* c */ select ';' ", "select ';'", nil},
+		{"select 1; select 2", "", ErrMultipleStatements},
+		{" ; -- nothing", "", ErrEmptyQuery},
+	}
+	for _, tt := range tests {
+		got, err := CleanQuery(tt.query)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("CleanQuery(%q) = %q, %v, want %q, %v", tt.query, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	a := "SELECT  id,\n\tName FROM Orders -- all\nWHERE x = 'A';"
+	b := "select id , name from orders where x='A'"
+	if Normalize(a) != Normalize(b) {
+		t.Fatalf("Normalize differs:\n%q\n%q", Normalize(a), Normalize(b))
+	}
+	if want := "select id , name from orders where x = 'A'"; Normalize(a) != want {
+		t.Fatalf("Normalize(%q) = %q, want %q", a, Normalize(a), want)
+	}
+	if Normalize(`select "Name" from t`) == Normalize(`select name from t`) {
+		t.Fatal("quoted identifiers were folded")
+	}
+	if Normalize("select 'A'") == Normalize("select 'a'") {
+		t.Fatal("literals were folded")
+	}
+}
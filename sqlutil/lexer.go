@@ -0,0 +1,208 @@
+package sqlutil
+
+import (
+	"strings"
+	"unicode"
+)
+
+type TokenKind int
+
+const (
+	TokenWhitespace TokenKind = iota
+	TokenComment
+	TokenString      // '...', E'...' and $tag$...$tag$ literals
+	TokenQuotedIdent // "..." and `...`
+	TokenIdent
+	TokenNumber
+	TokenTemplate // {{ name }} report parameter
+	TokenSymbol
+)
+
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  int
+}
+
+// IsCode reports whether the token is part of the statement proper,
+// as opposed to whitespace or a comment.
+func (t Token) IsCode() bool {
+	return t.Kind != TokenWhitespace && t.Kind != TokenComment
+}
+
+// Keyword reports whether t is the unquoted identifier kw, ignoring case.
+func (t Token) Keyword(kw string) bool {
+	return t.Kind == TokenIdent && strings.EqualFold(t.Text, kw)
+}
+
+// Ident returns the identifier t names, unquoting quoted identifiers.
+// Unquoted identifiers are folded to lower case as Postgres does.
+func (t Token) Ident() string {
+	switch t.Kind {
+	case TokenQuotedIdent:
+		q := t.Text[:1]
+		return strings.ReplaceAll(t.Text[1:len(t.Text)-1], q+q, q)
+	case TokenIdent:
+		return strings.ToLower(t.Text)
+	}
+	return ""
+}
+
+// Tokenize splits a SQL statement into tokens. It understands enough of
+// the Postgres, MySQL and SQLite lexical rules to tell code apart
+// from literals and comments; it does not validate the statement.
+// Concatenating every token's Text yields the original input.
+func Tokenize(sql string) []Token {
+	var tokens []Token
+	for i := 0; i < len(sql); {
+		start := i
+		kind := TokenSymbol
+		c := sql[i]
+
+		switch {
+		case isSpace(c):
+			kind = TokenWhitespace
+			for i < len(sql) && isSpace(sql[i]) {
+				i++
+			}
+		case strings.HasPrefix(sql[i:], "--"):
+			kind = TokenComment
+			i = indexFrom(sql, i, "\n", false)
+		case strings.HasPrefix(sql[i:], "/*"):
+			kind = TokenComment
+			i = skipBlockComment(sql, i)
+		case strings.HasPrefix(sql[i:], "{{"):
+			kind = TokenTemplate
+			i = indexFrom(sql, i+2, "}}", true)
+		case c == '\'':
+			kind = TokenString
+			i = skipQuoted(sql, i, '\'', false)
+		case (c == 'E' || c == 'e') && i+1 < len(sql) && sql[i+1] == '\'':
+			kind = TokenString
+			i = skipQuoted(sql, i+1, '\'', true)
+		case c == '$' && dollarTag(sql[i:]) != "":
+			kind = TokenString
+			tag := dollarTag(sql[i:])
+			i = indexFrom(sql, i+len(tag), tag, true)
+		case c == '"' || c == '`':
+			kind = TokenQuotedIdent
+			i = skipQuoted(sql, i, c, false)
+		case isIdentStart(c):
+			kind = TokenIdent
+			for i < len(sql) && isIdentPart(sql[i]) {
+				i++
+			}
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			kind = TokenNumber
+			for i < len(sql) && (isDigit(sql[i]) || sql[i] == '.' || sql[i] == 'e' || sql[i] == 'E') {
+				i++
+			}
+		default:
+			i += symbolLen(sql[i:])
+		}
+
+		tokens = append(tokens, Token{Kind: kind, Text: sql[start:i], Pos: start})
+	}
+	return tokens
+}
+
+// Code returns the tokens that are neither whitespace nor comments.
+func Code(tokens []Token) []Token {
+	code := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		if t.IsCode() {
+			code = append(code, t)
+		}
+	}
+	return code
+}
+
+func isSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c))
+}
+
+func isIdentPart(c byte) bool { return isIdentStart(c) || isDigit(c) || c == '$' }
+
+// indexFrom returns the offset just past the next occurrence of sep at or
+// after i, including sep when inclusive. Unterminated input runs to the end.
+func indexFrom(s string, i int, sep string, inclusive bool) int {
+	j := strings.Index(s[i:], sep)
+	if j < 0 {
+		return len(s)
+	}
+	if inclusive {
+		return i + j + len(sep)
+	}
+	return i + j
+}
+
+// skipBlockComment handles nested /* */ comments, which Postgres allows.
+func skipBlockComment(s string, i int) int {
+	depth := 0
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(s[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipQuoted returns the offset past the literal opened at s[i]. A doubled
+// quote is an escaped quote; backslash escapes are honoured when asked.
+func skipQuoted(s string, i int, quote byte, backslash bool) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return i
+}
+
+// dollarTag returns the opening "$tag$" of a dollar-quoted string at the
+// start of s, or "" when s starts with a positional parameter like $1.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || unicode.IsLetter(rune(c)) || (i > 1 && isDigit(c))) {
+			return ""
+		}
+	}
+	return ""
+}
+
+var multiCharSymbols = []string{"::", "<=", ">=", "<>", "!=", "||", "->>", "->", "=>"}
+
+func symbolLen(s string) int {
+	for _, sym := range multiCharSymbols {
+		if strings.HasPrefix(s, sym) {
+			return len(sym)
+		}
+	}
+	return 1
+}
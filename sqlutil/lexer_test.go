@@ -0,0 +1,89 @@
+package sqlutil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	type tok struct {
+		Kind TokenKind
+		Text string
+	}
+	tests := []struct {
+		name string
+		sql  string
+		want []tok
+	}{
+		{
+			"identifiers and symbols",
+			"select a::int, b->>'k'",
+			[]tok{
+				{TokenIdent, "select"}, {TokenWhitespace, " "}, {TokenIdent, "a"}, {TokenSymbol, "::"}, {TokenIdent, "int"},
+				{TokenSymbol, ","}, {TokenWhitespace, " "}, {TokenIdent, "b"}, {TokenSymbol, "->>"}, {TokenString, "'k'"},
+			},
+		},
+		{
+			"doubled quote",
+			"'it''s' \"a\"\"b\" `c`",
+			[]tok{{TokenString, "'it''s'"}, {TokenWhitespace, " "}, {TokenQuotedIdent, `"a""b"`}, {TokenWhitespace, " "}, {TokenQuotedIdent, "`c`"}},
+		},
+		{
+			"escape string",
+			`E'a\'b' x`,
+			[]tok{{TokenString, `E'a\'b'`}, {TokenWhitespace, " "}, {TokenIdent, "x"}},
+		},
+		{
+			"dollar quoting and positional parameter",
+			"$fn$ select ';' $fn$ = $1",
+			[]tok{{TokenString, "$fn$ select ';' $fn$"}, {TokenWhitespace, " "}, {TokenSymbol, "="}, {TokenWhitespace, " "}, {TokenSymbol, "$"}, {TokenNumber, "1"}},
+		},
+		{
+			"comments",
+			"a -- b;\n/* c /* d */ ; */e",
+			[]tok{{TokenIdent, "a"}, {TokenWhitespace, " "}, {TokenComment, "-- b;"}, {TokenWhitespace, "\n"}, {TokenComment, "/* c /* d */ ; */"}, {TokenIdent, "e"}},
+		},
+		{
+			"template",
+			"x = {{ from }} and 1.5e3",
+			[]tok{
+				{TokenIdent, "x"}, {TokenWhitespace, " "}, {TokenSymbol, "="}, {TokenWhitespace, " "}, {TokenTemplate, "{{ from }}"},
+				{TokenWhitespace, " "}, {TokenIdent, "and"}, {TokenWhitespace, " "}, {TokenNumber, "1.5e3"},
+			},
+		},
+		{
+			"unterminated literal",
+			"select 'abc",
+			[]tok{{TokenIdent, "select"}, {TokenWhitespace, " "}, {TokenString, "'abc"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := Tokenize(tt.sql)
+			var got []tok
+			var text strings.Builder
+			for _, token := range tokens {
+				got = append(got, tok{token.Kind, token.Text})
+				if token.Pos != text.Len() {
+					t.Errorf("token %q at %d, want %d", token.Text, token.Pos, text.Len())
+				}
+				text.WriteString(token.Text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Tokenize(%q)\n got %v\nwant %v", tt.sql, got, tt.want)
+			}
+			if text.String() != tt.sql {
+				t.Fatalf("tokens concatenate to %q", text.String())
+			}
+		})
+	}
+}
+
+func TestIdent(t *testing.T) {
+	for text, want := range map[string]string{`Orders`: "orders", `"Order""s"`: `Order"s`, "`a``b`": "a`b", `'x'`: ""} {
+		if got := Code(Tokenize(text))[0].Ident(); got != want {
+			t.Errorf("Ident(%s) = %q, want %q", text, got, want)
+		}
+	}
+}
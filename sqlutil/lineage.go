@@ -0,0 +1,422 @@
+package sqlutil
+
+import "strings"
+
+// TableRef names a table read by a query. Schema is empty when the query
+// leaves the table unqualified.
+type TableRef struct {
+	Schema string `json:"schema,omitempty"`
+	Name   string `json:"name"`
+}
+
+func (t TableRef) String() string {
+	if t.Schema == "" {
+		return t.Name
+	}
+	return t.Schema + "." + t.Name
+}
+
+// FunctionRef names a function a query calls. Schema is empty when the
+// call leaves it unqualified.
+type FunctionRef struct {
+	Schema string `json:"schema,omitempty"`
+	Name   string `json:"name"`
+}
+
+func (f FunctionRef) String() string {
+	return TableRef(f).String()
+}
+
+// ColumnRef is a column a query reads. Column is "*" for SELECT *, t.*
+// and whole-row references such as to_json(t). Unqualified columns in queries over several tables cannot be
+// attributed to one of them; they are reported against every table with
+// Ambiguous set.
+type ColumnRef struct {
+	Table     TableRef `json:"table"`
+	Column    string   `json:"column"`
+	Ambiguous bool     `json:"ambiguous,omitempty"`
+}
+
+type Lineage struct {
+	Tables    []TableRef    `json:"tables"`
+	Columns   []ColumnRef   `json:"columns"`
+	Functions []FunctionRef `json:"functions,omitempty"`
+}
+
+// ExtractLineage lists the tables and columns a SELECT statement reads.
+// It is a best-effort token-level analysis rather than a full parser:
+// when in doubt it over-reports, which is the safe side for impact
+// analysis and access checks.
+func ExtractLineage(query string) *Lineage {
+	p := &lineageParser{
+		tokens:   Code(Tokenize(query)),
+		consumed: make(map[int]bool),
+		aliases:  make(map[string]*TableRef),
+		ctes:     make(map[string]bool),
+	}
+	p.findCTEs()
+	p.skipLocking()
+	p.findTables()
+	return p.findColumns()
+}
+
+type lineageParser struct {
+	tokens   []Token
+	consumed map[int]bool
+	tables   []TableRef
+	// aliases maps lower-cased aliases and table names to the table they
+	// stand for; nil entries are CTEs and subqueries, which are not tables.
+	aliases map[string]*TableRef
+	ctes    map[string]bool
+	// stars are tables read whole by TABLE name.
+	stars []TableRef
+}
+
+func (p *lineageParser) at(i int) Token {
+	if i < 0 || i >= len(p.tokens) {
+		return Token{Kind: TokenWhitespace}
+	}
+	return p.tokens[i]
+}
+
+func (p *lineageParser) isSymbol(i int, sym string) bool {
+	t := p.at(i)
+	return t.Kind == TokenSymbol && t.Text == sym
+}
+
+func isName(t Token) bool {
+	return t.Kind == TokenQuotedIdent || (t.Kind == TokenIdent && !reserved[strings.ToLower(t.Text)])
+}
+
+// skipParens returns the index just past the parenthesis group opening
+// at i.
+func (p *lineageParser) skipParens(i int) int {
+	depth := 0
+	for ; i < len(p.tokens); i++ {
+		if p.isSymbol(i, "(") {
+			depth++
+		} else if p.isSymbol(i, ")") {
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// findCTEs records the names defined by WITH clauses so references to
+// them are not mistaken for tables.
+func (p *lineageParser) findCTEs() {
+	for i := 0; i < len(p.tokens); i++ {
+		if !p.at(i).Keyword("with") {
+			continue
+		}
+		j := i + 1
+		if p.at(j).Keyword("recursive") {
+			j++
+		}
+		for isName(p.at(j)) {
+			p.ctes[p.at(j).Ident()] = true
+			p.consumed[j] = true
+			j++
+			if p.isSymbol(j, "(") {
+				// Column list: WITH t (a, b) AS (...)
+				for k := j; k < p.skipParens(j); k++ {
+					p.consumed[k] = true
+				}
+				j = p.skipParens(j)
+			}
+			if !p.at(j).Keyword("as") {
+				break
+			}
+			j++
+			for p.at(j).Keyword("not") || p.at(j).Keyword("materialized") {
+				j++
+			}
+			if !p.isSymbol(j, "(") {
+				break
+			}
+			j = p.skipParens(j)
+			if !p.isSymbol(j, ",") {
+				break
+			}
+			j++
+		}
+	}
+}
+
+// skipLocking consumes FOR UPDATE and FOR SHARE clauses, whose keywords
+// and table names would otherwise read as columns:
+// FOR [NO KEY] UPDATE | [KEY] SHARE [OF name, ...] [NOWAIT | SKIP LOCKED].
+func (p *lineageParser) skipLocking() {
+	for i := 0; i < len(p.tokens); i++ {
+		if !p.at(i).Keyword("for") {
+			continue
+		}
+		next, after := p.at(i+1), p.at(i+2)
+		if !next.Keyword("update") && !next.Keyword("share") &&
+			!(next.Keyword("no") && after.Keyword("key")) && !(next.Keyword("key") && after.Keyword("share")) {
+			continue
+		}
+		j := i + 1
+		for ; j < len(p.tokens); j++ {
+			t := p.at(j)
+			if !lockingWords[strings.ToLower(t.Text)] && !isName(t) && !p.isSymbol(j, ",") && !p.isSymbol(j, ".") {
+				break
+			}
+			p.consumed[j] = true
+		}
+		i = j - 1
+	}
+}
+
+var lockingWords = map[string]bool{
+	"update": true, "share": true, "no": true, "key": true, "of": true, "nowait": true, "skip": true, "locked": true,
+}
+
+// Functions whose arguments use FROM without it starting a table list.
+var fromTakingFunctions = map[string]bool{
+	"extract": true, "substring": true, "trim": true, "position": true, "overlay": true,
+}
+
+// Keywords that end a FROM list at the same nesting level.
+var fromListEnd = map[string]bool{
+	"where": true, "group": true, "having": true, "order": true, "limit": true, "offset": true,
+	"fetch": true, "union": true, "except": true, "intersect": true, "window": true, "for": true,
+	"returning": true, "select": true, "qualify": true,
+}
+
+func (p *lineageParser) findTables() {
+	// openers[d] is the token before the parenthesis that opened depth d.
+	openers := []string{""}
+	inFrom := []bool{false}
+
+	for i := 0; i < len(p.tokens); i++ {
+		t := p.at(i)
+		depth := len(openers) - 1
+		switch {
+		case p.isSymbol(i, "("):
+			openers = append(openers, strings.ToLower(p.at(i-1).Text))
+			inFrom = append(inFrom, false)
+		case p.isSymbol(i, ")"):
+			if depth > 0 {
+				openers = openers[:depth]
+				inFrom = inFrom[:depth]
+			}
+		case t.Keyword("from") && fromTakingFunctions[openers[depth]]:
+			// EXTRACT(year FROM ts) and friends
+		case t.Keyword("from"), t.Keyword("join"):
+			inFrom[depth] = true
+			p.parseTable(i + 1)
+		case t.Keyword("table"):
+			// TABLE name, shorthand for SELECT * FROM name
+			if ref := p.parseTable(i + 1); ref != nil {
+				p.stars = append(p.stars, *ref)
+			}
+		case p.isSymbol(i, ",") && inFrom[depth]:
+			p.parseTable(i + 1)
+		case t.Kind == TokenIdent && fromListEnd[strings.ToLower(t.Text)]:
+			inFrom[depth] = false
+		}
+	}
+}
+
+// parseTable reads "[schema.]name [[AS] alias]" at i and returns the
+// table, or nil for CTE references. Subqueries and table functions are
+// left alone; their contents are visited anyway.
+func (p *lineageParser) parseTable(i int) *TableRef {
+	for p.at(i).Keyword("lateral") || p.at(i).Keyword("only") {
+		i++
+	}
+	if !isName(p.at(i)) {
+		return nil
+	}
+
+	start := i
+	parts := []string{p.at(i).Ident()}
+	for p.isSymbol(i+1, ".") && isName(p.at(i+2)) {
+		parts = append(parts, p.at(i+2).Ident())
+		i += 2
+	}
+	if p.isSymbol(i+1, "(") {
+		return nil // table function such as generate_series(...)
+	}
+	for k := start; k <= i; k++ {
+		p.consumed[k] = true
+	}
+
+	var ref *TableRef
+	if len(parts) == 1 && p.ctes[parts[0]] {
+		p.aliases[parts[0]] = nil
+	} else {
+		// A three-part name is catalog.schema.table; the catalog is dropped.
+		ref = &TableRef{Name: parts[len(parts)-1]}
+		if len(parts) > 1 {
+			ref.Schema = parts[len(parts)-2]
+		}
+		p.addTable(*ref)
+		p.aliases[ref.Name] = ref
+	}
+
+	j := i + 1
+	if p.at(j).Keyword("as") {
+		j++
+	}
+	if isName(p.at(j)) {
+		p.consumed[j] = true
+		p.aliases[p.at(j).Ident()] = ref
+	}
+	return ref
+}
+
+func (p *lineageParser) addTable(ref TableRef) {
+	for _, t := range p.tables {
+		if t == ref {
+			return
+		}
+	}
+	p.tables = append(p.tables, ref)
+}
+
+func (p *lineageParser) findColumns() *Lineage {
+	lineage := &Lineage{Tables: p.tables}
+	seen := make(map[ColumnRef]bool)
+	add := func(c ColumnRef) {
+		if !seen[c] {
+			seen[c] = true
+			lineage.Columns = append(lineage.Columns, c)
+		}
+	}
+	unqualified := func(column string) {
+		for _, t := range p.tables {
+			add(ColumnRef{Table: t, Column: column, Ambiguous: len(p.tables) > 1})
+		}
+	}
+
+	for _, t := range p.stars {
+		add(ColumnRef{Table: t, Column: "*"})
+	}
+
+	for i := 0; i < len(p.tokens); i++ {
+		t := p.at(i)
+
+		// Bare * in a select list, but not count(*) or a * b
+		if p.isSymbol(i, "*") {
+			prev := p.at(i - 1)
+			if prev.Keyword("select") || prev.Keyword("distinct") || (prev.Kind == TokenSymbol && prev.Text == ",") {
+				unqualified("*")
+			}
+			continue
+		}
+
+		if p.consumed[i] || !isName(t) || p.isAlias(i) {
+			continue
+		}
+
+		start := i
+		parts := []string{t.Ident()}
+		star := false
+		for p.isSymbol(i+1, ".") {
+			if p.isSymbol(i+2, "*") {
+				star = true
+				i += 2
+				break
+			}
+			if !isName(p.at(i + 2)) {
+				break
+			}
+			parts = append(parts, p.at(i+2).Ident())
+			i += 2
+		}
+
+		// Function calls, and typed literals such as timestamp '2025-01-01'
+		if p.isSymbol(i+1, "(") && !star {
+			fn := FunctionRef{Name: parts[len(parts)-1]}
+			if len(parts) > 1 {
+				fn.Schema = parts[len(parts)-2]
+			}
+			p.addFunction(lineage, fn)
+			continue
+		}
+		if len(parts) == 1 && !star && p.at(i+1).Kind == TokenString {
+			continue
+		}
+		for k := start; k <= i; k++ {
+			p.consumed[k] = true
+		}
+
+		if star {
+			parts = append(parts, "*")
+		}
+		switch len(parts) {
+		case 1:
+			// A table or alias used as a value is its whole row, as in
+			// to_json(u). A column of the same name would win, so the
+			// name is reported as a column too.
+			if ref := p.aliases[parts[0]]; ref != nil {
+				add(ColumnRef{Table: *ref, Column: "*"})
+			}
+			unqualified(parts[0])
+		case 2:
+			if ref := p.aliases[parts[0]]; ref != nil {
+				add(ColumnRef{Table: *ref, Column: parts[1]})
+			}
+		default:
+			n := len(parts)
+			ref := TableRef{Schema: parts[n-3], Name: parts[n-2]}
+			for _, table := range p.tables {
+				if table.Name == ref.Name && (table.Schema == ref.Schema || table.Schema == "") {
+					add(ColumnRef{Table: table, Column: parts[n-1]})
+				}
+			}
+		}
+	}
+	return lineage
+}
+
+func (p *lineageParser) addFunction(lineage *Lineage, fn FunctionRef) {
+	for _, f := range lineage.Functions {
+		if f == fn {
+			return
+		}
+	}
+	lineage.Functions = append(lineage.Functions, fn)
+}
+
+// isAlias reports whether the name at i defines an output or table alias:
+// "expr AS name", "expr name" or "(subquery) name". Names after :: are
+// type casts and are skipped too.
+func (p *lineageParser) isAlias(i int) bool {
+	prev := p.at(i - 1)
+	switch {
+	case prev.Keyword("as"):
+		return true
+	case prev.Kind == TokenSymbol:
+		return prev.Text == "::" || prev.Text == ")"
+	case prev.Kind == TokenNumber || prev.Kind == TokenString || prev.Kind == TokenQuotedIdent:
+		return true
+	case prev.Kind == TokenIdent:
+		return !reserved[strings.ToLower(prev.Text)]
+	}
+	return false
+}
+
+// reserved are the keywords that are never column or table names in
+// report queries. Type names like date and timestamp are left out on
+// purpose: they are common column names.
+var reserved = map[string]bool{}
+
+func init() {
+	for _, kw := range strings.Fields(`
+		all and any array as asc between both by case cast collate cross current
+		current_date current_time current_timestamp current_user default desc
+		distinct else end escape except exists false fetch filter first following
+		for from full group having ilike in inner intersect is join lateral leading
+		left like limit localtime localtimestamp materialized natural next not null
+		nulls of offset on only or order outer over partition preceding qualify range
+		recursive right row rows select session_user similar some symmetric table then
+		ties trailing true unbounded union using values when where window with within`) {
+		reserved[kw] = true
+	}
+}
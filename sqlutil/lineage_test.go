@@ -0,0 +1,82 @@
+package sqlutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractLineage(t *testing.T) {
+	orders := TableRef{Schema: "sales", Name: "orders"}
+	users := TableRef{Schema: "penguin", Name: "user"}
+
+	tests := []struct {
+		name  string
+		query string
+		want  Lineage
+	}{
+		{
+			"qualified columns",
+			"select o.id, o.amount from sales.orders o",
+			Lineage{Tables: []TableRef{orders}, Columns: []ColumnRef{{Table: orders, Column: "id"}, {Table: orders, Column: "amount"}}},
+		},
+		{
+			"select star",
+			"select * from sales.orders",
+			Lineage{Tables: []TableRef{orders}, Columns: []ColumnRef{{Table: orders, Column: "*"}}},
+		},
+		{
+			"ambiguous column",
+			"select id from sales.orders o join penguin.user u on u.id = o.user_id",
+			Lineage{
+				Tables: []TableRef{orders, users},
+				Columns: []ColumnRef{
+					{Table: orders, Column: "id", Ambiguous: true},
+					{Table: users, Column: "id", Ambiguous: true},
+					{Table: users, Column: "id"},
+					{Table: orders, Column: "user_id"},
+				},
+			},
+		},
+		{
+			"cte is not a table",
+			"with t as (select id from sales.orders) select id from t",
+			Lineage{Tables: []TableRef{orders}, Columns: []ColumnRef{{Table: orders, Column: "id"}}},
+		},
+		{
+			"whole row",
+			"select to_json(u) from penguin.user u",
+			Lineage{
+				Tables:    []TableRef{users},
+				Columns:   []ColumnRef{{Table: users, Column: "*"}, {Table: users, Column: "u"}},
+				Functions: []FunctionRef{{Name: "to_json"}},
+			},
+		},
+		{
+			"locking clause",
+			"select id from sales.orders for no key update of orders skip locked",
+			Lineage{Tables: []TableRef{orders}, Columns: []ColumnRef{{Table: orders, Column: "id"}}},
+		},
+		{
+			"substring for is not a locking clause",
+			"select substring(name from 1 for key) from sales.orders",
+			Lineage{
+				Tables:    []TableRef{orders},
+				Columns:   []ColumnRef{{Table: orders, Column: "name"}, {Table: orders, Column: "key"}},
+				Functions: []FunctionRef{{Name: "substring"}},
+			},
+		},
+		{
+			"functions",
+			"select pg_catalog.query_to_xml('select * from penguin.user', true, false, ''), x::numeric(10, 2) from generate_series(1, 3) x",
+			Lineage{Functions: []FunctionRef{{Schema: "pg_catalog", Name: "query_to_xml"}, {Name: "generate_series"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractLineage(tt.query)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("ExtractLineage(%q)\n got %+v\nwant %+v", tt.query, *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package sqlutil
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nishantd01/penguin-core/models"
+)
+
+var ErrInvalidParameter = errors.New("invalid parameter")
+
+const (
+	ParamString    = "string"
+	ParamInteger   = "integer"
+	ParamNumber    = "number"
+	ParamBoolean   = "boolean"
+	ParamDate      = "date"
+	ParamTimestamp = "timestamp"
+)
+
+// TemplateName returns the parameter name of a TokenTemplate token.
+func TemplateName(t Token) string {
+	name := strings.TrimPrefix(t.Text, "{{")
+	name = strings.TrimSuffix(name, "}}")
+	return strings.TrimSpace(name)
+}
+
+// Placeholders lists the distinct {{name}} parameters referenced by query,
+// in order of first use. Placeholders inside literals and comments are
+// ignored.
+func Placeholders(query string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, t := range Tokenize(query) {
+		if t.Kind != TokenTemplate {
+			continue
+		}
+		if name := TemplateName(t); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Bind rewrites every {{name}} placeholder in query to a driver bind
+// marker and returns the matching arguments. Values are coerced to the
+// declared parameter types and fall back to the declared defaults; the
+// resolved values are returned so callers can record what a report ran
+// with. Values are never spliced into the SQL text.
+func Bind(query string, decls []models.Parameter, values map[string]interface{}, placeholder func(int) string) (string, []interface{}, map[string]interface{}, error) {
+	byName := make(map[string]models.Parameter, len(decls))
+	for _, d := range decls {
+		if d.Name == "" {
+			return "", nil, nil, fmt.Errorf("%w: parameter without a name", ErrInvalidParameter)
+		}
+		if !ValidType(d.Type) {
+			return "", nil, nil, fmt.Errorf("%w: %s: unknown type %q", ErrInvalidParameter, d.Name, d.Type)
+		}
+		byName[d.Name] = d
+	}
+	for name := range values {
+		if _, ok := byName[name]; !ok {
+			return "", nil, nil, fmt.Errorf("%w: %s is not declared", ErrInvalidParameter, name)
+		}
+	}
+
+	resolved := make(map[string]interface{})
+	bound := make(map[string]interface{})
+	var sb strings.Builder
+	var args []interface{}
+	for _, t := range Tokenize(query) {
+		if t.Kind != TokenTemplate {
+			sb.WriteString(t.Text)
+			continue
+		}
+
+		name := TemplateName(t)
+		arg, ok := bound[name]
+		if !ok {
+			decl, declared := byName[name]
+			if !declared {
+				return "", nil, nil, fmt.Errorf("%w: {{%s}} is used but not declared", ErrInvalidParameter, name)
+			}
+
+			raw, supplied := values[name]
+			if !supplied {
+				raw = decl.Default
+			}
+			if raw == nil && decl.Required {
+				return "", nil, nil, fmt.Errorf("%w: %s is required", ErrInvalidParameter, name)
+			}
+
+			var err error
+			if arg, err = Coerce(decl.Type, raw); err != nil && !errors.Is(err, errMissingValue) {
+				return "", nil, nil, fmt.Errorf("%w: %s: %v", ErrInvalidParameter, name, err)
+			}
+			bound[name] = arg
+			resolved[name] = arg
+		}
+
+		// Each occurrence gets its own marker so "?" dialects line up.
+		args = append(args, arg)
+		sb.WriteString(placeholder(len(args)))
+	}
+
+	return sb.String(), args, resolved, nil
+}
+
+var errMissingValue = errors.New("no value")
+
+// ValidType accepts the declared parameter types; an empty type means
+// string.
+func ValidType(typ string) bool {
+	switch typ {
+	case "", ParamString, ParamInteger, ParamNumber, ParamBoolean, ParamDate, ParamTimestamp:
+		return true
+	}
+	return false
+}
+
+// Coerce converts a JSON-decoded value to the Go value bound for a
+// parameter of type typ. Dates and timestamps are normalised to strings
+// every supported driver accepts; nil yields errMissingValue.
+func Coerce(typ string, v interface{}) (interface{}, error) {
+	if !ValidType(typ) {
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	if typ == "" {
+		typ = ParamString
+	}
+	if v == nil {
+		return nil, errMissingValue
+	}
+
+	s, isString := v.(string)
+	switch typ {
+	case ParamString:
+		if isString {
+			return s, nil
+		}
+		return fmt.Sprint(v), nil
+
+	case ParamInteger:
+		if f, ok := v.(float64); ok {
+			if f != math.Trunc(f) {
+				return nil, fmt.Errorf("%v is not an integer", f)
+			}
+			return int64(f), nil
+		}
+		if isString {
+			return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		}
+
+	case ParamNumber:
+		if f, ok := v.(float64); ok {
+			return f, nil
+		}
+		if isString {
+			return strconv.ParseFloat(strings.TrimSpace(s), 64)
+		}
+
+	case ParamBoolean:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		if isString {
+			return strconv.ParseBool(strings.TrimSpace(s))
+		}
+
+	case ParamDate:
+		if isString {
+			d, err := time.Parse("2006-01-02", strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a YYYY-MM-DD date", s)
+			}
+			return d.Format("2006-01-02"), nil
+		}
+
+	case ParamTimestamp:
+		if isString {
+			// The wall clock time is kept as given; an offset, if any,
+			// is dropped rather than converted.
+			for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+				if ts, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+					return ts.Format("2006-01-02 15:04:05"), nil
+				}
+			}
+			return nil, fmt.Errorf("%q is not a timestamp", s)
+		}
+	}
+
+	return nil, fmt.Errorf("cannot use %T as %s", v, typ)
+}
@@ -0,0 +1,104 @@
+package sqlutil
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/nishantd01/penguin-core/models"
+)
+
+func dollar(n int) string { return "$" + strconv.Itoa(n) }
+
+func TestPlaceholders(t *testing.T) {
+	got := Placeholders("select {{ a }}, {{b}}, '{{c}}' -- {{d}}\n, {{a}}")
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Placeholders = %v, want %v", got, want)
+	}
+}
+
+func TestBind(t *testing.T) {
+	decls := []models.Parameter{
+		{Name: "from", Type: ParamDate, Required: true},
+		{Name: "limit", Type: ParamInteger, Default: float64(10)},
+		{Name: "name"},
+	}
+	tests := []struct {
+		name     string
+		query    string
+		values   map[string]interface{}
+		want     string
+		args     []interface{}
+		resolved map[string]interface{}
+		err      bool
+	}{
+		{
+			"defaults and repeats",
+			"select * from t where d >= {{from}} and d < {{ from }} limit {{limit}}",
+			map[string]interface{}{"from": "2025-08-01"},
+			"select * from t where d >= $1 and d < $2 limit $3",
+			[]interface{}{"2025-08-01", "2025-08-01", int64(10)},
+			map[string]interface{}{"from": "2025-08-01", "limit": int64(10)},
+			false,
+		},
+		{
+			"optional without default binds null",
+			"select {{name}}, '{{name}}'",
+			nil,
+			"select $1, '{{name}}'",
+			[]interface{}{nil},
+			map[string]interface{}{"name": nil},
+			false,
+		},
+		{"missing required", "select {{from}}", nil, "", nil, nil, true},
+		{"undeclared placeholder", "select {{other}}", nil, "", nil, nil, true},
+		{"undeclared value", "select 1", map[string]interface{}{"other": 1.0}, "", nil, nil, true},
+		{"bad value", "select {{limit}}", map[string]interface{}{"limit": 1.5}, "", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, resolved, err := Bind(tt.query, decls, tt.values, dollar)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidParameter) {
+					t.Fatalf("Bind error = %v, want ErrInvalidParameter", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || !reflect.DeepEqual(args, tt.args) || !reflect.DeepEqual(resolved, tt.resolved) {
+				t.Fatalf("Bind = %q, %v, %v, want %q, %v, %v", got, args, resolved, tt.want, tt.args, tt.resolved)
+			}
+		})
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		typ  string
+		in   interface{}
+		want interface{}
+		err  bool
+	}{
+		{"", 12.0, "12", false},
+		{ParamInteger, 3.0, int64(3), false},
+		{ParamInteger, " 42 ", int64(42), false},
+		{ParamInteger, 3.5, nil, true},
+		{ParamNumber, "2.5", 2.5, false},
+		{ParamBoolean, "true", true, false},
+		{ParamBoolean, 1.0, nil, true},
+		{ParamDate, "2025-08-15", "2025-08-15", false},
+		{ParamDate, "15/08/2025", nil, true},
+		{ParamTimestamp, "2025-08-15T10:15:00+02:00", "2025-08-15 10:15:00", false},
+		{ParamTimestamp, "2025-08-15", "2025-08-15 00:00:00", false},
+		{"uuid", "x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := Coerce(tt.typ, tt.in)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("Coerce(%q, %v) = %v, %v, want %v", tt.typ, tt.in, got, err, tt.want)
+		}
+	}
+}
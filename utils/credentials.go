@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+
+	"github.com/nishantd01/penguin-core/secrets"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// DefaultGoogleCredentials names the deployment's own Google credentials:
+// the secrets google.client_secret and google.oauth_token. Workspaces may
+// bring their own under another name.
+const DefaultGoogleCredentials = "google"
+
+type credentialsKey struct{}
+
+// WithGoogleCredentials makes the Google calls made with ctx use the
+// credentials stored under name, e.g. acme for the secrets
+// acme.client_secret and acme.oauth_token.
+func WithGoogleCredentials(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, name)
+}
+
+func googleCredentials(ctx context.Context) string {
+	if name, ok := ctx.Value(credentialsKey{}).(string); ok && name != "" {
+		return name
+	}
+	return DefaultGoogleCredentials
+}
+
+func clientSecretName(credentials string) string { return credentials + ".client_secret" }
+func tokenName(credentials string) string        { return credentials + ".oauth_token" }
+
+var ErrGoogleNotAuthorized = errors.New("no Google OAuth token stored; authorize through /api/v1/admin/google/authorize")
+
+// secretStore holds the Google client secret and OAuth token. Without
+// one, they are read from credentials.json and ~/.credentials/token.json
+// as before.
+var secretStore *secrets.Store
+
+// UseSecrets makes the Google clients read their credentials from store
+// and persist refreshed tokens to it.
+func UseSecrets(store *secrets.Store) {
+	secretStore = store
+}
+
+// googleClient returns an HTTP client authorised for the Google APIs the
+// service uses.
+func googleClient(ctx context.Context) (*http.Client, error) {
+	if secretStore == nil {
+		return fileGoogleClient()
+	}
+
+	credentials := googleCredentials(ctx)
+	config, err := googleConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := storedSecret(ctx, tokenName(credentials), tokenPath())
+	if errors.Is(err, secrets.ErrNotFound) {
+		return nil, fmt.Errorf("%w (%s)", ErrGoogleNotAuthorized, credentials)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(raw, &token); err != nil {
+		return nil, fmt.Errorf("stored Google token is malformed: %w", err)
+	}
+
+	src := &savingTokenSource{
+		src:         config.TokenSource(context.Background(), &token),
+		credentials: credentials,
+		last:        token.AccessToken,
+	}
+	return oauth2.NewClient(context.Background(), src), nil
+}
+
+func googleConfig(ctx context.Context) (*oauth2.Config, error) {
+	b, err := storedSecret(ctx, clientSecretName(googleCredentials(ctx)), "credentials.json")
+	if errors.Is(err, secrets.ErrNotFound) {
+		return nil, fmt.Errorf("Google client secret is not stored: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	config, err := google.ConfigFromJSON(b, googleScopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse Google client secret: %w", err)
+	}
+	return config, nil
+}
+
+// storedSecret reads a secret. The deployment's own credentials are
+// imported from legacyPath the first time, so existing deployments keep
+// working after the switch.
+func storedSecret(ctx context.Context, name, legacyPath string) ([]byte, error) {
+	value, err := secretStore.Get(ctx, name)
+	if !errors.Is(err, secrets.ErrNotFound) || googleCredentials(ctx) != DefaultGoogleCredentials {
+		return value, err
+	}
+	b, readErr := os.ReadFile(legacyPath)
+	if readErr != nil {
+		return nil, err
+	}
+	if _, err := secretStore.Put(ctx, name, b); err != nil {
+		return nil, err
+	}
+	log.Printf("🔐 Imported %s into secret %s; the file can be deleted", legacyPath, name)
+	return b, nil
+}
+
+// savingTokenSource writes each refreshed token back to the secret store,
+// so other replicas and restarts pick it up.
+type savingTokenSource struct {
+	src         oauth2.TokenSource
+	credentials string
+
+	mu   sync.Mutex
+	last string
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.last {
+		s.last = token.AccessToken
+		if err := saveToken(context.Background(), s.credentials, token); err != nil {
+			log.Printf("Failed to persist refreshed Google token: %v", err)
+		}
+	}
+	return token, nil
+}
+
+func saveToken(ctx context.Context, credentials string, token *oauth2.Token) error {
+	raw, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	_, err = secretStore.Put(ctx, tokenName(credentials), raw)
+	return err
+}
+
+// GoogleAuthURL returns the consent page an admin opens to authorise the
+// service with the credentials of ctx. The code it yields goes to
+// AuthorizeGoogle.
+func GoogleAuthURL(ctx context.Context, state string) (string, error) {
+	config, err := googleConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	return config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce), nil
+}
+
+// AuthorizeGoogle exchanges an authorisation code for a token and stores
+// it, replacing the previous one.
+func AuthorizeGoogle(ctx context.Context, code string) error {
+	config, err := googleConfig(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := config.Exchange(ctx, code)
+	if err != nil {
+		return fmt.Errorf("unable to exchange authorization code: %w", err)
+	}
+	return saveToken(ctx, googleCredentials(ctx), token)
+}
+
+func tokenPath() string {
+	usr, _ := user.Current()
+	return filepath.Join(usr.HomeDir, ".credentials", "token.json")
+}
+
+// fileGoogleClient is the client used without a secret store, from
+// credentials.json and the cached user token.
+func fileGoogleClient() (*http.Client, error) {
+	b, err := os.ReadFile("credentials.json")
+	if err != nil {
+		log.Printf("Unable to read client secret file: %v", err)
+		return nil, errors.New(err.Error())
+	}
+
+	config, err := google.ConfigFromJSON(b, googleScopes...)
+	if err != nil {
+		log.Printf("Unable to parse client secret file to config: %v", err)
+		return nil, errors.New(err.Error())
+	}
+	return getClient(config), nil
+}
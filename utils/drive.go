@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/script/v1"
+)
+
+// reportsFolderID is the Drive folder new report spreadsheets are created
+// in unless their workspace has its own.
+const reportsFolderID = "1hGITz-qza0wMpK9MW93za5iq9u9-3qcg"
+
+// googleScopes are the scopes the service's token is granted. Besides
+// creating files and script projects, they cover everything the bound
+// script does, which the Execution API requires of the caller of
+// createOnEditTrigger. Tokens issued before a scope was added must be
+// re-authorised.
+var googleScopes = append([]string{
+	drive.DriveFileScope,
+	script.ScriptProjectsScope,
+	script.ScriptDeploymentsScope,
+}, scriptScopes...)
+
+func newDriveService(ctx context.Context) (*drive.Service, error) {
+	client, err := googleClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	driveService, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Drive service: %w", err)
+	}
+	return driveService, nil
+}
+
+func newScriptService(ctx context.Context) (*script.Service, error) {
+	client, err := googleClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scriptService, err := script.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Apps Script service: %w", err)
+	}
+	return scriptService, nil
+}
+
+// CreateSpreadsheetFile creates an empty spreadsheet in a Drive folder, the
+// default reports folder when folderID is empty, and returns its file ID,
+// which is also its spreadsheet ID.
+func CreateSpreadsheetFile(ctx context.Context, folderID, name string) (string, error) {
+	if folderID == "" {
+		folderID = reportsFolderID
+	}
+	driveService, err := newDriveService(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	fileMetadata := &drive.File{
+		Name:     name,
+		MimeType: "application/vnd.google-apps.spreadsheet",
+		Parents:  []string{folderID},
+	}
+	file, err := driveService.Files.Create(fileMetadata).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to create spreadsheet: %w", err)
+	}
+
+	fmt.Printf("Spreadsheet created!\nID: %s\nURL: https://docs.google.com/spreadsheets/d/%s\n",
+		file.Id, file.Id)
+	return file.Id, nil
+}
+
+// TrashFile moves a Drive file to the trash. Scripts bound to the file
+// go with it.
+func TrashFile(ctx context.Context, fileID string) error {
+	driveService, err := newDriveService(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = driveService.Files.Update(fileID, &drive.File{Trashed: true}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to trash file %s: %w", fileID, err)
+	}
+	return nil
+}
+
+// AttachScript creates an Apps Script project bound to the spreadsheet
+// and uploads the edit-restricting code to it. It returns the script ID.
+func AttachScript(ctx context.Context, spreadsheetID, title string, settings ScriptSettings) (string, error) {
+	code, err := createAppScript(settings)
+	if err != nil {
+		return "", err
+	}
+
+	scriptService, err := newScriptService(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	project, err := scriptService.Projects.Create(&script.CreateProjectRequest{
+		Title:    title,
+		ParentId: spreadsheetID, // This binds the script to the spreadsheet
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to create Apps Script project: %w", err)
+	}
+	fmt.Printf("Created new Apps Script project: ScriptID=%s\n", project.ScriptId)
+
+	if err := updateScript(ctx, scriptService, project.ScriptId, code); err != nil {
+		return project.ScriptId, err
+	}
+
+	fmt.Println("Injected Apps Script code successfully!")
+	return project.ScriptId, nil
+}
+
+// DeleteScript disables a bound script project. The Apps Script API has
+// no delete call, so the code is replaced with an empty file; the project
+// itself is removed when its spreadsheet is trashed.
+func DeleteScript(ctx context.Context, scriptID string) error {
+	scriptService, err := newScriptService(ctx)
+	if err != nil {
+		return err
+	}
+	return updateScript(ctx, scriptService, scriptID, "")
+}
+
+// ErrScriptNotFound is returned for script projects that no longer exist.
+var ErrScriptNotFound = errors.New("script project not found")
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// UpdateScript replaces the code of a bound script project with the one
+// generated for settings.
+func UpdateScript(ctx context.Context, scriptID string, settings ScriptSettings) error {
+	code, err := createAppScript(settings)
+	if err != nil {
+		return err
+	}
+	scriptService, err := newScriptService(ctx)
+	if err != nil {
+		return err
+	}
+	return updateScript(ctx, scriptService, scriptID, code)
+}
+
+// ScriptSourceHash returns the hash of the code a script project runs,
+// comparable with ScriptHash.
+func ScriptSourceHash(ctx context.Context, scriptID string) (string, error) {
+	scriptService, err := newScriptService(ctx)
+	if err != nil {
+		return "", err
+	}
+	content, err := scriptService.Projects.GetContent(scriptID).Context(ctx).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return "", ErrScriptNotFound
+		}
+		return "", fmt.Errorf("failed to read script content: %w", err)
+	}
+	for _, f := range content.Files {
+		if f.Name == "Code" {
+			return hashString(f.Source), nil
+		}
+	}
+	return hashString(""), nil
+}
+
+func updateScript(ctx context.Context, scriptService *script.Service, scriptID, code string) error {
+	manifest, err := appScriptManifest.source()
+	if err != nil {
+		return err
+	}
+	content := &script.Content{
+		Files: []*script.File{
+			{
+				Name:   "Code",
+				Type:   "SERVER_JS",
+				Source: code,
+			},
+			{
+				Name:   "appsscript",
+				Type:   "JSON",
+				Source: manifest,
+			},
+		},
+	}
+
+	_, err = scriptService.Projects.UpdateContent(scriptID, content).Context(ctx).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return ErrScriptNotFound
+		}
+		return fmt.Errorf("failed to update script content: %w", err)
+	}
+	return nil
+}
+
+// ScriptParent returns the ID of the file a script project is bound to,
+// empty for standalone scripts.
+func ScriptParent(ctx context.Context, scriptID string) (string, error) {
+	scriptService, err := newScriptService(ctx)
+	if err != nil {
+		return "", err
+	}
+	project, err := scriptService.Projects.Get(scriptID).Context(ctx).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return "", ErrScriptNotFound
+		}
+		return "", fmt.Errorf("failed to read script project: %w", err)
+	}
+	return project.ParentId, nil
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrInvalidToken   = errors.New("invalid token signature")
+	ErrExpiredToken   = errors.New("token expired")
+	ErrMissingExpiry  = errors.New("token has no exp claim")
+)
+
+// VerifyHS256 checks an HS256 signed JWT and returns its claims.
+// Tokens signed with any other algorithm are rejected, and so are tokens
+// without an exp claim, which would otherwise be valid forever.
+func VerifyHS256(token string, secret []byte) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := float64(time.Now().Unix())
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, ErrMissingExpiry
+	}
+	if now >= exp {
+		return nil, ErrExpiredToken
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func signHS256(t *testing.T, secret []byte, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHS256(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", signHS256(t, secret, map[string]interface{}{"sub": "a", "exp": now + 60}), nil},
+		{"no exp", signHS256(t, secret, map[string]interface{}{"sub": "a"}), ErrMissingExpiry},
+		{"exp not a number", signHS256(t, secret, map[string]interface{}{"sub": "a", "exp": "tomorrow"}), ErrMissingExpiry},
+		{"expired", signHS256(t, secret, map[string]interface{}{"sub": "a", "exp": now - 1}), ErrExpiredToken},
+		{"not yet valid", signHS256(t, secret, map[string]interface{}{"sub": "a", "exp": now + 60, "nbf": now + 30}), ErrInvalidToken},
+		{"wrong secret", signHS256(t, []byte("other"), map[string]interface{}{"sub": "a", "exp": now + 60}), ErrInvalidToken},
+		{"malformed", "a.b", ErrMalformedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyHS256(tt.token, secret)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyHS256() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
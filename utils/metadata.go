@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// ColumnIDKey is the developer metadata key that tags each report column
+// with its stable ID. Column metadata moves with the column, so the ID
+// survives reordering and does not depend on the header text.
+const ColumnIDKey = "penguin.column_id"
+
+// TagColumns tags the columns of a sheet, left to right, with ids,
+// replacing any tags from an earlier write.
+func TagColumns(ctx context.Context, spreadsheetID, sheetName string, ids []string) error {
+	sheetsService, err := newSheetsService(ctx)
+	if err != nil {
+		return err
+	}
+	sheetID, err := sheetIDByName(ctx, sheetsService, spreadsheetID, sheetName)
+	if err != nil {
+		return err
+	}
+
+	existing, err := searchColumnIDs(ctx, sheetsService, spreadsheetID)
+	if err != nil {
+		return err
+	}
+
+	var requests []*sheets.Request
+	if len(existing) > 0 {
+		requests = append(requests, &sheets.Request{
+			DeleteDeveloperMetadata: &sheets.DeleteDeveloperMetadataRequest{
+				DataFilter: &sheets.DataFilter{
+					DeveloperMetadataLookup: &sheets.DeveloperMetadataLookup{MetadataKey: ColumnIDKey},
+				},
+			},
+		})
+	}
+	for i, id := range ids {
+		requests = append(requests, &sheets.Request{
+			CreateDeveloperMetadata: &sheets.CreateDeveloperMetadataRequest{
+				DeveloperMetadata: &sheets.DeveloperMetadata{
+					MetadataKey:   ColumnIDKey,
+					MetadataValue: id,
+					Visibility:    "DOCUMENT",
+					Location: &sheets.DeveloperMetadataLocation{
+						DimensionRange: &sheets.DimensionRange{
+							SheetId:    sheetID,
+							Dimension:  "COLUMNS",
+							StartIndex: int64(i),
+							EndIndex:   int64(i + 1),
+							// Zero is a meaningful value for both
+							ForceSendFields: []string{"SheetId", "StartIndex"},
+						},
+					},
+				},
+			},
+		})
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	_, err = sheetsService.Spreadsheets.BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to tag columns: %w", err)
+	}
+	return nil
+}
+
+// ReadColumnIDs returns the column IDs tagged on a spreadsheet by
+// 0-based column index. Columns added in Sheets have none.
+func ReadColumnIDs(ctx context.Context, spreadsheetID string) (map[int]string, error) {
+	sheetsService, err := newSheetsService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return searchColumnIDs(ctx, sheetsService, spreadsheetID)
+}
+
+func searchColumnIDs(ctx context.Context, sheetsService *sheets.Service, spreadsheetID string) (map[int]string, error) {
+	resp, err := sheetsService.Spreadsheets.DeveloperMetadata.Search(spreadsheetID, &sheets.SearchDeveloperMetadataRequest{
+		DataFilters: []*sheets.DataFilter{{
+			DeveloperMetadataLookup: &sheets.DeveloperMetadataLookup{MetadataKey: ColumnIDKey},
+		}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read column ids: %w", err)
+	}
+
+	ids := make(map[int]string)
+	for _, m := range resp.MatchedDeveloperMetadata {
+		md := m.DeveloperMetadata
+		if md == nil || md.Location == nil || md.Location.DimensionRange == nil {
+			continue
+		}
+		ids[int(md.Location.DimensionRange.StartIndex)] = md.MetadataValue
+	}
+	return ids, nil
+}
+
+func sheetIDByName(ctx context.Context, sheetsService *sheets.Service, spreadsheetID, sheetName string) (int64, error) {
+	spreadsheet, err := sheetsService.Spreadsheets.Get(spreadsheetID).Context(ctx).Do()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get spreadsheet details: %w", err)
+	}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties.Title == sheetName {
+			return sheet.Properties.SheetId, nil
+		}
+	}
+	return 0, fmt.Errorf("sheet %q not found", sheetName)
+}
+
+// ReadHeaderRow returns the first row of a sheet as displayed.
+func ReadHeaderRow(ctx context.Context, spreadsheetID, sheetName string) ([]string, error) {
+	sheetsService, err := newSheetsService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sheetsService.Spreadsheets.Values.Get(spreadsheetID, fmt.Sprintf("'%s'!1:1", sheetName)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header row: %w", err)
+	}
+	var header []string
+	if len(resp.Values) > 0 {
+		for _, v := range resp.Values[0] {
+			header = append(header, fmt.Sprint(v))
+		}
+	}
+	return header, nil
+}
+
+// WriteHeaderCells sets header cells of a sheet, by 0-based column index.
+func WriteHeaderCells(ctx context.Context, spreadsheetID, sheetName string, cells map[int]string) error {
+	if len(cells) == 0 {
+		return nil
+	}
+	sheetsService, err := newSheetsService(ctx)
+	if err != nil {
+		return err
+	}
+
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW"}
+	for idx, value := range cells {
+		req.Data = append(req.Data, &sheets.ValueRange{
+			Range:  fmt.Sprintf("'%s'!%s1", sheetName, ColumnLetters(idx)),
+			Values: [][]interface{}{{value}},
+		})
+	}
+	if _, err := sheetsService.Spreadsheets.Values.BatchUpdate(spreadsheetID, req).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to write header cells: %w", err)
+	}
+	return nil
+}
+
+// HeaderProtected reports whether a sheet still has the owner-only
+// protection ProtectHeaderRow puts on its first row.
+func HeaderProtected(ctx context.Context, spreadsheetID, sheetName string) (bool, error) {
+	sheetsService, err := newSheetsService(ctx)
+	if err != nil {
+		return false, err
+	}
+	spreadsheet, err := sheetsService.Spreadsheets.Get(spreadsheetID).
+		Fields("sheets(properties(sheetId,title),protectedRanges(range,warningOnly))").Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("failed to get spreadsheet details: %w", err)
+	}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties.Title != sheetName {
+			continue
+		}
+		for _, pr := range sheet.ProtectedRanges {
+			r := pr.Range
+			if !pr.WarningOnly && r != nil && r.StartRowIndex == 0 && r.EndRowIndex >= 1 && r.StartColumnIndex == 0 && r.EndColumnIndex == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("sheet %q not found", sheetName)
+}
+
+// ColumnLetters turns a 0-based column index into A1 letters: 0 is A,
+// 26 is AA.
+func ColumnLetters(idx int) string {
+	var letters []byte
+	for idx >= 0 {
+		letters = append([]byte{byte('A' + idx%26)}, letters...)
+		idx = idx/26 - 1
+	}
+	return string(letters)
+}
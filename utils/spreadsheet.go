@@ -2,52 +2,35 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"text/template"
 
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
-	"google.golang.org/api/script/v1"
 	"google.golang.org/api/sheets/v4"
 )
 
 // var client *http.Client
 
 func WriteDataToSheet(
+	ctx context.Context,
 	spreadsheetID string,
 	sheetName string,
 	startCell string,
 	data [][]interface{},
 ) error {
-	ctx := context.Background()
-	b, err := os.ReadFile("credentials.json")
+	sheetsService, err := newSheetsService(ctx)
 	if err != nil {
-		log.Printf("Unable to read client secret file: %v", err)
-		return errors.New(err.Error())
-	}
-
-	// IMPORTANT: Add script.ScriptProjectsScope to have permission to create/update Apps Script projects
-	config, err := google.ConfigFromJSON(b,
-		drive.DriveFileScope,
-		script.ScriptProjectsScope,
-	)
-	if err != nil {
-		log.Printf("Unable to parse client secret file to config: %v", err)
-		return errors.New(err.Error())
-	}
-
-	client := getClient(config)
-	sheetsService, err := sheets.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		return fmt.Errorf("unable to create Sheets service: %w", err)
+		return err
 	}
 
 	writeRange := fmt.Sprintf("'%s'!%s", sheetName, startCell)
@@ -141,146 +124,10 @@ func parseCell(cell string) (col int64, row int64) {
 	return
 }
 
-func UploadSheet(reportName string, title string) (string, error) {
-	ctx := context.Background()
-
-	b, err := os.ReadFile("credentials.json")
-	if err != nil {
-		log.Printf("Unable to read client secret file: %v", err)
-		return "", errors.New(err.Error())
-	}
-
-	// IMPORTANT: Add script.ScriptProjectsScope to have permission to create/update Apps Script projects
-	config, err := google.ConfigFromJSON(b,
-		drive.DriveFileScope,
-		script.ScriptProjectsScope,
-	)
-	if err != nil {
-		log.Printf("Unable to parse client secret file to config: %v", err)
-		return "", errors.New(err.Error())
-	}
-
-	client := getClient(config)
-
-	driveService, err := drive.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		log.Printf("Unable to create Drive service: %v", err)
-		return "", errors.New(err.Error())
-	}
-
-	folderID := "1hGITz-qza0wMpK9MW93za5iq9u9-3qcg"
-	// Create a new Google Sheet file inside your folder
-	fileMetadata := &drive.File{
-		Name:     reportName,
-		MimeType: "application/vnd.google-apps.spreadsheet",
-		Parents:  []string{folderID},
-	}
-
-	file, err := driveService.Files.Create(fileMetadata).Do()
-	if err != nil {
-		log.Printf("Unable to create spreadsheet: %v", err)
-		return "", errors.New(err.Error())
-	}
-
-	fmt.Printf("Spreadsheet created!\nID: %s\nURL: https://docs.google.com/spreadsheets/d/%s\n",
-		file.Id, file.Id)
-
-	// Create Apps Script service
-	scriptService, err := script.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		log.Printf("Unable to create Apps Script service: %v", err)
-		return "", errors.New(err.Error())
-	}
-
-	var project *script.Project
-
-	createReq := &script.CreateProjectRequest{
-		Title:    title,
-		ParentId: file.Id, // This binds the script to the spreadsheet
-	}
-
-	project, err = scriptService.Projects.Create(createReq).Do()
-	if err != nil {
-		// If the error is because the project already exists, we'll get it by ID
-		// Note: This is a simplified approach - in production you'd want better error handling
-		fmt.Println("Project likely already exists, attempting to find it...")
-
-		// Get the script ID from the spreadsheet's manifest
-		// This is a more reliable way to find the bound script
-		manifest, err := scriptService.Projects.GetContent(file.Id).Do()
-		if err != nil {
-			log.Printf("Failed to get script manifest: %v", err)
-			return "", errors.New(err.Error())
-		}
-
-		if manifest != nil && manifest.ScriptId != "" {
-			project, err = scriptService.Projects.Get(manifest.ScriptId).Do()
-			if err != nil {
-				log.Printf("Failed to get existing project: %v", err)
-				return "", errors.New(err.Error())
-			}
-			fmt.Printf("Using existing Apps Script project: ScriptID=%s\n", project.ScriptId)
-		} else {
-			log.Printf("Could not determine existing script ID")
-			return "", errors.New(err.Error())
-		}
-	} else {
-		fmt.Printf("Created new Apps Script project: ScriptID=%s\n", project.ScriptId)
-	}
-
-	var appscriptString = createAppScript()
-
-	scriptFiles := []*script.File{
-		{
-			Name:   "Code",
-			Type:   "SERVER_JS",
-			Source: appscriptString,
-		},
-		{
-			Name:   "appsscript",
-			Type:   "JSON",
-			Source: `{"timeZone":"America/New_York","exceptionLogging":"CLOUD"}`,
-		},
-	}
-
-	content := &script.Content{
-		Files: scriptFiles,
-	}
-
-	_, err = scriptService.Projects.UpdateContent(project.ScriptId, content).Do()
-	if err != nil {
-		log.Printf("Failed to update script content: %v", err)
-		return "", errors.New(err.Error())
-	}
-
-	fmt.Println("Injected Apps Script code successfully!")
-	fmt.Printf("Open your sheet and check Extensions > Apps Script to see the code.\n")
-
-	return file.Id, nil
-}
-
-func ProtectHeaderRow(spreadsheetID, sheetName string, data [][]interface{}) error {
-	b, err := os.ReadFile("credentials.json")
+func ProtectHeaderRow(ctx context.Context, spreadsheetID, sheetName string, data [][]interface{}) error {
+	sheetsService, err := newSheetsService(ctx)
 	if err != nil {
-		log.Printf("Unable to read client secret file: %v", err)
-		return errors.New(err.Error())
-	}
-
-	// IMPORTANT: Add script.ScriptProjectsScope to have permission to create/update Apps Script projects
-	config, err := google.ConfigFromJSON(b,
-		drive.DriveFileScope,
-		script.ScriptProjectsScope,
-	)
-	if err != nil {
-		log.Printf("Unable to parse client secret file to config: %v", err)
-		return errors.New(err.Error())
-	}
-
-	client := getClient(config)
-	ctx := context.Background()
-	sheetsService, err := sheets.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		return fmt.Errorf("failed to create Sheets service: %w", err)
+		return err
 	}
 
 	// Get the sheet ID from the sheet name
@@ -362,25 +209,8 @@ func ProtectHeaderRow(spreadsheetID, sheetName string, data [][]interface{}) err
 	return nil
 }
 
-func AddSheetToSpreadsheet(spreadsheetID, sheetName string) error {
-	b, err := os.ReadFile("credentials.json")
-	if err != nil {
-		log.Printf("Unable to read client secret file: %v", err)
-		return errors.New(err.Error())
-	}
-
-	// IMPORTANT: Add script.ScriptProjectsScope to have permission to create/update Apps Script projects
-	config, err := google.ConfigFromJSON(b,
-		drive.DriveFileScope,
-		script.ScriptProjectsScope,
-	)
-	if err != nil {
-		log.Printf("Unable to parse client secret file to config: %v", err)
-		return errors.New(err.Error())
-	}
-
-	client := getClient(config)
-	sheetsService, err := sheets.NewService(context.Background(), option.WithHTTPClient(client))
+func AddSheetToSpreadsheet(ctx context.Context, spreadsheetID, sheetName string) error {
+	sheetsService, err := newSheetsService(ctx)
 	if err != nil {
 		return err
 	}
@@ -401,10 +231,120 @@ func AddSheetToSpreadsheet(spreadsheetID, sheetName string) error {
 	return err
 }
 
-func createAppScript() string {
-	scp := `/**
+// ScriptSettings are the per-report values baked into the bound Apps
+// Script.
+type ScriptSettings struct {
+	// BaseURL is where the script reaches this server, e.g.
+	// https://penguin.example.com.
+	BaseURL string
+	// KeyColumnId is the ID of the report's key column, "" for none.
+	KeyColumnId string
+}
+
+// ColumnIDKey lets the script template refer to the metadata key.
+func (ScriptSettings) ColumnIDKey() string { return ColumnIDKey }
+
+func createAppScript(settings ScriptSettings) (string, error) {
+	var b strings.Builder
+	if err := appScriptTemplate.Execute(&b, settings); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var appScriptTemplate = template.Must(template.New("appscript").Parse(appScriptSource))
+
+// ScriptVersion identifies the script template. Reports whose bound
+// script was generated from another template have an outdated script.
+var ScriptVersion = hashString(appScriptSource + fmt.Sprintf("%+v", appScriptManifest))[:12]
+
+// ScriptHash returns the hash of the script generated for settings, to
+// tell whether a bound script has been modified since.
+func ScriptHash(settings ScriptSettings) (string, error) {
+	code, err := createAppScript(settings)
+	if err != nil {
+		return "", err
+	}
+	return hashString(code), nil
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+const appScriptSource = `const PENGUIN_URL = "{{js .BaseURL}}";
+const COLUMN_ID_KEY = "{{js .ColumnIDKey}}";
+const KEY_COLUMN_ID = "{{js .KeyColumnId}}";
+
+/**
+ * Authenticates calls to Penguin as the account the edit trigger runs as,
+ * which Penguin recorded when it installed the trigger. The script holds
+ * no secret: anyone who can edit the sheet can read its code.
+ */
+function identityHeaders() {
+  return { "Authorization": "Bearer " + ScriptApp.getIdentityToken() };
+}
+
+/**
+ * Returns the email of the user who made the edit. The installable
+ * trigger runs as the account that installed it, so the editor comes from
+ * the event rather than the session.
+ */
+function editorEmail(e) {
+  return (e.user && e.user.getEmail()) || "";
+}
+
+/**
+ * Returns the IDs tagged on the columns of range, "" for untagged ones.
+ * IDs identify columns for permissions; header text can be edited and
+ * repeated.
+ */
+function rangeColumnIds(range) {
+  const tagged = {};
+  range.getSheet().createDeveloperMetadataFinder().withKey(COLUMN_ID_KEY).find().forEach(function(m) {
+	const location = m.getLocation();
+	if (location.getLocationType() === SpreadsheetApp.DeveloperMetadataLocationType.COLUMN) {
+	  tagged[location.getColumn().getColumn()] = m.getValue();
+	}
+  });
+
+  const ids = [];
+  for (var i = 0; i < range.getNumColumns(); i++) {
+	ids.push(tagged[range.getColumn() + i] || "");
+  }
+  return ids;
+}
+
+/**
+ * Returns the values of the report's key column in the rows of range, or
+ * null when the report has none. The server finds the last known values
+ * of a row by its key, which survives sorting and inserted rows.
+ */
+function rowKeys(range) {
+  if (!KEY_COLUMN_ID) {
+	return null;
+  }
+  const sheet = range.getSheet();
+  var column = 0;
+  sheet.createDeveloperMetadataFinder().withKey(COLUMN_ID_KEY).withValue(KEY_COLUMN_ID).find().forEach(function(m) {
+	const location = m.getLocation();
+	if (location.getLocationType() === SpreadsheetApp.DeveloperMetadataLocationType.COLUMN) {
+	  column = location.getColumn().getColumn();
+	}
+  });
+  if (column === 0) {
+	return null;
+  }
+  return sheet.getRange(range.getRow(), column, range.getNumRows(), 1).getValues().map(function(row) {
+	return row[0];
+  });
+}
+
+/**
 	* Creates an installable onEdit trigger for the 'restrictColumnEditingToUser' function.
-	* This is designed to be called by an onOpen trigger to set up the environment.
+	* Penguin runs it through the Execution API when the report is created,
+	* and records the account returned as the one the trigger runs as.
 	*/
    function createOnEditTrigger() {
 	 // First, delete any existing triggers to prevent duplicates.
@@ -422,19 +362,22 @@ func createAppScript() string {
 		 .forSpreadsheet(SpreadsheetApp.getActive())
 		 .onEdit()
 		 .create();
+	 return Session.getEffectiveUser().getEmail();
    }
 
-   function checkAccess(emailId,sheetId, columnName) {
-	const url = "https://c9469ceab3be.ngrok-free.app/api/v1/check-edit-permission"; // Update this to your actual endpoint
+   function checkAccess(emailId,sheetId, columnName, columnId) {
+	const url = PENGUIN_URL + "/api/v1/check-edit-permission";
 	const payload = {
 	  email: emailId,
 	  sheet_id: sheetId,
-	  column_name: columnName
+	  column_name: columnName,
+	  column_id: columnId
 	};
   
 	const options = {
 	  method: "post",
 	  contentType: "application/json",
+	  headers: identityHeaders(),
 	  payload: JSON.stringify(payload),
 	  muteHttpExceptions: true
 	};
@@ -452,26 +395,113 @@ func createAppScript() string {
 	}
 	return status
   }
-   
-   /**
-	* An onOpen trigger that runs when the spreadsheet is opened.
-	* It will call the function to create the installable trigger.
-	*/
-   function onOpen() {
-	 
 
-	 SpreadsheetApp.getUi()
-	 .createMenu("Column Permission setup")
-	 .addItem('Enable Edit Trigger', 'createOnEditTrigger')
-	 .addToUi();
+  /**
+   * Records an allowed edit in the report's edit history. Failures are
+   * logged only: the edit itself has already been accepted.
+   */
+  function recordEdit(sheetId, e, columnName, columnIds) {
+	const range = e.range;
+	const payload = {
+	  editor: editorEmail(e),
+	  range: range.getA1Notation(),
+	  sheet_name: range.getSheet().getName(),
+	  column_name: String(columnName),
+	  row: range.getRow(),
+	  column_ids: columnIds,
+	  row_keys: rowKeys(range),
+	  old_value: typeof e.oldValue === 'undefined' ? null : e.oldValue,
+	  // e.value is only set for single-cell edits
+	  new_value: typeof e.value === 'undefined' ? (range.getNumRows() * range.getNumColumns() === 1 ? String(range.getValue()) : null) : e.value,
+	  values: range.getValues(),
+	  edited_at: new Date().toISOString()
+	};
 
-	//  createOnEditTrigger();
+	const options = {
+	  method: "post",
+	  contentType: "application/json",
+	  headers: identityHeaders(),
+	  payload: JSON.stringify(payload),
+	  muteHttpExceptions: true
+	};
 
-	//  SpreadsheetApp.getUi()
-	//  .createMenu("Test Menu")
-	//  .addItem("Say Hello", "sayHello")
-	//  .addToUi();
-   }
+	try {
+	  const response = UrlFetchApp.fetch(PENGUIN_URL + "/api/v1/reports/" + encodeURIComponent(sheetId) + "/edits", options);
+	  if (response.getResponseCode() !== 201) {
+		Logger.log("Edit not recorded: " + response.getContentText());
+	  }
+	} catch (error) {
+	  Logger.log("Error recording edit: " + error.message);
+	}
+  }
+
+  /**
+   * Undoes a denied edit. e.oldValue is missing for pastes, multi-cell
+   * edits and previously blank cells, so the values are fetched from the
+   * server, which keeps the last known value of every protected cell.
+   */
+  function revertEdit(sheetId, e, columnIds) {
+	const range = e.range;
+	const single = range.getNumRows() * range.getNumColumns() === 1;
+	const fallback = typeof e.oldValue === 'undefined' ? "" : e.oldValue;
+
+	const options = {
+	  method: "post",
+	  contentType: "application/json",
+	  headers: identityHeaders(),
+	  payload: JSON.stringify({
+		row: range.getRow(),
+		num_rows: range.getNumRows(),
+		column_ids: columnIds,
+		row_keys: rowKeys(range)
+	  }),
+	  muteHttpExceptions: true
+	};
+
+	var restored = null;
+	try {
+	  const response = UrlFetchApp.fetch(PENGUIN_URL + "/api/v1/reports/" + encodeURIComponent(sheetId) + "/restore", options);
+	  if (response.getResponseCode() === 200) {
+		restored = JSON.parse(response.getContentText());
+	  } else {
+		Logger.log("Could not fetch values to restore: " + response.getContentText());
+	  }
+	} catch (error) {
+	  Logger.log("Error fetching values to restore: " + error.message);
+	}
+
+	if (restored === null) {
+	  if (single) {
+		range.setValue(fallback);
+	  }
+	  return;
+	}
+
+	if (single && !restored.known[0][0]) {
+	  range.setValue(fallback);
+	  return;
+	}
+
+	// Cells the server does not track are not protected, so they are left
+	// as the edit put them
+	const allKnown = restored.known.every(function(row) {
+	  return row.every(function(known) { return known; });
+	});
+	const values = restored.values.map(function(row) {
+	  return row.map(function(value) { return value === null ? "" : value; });
+	});
+	if (allKnown) {
+	  range.setValues(values);
+	  return;
+	}
+	values.forEach(function(row, i) {
+	  row.forEach(function(value, j) {
+		if (restored.known[i][j]) {
+		  range.getCell(i + 1, j + 1).setValue(value);
+		}
+	  });
+	});
+  }
    
    // Your original function to restrict column editing.
    function restrictColumnEditingToUser(e) {
@@ -491,7 +521,7 @@ func createAppScript() string {
 	// var spreadsheetId = SpreadsheetApp.getActiveSpreadsheet().getId()
   
 	//getemailId
-	var emailid = Session.getActiveUser().getEmail()
+	var emailid = editorEmail(e)
   
 	// get First Row of the edited column
 	var editedRange = e.range;
@@ -507,22 +537,25 @@ func createAppScript() string {
 	// var response = UrlFetchApp.fetch("https://google.com");
 	// Logger.log("Response code: " + response.getResponseCode());
   
-	code = checkAccess(emailid,sheetId,editedColumnName);
+	// Every column of the range must be editable, not just the first
+	const columnIds = rangeColumnIds(editedRange);
+	var code = 200;
+	for (var i = 0; i < columnIds.length && code === 200; i++) {
+	  code = checkAccess(emailid, sheetId, editedSheet.getRange(1, columnEdited + i).getValue(), columnIds[i]);
+	}
   
 	if (code !== 200) {
 	  console.log(" should not be allowed to edit")
-	  editedRange.setValue(e.oldValue || ""); // May require enabling 'Undo' logic or storing value elsewhere
+	  revertEdit(sheetId, e, columnIds);
 	  SpreadsheetApp.getActiveSpreadsheet().toast("Edit to column " + editedColumnName + " is not permitted.");
 	} else {
 	  console.log(" User has Access ")
+	  recordEdit(sheetId, e, editedColumnName, columnIds);
 	}
   
   
   }`
 
-	return scp
-}
-
 func getClient(config *oauth2.Config) *http.Client {
 	usr, _ := user.Current()
 	tokenCacheDir := filepath.Join(usr.HomeDir, ".credentials")
@@ -557,3 +590,50 @@ func getClient(config *oauth2.Config) *http.Client {
 	}
 	return config.Client(context.Background(), token)
 }
+
+// ClearSheet removes every value from a sheet, keeping its formatting and
+// protections, so a shorter result set does not leave stale rows behind.
+func ClearSheet(ctx context.Context, spreadsheetID, sheetName string) error {
+	sheetsService, err := newSheetsService(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = sheetsService.Spreadsheets.Values.Clear(spreadsheetID, fmt.Sprintf("'%s'", sheetName), &sheets.ClearValuesRequest{}).Do()
+	if err != nil {
+		return fmt.Errorf("failed to clear sheet: %w", err)
+	}
+	return nil
+}
+
+func newSheetsService(ctx context.Context) (*sheets.Service, error) {
+	client, err := googleClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sheetsService, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Sheets service: %w", err)
+	}
+	return sheetsService, nil
+}
+
+// ReadSheet returns the values of a sheet as the Sheets API stores them:
+// numbers and booleans unformatted, dates as displayed. Trailing empty
+// cells and rows are omitted by the API.
+func ReadSheet(ctx context.Context, spreadsheetID, sheetName string) ([][]interface{}, error) {
+	sheetsService, err := newSheetsService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sheetsService.Spreadsheets.Values.Get(spreadsheetID, fmt.Sprintf("'%s'", sheetName)).
+		ValueRenderOption("UNFORMATTED_VALUE").
+		DateTimeRenderOption("FORMATTED_STRING").
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sheet: %w", err)
+	}
+	return resp.Values, nil
+}
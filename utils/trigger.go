@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/script/v1"
+)
+
+// scriptScopes are the scopes the bound script uses, declared in its
+// manifest so the Execution API knows what a caller must hold.
+var scriptScopes = []string{
+	"openid", // ScriptApp.getIdentityToken
+	"https://www.googleapis.com/auth/spreadsheets",
+	"https://www.googleapis.com/auth/script.external_request",
+	"https://www.googleapis.com/auth/script.scriptapp",
+	"https://www.googleapis.com/auth/script.container.ui",
+	"https://www.googleapis.com/auth/userinfo.email",
+}
+
+// scriptManifest is the shape of a bound script's appsscript.json. Its
+// fields are in the order json.Marshal wrote them from a map before.
+type scriptManifest struct {
+	ExceptionLogging string `json:"exceptionLogging"`
+	ExecutionAPI     struct {
+		Access string `json:"access"`
+	} `json:"executionApi"`
+	OAuthScopes []string `json:"oauthScopes"`
+	TimeZone    string   `json:"timeZone"`
+}
+
+// appScriptManifest is the bound script's manifest. executionApi lets the
+// service's own account run its functions.
+var appScriptManifest = func() scriptManifest {
+	m := scriptManifest{ExceptionLogging: "CLOUD", OAuthScopes: scriptScopes, TimeZone: "America/New_York"}
+	m.ExecutionAPI.Access = "MYSELF"
+	return m
+}()
+
+func (m scriptManifest) source() (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode script manifest: %w", err)
+	}
+	return string(b), nil
+}
+
+var (
+	// ErrScriptExecution is returned when a script function ran and threw.
+	ErrScriptExecution = errors.New("script function failed")
+	// ErrExecutionUnavailable is returned when the Execution API refuses to
+	// run a script at all. It only runs scripts linked to the Cloud project
+	// of the OAuth client, and projects created through the API are linked
+	// to a hidden default project until someone changes it in the editor.
+	ErrExecutionUnavailable = errors.New("the Execution API cannot run this script; link it to the OAuth client's Cloud project in the script editor (Project Settings) and install the trigger again")
+)
+
+// InstallEditTrigger deploys a bound script as an API executable and runs
+// its createOnEditTrigger function, so the sheet enforces column
+// permissions without anyone opening it. deploymentID is the deployment
+// of an earlier installation, if any; it is kept, and only moved to a new
+// version when the script's code changed. It returns the deployment ID
+// and the Google account the trigger runs as, which is the one whose
+// identity token the script sends.
+func InstallEditTrigger(ctx context.Context, scriptID, deploymentID string) (string, string, error) {
+	scriptService, err := newScriptService(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	deploymentID, err = deployScript(ctx, scriptService, scriptID, deploymentID)
+	if err != nil {
+		return "", "", err
+	}
+	var owner string
+	if err := runScriptFunction(ctx, scriptService, deploymentID, "createOnEditTrigger", &owner); err != nil {
+		return deploymentID, "", err
+	}
+	if owner == "" {
+		return deploymentID, "", fmt.Errorf("%w: createOnEditTrigger did not return the trigger's account", ErrScriptExecution)
+	}
+	return deploymentID, owner, nil
+}
+
+// deployScript points a deployment at the script's current code. The
+// deployment description carries a hash of that code, so an installation
+// with unchanged code creates neither a version nor a deployment: a
+// project has a limited number of versions and the API cannot delete any.
+func deployScript(ctx context.Context, scriptService *script.Service, scriptID, deploymentID string) (string, error) {
+	content, err := scriptService.Projects.GetContent(scriptID).Context(ctx).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return "", ErrScriptNotFound
+		}
+		return "", fmt.Errorf("failed to read script content: %w", err)
+	}
+	var code strings.Builder
+	for _, f := range content.Files {
+		code.WriteString(f.Name + "\n" + f.Source + "\n")
+	}
+	description := "penguin " + hashString(code.String())[:12]
+
+	if deploymentID != "" {
+		deployment, err := scriptService.Projects.Deployments.Get(scriptID, deploymentID).Context(ctx).Do()
+		switch {
+		case isNotFound(err):
+			deploymentID = ""
+		case err != nil:
+			return "", fmt.Errorf("failed to read script deployment: %w", err)
+		case deployment.DeploymentConfig != nil && deployment.DeploymentConfig.Description == description:
+			return deploymentID, nil
+		}
+	}
+
+	version, err := scriptService.Projects.Versions.Create(scriptID, &script.Version{
+		Description: description,
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to create script version: %w", err)
+	}
+	config := &script.DeploymentConfig{
+		VersionNumber:    version.VersionNumber,
+		ManifestFileName: "appsscript",
+		Description:      description,
+	}
+	if deploymentID != "" {
+		_, err = scriptService.Projects.Deployments.Update(scriptID, deploymentID, &script.UpdateDeploymentRequest{
+			DeploymentConfig: config,
+		}).Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("failed to update script deployment: %w", err)
+		}
+		return deploymentID, nil
+	}
+	deployment, err := scriptService.Projects.Deployments.Create(scriptID, config).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to deploy script: %w", err)
+	}
+	return deployment.DeploymentId, nil
+}
+
+// runScriptFunction runs function and decodes what it returns into result.
+func runScriptFunction(ctx context.Context, scriptService *script.Service, deploymentID, function string, result interface{}) error {
+	op, err := scriptService.Scripts.Run(deploymentID, &script.ExecutionRequest{Function: function}).Context(ctx).Do()
+	if err != nil {
+		// A script outside the client's Cloud project looks like a missing
+		// or forbidden one
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusForbidden) {
+			return fmt.Errorf("%w: %s", ErrExecutionUnavailable, apiErr.Message)
+		}
+		return fmt.Errorf("failed to run %s: %w", function, err)
+	}
+	if op.Error == nil {
+		if len(op.Response) == 0 {
+			return nil
+		}
+		// The response is an ExecutionResponse, whose result field holds
+		// the return value
+		var resp struct {
+			Result json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal(op.Response, &resp); err != nil {
+			return fmt.Errorf("failed to read the result of %s: %w", function, err)
+		}
+		if len(resp.Result) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to read the result of %s: %w", function, err)
+		}
+		return nil
+	}
+
+	// Details carry the script's own exception, which says more than the
+	// generic status message
+	msg := op.Error.Message
+	for _, raw := range op.Error.Details {
+		var detail script.ExecutionError
+		if json.Unmarshal(raw, &detail) == nil && detail.ErrorMessage != "" {
+			msg = strings.TrimSpace(detail.ErrorType + ": " + detail.ErrorMessage)
+			break
+		}
+	}
+	return fmt.Errorf("%w: %s: %s", ErrScriptExecution, function, msg)
+}